@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+
+	"github.com/nixpig/syringe.sh/config"
+	"github.com/nixpig/syringe.sh/pkg/turso"
+)
+
+// startFakeTurso serves the fake Turso API in-process, keeping databases in
+// the configured directory, and points the config at it.
+func startFakeTurso(cfg *config.Server) (*turso.FakeServer, error) {
+	if err := os.MkdirAll(cfg.Turso.FakeDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	fake := turso.NewFakeServer(cfg.Turso.Organization, cfg.Turso.APIToken)
+	fake.Dir = cfg.Turso.FakeDir
+
+	cfg.Turso.APIBaseURL = fake.BaseURL()
+
+	return fake, nil
+}
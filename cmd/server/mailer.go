@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/nixpig/syringe.sh/config"
+	"github.com/nixpig/syringe.sh/pkg/mailer"
+	"github.com/rs/zerolog"
+)
+
+// newMailer picks how emails are sent from the config: through the SMTP
+// server, into files in a directory, or otherwise logged, each as a single
+// entry.
+func newMailer(cfg config.Mail, logger *zerolog.Logger) mailer.Mailer {
+	if cfg.SMTPAddr != "" {
+		return mailer.NewSMTPMailer(
+			cfg.SMTPAddr,
+			cfg.From,
+			cfg.SMTPUsername,
+			cfg.SMTPPassword,
+		)
+	}
+
+	if cfg.Dir != "" {
+		return mailer.NewFileMailer(cfg.Dir, cfg.From)
+	}
+
+	return mailer.NewLogMailer(logger)
+}
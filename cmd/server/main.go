@@ -1,72 +1,375 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/charmbracelet/wish"
 	"github.com/joho/godotenv"
+	"github.com/nixpig/syringe.sh/config"
+	"github.com/nixpig/syringe.sh/internal/admin"
 	"github.com/nixpig/syringe.sh/internal/auth"
 	"github.com/nixpig/syringe.sh/internal/database"
 	"github.com/nixpig/syringe.sh/internal/middleware"
+	"github.com/nixpig/syringe.sh/internal/secret"
+	"github.com/nixpig/syringe.sh/internal/user"
+	"github.com/nixpig/syringe.sh/pkg/hostkeys"
+	"github.com/nixpig/syringe.sh/pkg/ratelimit"
+	"github.com/nixpig/syringe.sh/pkg/resilience"
+	"github.com/nixpig/syringe.sh/pkg/tracing"
+	"github.com/nixpig/syringe.sh/pkg/turso"
 	"github.com/nixpig/syringe.sh/pkg/validation"
 	"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
 )
 
 func main() {
 	// -- LOGGING
-	log := zerolog.
-		New(os.Stdout).
-		Output(zerolog.ConsoleWriter{
-			Out:        os.Stdout,
-			TimeFormat: "2006-01-02T15:04:05.999Z07:00",
-		}).With().Timestamp().Logger()
+	// logs are JSON until the config says otherwise
+	log := newLogger(config.DefaultServer().Log)
 
 	// -- ENV
-	log.Info().Msg("loading environment")
-	if err := godotenv.Load(".env"); err != nil {
+	// a '.env' file is optional, and only sets variables that aren't already
+	// in the environment
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Error().Err(err).Msg("failed to load '.env' file")
 		os.Exit(1)
 	}
 
+	a := &app{logger: &log}
+
+	err := newCmdRoot(a).Execute()
+	a.close()
+
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// newLogger logs to stdout at the configured level, as JSON unless the
+// format is 'console'. It's also the logger used for contexts without one.
+func newLogger(cfg config.Log) zerolog.Logger {
+	level, err := zerolog.ParseLevel(cfg.Level)
+	if err != nil {
+		level = zerolog.InfoLevel
+	}
+
+	var log zerolog.Logger
+
+	if cfg.Format == "console" {
+		log = zerolog.New(zerolog.ConsoleWriter{
+			Out:        os.Stdout,
+			TimeFormat: "2006-01-02T15:04:05.999Z07:00",
+		})
+	} else {
+		log = zerolog.New(os.Stdout)
+	}
+
+	log = log.Level(level).With().Timestamp().Logger()
+
+	zerolog.DefaultContextLogger = &log
+
+	return log
+}
+
+// app is what the server and operator commands run against, built once the
+// config has been loaded.
+type app struct {
+	logger   *zerolog.Logger
+	cfg      config.Server
+	validate validation.Validate
+	appDB    *sql.DB
+	closers  []func() error
+}
+
+// start loads the config and connects to the app database, first starting
+// the fake Turso API if it's configured.
+func (a *app) start(flags *pflag.FlagSet) error {
+	// -- CONFIG
+	a.logger.Info().Msg("loading config")
+	cfg, err := config.LoadServer(flags, os.Getenv)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("failed to load config")
+		return err
+	}
+
+	a.cfg = cfg
+	a.validate = validation.New()
+
+	*a.logger = newLogger(a.cfg.Log)
+
+	// -- FAKE TURSO
+	if a.cfg.Turso.FakeDir != "" {
+		a.logger.Warn().Str("dir", a.cfg.Turso.FakeDir).Msg("starting fake turso api")
+		fakeTurso, err := startFakeTurso(&a.cfg)
+		if err != nil {
+			a.logger.Error().Err(err).Msg("failed to start fake turso api")
+			return err
+		}
+
+		a.closers = append(a.closers, func() error {
+			fakeTurso.Close()
+			return nil
+		})
+	}
+
 	// -- DATABASE
-	log.Info().Msg("connecting to database")
+	a.logger.Info().Msg("connecting to database")
 	appDB, err := database.Connection(
-		os.Getenv("DATABASE_URL"),
-		os.Getenv("DATABASE_TOKEN"),
+		context.Background(),
+		a.cfg.Database.URL,
+		a.cfg.Database.Token,
 	)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to connect to database")
-		os.Exit(1)
+		a.logger.Error().Err(err).Msg("failed to connect to database")
+		return err
+	}
+
+	a.appDB = appDB
+	a.closers = append(a.closers, appDB.Close)
+
+	return nil
+}
+
+// close releases whatever start acquired, in reverse order.
+func (a *app) close() {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		a.closers[i]()
 	}
 
-	defer appDB.Close()
+	a.closers = nil
+}
+
+// userService is for operator commands, which only touch the app database.
+func (a *app) userService() user.UserService {
+	return user.NewUserServiceImpl(
+		user.NewSqliteUserStore(a.appDB),
+		a.validate,
+		http.Client{},
+		user.TursoAPISettings{},
+	)
+}
+
+func (a *app) serve() error {
+	monitor := newMonitor(a.appDB)
+
+	// failing calls to the Turso API, unless they might already have taken
+	// effect, and failing connections to user databases, are retried until
+	// too many fail in a row; each user database counts its own failures, so
+	// one that's broken doesn't stop others being connected to
+	tursoRetry := resilience.DefaultPolicy
+	tursoRetry.Breaker = resilience.NewBreaker(5, 30*time.Second)
+
+	userDBRetry := resilience.DefaultPolicy
+
+	tursoAPISettings := user.TursoAPISettings{
+		URL:          a.cfg.Turso.APIBaseURL,
+		Token:        a.cfg.Turso.APIToken,
+		Retry:        &tursoRetry,
+		Organization: a.cfg.Turso.Organization,
+		Group:        a.cfg.Turso.Group,
+	}
+
+	connectionOptions := []database.ConnectionManagerOption{
+		database.WithRetry(userDBRetry),
+		database.WithBreaker(5, 30*time.Second),
+		// databases created before columns were added to their tables get them
+		// the first time they're connected to
+		database.WithMigrate(func(ctx context.Context, db *sql.DB) error {
+			return secret.NewSqliteSecretStore(db).CreateTables(ctx)
+		}),
+	}
+
+	if a.cfg.Turso.FakeDir != "" {
+		tursoAPISettings.DatabaseURL = database.FakeURL(a.cfg.Turso.FakeDir)
+		connectionOptions = append(connectionOptions, database.WithURL(tursoAPISettings.DatabaseURL))
+	}
+
+	tursoAPI := turso.New(
+		a.cfg.Turso.Organization,
+		a.cfg.Turso.APIToken,
+		monitor.tursoClient(),
+		turso.WithBaseURL(a.cfg.Turso.APIBaseURL),
+		turso.WithRetry(tursoRetry),
+	)
+
+	connections := database.NewConnectionManager(
+		&tursoAPI,
+		a.cfg.Turso.Organization,
+		connectionOptions...,
+	)
+
+	defer connections.Close()
+
+	go connections.EvictIdleEvery(time.Minute)
+
+	monitor.watchConnections(connections)
+
+	// -- MONITORING
+	if a.cfg.MetricsAddr != "" {
+		monitorServer := &http.Server{
+			Addr:              a.cfg.MetricsAddr,
+			Handler:           monitor.handler(),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+
+		go func() {
+			a.logger.Info().Str("address", a.cfg.MetricsAddr).Msg("serving metrics and health checks")
+
+			if err := monitorServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				a.logger.Error().Err(err).Msg("failed to serve metrics and health checks")
+			}
+		}()
+
+		defer monitorServer.Close()
+	}
+
+	// -- TRACING
+	if a.cfg.Tracing.Exporter != "" {
+		provider, err := tracing.NewProvider(
+			context.Background(),
+			"syringe",
+			a.cfg.Tracing.Exporter,
+			a.cfg.Tracing.Endpoint,
+		)
+		if err != nil {
+			a.logger.Error().Err(err).Msg("failed to set up tracing")
+			return err
+		}
+
+		a.logger.Info().Str("exporter", a.cfg.Tracing.Exporter).Msg("tracing sessions")
+
+		// spans still buffered are sent before exiting
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := provider.Shutdown(ctx); err != nil {
+				a.logger.Error().Err(err).Msg("failed to flush traces")
+			}
+		}()
+	}
 
 	// -- DEPENDENCY CONSTRUCTION
-	log.Info().Msg("building app components")
-	validate := validation.New()
-	authStore := auth.NewSqliteAuthStore(appDB)
-	authService := auth.NewAuthService(authStore, validate)
+	a.logger.Info().Msg("building app components")
+	certificates, err := a.certificateAuthority()
+	if err != nil {
+		a.logger.Error().Err(err).Msg("failed to load certificate authority")
+		return err
+	}
+
+	var authOptions []auth.Option
+	if certificates != nil {
+		authOptions = append(authOptions, auth.WithCertificateAuthority(certificates))
+	}
+
+	authStore := auth.NewSqliteAuthStore(a.appDB)
+	authService := auth.NewAuthService(authStore, a.validate, authOptions...)
+
+	// -- HOST KEYS
+	hostKeys, err := hostkeys.Load(
+		a.cfg.HostKeyDir,
+		a.cfg.HostKeyAlgorithms,
+		a.cfg.NextHostKeys,
+	)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("failed to load host keys")
+		return err
+	}
+
+	// -- RATE LIMITS
+	guard := ratelimit.NewGuard(ratelimit.Settings{
+		Connections: ratelimit.Limit{
+			PerMinute: a.cfg.RateLimits.Connections,
+			Burst:     a.cfg.RateLimits.ConnectionBurst,
+		},
+		Commands: ratelimit.Limit{
+			PerMinute: a.cfg.RateLimits.Commands,
+			Burst:     a.cfg.RateLimits.CommandBurst,
+		},
+		Failures: ratelimit.Limit{
+			PerMinute: a.cfg.RateLimits.Failures,
+			Burst:     a.cfg.RateLimits.FailureBurst,
+		},
+		BanDuration: a.cfg.RateLimits.BanDuration,
+	})
+
+	defer guard.Close()
 
-	// -- CMD
+	go guard.PruneEvery(time.Minute)
 
 	// -- SERVER
 	sshServer := newServer(
-		&log,
+		a.logger,
 		[]wish.Middleware{
-			middleware.NewMiddlewareCommand(&log, appDB, validate),
-			middleware.NewMiddlewareAuth(&log, authService),
-			middleware.NewMiddlewareLogging(&log),
+			middleware.NewMiddlewareCommand(
+				a.logger,
+				a.appDB,
+				a.validate,
+				connections,
+				tursoAPISettings,
+				monitor.tursoClient(),
+				newMailer(a.cfg.Mail, a.logger),
+				admin.NewAllowlist(a.cfg.AdminKeys...),
+				a.cfg.Timeouts.For,
+			),
+			middleware.NewMiddlewareUserRateLimit(a.logger, guard),
+			middleware.NewMiddlewareAuth(a.logger, authService),
+			middleware.NewMiddlewareRateLimit(a.logger, guard),
+			middleware.NewMiddlewareLogging(a.logger),
+			hostKeys.Middleware(),
+			middleware.NewMiddlewareTracing(),
+			middleware.NewMiddlewareMetrics(monitor.sessions),
+		},
+		a.cfg.SessionTimeout,
+		hostKeys,
+		auth.KeyPolicy{
+			Algorithms: a.cfg.KeyAlgorithms,
+			MinRSABits: a.cfg.MinRSABits,
 		},
-		time.Duration(time.Second*30),
-		".ssh/id_ed25519",
+		certificates,
+		guard,
+		monitor,
 	)
 
 	if err := sshServer.Start(
-		os.Getenv("APP_HOST"),
-		os.Getenv("APP_PORT"),
+		a.cfg.Host,
+		strconv.Itoa(a.cfg.Port),
 	); err != nil {
-		log.Error().Err(err).Msg("failed to start ssh server")
-		os.Exit(1)
+		a.logger.Error().Err(err).Msg("failed to start ssh server")
+		return err
+	}
+
+	return nil
+}
+
+// certificateAuthority is the CA user certificates are trusted from, or nil
+// if none is configured.
+func (a *app) certificateAuthority() (*auth.CertificateAuthority, error) {
+	if a.cfg.Certificates.TrustedCAKeys == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(a.cfg.Certificates.TrustedCAKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := auth.ParseCAKeys(b)
+	if err != nil {
+		return nil, err
+	}
+
+	principals, err := auth.ParsePrincipals(a.cfg.Certificates.Principals)
+	if err != nil {
+		return nil, err
 	}
+
+	return auth.NewCertificateAuthority(keys, auth.WithPrincipals(principals)), nil
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/nixpig/syringe.sh/internal/database"
+	"github.com/nixpig/syringe.sh/internal/middleware"
+	"github.com/nixpig/syringe.sh/pkg/metrics"
+)
+
+// healthCheckTimeout is how long health checks wait for the app database.
+const healthCheckTimeout = 2 * time.Second
+
+// monitor records metrics about the server, and serves them, and whether
+// the server's healthy, over HTTP.
+type monitor struct {
+	registry *metrics.Registry
+	sessions middleware.SessionMetrics
+	turso    metrics.RoundTripperMetrics
+	appDB    *sql.DB
+	// serving is whether the SSH server is accepting connections
+	serving atomic.Bool
+}
+
+func newMonitor(appDB *sql.DB) *monitor {
+	registry := metrics.NewRegistry()
+
+	m := &monitor{
+		registry: registry,
+		appDB:    appDB,
+		sessions: middleware.SessionMetrics{
+			Active:      registry.Gauge("syringe_sessions_active", "Sessions in progress."),
+			Total:       registry.Counter("syringe_sessions_total", "Sessions started."),
+			RateLimited: registry.Counter("syringe_sessions_rate_limited_total", "Sessions refused for being rate limited."),
+			Auth: registry.Counter(
+				"syringe_auth_total",
+				"Authentications, by result: success, anonymous or failure.",
+				"result",
+			),
+			Commands: registry.Counter(
+				"syringe_commands_total",
+				"Commands run, by command path and result: ok or error.",
+				"command", "result",
+			),
+			CommandDuration: registry.Histogram(
+				"syringe_command_duration_seconds",
+				"How long commands took, by command path.",
+				metrics.DefaultBuckets,
+				"command",
+			),
+		},
+		turso: metrics.RoundTripperMetrics{
+			Requests: registry.Counter(
+				"syringe_turso_requests_total",
+				"Requests to the Turso API, by method and status code.",
+				"method", "code",
+			),
+			Duration: registry.Histogram(
+				"syringe_turso_request_duration_seconds",
+				"How long requests to the Turso API took, by method.",
+				metrics.DefaultBuckets,
+				"method",
+			),
+			Errors: registry.Counter(
+				"syringe_turso_errors_total",
+				"Requests to the Turso API that failed, or got a 429 or 5xx status, by method.",
+				"method",
+			),
+		},
+	}
+
+	registry.GaugeFunc("syringe_app_db_connections_open", "Connections open to the app database.", func() float64 {
+		return float64(appDB.Stats().OpenConnections)
+	})
+	registry.GaugeFunc("syringe_app_db_connections_in_use", "Connections to the app database in use.", func() float64 {
+		return float64(appDB.Stats().InUse)
+	})
+	registry.GaugeFunc("syringe_app_db_connections_idle", "Idle connections to the app database.", func() float64 {
+		return float64(appDB.Stats().Idle)
+	})
+
+	return m
+}
+
+// watchConnections records the stats of the pool of user database
+// connections.
+func (m *monitor) watchConnections(connections *database.ConnectionManager) {
+	m.registry.GaugeFunc("syringe_user_db_connections_open", "Connections pooled to user databases.", func() float64 {
+		return float64(connections.Stats().Open)
+	})
+	m.registry.CounterFunc("syringe_user_db_connection_hits_total", "Times a pooled user database connection was reused.", func() float64 {
+		return float64(connections.Stats().Hits)
+	})
+	m.registry.CounterFunc("syringe_user_db_connection_misses_total", "Times a user database connection was opened.", func() float64 {
+		return float64(connections.Stats().Misses)
+	})
+	m.registry.CounterFunc("syringe_user_db_connection_evictions_total", "Times an idle user database connection was closed.", func() float64 {
+		return float64(connections.Stats().Evictions)
+	})
+}
+
+// tursoClient is an HTTP client for the Turso API that records its requests.
+func (m *monitor) tursoClient() http.Client {
+	return http.Client{Transport: metrics.InstrumentRoundTripper(m.turso, nil)}
+}
+
+func (m *monitor) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /metrics", m.registry.Handler())
+	mux.HandleFunc("GET /healthz", m.healthz)
+	mux.HandleFunc("GET /readyz", m.readyz)
+
+	return mux
+}
+
+// healthz is whether the server can reach the app database.
+func (m *monitor) healthz(w http.ResponseWriter, r *http.Request) {
+	if err := m.ping(r.Context()); err != nil {
+		http.Error(w, "app database unreachable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Write([]byte("ok\n"))
+}
+
+// readyz is whether the server is accepting connections, as well as healthy.
+func (m *monitor) readyz(w http.ResponseWriter, r *http.Request) {
+	if !m.serving.Load() {
+		http.Error(w, "not serving", http.StatusServiceUnavailable)
+		return
+	}
+
+	m.healthz(w, r)
+}
+
+func (m *monitor) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	return m.appDB.PingContext(ctx)
+}
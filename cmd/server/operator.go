@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/nixpig/syringe.sh/config"
+	"github.com/nixpig/syringe.sh/internal/user"
+	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/spf13/cobra"
+)
+
+// newCmdRoot builds the server's command, which runs the server, along with
+// the commands operators run on the server itself, against the app
+// database, rather than over SSH.
+func newCmdRoot(a *app) *cobra.Command {
+	cmdRoot := &cobra.Command{
+		Use:          "syringeserver",
+		Short:        "Run the syringe.sh server, or manage it with one of the commands below",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return a.start(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.serve()
+		},
+	}
+
+	cmdRoot.CompletionOptions.HiddenDefaultCmd = true
+
+	config.AddServerFlags(cmdRoot.PersistentFlags())
+
+	cmdUser := user.NewCmdUser()
+	cmdUser.AddCommand(user.NewCmdUserSuspend(withUserService(a, user.NewHandlerUserSuspend)))
+	cmdUser.AddCommand(user.NewCmdUserLock(withUserService(a, user.NewHandlerUserLock)))
+	cmdUser.AddCommand(user.NewCmdUserReactivate(withUserService(a, user.NewHandlerUserReactivate)))
+	cmdRoot.AddCommand(cmdUser)
+
+	return cmdRoot
+}
+
+// withUserService defers building a handler until it runs, since the app
+// database isn't connected until the config has been loaded.
+func withUserService(
+	a *app,
+	newHandler func(userService user.UserService) pkg.CobraHandler,
+) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		return newHandler(a.userService())(cmd, args)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
@@ -11,37 +12,96 @@ import (
 
 	"github.com/charmbracelet/ssh"
 	"github.com/charmbracelet/wish"
+	"github.com/nixpig/syringe.sh/internal/auth"
+	"github.com/nixpig/syringe.sh/pkg/hostkeys"
+	"github.com/nixpig/syringe.sh/pkg/ratelimit"
 	"github.com/rs/zerolog"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 type Server struct {
-	logger      *zerolog.Logger
-	middleware  []wish.Middleware
-	timeout     time.Duration
-	hostKeyPath string
+	logger     *zerolog.Logger
+	middleware []wish.Middleware
+	timeout    time.Duration
+	hostKeys   *hostkeys.HostKeys
+	keyPolicy  auth.KeyPolicy
+	// certificates, if set, is the CA users' certificates are checked
+	// against. Without it, certificates are refused.
+	certificates *auth.CertificateAuthority
+	guard        *ratelimit.Guard
+	monitor      *monitor
 }
 
 func newServer(
 	logger *zerolog.Logger,
 	middleware []wish.Middleware,
 	timeout time.Duration,
-	hostKeyPath string,
+	hostKeys *hostkeys.HostKeys,
+	keyPolicy auth.KeyPolicy,
+	certificates *auth.CertificateAuthority,
+	guard *ratelimit.Guard,
+	monitor *monitor,
 ) Server {
 	return Server{
-		logger:      logger,
-		middleware:  middleware,
-		timeout:     timeout,
-		hostKeyPath: hostKeyPath,
+		logger:       logger,
+		middleware:   middleware,
+		timeout:      timeout,
+		hostKeys:     hostKeys,
+		keyPolicy:    keyPolicy,
+		certificates: certificates,
+		guard:        guard,
+		monitor:      monitor,
 	}
 }
 
 func (s Server) Start(host, port string) error {
 	server, err := wish.NewServer(
 		wish.WithAddress(net.JoinHostPort(host, port)),
-		wish.WithHostKeyPath(s.hostKeyPath),
+		s.hostKeys.Option(),
 		wish.WithMaxTimeout(s.timeout),
+		ssh.WrapConn(func(ctx ssh.Context, conn net.Conn) net.Conn {
+			if err := s.guard.Connect(conn.RemoteAddr()); err != nil {
+				s.logger.Warn().Err(err).
+					Str("address", conn.RemoteAddr().String()).
+					Msg("rate limited connection")
+
+				// there's no session to write to yet, but clients can show
+				// lines sent before the version exchange
+				conn.Write([]byte(fmt.Sprintf("%s\r\n", err)))
+
+				return nil
+			}
+
+			return conn
+		}),
 		wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
-			return key.Type() == "ssh-ed25519" || key.Type() == "ssh-rsa"
+			if err := s.keyPolicy.Check(key); err != nil {
+				s.logger.Warn().Err(err).
+					Str("session", ctx.SessionID()).
+					Str("fingerprint", gossh.FingerprintSHA256(key)).
+					Msg("rejected key")
+				s.failAuth(ctx)
+				return false
+			}
+
+			// refused certificates are refused now, so clients can try another key
+			if cert, ok := key.(*gossh.Certificate); ok {
+				if s.certificates == nil {
+					s.failAuth(ctx)
+					return false
+				}
+
+				if err := s.certificates.Authenticate(ctx.User(), ctx.RemoteAddr(), cert); err != nil {
+					s.logger.Warn().Err(err).
+						Str("session", ctx.SessionID()).
+						Str("key_id", cert.KeyId).
+						Msg("rejected certificate")
+					s.failAuth(ctx)
+					return false
+				}
+			}
+
+			return true
 		}),
 		wish.WithMiddleware(
 			s.middleware...,
@@ -60,15 +120,25 @@ func (s Server) Start(host, port string) error {
 		Str("port", port).
 		Msg("starting server")
 
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("failed to start server")
+		return err
+	}
+
 	go func() {
-		if err = server.ListenAndServe(); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
-			s.logger.Error().Err(err).Msg("failed to start server")
+		if err := server.Serve(listener); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
+			s.logger.Error().Err(err).Msg("failed to serve")
 			done <- nil
 		}
 	}()
 
+	s.monitor.serving.Store(true)
+
 	<-done
 
+	s.monitor.serving.Store(false)
+
 	s.logger.Info().Msg("stopping server")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -81,3 +151,10 @@ func (s Server) Start(host, port string) error {
 
 	return nil
 }
+
+// failAuth counts a key refused during the handshake, towards banning the
+// address.
+func (s Server) failAuth(ctx ssh.Context) {
+	s.guard.Fail(ctx.RemoteAddr())
+	s.monitor.sessions.Auth.Inc("failure")
+}
@@ -0,0 +1,406 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nixpig/syringe.sh/internal/auth"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/nixpig/syringe.sh/pkg/turso"
+	"github.com/nixpig/syringe.sh/pkg/validation"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnv names the environment variable holding the path of the server's
+// config file, when it isn't given with '--config'.
+const ConfigEnv = "SYRINGE_CONFIG"
+
+// Server is the server's configuration. Each setting is taken from, in order
+// of precedence, its flag, its environment variable, the config file, and
+// finally its default.
+type Server struct {
+	Host           string        `yaml:"host" name:"host"`
+	Port           int           `yaml:"port" name:"port" validate:"min=1,max=65535"`
+	SessionTimeout time.Duration `yaml:"session_timeout" name:"session_timeout" validate:"min=0"`
+	// HostKeyDir holds a host key for each of HostKeyAlgorithms, as
+	// 'id_<algorithm>', generated if it's missing.
+	HostKeyDir        string   `yaml:"host_key_dir" name:"host_key_dir" validate:"required"`
+	HostKeyAlgorithms []string `yaml:"host_key_algorithms" name:"host_key_algorithms" validate:"required,dive,oneof=ed25519 ecdsa rsa"`
+	// NextHostKeys are the paths of host keys being rotated in, which are
+	// advertised to clients but not yet used.
+	NextHostKeys []string `yaml:"next_host_keys" name:"next_host_keys" validate:"dive,required"`
+	// KeyAlgorithms are the types of key users can connect with.
+	KeyAlgorithms []string `yaml:"key_algorithms" name:"key_algorithms" validate:"required,dive,oneof=ssh-ed25519 sk-ssh-ed25519@openssh.com ecdsa-sha2-nistp256 ecdsa-sha2-nistp384 ecdsa-sha2-nistp521 sk-ecdsa-sha2-nistp256@openssh.com ssh-rsa"`
+	MinRSABits    int      `yaml:"min_rsa_bits" name:"min_rsa_bits" validate:"min=1024"`
+	// AdminKeys are the SHA256 fingerprints of keys allowed to run admin
+	// commands.
+	AdminKeys []string `yaml:"admin_keys" name:"admin_keys"`
+	// MetricsAddr is the address metrics and health checks are served on
+	// over HTTP, or empty not to serve them.
+	MetricsAddr string `yaml:"metrics_addr" name:"metrics_addr"`
+
+	Database     Database     `yaml:"database" name:"database"`
+	Turso        Turso        `yaml:"turso" name:"turso"`
+	Mail         Mail         `yaml:"mail" name:"mail"`
+	Certificates Certificates `yaml:"certificates" name:"certificates"`
+	RateLimits   RateLimits   `yaml:"rate_limits" name:"rate_limits"`
+	Timeouts     Timeouts     `yaml:"timeouts" name:"timeouts"`
+	Tracing      Tracing      `yaml:"tracing" name:"tracing"`
+	Log          Log          `yaml:"log" name:"log"`
+}
+
+// Database is the app database, holding users, keys and organisations.
+type Database struct {
+	URL   string `yaml:"url" name:"database.url" validate:"required"`
+	Token string `yaml:"token" name:"database.token"`
+}
+
+// Turso is the Turso organisation users' databases are created in.
+type Turso struct {
+	Organization string `yaml:"organization" name:"turso.organization" validate:"required"`
+	Group        string `yaml:"group" name:"turso.group" validate:"required"`
+	APIToken     string `yaml:"api_token" name:"turso.api_token"`
+	APIBaseURL   string `yaml:"api_base_url" name:"turso.api_base_url"`
+	// FakeDir, if set, serves a fake of the Turso API in-process, keeping
+	// databases as files in the directory.
+	FakeDir string `yaml:"fake_dir" name:"turso.fake_dir"`
+}
+
+// Mail is how emails are sent: through the SMTP server at SMTPAddr, into
+// files in Dir, or otherwise written to stdout.
+type Mail struct {
+	From         string `yaml:"from" name:"mail.from" validate:"required"`
+	SMTPAddr     string `yaml:"smtp_addr" name:"mail.smtp_addr"`
+	SMTPUsername string `yaml:"smtp_username" name:"mail.smtp_username"`
+	SMTPPassword string `yaml:"smtp_password" name:"mail.smtp_password"`
+	Dir          string `yaml:"dir" name:"mail.dir"`
+}
+
+// Certificates are OpenSSH user certificates users can connect with instead
+// of a registered key, once they've registered one.
+type Certificates struct {
+	// TrustedCAKeys is the path of a file of CA public keys, in
+	// authorized_keys format, that certificates are trusted from. Without
+	// it, certificates are refused.
+	TrustedCAKeys string `yaml:"trusted_ca_keys" name:"certificates.trusted_ca_keys"`
+	// Principals map certificates' principals to usernames, each as
+	// 'principal=username'. Principals that aren't mapped are usernames.
+	Principals []string `yaml:"principals" name:"certificates.principals" validate:"dive,contains=="`
+}
+
+// RateLimits limit each address and each user. Each is a token bucket, of
+// up to its burst at once, refilled at its rate a minute; a rate of zero is
+// no limit.
+type RateLimits struct {
+	Connections     int `yaml:"connections" name:"rate_limits.connections" validate:"min=0"`
+	ConnectionBurst int `yaml:"connection_burst" name:"rate_limits.connection_burst" validate:"min=1"`
+	Commands        int `yaml:"commands" name:"rate_limits.commands" validate:"min=0"`
+	CommandBurst    int `yaml:"command_burst" name:"rate_limits.command_burst" validate:"min=1"`
+	// Failures are failed authentications, and running out of them bans the
+	// address or user for BanDuration.
+	Failures     int           `yaml:"failures" name:"rate_limits.failures" validate:"min=0"`
+	FailureBurst int           `yaml:"failure_burst" name:"rate_limits.failure_burst" validate:"min=1"`
+	BanDuration  time.Duration `yaml:"ban_duration" name:"rate_limits.ban_duration" validate:"min=0"`
+}
+
+// Timeouts limit how long each command can run for, after which its queries
+// and calls are cancelled, as they are when its session ends.
+type Timeouts struct {
+	// Command is how long commands can run for, or 0 for no limit but the
+	// session's.
+	Command time.Duration `yaml:"command" name:"timeouts.command" validate:"min=0"`
+	// Commands override Command for particular commands, each as
+	// 'command=duration', e.g. 'user register=2m'. The override for the
+	// longest matching command is used.
+	Commands []string `yaml:"commands" name:"timeouts.commands" validate:"dive,contains=="`
+}
+
+// For is how long the command in a session's args can run for.
+func (t Timeouts) For(args []string) time.Duration {
+	overrides, err := t.overrides()
+	if err != nil {
+		return t.Command
+	}
+
+	var words []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			break
+		}
+		words = append(words, arg)
+	}
+
+	timeout, matched := t.Command, 0
+
+	for command, duration := range overrides {
+		commandWords := strings.Fields(command)
+
+		if len(commandWords) > len(words) || len(commandWords) <= matched {
+			continue
+		}
+
+		if slices.Equal(commandWords, words[:len(commandWords)]) {
+			timeout, matched = duration, len(commandWords)
+		}
+	}
+
+	return timeout
+}
+
+func (t Timeouts) overrides() (map[string]time.Duration, error) {
+	overrides := map[string]time.Duration{}
+
+	for _, override := range t.Commands {
+		command, value, _ := strings.Cut(override, "=")
+
+		duration, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || strings.TrimSpace(command) == "" || duration < 0 {
+			return nil, fmt.Errorf("invalid command timeout '%s', expected 'command=duration'", override)
+		}
+
+		overrides[strings.Join(strings.Fields(command), " ")] = duration
+	}
+
+	return overrides, nil
+}
+
+// Tracing is where traces of sessions, and the calls they make, are sent.
+type Tracing struct {
+	// Exporter is 'otlp' to send spans to an OpenTelemetry collector,
+	// 'stdout' to write them to stdout, or empty not to trace.
+	Exporter string `yaml:"exporter" name:"tracing.exporter" validate:"omitempty,oneof=otlp stdout"`
+	// Endpoint is the URL of the collector, e.g. http://localhost:4318. If
+	// it's empty, the OTEL_EXPORTER_OTLP_* environment variables are used.
+	Endpoint string `yaml:"endpoint" name:"tracing.endpoint"`
+}
+
+// Log is what the server logs, and how.
+type Log struct {
+	Level string `yaml:"level" name:"log.level" validate:"oneof=trace debug info warn error"`
+	// Format is 'json', one object a line, or 'console' to be read by people.
+	Format string `yaml:"format" name:"log.format" validate:"oneof=json console"`
+}
+
+// DefaultServer is the configuration before any flags, environment variables
+// or config file are applied.
+func DefaultServer() Server {
+	return Server{
+		Port:              23234,
+		SessionTimeout:    30 * time.Second,
+		HostKeyDir:        ".ssh",
+		HostKeyAlgorithms: []string{"ed25519", "ecdsa", "rsa"},
+		KeyAlgorithms:     slices.Clone(auth.KeyAlgorithms),
+		MinRSABits:        auth.DefaultKeyPolicy.MinRSABits,
+		Turso: Turso{
+			Group: "default",
+		},
+		Mail: Mail{
+			From: "syringe.sh <noreply@syringe.sh>",
+		},
+		RateLimits: RateLimits{
+			Connections:     30,
+			ConnectionBurst: 10,
+			Commands:        60,
+			CommandBurst:    20,
+			Failures:        1,
+			FailureBurst:    10,
+			BanDuration:     15 * time.Minute,
+		},
+		Timeouts: Timeouts{
+			Command: 20 * time.Second,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+	}
+}
+
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	// value points to the field the setting is stored in
+	value any
+}
+
+func (s *Server) settings() []setting {
+	return []setting{
+		{"host", "APP_HOST", "Host to listen on", &s.Host},
+		{"port", "APP_PORT", "Port to listen on", &s.Port},
+		{"session-timeout", "SESSION_TIMEOUT", "Longest a session can last, or 0 for no limit", &s.SessionTimeout},
+		{"host-key-dir", "HOST_KEY_DIR", "Directory of SSH host keys, which are created if they don't exist", &s.HostKeyDir},
+		{"host-key-algorithms", "HOST_KEY_ALGORITHMS", "Algorithms of the SSH host keys: ed25519, ecdsa and rsa", &s.HostKeyAlgorithms},
+		{"next-host-keys", "NEXT_HOST_KEYS", "Paths of SSH host keys being rotated in", &s.NextHostKeys},
+		{"key-algorithms", "KEY_ALGORITHMS", "Types of key users can connect with", &s.KeyAlgorithms},
+		{"min-rsa-bits", "MIN_RSA_BITS", "Size of the smallest RSA key users can connect with", &s.MinRSABits},
+		{"admin-keys", "ADMIN_KEYS", "SHA256 fingerprints of keys allowed to run admin commands", &s.AdminKeys},
+		{"metrics-addr", "METRICS_ADDR", "Address to serve metrics and health checks on over HTTP, e.g. :9090", &s.MetricsAddr},
+		{"database-url", "DATABASE_URL", "URL of the app database", &s.Database.URL},
+		{"database-token", "DATABASE_TOKEN", "Token for the app database", &s.Database.Token},
+		{"turso-org", "DATABASE_ORG", "Turso organisation user databases are created in", &s.Turso.Organization},
+		{"turso-group", "DATABASE_GROUP", "Turso group user databases are created in", &s.Turso.Group},
+		{"turso-api-token", "API_TOKEN", "Token for the Turso API", &s.Turso.APIToken},
+		{"turso-api-base-url", "API_BASE_URL", "URL the Turso API is served from", &s.Turso.APIBaseURL},
+		{"turso-fake-dir", "TURSO_FAKE_DIR", "Serve a fake Turso API, keeping databases in this directory", &s.Turso.FakeDir},
+		{"mail-from", "MAIL_FROM", "Address emails are sent from", &s.Mail.From},
+		{"smtp-addr", "SMTP_ADDR", "SMTP server emails are sent through, as host:port", &s.Mail.SMTPAddr},
+		{"smtp-username", "SMTP_USERNAME", "Username for the SMTP server", &s.Mail.SMTPUsername},
+		{"smtp-password", "SMTP_PASSWORD", "Password for the SMTP server", &s.Mail.SMTPPassword},
+		{"mail-dir", "MAIL_DIR", "Directory emails are written to, when there's no SMTP server", &s.Mail.Dir},
+		{"trusted-user-ca-keys", "TRUSTED_USER_CA_KEYS", "File of CA keys user certificates are trusted from", &s.Certificates.TrustedCAKeys},
+		{"rate-limit-connections", "RATE_LIMIT_CONNECTIONS", "Connections a minute from each address and user, or 0 for no limit", &s.RateLimits.Connections},
+		{"rate-limit-connection-burst", "RATE_LIMIT_CONNECTION_BURST", "Connections at once from each address and user", &s.RateLimits.ConnectionBurst},
+		{"rate-limit-commands", "RATE_LIMIT_COMMANDS", "Commands a minute from each address and user, or 0 for no limit", &s.RateLimits.Commands},
+		{"rate-limit-command-burst", "RATE_LIMIT_COMMAND_BURST", "Commands at once from each address and user", &s.RateLimits.CommandBurst},
+		{"rate-limit-failures", "RATE_LIMIT_FAILURES", "Failed authentications a minute from each address and user before a ban, or 0 for no limit", &s.RateLimits.Failures},
+		{"rate-limit-failure-burst", "RATE_LIMIT_FAILURE_BURST", "Failed authentications at once from each address and user before a ban", &s.RateLimits.FailureBurst},
+		{"ban-duration", "BAN_DURATION", "How long addresses and users are banned for after too many failed authentications", &s.RateLimits.BanDuration},
+		{"certificate-principals", "CERTIFICATE_PRINCIPALS", "Certificate principals mapped to usernames, as principal=username", &s.Certificates.Principals},
+		{"command-timeout", "COMMAND_TIMEOUT", "Longest a command can run for, or 0 for no limit but the session's", &s.Timeouts.Command},
+		{"command-timeouts", "COMMAND_TIMEOUTS", "Timeouts of particular commands, as command=duration, e.g. 'user register=2m'", &s.Timeouts.Commands},
+		{"tracing-exporter", "TRACING_EXPORTER", "Where traces are sent: otlp or stdout, or empty not to trace", &s.Tracing.Exporter},
+		{"otlp-endpoint", "OTLP_ENDPOINT", "URL of the OpenTelemetry collector traces are sent to", &s.Tracing.Endpoint},
+		{"log-level", "LOG_LEVEL", "Least severe level logged: trace, debug, info, warn or error", &s.Log.Level},
+		{"log-format", "LOG_FORMAT", "Format of logs: json or console", &s.Log.Format},
+	}
+}
+
+// AddServerFlags adds a flag for each setting, and '--config' for the path
+// of the config file.
+func AddServerFlags(flags *pflag.FlagSet) {
+	defaults := DefaultServer()
+
+	flags.String("config", "", fmt.Sprintf("Path of the YAML config file (env: %s)", ConfigEnv))
+
+	for _, s := range defaults.settings() {
+		usage := fmt.Sprintf("%s (env: %s)", s.usage, s.env)
+
+		switch v := s.value.(type) {
+		case *string:
+			flags.String(s.flag, *v, usage)
+		case *int:
+			flags.Int(s.flag, *v, usage)
+		case *time.Duration:
+			flags.Duration(s.flag, *v, usage)
+		case *[]string:
+			flags.StringSlice(s.flag, *v, usage)
+		}
+	}
+}
+
+// LoadServer loads and validates the configuration. Only flags that have
+// been set override the other sources.
+func LoadServer(flags *pflag.FlagSet, getenv func(key string) string) (Server, error) {
+	cfg := DefaultServer()
+
+	path := getenv(ConfigEnv)
+	if flags.Changed("config") {
+		path, _ = flags.GetString("config")
+	}
+
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return Server{}, err
+		}
+	}
+
+	for _, s := range cfg.settings() {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(value); err != nil {
+				return Server{}, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+
+		if flags.Changed(s.flag) {
+			if err := s.setFlag(flags); err != nil {
+				return Server{}, fmt.Errorf("invalid --%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	// the fake keeps the app database alongside users' databases, unless told
+	// otherwise
+	if cfg.Turso.FakeDir != "" && cfg.Database.URL == "" {
+		cfg.Database.URL = turso.FakeDatabaseURL(cfg.Turso.FakeDir, "app")
+	}
+
+	if err := validation.New().Struct(cfg); err != nil {
+		return Server{}, fmt.Errorf("invalid config:\n%w", serrors.ValidationError(err))
+	}
+
+	if _, err := cfg.Timeouts.overrides(); err != nil {
+		return Server{}, fmt.Errorf("invalid config:\n%w", err)
+	}
+
+	return cfg, nil
+}
+
+func (s *Server) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file:\n%w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(s); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file '%s':\n%w", path, err)
+	}
+
+	return nil
+}
+
+func (s setting) set(value string) error {
+	switch v := s.value.(type) {
+	case *string:
+		*v = value
+	case *int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*v = i
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*v = d
+	case *[]string:
+		*v = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s setting) setFlag(flags *pflag.FlagSet) error {
+	var err error
+
+	switch v := s.value.(type) {
+	case *string:
+		*v, err = flags.GetString(s.flag)
+	case *int:
+		*v, err = flags.GetInt(s.flag)
+	case *time.Duration:
+		*v, err = flags.GetDuration(s.flag)
+	case *[]string:
+		*v, err = flags.GetStringSlice(s.flag)
+	}
+
+	return err
+}
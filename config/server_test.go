@@ -0,0 +1,225 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nixpig/syringe.sh/config"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadServer(t *testing.T) {
+	scenarios := map[string]func(t *testing.T, flags *pflag.FlagSet, env map[string]string){
+		"test load server defaults":              testLoadServerDefaults,
+		"test load server from file":             testLoadServerFromFile,
+		"test load server env overrides file":    testLoadServerEnvOverridesFile,
+		"test load server flag overrides env":    testLoadServerFlagOverridesEnv,
+		"test load server unknown field in file": testLoadServerUnknownFieldInFile,
+		"test load server invalid env":           testLoadServerInvalidEnv,
+		"test load server missing required":      testLoadServerMissingRequired,
+		"test load server fake turso":            testLoadServerFakeTurso,
+		"test load server invalid timeout":       testLoadServerInvalidTimeout,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			flags := pflag.NewFlagSet("syringeserver", pflag.ContinueOnError)
+			config.AddServerFlags(flags)
+
+			env := map[string]string{
+				"DATABASE_URL": "libsql://app.turso.io",
+				"DATABASE_ORG": "my_cool_org",
+			}
+
+			fn(t, flags, env)
+		})
+	}
+}
+
+func getenv(env map[string]string) func(key string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "syringe.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func testLoadServerDefaults(t *testing.T, flags *pflag.FlagSet, env map[string]string) {
+	cfg, err := config.LoadServer(flags, getenv(env))
+	require.NoError(t, err)
+
+	expected := config.DefaultServer()
+	expected.Database.URL = "libsql://app.turso.io"
+	expected.Turso.Organization = "my_cool_org"
+
+	require.Equal(t, expected, cfg)
+}
+
+func testLoadServerFromFile(t *testing.T, flags *pflag.FlagSet, env map[string]string) {
+	env[config.ConfigEnv] = writeConfigFile(t, `
+port: 2222
+session_timeout: 1m
+metrics_addr: 127.0.0.1:9090
+admin_keys:
+  - SHA256:abc
+  - SHA256:def
+turso:
+  group: my_cool_group
+mail:
+  smtp_addr: localhost:25
+certificates:
+  trusted_ca_keys: /etc/ssh/user_ca.pub
+  principals:
+    - jane.doe@example.org=janedoe
+rate_limits:
+  commands: 0
+  ban_duration: 1h
+timeouts:
+  command: 10s
+  commands:
+    - user register=2m
+tracing:
+  exporter: otlp
+  endpoint: http://localhost:4318
+log:
+  level: debug
+`)
+
+	cfg, err := config.LoadServer(flags, getenv(env))
+	require.NoError(t, err)
+
+	require.Equal(t, 2222, cfg.Port)
+	require.Equal(t, time.Minute, cfg.SessionTimeout)
+	require.Equal(t, "127.0.0.1:9090", cfg.MetricsAddr)
+	require.Equal(t, []string{"SHA256:abc", "SHA256:def"}, cfg.AdminKeys)
+	require.Equal(t, "my_cool_group", cfg.Turso.Group)
+	require.Equal(t, "localhost:25", cfg.Mail.SMTPAddr)
+	require.Equal(t, "/etc/ssh/user_ca.pub", cfg.Certificates.TrustedCAKeys)
+	require.Equal(t, []string{"jane.doe@example.org=janedoe"}, cfg.Certificates.Principals)
+	require.Equal(t, 0, cfg.RateLimits.Commands)
+	require.Equal(t, time.Hour, cfg.RateLimits.BanDuration)
+	require.Equal(t, 30, cfg.RateLimits.Connections)
+	require.Equal(t, 10*time.Second, cfg.Timeouts.Command)
+	require.Equal(t, []string{"user register=2m"}, cfg.Timeouts.Commands)
+	require.Equal(t, "otlp", cfg.Tracing.Exporter)
+	require.Equal(t, "http://localhost:4318", cfg.Tracing.Endpoint)
+	require.Equal(t, "debug", cfg.Log.Level)
+	require.Equal(t, "json", cfg.Log.Format)
+	require.Equal(t, ".ssh", cfg.HostKeyDir)
+}
+
+func testLoadServerEnvOverridesFile(t *testing.T, flags *pflag.FlagSet, env map[string]string) {
+	path := writeConfigFile(t, `
+port: 2222
+database:
+  url: libsql://file.turso.io
+`)
+
+	env["APP_PORT"] = "3333"
+	env["ADMIN_KEYS"] = "SHA256:abc, SHA256:def"
+
+	require.NoError(t, flags.Parse([]string{"--config", path}))
+
+	cfg, err := config.LoadServer(flags, getenv(env))
+	require.NoError(t, err)
+
+	require.Equal(t, 3333, cfg.Port)
+	require.Equal(t, "libsql://app.turso.io", cfg.Database.URL)
+	require.Equal(t, []string{"SHA256:abc", "SHA256:def"}, cfg.AdminKeys)
+}
+
+func testLoadServerFlagOverridesEnv(t *testing.T, flags *pflag.FlagSet, env map[string]string) {
+	env["APP_PORT"] = "3333"
+	env["SESSION_TIMEOUT"] = "1m"
+
+	require.NoError(t, flags.Parse([]string{"--port", "4444", "--host-key-algorithms", "ed25519,rsa"}))
+
+	cfg, err := config.LoadServer(flags, getenv(env))
+	require.NoError(t, err)
+
+	require.Equal(t, 4444, cfg.Port)
+	require.Equal(t, []string{"ed25519", "rsa"}, cfg.HostKeyAlgorithms)
+	require.Equal(t, time.Minute, cfg.SessionTimeout)
+}
+
+func testLoadServerUnknownFieldInFile(t *testing.T, flags *pflag.FlagSet, env map[string]string) {
+	env[config.ConfigEnv] = writeConfigFile(t, "prot: 2222\n")
+
+	_, err := config.LoadServer(flags, getenv(env))
+	require.ErrorContains(t, err, "field prot not found")
+}
+
+func testLoadServerInvalidEnv(t *testing.T, flags *pflag.FlagSet, env map[string]string) {
+	env["SESSION_TIMEOUT"] = "forever"
+
+	_, err := config.LoadServer(flags, getenv(env))
+	require.ErrorContains(t, err, "invalid SESSION_TIMEOUT")
+}
+
+func testLoadServerMissingRequired(t *testing.T, flags *pflag.FlagSet, env map[string]string) {
+	delete(env, "DATABASE_URL")
+	delete(env, "DATABASE_ORG")
+	env["APP_PORT"] = "0"
+	env["HOST_KEY_ALGORITHMS"] = "ed25519,dsa"
+	env["KEY_ALGORITHMS"] = "ssh-ed25519,ssh-dss"
+	env["MIN_RSA_BITS"] = "512"
+	env["CERTIFICATE_PRINCIPALS"] = "janedoe"
+	env["TRACING_EXPORTER"] = "jaeger"
+	env["LOG_FORMAT"] = "text"
+
+	_, err := config.LoadServer(flags, getenv(env))
+	require.ErrorContains(t, err, `"port" is invalid`)
+	require.ErrorContains(t, err, `"host_key_algorithms[1]" must be one of: ed25519, ecdsa, rsa`)
+	require.ErrorContains(t, err, `"key_algorithms[1]" must be one of`)
+	require.ErrorContains(t, err, `"min_rsa_bits" is invalid`)
+	require.ErrorContains(t, err, `"certificates.principals[0]" is invalid`)
+	require.ErrorContains(t, err, `"tracing.exporter" must be one of: otlp, stdout`)
+	require.ErrorContains(t, err, `"log.format" must be one of: json, console`)
+	require.ErrorContains(t, err, `"database.url" is required`)
+	require.ErrorContains(t, err, `"turso.organization" is required`)
+}
+
+func testLoadServerFakeTurso(t *testing.T, flags *pflag.FlagSet, env map[string]string) {
+	delete(env, "DATABASE_URL")
+
+	require.NoError(t, flags.Parse([]string{"--turso-fake-dir", "tmp/turso"}))
+
+	cfg, err := config.LoadServer(flags, getenv(env))
+	require.NoError(t, err)
+
+	require.Equal(t, "tmp/turso", cfg.Turso.FakeDir)
+	require.Equal(t, "file:tmp/turso/app.db", cfg.Database.URL)
+}
+
+func testLoadServerInvalidTimeout(t *testing.T, flags *pflag.FlagSet, env map[string]string) {
+	env["COMMAND_TIMEOUTS"] = "user register=forever"
+
+	_, err := config.LoadServer(flags, getenv(env))
+	require.ErrorContains(t, err, "invalid command timeout 'user register=forever'")
+}
+
+func TestTimeoutsFor(t *testing.T) {
+	timeouts := config.Timeouts{
+		Command: 20 * time.Second,
+		Commands: []string{
+			"user=1m",
+			"user register=2m",
+			"secret  list=0s",
+		},
+	}
+
+	require.Equal(t, 20*time.Second, timeouts.For([]string{"project", "list"}))
+	require.Equal(t, 20*time.Second, timeouts.For(nil))
+	require.Equal(t, time.Minute, timeouts.For([]string{"user", "delete"}))
+	require.Equal(t, 2*time.Minute, timeouts.For([]string{"user", "register", "--email", "jane@example.org"}))
+	require.Equal(t, time.Duration(0), timeouts.For([]string{"secret", "list", "-p", "my_cool_project"}))
+	require.Equal(t, 20*time.Second, timeouts.For([]string{"secret", "--help", "list"}))
+}
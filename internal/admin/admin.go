@@ -0,0 +1,141 @@
+package admin
+
+import (
+	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/spf13/cobra"
+)
+
+func NewCmdAdmin() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "admin",
+		Short:  "Administer the service",
+		Long:   "Administer the service. Only keys in the server's admin allowlist can run these commands.",
+		Hidden: true,
+	}
+
+	return cmd
+}
+
+func NewCmdAdminUsers() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "users",
+		Aliases: []string{"u"},
+		Short:   "Manage users",
+	}
+
+	return cmd
+}
+
+func NewCmdAdminUsersList(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list [flags]",
+		Aliases: []string{"l"},
+		Short:   "List users",
+		Long:    "List users, one per line, as: USERNAME EMAIL STATUS KEYS CREATED_AT",
+		Example: "syringe admin users list",
+		Args:    cobra.NoArgs,
+		RunE:    handler,
+	}
+
+	return cmd
+}
+
+func NewCmdAdminUsersShow(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "show [flags] USERNAME",
+		Aliases: []string{"s"},
+		Short:   "Show a user, their keys and databases",
+		Example: "syringe admin users show janedoe",
+		Args:    cobra.ExactArgs(1),
+		RunE:    handler,
+	}
+
+	return cmd
+}
+
+func NewCmdAdminUsersSuspend(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "suspend [flags] USERNAME",
+		Short:   "Suspend a user",
+		Long:    "Suspend a user, so they can no longer connect. Their data is kept, and they can be reactivated from the server with 'syringeserver user reactivate USERNAME'.",
+		Example: "syringe admin users suspend janedoe",
+		Args:    cobra.ExactArgs(1),
+		RunE:    handler,
+	}
+
+	return cmd
+}
+
+func NewCmdAdminKeys() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "keys",
+		Aliases: []string{"k"},
+		Short:   "Manage keys",
+	}
+
+	return cmd
+}
+
+func NewCmdAdminKeysRevoke(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "revoke [flags] FINGERPRINT",
+		Aliases: []string{"r"},
+		Short:   "Revoke a key",
+		Long:    "Revoke a key by its SHA256 fingerprint, so it can no longer be used to connect. The database it was used with is kept.",
+		Example: "syringe admin keys revoke SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s",
+		Args:    cobra.ExactArgs(1),
+		RunE:    handler,
+	}
+
+	return cmd
+}
+
+func NewCmdAdminDatabases() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "databases",
+		Aliases: []string{"d"},
+		Short:   "Inspect databases",
+	}
+
+	return cmd
+}
+
+func NewCmdAdminDatabasesList(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list [flags]",
+		Aliases: []string{"l"},
+		Short:   "List databases",
+		Long:    "List every database in the Turso organisation, one per line, as: NAME OWNER",
+		Example: "syringe admin databases list",
+		Args:    cobra.NoArgs,
+		RunE:    handler,
+	}
+
+	return cmd
+}
+
+func NewCmdAdminDatabasesOrphans(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "orphans [flags]",
+		Aliases: []string{"o"},
+		Short:   "Find orphaned and missing databases",
+		Long:    "Cross-check the databases in the Turso organisation against users and organisations, listing databases nobody owns as 'orphaned NAME', and those somebody should own but don't exist as 'missing NAME OWNER'.",
+		Example: "syringe admin databases orphans",
+		Args:    cobra.NoArgs,
+		RunE:    handler,
+	}
+
+	return cmd
+}
+
+func NewCmdAdminStats(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "stats [flags]",
+		Short:   "Show service stats",
+		Example: "syringe admin stats",
+		Args:    cobra.NoArgs,
+		RunE:    handler,
+	}
+
+	return cmd
+}
@@ -0,0 +1,175 @@
+package admin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/spf13/cobra"
+)
+
+func NewHandlerAdminUsersList(adminService AdminService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		users, err := adminService.ListUsers(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		usersList := make([]string, len(users.Users))
+		for i, u := range users.Users {
+			usersList[i] = fmt.Sprintf(
+				"%s %s %s %d %s",
+				u.Username,
+				u.Email,
+				u.Status,
+				u.Keys,
+				u.CreatedAt,
+			)
+		}
+
+		cmd.Print(strings.Join(usersList, "\n"))
+
+		return nil
+	}
+}
+
+func NewHandlerAdminUsersShow(adminService AdminService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		user, err := adminService.ShowUser(cmd.Context(), ShowUserRequest{Username: args[0]})
+		if err != nil {
+			return err
+		}
+
+		cmd.Println(fmt.Sprintf("Username:   %s", user.User.Username))
+		cmd.Println(fmt.Sprintf("Email:      %s", user.User.Email))
+		cmd.Println(fmt.Sprintf("Status:     %s", user.User.Status))
+		cmd.Println(fmt.Sprintf("Created at: %s", user.User.CreatedAt))
+
+		cmd.Println("Keys:")
+		for i, key := range user.Keys {
+			cmd.Println(fmt.Sprintf(
+				"  %s %s (database %s, added %s)",
+				key.Fingerprint,
+				strings.Fields(key.PublicKey)[0],
+				user.Databases[i],
+				key.CreatedAt,
+			))
+		}
+
+		return nil
+	}
+}
+
+func NewHandlerAdminUsersSuspend(adminService AdminService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := adminService.SuspendUser(cmd.Context(), SuspendUserRequest{
+			Username: args[0],
+		}); err != nil {
+			return fmt.Errorf("unable to suspend user: %w", err)
+		}
+
+		cmd.Println(fmt.Sprintf("User '%s' suspended", args[0]))
+
+		return nil
+	}
+}
+
+func NewHandlerAdminKeysRevoke(adminService AdminService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		key, err := adminService.RevokeKey(cmd.Context(), RevokeKeyRequest{Fingerprint: args[0]})
+		if err != nil {
+			return fmt.Errorf("unable to revoke key: %w", err)
+		}
+
+		cmd.Println(fmt.Sprintf("Key '%s' of user '%s' revoked", key.Fingerprint, key.Username))
+
+		return nil
+	}
+}
+
+func NewHandlerAdminDatabasesList(adminService AdminService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		databases, err := adminService.ListDatabases(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		databasesList := make([]string, len(databases.Databases))
+		for i, d := range databases.Databases {
+			owner := d.Owner
+			if owner == "" {
+				owner = "-"
+			}
+
+			databasesList[i] = fmt.Sprintf("%s %s", d.Name, owner)
+		}
+
+		cmd.Print(strings.Join(databasesList, "\n"))
+
+		return nil
+	}
+}
+
+func NewHandlerAdminDatabasesOrphans(adminService AdminService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		orphans, err := adminService.Orphans(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		var orphansList []string
+
+		for _, d := range orphans.Orphaned {
+			orphansList = append(orphansList, fmt.Sprintf("orphaned %s", d.Name))
+		}
+
+		for _, d := range orphans.Missing {
+			orphansList = append(orphansList, fmt.Sprintf("missing %s %s", d.Name, d.Owner))
+		}
+
+		cmd.Print(strings.Join(orphansList, "\n"))
+
+		return nil
+	}
+}
+
+func NewHandlerAdminStats(adminService AdminService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		stats, err := adminService.Stats(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		statuses := make([]string, 0, len(stats.UsersByStatus))
+		users := 0
+
+		for status, n := range stats.UsersByStatus {
+			statuses = append(statuses, fmt.Sprintf("%s %d", status, n))
+			users += n
+		}
+
+		sort.Strings(statuses)
+
+		if len(statuses) > 0 {
+			cmd.Println(fmt.Sprintf("Users:         %d (%s)", users, strings.Join(statuses, ", ")))
+		} else {
+			cmd.Println("Users:         0")
+		}
+		cmd.Println(fmt.Sprintf("Keys:          %d", stats.Keys))
+		cmd.Println(fmt.Sprintf("Organisations: %d", stats.Orgs))
+		cmd.Println(fmt.Sprintf("Databases:     %d", stats.Databases))
+
+		if c := stats.Connections; c != nil {
+			cmd.Println(fmt.Sprintf(
+				"Connections:   %d open (%d hits, %d misses, %d evictions)",
+				c.Open,
+				c.Hits,
+				c.Misses,
+				c.Evictions,
+			))
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,303 @@
+package admin
+
+import (
+	"context"
+	"sort"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/nixpig/syringe.sh/internal/database"
+	"github.com/nixpig/syringe.sh/internal/user"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/nixpig/syringe.sh/pkg/turso"
+	"github.com/nixpig/syringe.sh/pkg/validation"
+)
+
+type ShowUserRequest struct {
+	Username string `name:"username" validate:"required,max=256"`
+}
+
+type ShowUserResponse struct {
+	User User
+	Keys []Key
+	// Databases are the names of the user's databases, one for each of their
+	// keys.
+	Databases []string
+}
+
+type SuspendUserRequest struct {
+	Username string `name:"username" validate:"required,max=256"`
+}
+
+type RevokeKeyRequest struct {
+	Fingerprint string `name:"fingerprint" validate:"required,startswith=SHA256:,max=64"`
+}
+
+type ListUsersResponse struct {
+	Users []User
+}
+
+type Database struct {
+	Name string
+	// Owner is who the database belongs to, as 'user:USERNAME' or
+	// 'org:ORG_NAME', or empty if nobody.
+	Owner string
+}
+
+type ListDatabasesResponse struct {
+	Databases []Database
+}
+
+type OrphansResponse struct {
+	// Orphaned are databases that don't belong to anybody.
+	Orphaned []Database
+	// Missing are databases that belong to somebody, but don't exist.
+	Missing []Database
+}
+
+type StatsResponse struct {
+	UsersByStatus map[string]int
+	Keys          int
+	Orgs          int
+	Databases     int
+	Connections   *database.ConnectionStats
+}
+
+type AdminService interface {
+	ListUsers(ctx context.Context) (*ListUsersResponse, error)
+	ShowUser(ctx context.Context, request ShowUserRequest) (*ShowUserResponse, error)
+	SuspendUser(ctx context.Context, request SuspendUserRequest) error
+	RevokeKey(ctx context.Context, request RevokeKeyRequest) (*Key, error)
+	ListDatabases(ctx context.Context) (*ListDatabasesResponse, error)
+	Orphans(ctx context.Context) (*OrphansResponse, error)
+	Stats(ctx context.Context) (*StatsResponse, error)
+}
+
+type AdminServiceImpl struct {
+	store       AdminStore
+	validate    validation.Validator
+	userService user.UserService
+	api         turso.TursoDatabaseAPI
+	connections *database.ConnectionManager
+}
+
+type Option func(a *AdminServiceImpl)
+
+// WithConnections includes the stats of the pool of user database
+// connections in Stats.
+func WithConnections(connections *database.ConnectionManager) Option {
+	return func(a *AdminServiceImpl) {
+		a.connections = connections
+	}
+}
+
+func NewAdminServiceImpl(
+	store AdminStore,
+	validate validation.Validator,
+	userService user.UserService,
+	api turso.TursoDatabaseAPI,
+	options ...Option,
+) AdminService {
+	a := AdminServiceImpl{
+		store:       store,
+		validate:    validate,
+		userService: userService,
+		api:         api,
+	}
+
+	for _, option := range options {
+		option(&a)
+	}
+
+	return a
+}
+
+func (a AdminServiceImpl) ListUsers(ctx context.Context) (*ListUsersResponse, error) {
+	users, err := a.store.ListUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListUsersResponse{Users: users}, nil
+}
+
+func (a AdminServiceImpl) ShowUser(ctx context.Context, request ShowUserRequest) (*ShowUserResponse, error) {
+	if err := a.validate.Struct(request); err != nil {
+		return nil, serrors.ValidationError(err)
+	}
+
+	user, err := a.store.GetUser(ctx, request.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := a.store.GetUserKeys(ctx, request.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	databases := make([]string, 0, len(keys))
+	for _, key := range keys {
+		name, err := databaseName(key)
+		if err != nil {
+			return nil, err
+		}
+
+		databases = append(databases, name)
+	}
+
+	return &ShowUserResponse{
+		User:      *user,
+		Keys:      keys,
+		Databases: databases,
+	}, nil
+}
+
+func (a AdminServiceImpl) SuspendUser(ctx context.Context, request SuspendUserRequest) error {
+	if err := a.validate.Struct(request); err != nil {
+		return serrors.ValidationError(err)
+	}
+
+	_, err := a.userService.SetUserStatus(ctx, user.SetUserStatusRequest{
+		Username: request.Username,
+		Status:   user.StatusSuspended,
+	})
+
+	return err
+}
+
+// RevokeKey deletes a key, so it can no longer be used to connect. The
+// database it was used to connect to is kept.
+func (a AdminServiceImpl) RevokeKey(ctx context.Context, request RevokeKeyRequest) (*Key, error) {
+	if err := a.validate.Struct(request); err != nil {
+		return nil, serrors.ValidationError(err)
+	}
+
+	return a.store.DeleteKey(ctx, request.Fingerprint)
+}
+
+// ListDatabases lists every database in the Turso organisation, along with
+// who it belongs to.
+func (a AdminServiceImpl) ListDatabases(ctx context.Context) (*ListDatabasesResponse, error) {
+	databases, err := a.api.ListDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	owners, err := a.owners(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make([]Database, len(databases.Databases))
+	for i, d := range databases.Databases {
+		listed[i] = Database{Name: d.Name, Owner: owners[d.Name]}
+	}
+
+	return &ListDatabasesResponse{Databases: listed}, nil
+}
+
+// Orphans cross-checks the databases in the Turso organisation against the
+// users and organisations they belong to, finding those nobody owns and
+// those that should exist but don't.
+func (a AdminServiceImpl) Orphans(ctx context.Context) (*OrphansResponse, error) {
+	databases, err := a.api.ListDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	owners, err := a.owners(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans OrphansResponse
+
+	exists := map[string]bool{}
+
+	for _, d := range databases.Databases {
+		exists[d.Name] = true
+
+		if owners[d.Name] == "" {
+			orphans.Orphaned = append(orphans.Orphaned, Database{Name: d.Name})
+		}
+	}
+
+	for name, owner := range owners {
+		if !exists[name] {
+			orphans.Missing = append(orphans.Missing, Database{Name: name, Owner: owner})
+		}
+	}
+
+	sort.Slice(orphans.Missing, func(i, j int) bool {
+		return orphans.Missing[i].Name < orphans.Missing[j].Name
+	})
+
+	return &orphans, nil
+}
+
+// owners maps the name of every database that should exist to who it
+// belongs to.
+func (a AdminServiceImpl) owners(ctx context.Context) (map[string]string, error) {
+	keys, err := a.store.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs, err := a.store.ListOrgDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := map[string]string{}
+
+	for _, key := range keys {
+		name, err := databaseName(key)
+		if err != nil {
+			return nil, err
+		}
+
+		owners[name] = "user:" + key.Username
+	}
+
+	for _, org := range orgs {
+		owners[org.DatabaseName] = "org:" + org.Name
+	}
+
+	return owners, nil
+}
+
+func (a AdminServiceImpl) Stats(ctx context.Context) (*StatsResponse, error) {
+	counts, err := a.store.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	databases, err := a.api.ListDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := StatsResponse{
+		UsersByStatus: counts.UsersByStatus,
+		Keys:          counts.Keys,
+		Orgs:          counts.Orgs,
+		Databases:     len(databases.Databases),
+	}
+
+	if a.connections != nil {
+		connectionStats := a.connections.Stats()
+		stats.Connections = &connectionStats
+	}
+
+	return &stats, nil
+}
+
+// databaseName is the name of the database a user connects to with a key.
+func databaseName(key Key) (string, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+	if err != nil {
+		return "", err
+	}
+
+	return database.UserDBName(publicKey), nil
+}
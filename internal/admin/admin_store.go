@@ -0,0 +1,306 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+)
+
+type User struct {
+	ID        int
+	Username  string
+	Email     string
+	Status    string
+	CreatedAt string
+	Keys      int
+}
+
+type Key struct {
+	ID          int
+	Username    string
+	PublicKey   string
+	Fingerprint string
+	CreatedAt   string
+}
+
+type OrgDatabase struct {
+	Name         string
+	DatabaseName string
+}
+
+type Counts struct {
+	UsersByStatus map[string]int
+	Keys          int
+	Orgs          int
+}
+
+type AdminStore interface {
+	ListUsers(ctx context.Context) ([]User, error)
+	GetUser(ctx context.Context, username string) (*User, error)
+	ListKeys(ctx context.Context) ([]Key, error)
+	GetUserKeys(ctx context.Context, username string) ([]Key, error)
+	DeleteKey(ctx context.Context, fingerprint string) (*Key, error)
+	ListOrgDatabases(ctx context.Context) ([]OrgDatabase, error)
+	Count(ctx context.Context) (*Counts, error)
+}
+
+type SqliteAdminStore struct {
+	appDB *sql.DB
+}
+
+func NewSqliteAdminStore(appDB *sql.DB) SqliteAdminStore {
+	return SqliteAdminStore{appDB}
+}
+
+const userColumns = `
+	u.id_, u.username_, u.email_, u.status_, u.created_at_, count(k.id_)
+	from users_ u
+	left join
+	keys_ k
+	on k.user_id_ = u.id_
+`
+
+func (s SqliteAdminStore) ListUsers(ctx context.Context) ([]User, error) {
+	query := `select ` + userColumns + `
+		group by u.id_
+		order by u.id_
+	`
+
+	rows, err := s.appDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	defer rows.Close()
+
+	var users []User
+
+	for rows.Next() {
+		var user User
+
+		if err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+			&user.Status,
+			&user.CreatedAt,
+			&user.Keys,
+		); err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return users, nil
+}
+
+// GetUser gets a user and how many keys they have. It returns
+// serrors.ErrUserNotFound if there isn't one.
+func (s SqliteAdminStore) GetUser(ctx context.Context, username string) (*User, error) {
+	query := `select ` + userColumns + `
+		where u.username_ = $username
+		group by u.id_
+	`
+
+	var user User
+
+	if err := s.appDB.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("username", username),
+	).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Status,
+		&user.CreatedAt,
+		&user.Keys,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, serrors.ErrUserNotFound
+		}
+
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return &user, nil
+}
+
+const keyColumns = `
+	k.id_, u.username_, k.ssh_public_key_, k.fingerprint_, k.created_at_
+	from keys_ k
+	inner join
+	users_ u
+	on k.user_id_ = u.id_
+`
+
+func (s SqliteAdminStore) ListKeys(ctx context.Context) ([]Key, error) {
+	query := `select ` + keyColumns + `
+		order by k.id_
+	`
+
+	return s.queryKeys(ctx, query)
+}
+
+func (s SqliteAdminStore) GetUserKeys(ctx context.Context, username string) ([]Key, error) {
+	query := `select ` + keyColumns + `
+		where u.username_ = $username
+		order by k.id_
+	`
+
+	return s.queryKeys(ctx, query, sql.Named("username", username))
+}
+
+func (s SqliteAdminStore) queryKeys(ctx context.Context, query string, args ...any) ([]Key, error) {
+	rows, err := s.appDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	defer rows.Close()
+
+	var keys []Key
+
+	for rows.Next() {
+		var key Key
+
+		if err := rows.Scan(
+			&key.ID,
+			&key.Username,
+			&key.PublicKey,
+			&key.Fingerprint,
+			&key.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return keys, nil
+}
+
+// DeleteKey deletes a key by its SHA256 fingerprint, returning what was
+// deleted. It returns serrors.ErrKeyNotFound if there isn't one.
+func (s SqliteAdminStore) DeleteKey(ctx context.Context, fingerprint string) (*Key, error) {
+	query := `
+		delete from keys_
+		where fingerprint_ = $fingerprint
+		returning
+		id_,
+		(select u.username_ from users_ u where u.id_ = keys_.user_id_),
+		ssh_public_key_,
+		fingerprint_,
+		created_at_
+	`
+
+	var key Key
+
+	if err := s.appDB.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("fingerprint", fingerprint),
+	).Scan(
+		&key.ID,
+		&key.Username,
+		&key.PublicKey,
+		&key.Fingerprint,
+		&key.CreatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, serrors.ErrKeyNotFound
+		}
+
+		return nil, serrors.ErrDatabaseExec(err)
+	}
+
+	return &key, nil
+}
+
+func (s SqliteAdminStore) ListOrgDatabases(ctx context.Context) ([]OrgDatabase, error) {
+	query := `
+		select name_, database_name_
+		from orgs_
+		order by id_
+	`
+
+	rows, err := s.appDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	defer rows.Close()
+
+	var orgs []OrgDatabase
+
+	for rows.Next() {
+		var org OrgDatabase
+
+		if err := rows.Scan(&org.Name, &org.DatabaseName); err != nil {
+			return nil, err
+		}
+
+		orgs = append(orgs, org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return orgs, nil
+}
+
+func (s SqliteAdminStore) Count(ctx context.Context) (*Counts, error) {
+	usersQuery := `
+		select status_, count(*)
+		from users_
+		group by status_
+	`
+
+	rows, err := s.appDB.QueryContext(ctx, usersQuery)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	defer rows.Close()
+
+	counts := Counts{UsersByStatus: map[string]int{}}
+
+	for rows.Next() {
+		var status string
+		var n int
+
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+
+		counts.UsersByStatus[status] = n
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	totalsQuery := `
+		select (select count(*) from keys_), (select count(*) from orgs_)
+	`
+
+	if err := s.appDB.QueryRowContext(ctx, totalsQuery).Scan(
+		&counts.Keys,
+		&counts.Orgs,
+	); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return &counts, nil
+}
@@ -0,0 +1,474 @@
+package admin_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/nixpig/syringe.sh/internal/admin"
+	"github.com/nixpig/syringe.sh/internal/database"
+	"github.com/nixpig/syringe.sh/internal/user"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/nixpig/syringe.sh/pkg/turso"
+	"github.com/nixpig/syringe.sh/pkg/validation"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+type mockUserService struct {
+	user.UserService
+	statuses map[string]string
+}
+
+func (m *mockUserService) SetUserStatus(
+	ctx context.Context,
+	statusDetails user.SetUserStatusRequest,
+) (*user.SetUserStatusResponse, error) {
+	m.statuses[statusDetails.Username] = statusDetails.Status
+
+	return &user.SetUserStatusResponse{
+		Username: statusDetails.Username,
+		Status:   statusDetails.Status,
+	}, nil
+}
+
+const (
+	listUsersQuery = `
+		select
+		u.id_, u.username_, u.email_, u.status_, u.created_at_, count(k.id_)
+		from users_ u
+		left join
+		keys_ k
+		on k.user_id_ = u.id_
+		group by u.id_
+		order by u.id_
+	`
+
+	getUserQuery = `
+		select
+		u.id_, u.username_, u.email_, u.status_, u.created_at_, count(k.id_)
+		from users_ u
+		left join
+		keys_ k
+		on k.user_id_ = u.id_
+		where u.username_ = $username
+		group by u.id_
+	`
+
+	listKeysQuery = `
+		select
+		k.id_, u.username_, k.ssh_public_key_, k.fingerprint_, k.created_at_
+		from keys_ k
+		inner join
+		users_ u
+		on k.user_id_ = u.id_
+		order by k.id_
+	`
+
+	getUserKeysQuery = `
+		select
+		k.id_, u.username_, k.ssh_public_key_, k.fingerprint_, k.created_at_
+		from keys_ k
+		inner join
+		users_ u
+		on k.user_id_ = u.id_
+		where u.username_ = $username
+		order by k.id_
+	`
+
+	deleteKeyQuery = `
+		delete from keys_
+		where fingerprint_ = $fingerprint
+		returning
+	`
+
+	listOrgDatabasesQuery = `
+		select name_, database_name_
+		from orgs_
+		order by id_
+	`
+
+	countUsersQuery = `
+		select status_, count(*)
+		from users_
+		group by status_
+	`
+
+	countTotalsQuery = `
+		select (select count(*) from keys_), (select count(*) from orgs_)
+	`
+)
+
+var (
+	userColumns = []string{"id_", "username_", "email_", "status_", "created_at_", "keys"}
+	keyColumns  = []string{"id_", "username_", "ssh_public_key_", "fingerprint_", "created_at_"}
+)
+
+func TestAdminCmd(t *testing.T) {
+	scenarios := map[string]func(
+		t *testing.T,
+		cmd *cobra.Command,
+		mock sqlmock.Sqlmock,
+		server *turso.FakeServer,
+		userService *mockUserService,
+	){
+		"test admin command not admin":           testAdminCmdNotAdmin,
+		"test admin users list command":          testAdminUsersListCmd,
+		"test admin users show command":          testAdminUsersShowCmd,
+		"test admin users show command missing":  testAdminUsersShowCmdMissing,
+		"test admin users suspend command":       testAdminUsersSuspendCmd,
+		"test admin keys revoke command":         testAdminKeysRevokeCmd,
+		"test admin keys revoke command missing": testAdminKeysRevokeCmdMissing,
+		"test admin keys revoke command invalid": testAdminKeysRevokeCmdInvalid,
+		"test admin databases list command":      testAdminDatabasesListCmd,
+		"test admin databases orphans command":   testAdminDatabasesOrphansCmd,
+		"test admin stats command":               testAdminStatsCmd,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			server := turso.NewFakeServer("my_cool_org", "api_token")
+			defer server.Close()
+
+			api := server.TursoClient()
+
+			userService := &mockUserService{statuses: map[string]string{}}
+
+			service := admin.NewAdminServiceImpl(
+				admin.NewSqliteAdminStore(db),
+				validation.New(),
+				userService,
+				&api,
+			)
+
+			cmd := newCmdAdmin(service)
+			cmd.SetContext(context.WithValue(context.Background(), ctxkeys.Admin, true))
+
+			fn(t, cmd, mock, server, userService)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func newCmdAdmin(service admin.AdminService) *cobra.Command {
+	cmd := admin.NewCmdAdmin()
+	cmd.PersistentPreRunE = admin.PreRunE
+
+	cmdUsers := admin.NewCmdAdminUsers()
+	cmdUsers.AddCommand(admin.NewCmdAdminUsersList(admin.NewHandlerAdminUsersList(service)))
+	cmdUsers.AddCommand(admin.NewCmdAdminUsersShow(admin.NewHandlerAdminUsersShow(service)))
+	cmdUsers.AddCommand(admin.NewCmdAdminUsersSuspend(admin.NewHandlerAdminUsersSuspend(service)))
+	cmd.AddCommand(cmdUsers)
+
+	cmdKeys := admin.NewCmdAdminKeys()
+	cmdKeys.AddCommand(admin.NewCmdAdminKeysRevoke(admin.NewHandlerAdminKeysRevoke(service)))
+	cmd.AddCommand(cmdKeys)
+
+	cmdDatabases := admin.NewCmdAdminDatabases()
+	cmdDatabases.AddCommand(admin.NewCmdAdminDatabasesList(admin.NewHandlerAdminDatabasesList(service)))
+	cmdDatabases.AddCommand(admin.NewCmdAdminDatabasesOrphans(admin.NewHandlerAdminDatabasesOrphans(service)))
+	cmd.AddCommand(cmdDatabases)
+
+	cmd.AddCommand(admin.NewCmdAdminStats(admin.NewHandlerAdminStats(service)))
+
+	return cmd
+}
+
+func execute(t *testing.T, cmd *cobra.Command, args ...string) (string, error) {
+	cmdOut := bytes.NewBufferString("")
+
+	cmd.SetArgs(args)
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(cmdOut)
+
+	err := cmd.Execute()
+
+	return cmdOut.String(), err
+}
+
+func newPublicKey(t *testing.T) gossh.PublicKey {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := gossh.NewPublicKey(publicKey)
+	require.NoError(t, err)
+
+	return key
+}
+
+func keyRow(rows *sqlmock.Rows, id int, username string, key gossh.PublicKey) *sqlmock.Rows {
+	return rows.AddRow(
+		id,
+		username,
+		string(gossh.MarshalAuthorizedKey(key)),
+		gossh.FingerprintSHA256(key),
+		"2024-06-01",
+	)
+}
+
+func testAdminCmdNotAdmin(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	cmd.SetContext(context.WithValue(context.Background(), ctxkeys.Admin, false))
+
+	_, err := execute(t, cmd, "users", "suspend", "janedoe")
+	require.ErrorIs(t, err, serrors.ErrNotAdmin)
+	require.Empty(t, userService.statuses)
+}
+
+func testAdminUsersListCmd(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	mock.ExpectQuery(regexp.QuoteMeta(listUsersQuery)).
+		WillReturnRows(
+			sqlmock.NewRows(userColumns).
+				AddRow(1, "janedoe", "jane@example.org", "active", "2024-06-01", 1).
+				AddRow(2, "johndoe", "john@example.org", "suspended", "2024-06-02", 2),
+		)
+
+	out, err := execute(t, cmd, "users", "list")
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		"janedoe jane@example.org active 1 2024-06-01\njohndoe john@example.org suspended 2 2024-06-02",
+		out,
+	)
+}
+
+func testAdminUsersShowCmd(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	key := newPublicKey(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.NewRows(userColumns).
+				AddRow(1, "janedoe", "jane@example.org", "pending", "2024-06-01", 1),
+		)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeysQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(keyRow(sqlmock.NewRows(keyColumns), 1, "janedoe", key))
+
+	out, err := execute(t, cmd, "users", "show", "janedoe")
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		"Username:   janedoe\n"+
+			"Email:      jane@example.org\n"+
+			"Status:     pending\n"+
+			"Created at: 2024-06-01\n"+
+			"Keys:\n"+
+			"  "+gossh.FingerprintSHA256(key)+" ssh-ed25519 (database "+database.UserDBName(key)+", added 2024-06-01)\n",
+		out,
+	)
+}
+
+func testAdminUsersShowCmdMissing(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(sqlmock.NewRows(userColumns))
+
+	_, err := execute(t, cmd, "users", "show", "janedoe")
+	require.ErrorIs(t, err, serrors.ErrUserNotFound)
+}
+
+func testAdminUsersSuspendCmd(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	out, err := execute(t, cmd, "users", "suspend", "janedoe")
+	require.NoError(t, err)
+	require.Equal(t, "User 'janedoe' suspended\n", out)
+	require.Equal(t, map[string]string{"janedoe": user.StatusSuspended}, userService.statuses)
+}
+
+func testAdminKeysRevokeCmd(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	key := newPublicKey(t)
+	fingerprint := gossh.FingerprintSHA256(key)
+
+	mock.ExpectQuery(regexp.QuoteMeta(deleteKeyQuery)).
+		WithArgs(fingerprint).
+		WillReturnRows(keyRow(sqlmock.NewRows(keyColumns), 1, "janedoe", key))
+
+	out, err := execute(t, cmd, "keys", "revoke", fingerprint)
+	require.NoError(t, err)
+	require.Equal(t, "Key '"+fingerprint+"' of user 'janedoe' revoked\n", out)
+}
+
+func testAdminKeysRevokeCmdMissing(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	fingerprint := gossh.FingerprintSHA256(newPublicKey(t))
+
+	mock.ExpectQuery(regexp.QuoteMeta(deleteKeyQuery)).
+		WithArgs(fingerprint).
+		WillReturnRows(sqlmock.NewRows(keyColumns))
+
+	_, err := execute(t, cmd, "keys", "revoke", fingerprint)
+	require.ErrorIs(t, err, serrors.ErrKeyNotFound)
+}
+
+func testAdminKeysRevokeCmdInvalid(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	_, err := execute(t, cmd, "keys", "revoke", "janedoe")
+	require.EqualError(t, err, `unable to revoke key: "fingerprint" is invalid`)
+}
+
+// expectOwners expects janedoe to have a key, and my_cool_org to have a
+// database, returning the name of janedoe's database.
+func expectOwners(t *testing.T, mock sqlmock.Sqlmock) string {
+	key := newPublicKey(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(listKeysQuery)).
+		WillReturnRows(keyRow(sqlmock.NewRows(keyColumns), 1, "janedoe", key))
+
+	mock.ExpectQuery(regexp.QuoteMeta(listOrgDatabasesQuery)).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"name_", "database_name_"}).
+				AddRow("my_cool_org", "org_db"),
+		)
+
+	return database.UserDBName(key)
+}
+
+func createDatabases(t *testing.T, server *turso.FakeServer, names ...string) {
+	api := server.TursoClient()
+
+	for _, name := range names {
+		_, err := api.CreateDatabase(context.Background(), name, "default")
+		require.NoError(t, err)
+	}
+}
+
+func testAdminDatabasesListCmd(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	userDB := expectOwners(t, mock)
+
+	createDatabases(t, server, userDB, "org_db", "stray_db")
+
+	out, err := execute(t, cmd, "databases", "list")
+	require.NoError(t, err)
+	require.ElementsMatch(
+		t,
+		[]string{userDB + " user:janedoe", "org_db org:my_cool_org", "stray_db -"},
+		regexp.MustCompile("\n").Split(out, -1),
+	)
+}
+
+func testAdminDatabasesOrphansCmd(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	userDB := expectOwners(t, mock)
+
+	createDatabases(t, server, userDB, "stray_db")
+
+	out, err := execute(t, cmd, "databases", "orphans")
+	require.NoError(t, err)
+	require.Equal(t, "orphaned stray_db\nmissing org_db org:my_cool_org", out)
+}
+
+func testAdminStatsCmd(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	userService *mockUserService,
+) {
+	mock.ExpectQuery(regexp.QuoteMeta(countUsersQuery)).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"status_", "count"}).
+				AddRow("active", 2).
+				AddRow("pending", 1),
+		)
+
+	mock.ExpectQuery(regexp.QuoteMeta(countTotalsQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"keys", "orgs"}).AddRow(3, 1))
+
+	createDatabases(t, server, "user_db", "org_db")
+
+	out, err := execute(t, cmd, "stats")
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		"Users:         3 (active 2, pending 1)\n"+
+			"Keys:          3\n"+
+			"Organisations: 1\n"+
+			"Databases:     2\n",
+		out,
+	)
+}
+
+func TestAllowlist(t *testing.T) {
+	admin1 := newPublicKey(t)
+	admin2 := newPublicKey(t)
+
+	allowlist := admin.ParseAllowlist(
+		" " + gossh.FingerprintSHA256(admin1) + ", " + gossh.FingerprintSHA256(admin2) + ",",
+	)
+
+	require.Len(t, allowlist, 2)
+	require.True(t, allowlist.Allows(admin1))
+	require.True(t, allowlist.Allows(admin2))
+	require.False(t, allowlist.Allows(newPublicKey(t)))
+	require.False(t, allowlist.Allows(nil))
+	require.Empty(t, admin.ParseAllowlist(""))
+}
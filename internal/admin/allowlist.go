@@ -0,0 +1,39 @@
+package admin
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Allowlist is the keys, by SHA256 fingerprint, allowed to run admin
+// commands.
+type Allowlist map[string]bool
+
+// ParseAllowlist parses a comma-separated list of SHA256 fingerprints, as
+// printed by 'ssh-keygen -l'.
+func ParseAllowlist(s string) Allowlist {
+	return NewAllowlist(strings.Split(s, ",")...)
+}
+
+// NewAllowlist allows the keys with the SHA256 fingerprints.
+func NewAllowlist(fingerprints ...string) Allowlist {
+	allowlist := Allowlist{}
+
+	for _, fingerprint := range fingerprints {
+		if fingerprint = strings.TrimSpace(fingerprint); fingerprint != "" {
+			allowlist[fingerprint] = true
+		}
+	}
+
+	return allowlist
+}
+
+func (a Allowlist) Allows(publicKey ssh.PublicKey) bool {
+	if publicKey == nil {
+		return false
+	}
+
+	return a[gossh.FingerprintSHA256(publicKey)]
+}
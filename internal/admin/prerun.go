@@ -0,0 +1,15 @@
+package admin
+
+import (
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/spf13/cobra"
+)
+
+func PreRunE(cmd *cobra.Command, args []string) error {
+	if admin, ok := cmd.Context().Value(ctxkeys.Admin).(bool); !ok || !admin {
+		return serrors.ErrNotAdmin
+	}
+
+	return nil
+}
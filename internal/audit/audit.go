@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/spf13/cobra"
+)
+
+func NewCmdAudit() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "audit",
+		Aliases: []string{"a"},
+		Short:   "Inspect the audit log",
+		Long: `Inspect the log of every secret read and change.
+
+Entries are chained by hash, so changing an entry, or removing one followed by others, can be detected with 'audit verify'.`,
+	}
+
+	return cmd
+}
+
+func NewCmdAuditList(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list [flags]",
+		Aliases: []string{"l"},
+		Short:   "List audit log entries",
+		Example: "syringe audit list --since 7d -p my_cool_project -k SECRET_KEY",
+		Args:    cobra.MatchAll(cobra.ExactArgs(0)),
+		RunE:    handler,
+	}
+
+	cmd.Flags().String("since", "24h", "Only entries since a duration ago (e.g. 12h or 7d) or a date (e.g. 2006-01-02)")
+	cmd.Flags().StringP("project", "p", "", "Project name")
+	cmd.Flags().StringP("key", "k", "", "Secret key")
+
+	return cmd
+}
+
+// NewCmdAuditVerify checks each entry's hash and its link to the entry
+// before it. The head of the chain isn't recorded anywhere else, so removing
+// the newest entries leaves a shorter chain that still verifies; only the
+// count of entries it reports shows they're missing.
+func NewCmdAuditVerify(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "verify",
+		Aliases: []string{"v"},
+		Short:   "Verify the audit log hasn't been tampered with",
+		Long: `Verify no entry in the audit log has been changed, or removed from before another.
+
+The newest entries can be removed without breaking the chain, so compare the number of entries verified with an earlier run.`,
+		Example: "syringe audit verify",
+		Args:    cobra.MatchAll(cobra.ExactArgs(0)),
+		RunE:    handler,
+	}
+
+	return cmd
+}
@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/nixpig/syringe.sh/internal/policy"
+	"github.com/nixpig/syringe.sh/internal/secret"
+)
+
+// NewSecretService records every call to the secret service in the audit log,
+// including calls that are denied or fail. It should wrap the policy
+// decorator so that denials are recorded too.
+func NewSecretService(
+	next secret.SecretService,
+	auditService AuditService,
+	actor Actor,
+	subject policy.Subject,
+	command string,
+) secret.SecretService {
+	return secretService{
+		next:         next,
+		auditService: auditService,
+		actor:        actor,
+		subject:      subject,
+		command:      command,
+	}
+}
+
+type secretService struct {
+	next         secret.SecretService
+	auditService AuditService
+	actor        Actor
+	subject      policy.Subject
+	command      string
+}
+
+func (s secretService) CreateTables(ctx context.Context) error {
+	return s.next.CreateTables(ctx)
+}
+
+func (s secretService) Set(ctx context.Context, request secret.SetSecretRequest) error {
+	err := s.next.Set(ctx, request)
+
+	return s.record(ctx, "set", request.Project, request.Environment, request.Key, err)
+}
+
+func (s secretService) Get(ctx context.Context, request secret.GetSecretRequest) (*secret.GetSecretResponse, error) {
+	res, err := s.next.Get(ctx, request)
+
+	if err := s.record(ctx, "get", request.Project, request.Environment, request.Key, err); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (s secretService) List(ctx context.Context, request secret.ListSecretsRequest) (*secret.ListSecretsResponse, error) {
+	res, err := s.next.List(ctx, request)
+
+	if err := s.record(ctx, "list", request.Project, request.Environment, "", err); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (s secretService) ListOverdue(ctx context.Context) (*secret.ListOverdueSecretsResponse, error) {
+	res, err := s.next.ListOverdue(ctx)
+
+	if err := s.record(ctx, "stale", "", "", "", err); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (s secretService) Remove(ctx context.Context, request secret.RemoveSecretRequest) error {
+	err := s.next.Remove(ctx, request)
+
+	return s.record(ctx, "remove", request.Project, request.Environment, request.Key, err)
+}
+
+// record appends the outcome of a call and passes its error on. Secrets are
+// never handed back if the access can't be recorded. The outcome's recorded
+// even if the session's ended or the command's run out of time, so the log
+// doesn't miss calls that were cut short.
+func (s secretService) record(ctx context.Context, op, projectName, environmentName, key string, callErr error) error {
+	ctx = context.WithoutCancel(ctx)
+
+	command := s.command
+	if command == "" {
+		command = "secret " + op
+	}
+
+	if err := s.auditService.Record(ctx, RecordRequest{
+		Actor:       s.actor,
+		Subject:     s.subject,
+		Command:     command,
+		Project:     projectName,
+		Environment: environmentName,
+		Key:         key,
+		Outcome:     Outcome(callErr),
+	}); err != nil {
+		return err
+	}
+
+	return callErr
+}
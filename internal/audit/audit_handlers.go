@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nixpig/syringe.sh/internal/policy"
+	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/nixpig/syringe.sh/pkg/helpers"
+	"github.com/spf13/cobra"
+)
+
+func NewHandlerAuditList(auditService AuditService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		since, _ := cmd.Flags().GetString("since")
+		project, _ := cmd.Flags().GetString("project")
+		key, _ := cmd.Flags().GetString("key")
+
+		sinceTime, err := parseSince(since, time.Now())
+		if err != nil {
+			return err
+		}
+
+		entries, err := auditService.List(cmd.Context(), ListAuditRequest{
+			Subject: subjectFromCmd(cmd),
+			Since:   sinceTime,
+			Project: project,
+			Key:     key,
+		})
+		if err != nil {
+			return err
+		}
+
+		entriesList := make([]string, len(entries.Entries))
+		for i, e := range entries.Entries {
+			target := strings.Trim(
+				strings.Join([]string{e.Project, e.Environment, e.Key}, "/"),
+				"/",
+			)
+
+			entriesList[i] = fmt.Sprintf(
+				"%s %s %s %s %s %s %s",
+				e.CreatedAt,
+				e.Actor,
+				e.KeyFingerprint,
+				e.RemoteAddress,
+				strings.ReplaceAll(e.Command, " ", "_"),
+				target,
+				e.Outcome,
+			)
+		}
+
+		cmd.Print(strings.Join(entriesList, "\n"))
+
+		return nil
+	}
+}
+
+func NewHandlerAuditVerify(auditService AuditService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		res, err := auditService.Verify(cmd.Context(), VerifyAuditRequest{
+			Subject: subjectFromCmd(cmd),
+		})
+		if err != nil {
+			return err
+		}
+
+		cmd.Println(fmt.Sprintf("Audit log verified (%d entries)", res.Entries))
+
+		return nil
+	}
+}
+
+func subjectFromCmd(cmd *cobra.Command) policy.Subject {
+	subject := policy.SubjectFromCmd(cmd)
+	subject.SharedBy, _ = cmd.Flags().GetString("shared-by")
+
+	return subject
+}
+
+// parseSince accepts either a duration before now, e.g. '12h' or '7d', or a
+// date, e.g. '2006-01-02'.
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, since); err == nil {
+		return t, nil
+	}
+
+	d, err := helpers.ParseDuration(since)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q (use a duration, e.g. 7d, or a date, e.g. 2006-01-02)", since)
+	}
+
+	return now.Add(-d), nil
+}
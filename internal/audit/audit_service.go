@@ -0,0 +1,263 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nixpig/syringe.sh/internal/policy"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/nixpig/syringe.sh/pkg/validation"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+)
+
+// timeLayout has fixed width so that timestamps sort and compare as strings.
+const timeLayout = "2006-01-02T15:04:05.000000Z"
+
+// Actor is who, and from where, an audited call was made.
+type Actor struct {
+	Username       string
+	KeyFingerprint string
+	RemoteAddress  string
+	SessionID      string
+}
+
+type RecordRequest struct {
+	Actor       Actor
+	Subject     policy.Subject
+	Command     string
+	Project     string
+	Environment string
+	Key         string
+	Outcome     string
+}
+
+type ListAuditRequest struct {
+	Subject policy.Subject
+	Since   time.Time
+	Project string `name:"project name" validate:"max=256"`
+	Key     string `name:"secret key" validate:"max=256"`
+}
+
+type EntryResponse struct {
+	ID             int
+	Actor          string
+	KeyFingerprint string
+	RemoteAddress  string
+	SessionID      string
+	Command        string
+	Project        string
+	Environment    string
+	Key            string
+	Outcome        string
+	CreatedAt      string
+}
+
+type ListAuditResponse struct {
+	Entries []EntryResponse
+}
+
+type VerifyAuditRequest struct {
+	Subject policy.Subject
+}
+
+type VerifyAuditResponse struct {
+	Entries int
+}
+
+type AuditService interface {
+	Record(ctx context.Context, request RecordRequest) error
+	List(ctx context.Context, request ListAuditRequest) (*ListAuditResponse, error)
+	Verify(ctx context.Context, request VerifyAuditRequest) (*VerifyAuditResponse, error)
+}
+
+func NewAuditServiceImpl(
+	store AuditStore,
+	validate validation.Validator,
+	policyService policy.PolicyService,
+) AuditService {
+	return AuditServiceImpl{
+		store:         store,
+		validate:      validate,
+		policyService: policyService,
+	}
+}
+
+type AuditServiceImpl struct {
+	store         AuditStore
+	validate      validation.Validator
+	policyService policy.PolicyService
+}
+
+func (a AuditServiceImpl) Record(ctx context.Context, request RecordRequest) error {
+	scope, err := a.scope(ctx, request.Subject)
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.store.Append(ctx, Entry{
+		Scope:          scope,
+		Actor:          request.Actor.Username,
+		KeyFingerprint: request.Actor.KeyFingerprint,
+		RemoteAddress:  request.Actor.RemoteAddress,
+		SessionID:      request.Actor.SessionID,
+		Command:        request.Command,
+		Project:        request.Project,
+		Environment:    request.Environment,
+		Key:            request.Key,
+		Outcome:        request.Outcome,
+		CreatedAt:      time.Now().UTC().Format(timeLayout),
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (a AuditServiceImpl) List(ctx context.Context, request ListAuditRequest) (*ListAuditResponse, error) {
+	if err := a.validate.Struct(request); err != nil {
+		return nil, serrors.ValidationError(err)
+	}
+
+	if err := a.authorize(ctx, request.Subject); err != nil {
+		return nil, err
+	}
+
+	scope, err := a.scope(ctx, request.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := a.store.List(
+		ctx,
+		scope,
+		request.Since.UTC().Format(timeLayout),
+		request.Project,
+		request.Key,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var entriesResponseList []EntryResponse
+
+	for _, e := range *entries {
+		entriesResponseList = append(entriesResponseList, EntryResponse{
+			ID:             e.ID,
+			Actor:          e.Actor,
+			KeyFingerprint: e.KeyFingerprint,
+			RemoteAddress:  e.RemoteAddress,
+			SessionID:      e.SessionID,
+			Command:        e.Command,
+			Project:        e.Project,
+			Environment:    e.Environment,
+			Key:            e.Key,
+			Outcome:        e.Outcome,
+			CreatedAt:      e.CreatedAt,
+		})
+	}
+
+	return &ListAuditResponse{Entries: entriesResponseList}, nil
+}
+
+func (a AuditServiceImpl) Verify(ctx context.Context, request VerifyAuditRequest) (*VerifyAuditResponse, error) {
+	if err := a.authorize(ctx, request.Subject); err != nil {
+		return nil, err
+	}
+
+	scope, err := a.scope(ctx, request.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := a.store.ListAll(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var prevHash string
+
+	for _, e := range *entries {
+		if e.PrevHash != prevHash || e.ComputeHash() != e.Hash {
+			return nil, fmt.Errorf("%w at entry %d", serrors.ErrAuditChainBroken, e.ID)
+		}
+
+		prevHash = e.Hash
+	}
+
+	return &VerifyAuditResponse{Entries: len(*entries)}, nil
+}
+
+// authorize allows subjects to audit their own database, or an
+// organisation's if they administer it.
+func (a AuditServiceImpl) authorize(ctx context.Context, subject policy.Subject) error {
+	if !subject.Authenticated {
+		return serrors.ErrNotAuthenticated
+	}
+
+	if subject.SharedBy != "" {
+		return serrors.ErrPermissionDenied
+	}
+
+	if subject.Org != "" {
+		return a.policyService.Authorize(ctx, policy.AuthorizeRequest{
+			Subject: subject,
+			Action:  policy.ActionAdmin,
+		})
+	}
+
+	return nil
+}
+
+// scope identifies the database that a subject's calls are made against,
+// which is what audit entries are grouped and chained by. It's the id of the
+// user or organisation rather than their name, since names are freed when
+// they're deleted, and whoever takes one next mustn't get its log. Calls
+// made for a user or organisation that doesn't exist are kept apart from
+// every other's.
+func (a AuditServiceImpl) scope(ctx context.Context, subject policy.Subject) (string, error) {
+	if subject.Org != "" {
+		id, err := a.store.GetOrgID(ctx, subject.Org)
+		if errors.Is(err, serrors.ErrOrgNotFound) {
+			return "unknown_org:" + subject.Org, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("org_id:%d", id), nil
+	}
+
+	username := subject.Username
+	if subject.SharedBy != "" {
+		username = subject.SharedBy
+	}
+
+	id, err := a.store.GetUserID(ctx, username)
+	if errors.Is(err, serrors.ErrUserNotFound) {
+		return "unknown_user:" + username, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("user_id:%d", id), nil
+}
+
+// Outcome classifies the result of an audited call.
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, serrors.ErrPermissionDenied), errors.Is(err, serrors.ErrNotAuthenticated):
+		return OutcomeDenied
+	default:
+		return OutcomeFailure
+	}
+}
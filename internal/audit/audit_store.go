@@ -0,0 +1,255 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+)
+
+type Entry struct {
+	ID             int
+	Scope          string
+	Actor          string
+	KeyFingerprint string
+	RemoteAddress  string
+	SessionID      string
+	Command        string
+	Project        string
+	Environment    string
+	Key            string
+	Outcome        string
+	CreatedAt      string
+	PrevHash       string
+	Hash           string
+}
+
+// ComputeHash chains the entry to the one before it in the same scope, so
+// that removing or changing an entry breaks the chain after it.
+func (e Entry) ComputeHash() string {
+	fields := strings.Join([]string{
+		e.PrevHash,
+		e.Scope,
+		e.Actor,
+		e.KeyFingerprint,
+		e.RemoteAddress,
+		e.SessionID,
+		e.Command,
+		e.Project,
+		e.Environment,
+		e.Key,
+		e.Outcome,
+		e.CreatedAt,
+	}, "\x1f")
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fields)))
+}
+
+type AuditStore interface {
+	Append(ctx context.Context, entry Entry) (*Entry, error)
+	List(ctx context.Context, scope, since, project, key string) (*[]Entry, error)
+	ListAll(ctx context.Context, scope string) (*[]Entry, error)
+	GetUserID(ctx context.Context, username string) (int, error)
+	GetOrgID(ctx context.Context, orgName string) (int, error)
+}
+
+type SqliteAuditStore struct {
+	appDB *sql.DB
+}
+
+func NewSqliteAuditStore(appDB *sql.DB) SqliteAuditStore {
+	return SqliteAuditStore{appDB}
+}
+
+func (s SqliteAuditStore) Append(ctx context.Context, entry Entry) (*Entry, error) {
+	lastHashQuery := `
+		select hash_ from audit_
+		where scope_ = $scope
+		order by id_ desc
+		limit 1
+	`
+
+	insertQuery := `
+		insert into audit_ (
+			scope_, actor_, key_fingerprint_, remote_address_, session_id_, command_,
+			project_, environment_, secret_key_, outcome_, created_at_, prev_hash_, hash_
+		) values (
+			$scope, $actor, $keyFingerprint, $remoteAddress, $sessionID, $command,
+			$project, $environment, $key, $outcome, $createdAt, $prevHash, $hash
+		)
+		returning id_
+	`
+
+	// reading the previous hash and appending must happen together, or two
+	// sessions could chain onto the same entry
+	trx, err := s.appDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, serrors.ErrDatabaseExec(err)
+	}
+
+	defer trx.Rollback()
+
+	if err := trx.QueryRowContext(
+		ctx,
+		lastHashQuery,
+		sql.Named("scope", entry.Scope),
+	).Scan(&entry.PrevHash); err != nil && err != sql.ErrNoRows {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	entry.Hash = entry.ComputeHash()
+
+	if err := trx.QueryRowContext(
+		ctx,
+		insertQuery,
+		sql.Named("scope", entry.Scope),
+		sql.Named("actor", entry.Actor),
+		sql.Named("keyFingerprint", entry.KeyFingerprint),
+		sql.Named("remoteAddress", entry.RemoteAddress),
+		sql.Named("sessionID", entry.SessionID),
+		sql.Named("command", entry.Command),
+		sql.Named("project", entry.Project),
+		sql.Named("environment", entry.Environment),
+		sql.Named("key", entry.Key),
+		sql.Named("outcome", entry.Outcome),
+		sql.Named("createdAt", entry.CreatedAt),
+		sql.Named("prevHash", entry.PrevHash),
+		sql.Named("hash", entry.Hash),
+	).Scan(&entry.ID); err != nil {
+		return nil, serrors.ErrDatabaseExec(err)
+	}
+
+	if err := trx.Commit(); err != nil {
+		return nil, serrors.ErrDatabaseExec(err)
+	}
+
+	return &entry, nil
+}
+
+func (s SqliteAuditStore) List(ctx context.Context, scope, since, project, key string) (*[]Entry, error) {
+	query := `
+		select id_, scope_, actor_, key_fingerprint_, remote_address_, session_id_, command_,
+		project_, environment_, secret_key_, outcome_, created_at_, prev_hash_, hash_
+		from audit_
+		where scope_ = $scope
+		and created_at_ >= $since
+		and ($project = '' or project_ = $project)
+		and ($key = '' or secret_key_ = $key)
+		order by id_
+	`
+
+	rows, err := s.appDB.QueryContext(
+		ctx,
+		query,
+		sql.Named("scope", scope),
+		sql.Named("since", since),
+		sql.Named("project", project),
+		sql.Named("key", key),
+	)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return scanEntries(rows)
+}
+
+func (s SqliteAuditStore) ListAll(ctx context.Context, scope string) (*[]Entry, error) {
+	query := `
+		select id_, scope_, actor_, key_fingerprint_, remote_address_, session_id_, command_,
+		project_, environment_, secret_key_, outcome_, created_at_, prev_hash_, hash_
+		from audit_
+		where scope_ = $scope
+		order by id_
+	`
+
+	rows, err := s.appDB.QueryContext(ctx, query, sql.Named("scope", scope))
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return scanEntries(rows)
+}
+
+func (s SqliteAuditStore) GetUserID(ctx context.Context, username string) (int, error) {
+	query := `
+		select id_ from users_ where username_ = $username
+	`
+
+	var id int
+
+	if err := s.appDB.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("username", username),
+	).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, serrors.ErrUserNotFound
+		}
+
+		return 0, serrors.ErrDatabaseQuery(err)
+	}
+
+	return id, nil
+}
+
+func (s SqliteAuditStore) GetOrgID(ctx context.Context, orgName string) (int, error) {
+	query := `
+		select id_ from orgs_ where name_ = $orgName
+	`
+
+	var id int
+
+	if err := s.appDB.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+	).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, serrors.ErrOrgNotFound
+		}
+
+		return 0, serrors.ErrDatabaseQuery(err)
+	}
+
+	return id, nil
+}
+
+func scanEntries(rows *sql.Rows) (*[]Entry, error) {
+	defer rows.Close()
+
+	var entries []Entry
+
+	for rows.Next() {
+		var entry Entry
+
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.Scope,
+			&entry.Actor,
+			&entry.KeyFingerprint,
+			&entry.RemoteAddress,
+			&entry.SessionID,
+			&entry.Command,
+			&entry.Project,
+			&entry.Environment,
+			&entry.Key,
+			&entry.Outcome,
+			&entry.CreatedAt,
+			&entry.PrevHash,
+			&entry.Hash,
+		); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return &entries, nil
+}
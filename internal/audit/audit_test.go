@@ -0,0 +1,480 @@
+package audit_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/nixpig/syringe.sh/internal/audit"
+	"github.com/nixpig/syringe.sh/internal/policy"
+	"github.com/nixpig/syringe.sh/internal/secret"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/nixpig/syringe.sh/pkg/validation"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+const lastHashQuery = `
+	select hash_ from audit_
+	where scope_ = $scope
+	order by id_ desc
+	limit 1
+`
+
+const insertQuery = `
+	insert into audit_ (
+		scope_, actor_, key_fingerprint_, remote_address_, session_id_, command_,
+		project_, environment_, secret_key_, outcome_, created_at_, prev_hash_, hash_
+	) values (
+		$scope, $actor, $keyFingerprint, $remoteAddress, $sessionID, $command,
+		$project, $environment, $key, $outcome, $createdAt, $prevHash, $hash
+	)
+	returning id_
+`
+
+const listAllQuery = `
+	select id_, scope_, actor_, key_fingerprint_, remote_address_, session_id_, command_,
+	project_, environment_, secret_key_, outcome_, created_at_, prev_hash_, hash_
+	from audit_
+	where scope_ = $scope
+	order by id_
+`
+
+var entryColumns = []string{
+	"id_", "scope_", "actor_", "key_fingerprint_", "remote_address_", "session_id_", "command_",
+	"project_", "environment_", "secret_key_", "outcome_", "created_at_", "prev_hash_", "hash_",
+}
+
+const userIDQuery = `
+	select id_ from users_ where username_ = $username
+`
+
+func expectUserID(mock sqlmock.Sqlmock, id int) {
+	mock.ExpectQuery(regexp.QuoteMeta(userIDQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(sqlmock.NewRows([]string{"id_"}).AddRow(id))
+}
+
+var subject = policy.Subject{Username: "janedoe", Authenticated: true}
+
+var actor = audit.Actor{
+	Username:       "janedoe",
+	KeyFingerprint: "SHA256:abc",
+	RemoteAddress:  "127.0.0.1:2222",
+	SessionID:      "session_id",
+}
+
+func TestAudit(t *testing.T) {
+	scenarios := map[string]func(
+		t *testing.T,
+		mock sqlmock.Sqlmock,
+		db *sql.DB,
+		service audit.AuditService,
+	){
+		"test append chains to previous entry":    testAppendChainsToPreviousEntry,
+		"test secret get records outcome":         testSecretGetRecordsOutcome,
+		"test secret get records denied outcome":  testSecretGetRecordsDeniedOutcome,
+		"test verify intact chain":                testVerifyIntactChain,
+		"test verify detects removed entry":       testVerifyDetectsRemovedEntry,
+		"test verify denied for shared databases": testVerifyDeniedForShared,
+		"test audit list command":                 testAuditListCmd,
+		"test audit list command invalid since":   testAuditListCmdInvalidSince,
+		"test verify scoped by account not name":  testVerifyScopedByAccount,
+		"test record for unknown user":            testRecordForUnknownUser,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			service := audit.NewAuditServiceImpl(
+				audit.NewSqliteAuditStore(db),
+				validation.New(),
+				policy.NewPolicyServiceImpl(policy.NewSqlitePolicyStore(db), validation.New()),
+			)
+
+			fn(t, mock, db, service)
+		})
+	}
+}
+
+// chain builds entries that are correctly chained together.
+func chain(entries ...audit.Entry) []audit.Entry {
+	var prevHash string
+
+	for i := range entries {
+		entries[i].ID = i + 1
+		entries[i].PrevHash = prevHash
+		entries[i].Hash = entries[i].ComputeHash()
+		prevHash = entries[i].Hash
+	}
+
+	return entries
+}
+
+func entryRows(entries []audit.Entry) *sqlmock.Rows {
+	rows := sqlmock.NewRows(entryColumns)
+
+	for _, e := range entries {
+		rows.AddRow(
+			e.ID, e.Scope, e.Actor, e.KeyFingerprint, e.RemoteAddress, e.SessionID, e.Command,
+			e.Project, e.Environment, e.Key, e.Outcome, e.CreatedAt, e.PrevHash, e.Hash,
+		)
+	}
+
+	return rows
+}
+
+func entry(key, createdAt string) audit.Entry {
+	return audit.Entry{
+		Scope:          "user_id:1",
+		Actor:          "janedoe",
+		KeyFingerprint: "SHA256:abc",
+		RemoteAddress:  "127.0.0.1:2222",
+		SessionID:      "session_id",
+		Command:        "secret get",
+		Project:        "my_cool_project",
+		Environment:    "dev",
+		Key:            key,
+		Outcome:        audit.OutcomeSuccess,
+		CreatedAt:      createdAt,
+	}
+}
+
+func testAppendChainsToPreviousEntry(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service audit.AuditService,
+) {
+	e := entry("SECRET_KEY", "2024-06-01T12:00:00.000000Z")
+	e.PrevHash = "previous_hash"
+	hash := e.ComputeHash()
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(regexp.QuoteMeta(lastHashQuery)).
+		WithArgs("user_id:1").
+		WillReturnRows(sqlmock.NewRows([]string{"hash_"}).AddRow("previous_hash"))
+
+	mock.ExpectQuery(regexp.QuoteMeta(insertQuery)).
+		WithArgs(
+			"user_id:1", "janedoe", "SHA256:abc", "127.0.0.1:2222", "session_id", "secret get",
+			"my_cool_project", "dev", "SECRET_KEY", "success", "2024-06-01T12:00:00.000000Z",
+			"previous_hash", hash,
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id_"}).AddRow(2))
+
+	mock.ExpectCommit()
+
+	appended, err := audit.NewSqliteAuditStore(db).Append(context.Background(), e)
+
+	require.NoError(t, err)
+	require.Equal(t, 2, appended.ID)
+	require.Equal(t, hash, appended.Hash)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+type secretServiceStub struct {
+	secret.SecretService
+	err error
+}
+
+func (s secretServiceStub) Get(ctx context.Context, request secret.GetSecretRequest) (*secret.GetSecretResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return &secret.GetSecretResponse{Key: request.Key, Value: "secret_value"}, nil
+}
+
+func expectRecord(mock sqlmock.Sqlmock, outcome string) {
+	expectUserID(mock, 1)
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(regexp.QuoteMeta(lastHashQuery)).
+		WithArgs("user_id:1").
+		WillReturnError(sql.ErrNoRows)
+
+	mock.ExpectQuery(regexp.QuoteMeta(insertQuery)).
+		WithArgs(
+			"user_id:1", "janedoe", "SHA256:abc", "127.0.0.1:2222", "session_id", "secret get",
+			"my_cool_project", "dev", "SECRET_KEY", outcome, sqlmock.AnyArg(),
+			"", sqlmock.AnyArg(),
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id_"}).AddRow(1))
+
+	mock.ExpectCommit()
+}
+
+func testSecretGetRecordsOutcome(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service audit.AuditService,
+) {
+	expectRecord(mock, audit.OutcomeSuccess)
+
+	secretService := audit.NewSecretService(secretServiceStub{}, service, actor, subject, "")
+
+	res, err := secretService.Get(context.Background(), secret.GetSecretRequest{
+		Project:     "my_cool_project",
+		Environment: "dev",
+		Key:         "SECRET_KEY",
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "secret_value", res.Value)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testSecretGetRecordsDeniedOutcome(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service audit.AuditService,
+) {
+	expectRecord(mock, audit.OutcomeDenied)
+
+	secretService := audit.NewSecretService(
+		secretServiceStub{err: serrors.ErrPermissionDenied},
+		service,
+		actor,
+		subject,
+		"",
+	)
+
+	res, err := secretService.Get(context.Background(), secret.GetSecretRequest{
+		Project:     "my_cool_project",
+		Environment: "dev",
+		Key:         "SECRET_KEY",
+	})
+
+	require.ErrorIs(t, err, serrors.ErrPermissionDenied)
+	require.Nil(t, res)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testVerifyIntactChain(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service audit.AuditService,
+) {
+	entries := chain(
+		entry("KEY_1", "2024-06-01T12:00:00.000000Z"),
+		entry("KEY_2", "2024-06-01T12:01:00.000000Z"),
+		entry("KEY_3", "2024-06-01T12:02:00.000000Z"),
+	)
+
+	expectUserID(mock, 1)
+
+	mock.ExpectQuery(regexp.QuoteMeta(listAllQuery)).
+		WithArgs("user_id:1").
+		WillReturnRows(entryRows(entries))
+
+	res, err := service.Verify(context.Background(), audit.VerifyAuditRequest{
+		Subject: subject,
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 3, res.Entries)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testVerifyDetectsRemovedEntry(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service audit.AuditService,
+) {
+	entries := chain(
+		entry("KEY_1", "2024-06-01T12:00:00.000000Z"),
+		entry("KEY_2", "2024-06-01T12:01:00.000000Z"),
+		entry("KEY_3", "2024-06-01T12:02:00.000000Z"),
+	)
+
+	expectUserID(mock, 1)
+
+	mock.ExpectQuery(regexp.QuoteMeta(listAllQuery)).
+		WithArgs("user_id:1").
+		WillReturnRows(entryRows([]audit.Entry{entries[0], entries[2]}))
+
+	res, err := service.Verify(context.Background(), audit.VerifyAuditRequest{
+		Subject: subject,
+	})
+
+	require.ErrorIs(t, err, serrors.ErrAuditChainBroken)
+	require.ErrorContains(t, err, "at entry 3")
+	require.Nil(t, res)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testVerifyDeniedForShared(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service audit.AuditService,
+) {
+	res, err := service.Verify(context.Background(), audit.VerifyAuditRequest{
+		Subject: policy.Subject{Username: "janedoe", SharedBy: "johndoe", Authenticated: true},
+	})
+
+	require.ErrorIs(t, err, serrors.ErrPermissionDenied)
+	require.Nil(t, res)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func newCmdAuditList(service audit.AuditService) *cobra.Command {
+	cmd := audit.NewCmdAuditList(audit.NewHandlerAuditList(service))
+	cmd.Flags().String("org", "", "")
+	cmd.Flags().String("shared-by", "", "")
+
+	ctx := context.WithValue(context.Background(), ctxkeys.Username, "janedoe")
+	ctx = context.WithValue(ctx, ctxkeys.Authenticated, true)
+	cmd.SetContext(ctx)
+
+	return cmd
+}
+
+func testAuditListCmd(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service audit.AuditService,
+) {
+	listQuery := `
+		select id_, scope_, actor_, key_fingerprint_, remote_address_, session_id_, command_,
+		project_, environment_, secret_key_, outcome_, created_at_, prev_hash_, hash_
+		from audit_
+		where scope_ = $scope
+		and created_at_ >= $since
+		and ($project = '' or project_ = $project)
+		and ($key = '' or secret_key_ = $key)
+		order by id_
+	`
+
+	entries := chain(
+		entry("SECRET_KEY", "2024-06-01T12:00:00.000000Z"),
+	)
+
+	expectUserID(mock, 1)
+
+	mock.ExpectQuery(regexp.QuoteMeta(listQuery)).
+		WithArgs("user_id:1", "2024-06-01T00:00:00.000000Z", "my_cool_project", "SECRET_KEY").
+		WillReturnRows(entryRows(entries))
+
+	cmd := newCmdAuditList(service)
+
+	cmdOut := bytes.NewBufferString("")
+	cmdErr := bytes.NewBufferString("")
+
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(cmdErr)
+	cmd.SetArgs([]string{
+		"--since", "2024-06-01",
+		"--project", "my_cool_project",
+		"--key", "SECRET_KEY",
+	})
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, cmdErr.String())
+	require.Equal(
+		t,
+		"2024-06-01T12:00:00.000000Z janedoe SHA256:abc 127.0.0.1:2222 secret_get my_cool_project/dev/SECRET_KEY success",
+		cmdOut.String(),
+	)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testAuditListCmdInvalidSince(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service audit.AuditService,
+) {
+	cmd := newCmdAuditList(service)
+
+	cmdOut := bytes.NewBufferString("")
+	cmdErr := bytes.NewBufferString("")
+
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(cmdErr)
+	cmd.SetArgs([]string{"--since", "last tuesday"})
+
+	err := cmd.Execute()
+
+	require.ErrorContains(t, err, `invalid since "last tuesday"`)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testVerifyScopedByAccount(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service audit.AuditService,
+) {
+	// janedoe was deleted, and someone else has since registered the name
+	expectUserID(mock, 2)
+
+	mock.ExpectQuery(regexp.QuoteMeta(listAllQuery)).
+		WithArgs("user_id:2").
+		WillReturnRows(sqlmock.NewRows(entryColumns))
+
+	res, err := service.Verify(context.Background(), audit.VerifyAuditRequest{
+		Subject: subject,
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 0, res.Entries)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testRecordForUnknownUser(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service audit.AuditService,
+) {
+	mock.ExpectQuery(regexp.QuoteMeta(userIDQuery)).
+		WithArgs("janedoe").
+		WillReturnError(sql.ErrNoRows)
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(regexp.QuoteMeta(lastHashQuery)).
+		WithArgs("unknown_user:janedoe").
+		WillReturnError(sql.ErrNoRows)
+
+	mock.ExpectQuery(regexp.QuoteMeta(insertQuery)).
+		WithArgs(
+			"unknown_user:janedoe", "janedoe", "SHA256:abc", "127.0.0.1:2222", "session_id", "secret get",
+			"my_cool_project", "dev", "SECRET_KEY", audit.OutcomeDenied, sqlmock.AnyArg(),
+			"", sqlmock.AnyArg(),
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id_"}).AddRow(1))
+
+	mock.ExpectCommit()
+
+	require.NoError(t, service.Record(context.Background(), audit.RecordRequest{
+		Actor:       actor,
+		Subject:     subject,
+		Command:     "secret get",
+		Project:     "my_cool_project",
+		Environment: "dev",
+		Key:         "SECRET_KEY",
+		Outcome:     audit.OutcomeDenied,
+	}))
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,68 +1,164 @@
 package auth
 
 import (
-	"fmt"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
 
 	"github.com/charmbracelet/ssh"
+	"github.com/nixpig/syringe.sh/internal/user"
 	"github.com/nixpig/syringe.sh/pkg/validation"
-	"github.com/rs/zerolog"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 type AuthenticateUserRequest struct {
-	Username  string
-	PublicKey ssh.PublicKey
+	Username   string
+	PublicKey  ssh.PublicKey
+	RemoteAddr net.Addr
 }
 
 type AuthenticateUserResponse struct {
 	Auth bool
+	// Verified is whether the user has verified their email address.
+	Verified bool
+	// PublicKey is the key the user registered with, which their database
+	// is named from. It's only set if they're authenticated, and differs
+	// from the key they connected with if that's a certificate.
+	PublicKey ssh.PublicKey
 }
 
 type AuthService interface {
-	AuthenticateUser(authDetails AuthenticateUserRequest) (*AuthenticateUserResponse, error)
+	AuthenticateUser(ctx context.Context, authDetails AuthenticateUserRequest) (*AuthenticateUserResponse, error)
 }
 
 type AuthServiceImpl struct {
-	store    AuthStore
-	validate validation.Validator
-	logger   *zerolog.Logger
+	store        AuthStore
+	validate     validation.Validator
+	certificates *CertificateAuthority
+}
+
+type Option func(a *AuthServiceImpl)
+
+// WithCertificateAuthority authenticates users by certificates it trusts,
+// as well as by their registered keys.
+func WithCertificateAuthority(certificates *CertificateAuthority) Option {
+	return func(a *AuthServiceImpl) {
+		a.certificates = certificates
+	}
 }
 
 func NewAuthService(
 	store AuthStore,
 	validate validation.Validator,
+	options ...Option,
 ) AuthServiceImpl {
-	return AuthServiceImpl{
+	a := AuthServiceImpl{
 		store:    store,
 		validate: validate,
 	}
+
+	for _, option := range options {
+		option(&a)
+	}
+
+	return a
 }
 
+// AuthenticateUser checks that a public key is registered to the user
+// connecting with it, or is a certificate for them from a trusted CA. It
+// fails with serrors.ErrAccountSuspended or serrors.ErrAccountLocked if an
+// operator has cut the user off.
 func (a AuthServiceImpl) AuthenticateUser(
+	ctx context.Context,
 	authDetails AuthenticateUserRequest,
 ) (*AuthenticateUserResponse, error) {
 	if err := a.validate.Struct(authDetails); err != nil {
 		return nil, err
 	}
 
-	publicKeysDetails, err := a.store.GetUserPublicKeys(authDetails.Username)
+	if cert, ok := authDetails.PublicKey.(*gossh.Certificate); ok {
+		return a.authenticateCertificate(ctx, authDetails, cert)
+	}
+
+	// the user is whoever the key is registered to, and the username they
+	// connected with has to be theirs
+	keyDetails, err := a.store.GetUserKeyByFingerprint(
+		ctx,
+		gossh.FingerprintSHA256(authDetails.PublicKey),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &AuthenticateUserResponse{Auth: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(keyDetails.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ssh.KeysEqual(authDetails.PublicKey, parsed) ||
+		keyDetails.Username != authDetails.Username {
+		return &AuthenticateUserResponse{Auth: false}, nil
+	}
+
+	return authenticated(keyDetails.Status, parsed)
+}
+
+// authenticateCertificate authenticates the user a certificate is for, by
+// who they are rather than by the key, which isn't registered.
+func (a AuthServiceImpl) authenticateCertificate(
+	ctx context.Context,
+	authDetails AuthenticateUserRequest,
+	cert *gossh.Certificate,
+) (*AuthenticateUserResponse, error) {
+	if a.certificates == nil {
+		return &AuthenticateUserResponse{Auth: false}, nil
+	}
+
+	if err := a.certificates.Authenticate(
+		authDetails.Username,
+		authDetails.RemoteAddr,
+		cert,
+	); err != nil {
+		return &AuthenticateUserResponse{Auth: false}, nil
+	}
+
+	keyDetails, err := a.store.GetUserKeyByUsername(ctx, authDetails.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &AuthenticateUserResponse{Auth: false}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	for _, v := range *publicKeysDetails {
-		parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(v.PublicKey))
-		if err != nil {
-			fmt.Println(" >>> failed to parse authorised key")
-			return nil, err
-		}
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(keyDetails.PublicKey))
+	if err != nil {
+		return nil, err
+	}
 
-		if ssh.KeysEqual(
-			authDetails.PublicKey,
-			parsed,
-		) {
-			return &AuthenticateUserResponse{Auth: true}, nil
-		}
+	return authenticated(keyDetails.Status, parsed)
+}
+
+// authenticated is whether a user with a status can authenticate.
+func authenticated(status string, publicKey ssh.PublicKey) (*AuthenticateUserResponse, error) {
+	if err := user.StatusError(status); err != nil {
+		return nil, err
 	}
 
-	return &AuthenticateUserResponse{Auth: false}, nil
+	switch status {
+	case user.StatusActive, user.StatusPending:
+		// users yet to verify their email address can authenticate, but are
+		// restricted in what they can do
+		return &AuthenticateUserResponse{
+			Auth:      true,
+			Verified:  status == user.StatusActive,
+			PublicKey: publicKey,
+		}, nil
+	default:
+		// users whose registration hasn't finished can't
+		return &AuthenticateUserResponse{Auth: false}, nil
+	}
 }
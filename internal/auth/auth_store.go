@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"database/sql"
 )
 
@@ -8,11 +9,14 @@ type UserKey struct {
 	ID        int
 	PublicKey string
 	UserID    int
+	Username  string
+	Status    string
 	CreatedAt string
 }
 
 type AuthStore interface {
-	GetUserPublicKeys(username string) (*[]UserKey, error)
+	GetUserKeyByFingerprint(ctx context.Context, fingerprint string) (*UserKey, error)
+	GetUserKeyByUsername(ctx context.Context, username string) (*UserKey, error)
 }
 
 type SqliteAuthStore struct {
@@ -23,40 +27,56 @@ func NewSqliteAuthStore(appDB *sql.DB) SqliteAuthStore {
 	return SqliteAuthStore{appDB}
 }
 
-func (s SqliteAuthStore) GetUserPublicKeys(username string) (*[]UserKey, error) {
+// GetUserKeyByFingerprint gets a public key, and the user it's registered
+// to, by the key's SHA256 fingerprint. It returns sql.ErrNoRows if there
+// isn't one.
+func (s SqliteAuthStore) GetUserKeyByFingerprint(ctx context.Context, fingerprint string) (*UserKey, error) {
 	query := `
-		select k.id_, k.user_id_, k.ssh_public_key_, k.created_at_
+		select k.id_, k.user_id_, u.username_, u.status_, k.ssh_public_key_, k.created_at_
 		from keys_ k 
 		inner join
 		users_ u
 		on k.user_id_ = u.id_
-		where u.username_ = $username
+		where k.fingerprint_ = $fingerprint
 	`
 
-	rows, err := s.appDB.Query(
-		query,
-		sql.Named("username", username),
-	)
-	if err != nil {
-		return nil, err
-	}
+	row := s.appDB.QueryRowContext(ctx, query, sql.Named("fingerprint", fingerprint))
+
+	return scanUserKey(row)
+}
+
+// GetUserKeyByUsername gets the first key registered to a user, which names
+// their database, and the user. It returns sql.ErrNoRows if there isn't one.
+func (s SqliteAuthStore) GetUserKeyByUsername(ctx context.Context, username string) (*UserKey, error) {
+	query := `
+		select k.id_, k.user_id_, u.username_, u.status_, k.ssh_public_key_, k.created_at_
+		from keys_ k
+		inner join
+		users_ u
+		on k.user_id_ = u.id_
+		where u.username_ = $username
+		order by k.id_
+		limit 1
+	`
 
-	var keys []UserKey
+	row := s.appDB.QueryRowContext(ctx, query, sql.Named("username", username))
 
-	for rows.Next() {
-		var key UserKey
+	return scanUserKey(row)
+}
 
-		if err := rows.Scan(
-			&key.ID,
-			&key.UserID,
-			&key.PublicKey,
-			&key.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
+func scanUserKey(row *sql.Row) (*UserKey, error) {
+	var key UserKey
 
-		keys = append(keys, key)
+	if err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Username,
+		&key.Status,
+		&key.PublicKey,
+		&key.CreatedAt,
+	); err != nil {
+		return nil, err
 	}
 
-	return &keys, nil
+	return &key, nil
 }
@@ -1,10 +1,12 @@
 package auth_test
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"database/sql"
 	"errors"
+	"net"
 	"regexp"
 	"strings"
 	"testing"
@@ -13,6 +15,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/charmbracelet/ssh"
 	"github.com/nixpig/syringe.sh/internal/auth"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
 	"github.com/nixpig/syringe.sh/pkg/validation"
 	"github.com/stretchr/testify/require"
 	gossh "golang.org/x/crypto/ssh"
@@ -21,8 +24,14 @@ import (
 func TestAuthInternalPkg(t *testing.T) {
 	scenarios := map[string]func(t *testing.T, mock sqlmock.Sqlmock, db *sql.DB, service auth.AuthService){
 		"test authenticate user with matching key":       testAuthUserWithMatchingKey,
+		"test authenticate user with security key":       testAuthUserWithSecurityKey,
+		"test authenticate unverified user":              testAuthUnverifiedUser,
 		"test authenticate user with non-matching key":   testAuthUserWithNonMatchingKey,
-		"test authenticate user with no keys":            testAuthUserWithNoKeys,
+		"test authenticate user with another user's key": testAuthUserWithAnotherUsersKey,
+		"test authenticate user with unregistered key":   testAuthUserWithUnregisteredKey,
+		"test authenticate suspended user":               testAuthSuspendedUser,
+		"test authenticate locked user":                  testAuthLockedUser,
+		"test authenticate unfinished registration":      testAuthUnfinishedRegistration,
 		"test authenticate user when key parsing errors": testAuthUserKeyParsingError,
 		"test authenticate user db query error":          testAuthUserDBQueryError,
 		"test authenticate user db scan error":           testAuthUserDBScanError,
@@ -66,6 +75,17 @@ func generatePublicKey() (ssh.PublicKey, error) {
 	return charmPublicKey, err
 }
 
+const getUserKeyByFingerprintQuery = `
+	select k.id_, k.user_id_, u.username_, u.status_, k.ssh_public_key_, k.created_at_
+	from keys_ k
+	inner join
+	users_ u
+	on k.user_id_ = u.id_
+	where k.fingerprint_ = $fingerprint
+`
+
+var userKeyColumns = []string{"id_", "user_id_", "username_", "status_", "ssh_public_key_", "created_at_"}
+
 func testAuthUserWithMatchingKey(
 	t *testing.T,
 	mock sqlmock.Sqlmock,
@@ -77,21 +97,77 @@ func testAuthUserWithMatchingKey(
 		t.Errorf("failed to generate public key: %s", err)
 	}
 
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		select k.id_, k.user_id_, k.ssh_public_key_, k.created_at_
-		from keys_ k
-		inner join
-		users_ u
-		on k.user_id_ = u.id_
-		where u.username_ = $username
-		`)).WithArgs("janedoe").
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByFingerprintQuery)).
+		WithArgs(gossh.FingerprintSHA256(key)).
+		WillReturnRows(
+			sqlmock.
+				NewRows(userKeyColumns).
+				AddRow(23, 42, "janedoe", "active", gossh.MarshalAuthorizedKey(key), time.Now().String()),
+		)
+
+	res, err := service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
+		Username:  "janedoe",
+		PublicKey: key,
+	})
+
+	require.NoError(t, err)
+
+	require.Equal(
+		t,
+		&auth.AuthenticateUserResponse{
+			Auth:      true,
+			Verified:  true,
+			PublicKey: key,
+		},
+		res,
+	)
+}
+
+func testAuthUserWithSecurityKey(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+) {
+	key := generateSKEd25519Key(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByFingerprintQuery)).
+		WithArgs(gossh.FingerprintSHA256(key)).
+		WillReturnRows(
+			sqlmock.
+				NewRows(userKeyColumns).
+				AddRow(23, 42, "janedoe", "active", gossh.MarshalAuthorizedKey(key), time.Now().String()),
+		)
+
+	res, err := service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
+		Username:  "janedoe",
+		PublicKey: key,
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, &auth.AuthenticateUserResponse{Auth: true, Verified: true, PublicKey: key}, res)
+}
+
+func testAuthUnverifiedUser(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+) {
+	key, err := generatePublicKey()
+	if err != nil {
+		t.Errorf("failed to generate public key: %s", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByFingerprintQuery)).
+		WithArgs(gossh.FingerprintSHA256(key)).
 		WillReturnRows(
 			sqlmock.
-				NewRows([]string{"id_", "user_id_", "ssh_public_key_", "created_at_"}).
-				AddRow(23, 42, gossh.MarshalAuthorizedKey(key), time.Now().String()),
+				NewRows(userKeyColumns).
+				AddRow(23, 42, "janedoe", "pending", gossh.MarshalAuthorizedKey(key), time.Now().String()),
 		)
 
-	res, err := service.AuthenticateUser(auth.AuthenticateUserRequest{
+	res, err := service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
 		Username:  "janedoe",
 		PublicKey: key,
 	})
@@ -101,7 +177,9 @@ func testAuthUserWithMatchingKey(
 	require.Equal(
 		t,
 		&auth.AuthenticateUserResponse{
-			Auth: true,
+			Auth:      true,
+			Verified:  false,
+			PublicKey: key,
 		},
 		res,
 	)
@@ -123,21 +201,15 @@ func testAuthUserWithNonMatchingKey(
 		t.Errorf("failed to generate public key: %s", err)
 	}
 
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		select k.id_, k.user_id_, k.ssh_public_key_, k.created_at_
-		from keys_ k
-		inner join
-		users_ u
-		on k.user_id_ = u.id_
-		where u.username_ = $username
-		`)).WithArgs("janedoe").
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByFingerprintQuery)).
+		WithArgs(gossh.FingerprintSHA256(key2)).
 		WillReturnRows(
 			sqlmock.
-				NewRows([]string{"id_", "user_id_", "ssh_public_key_", "created_at_"}).
-				AddRow(23, 42, gossh.MarshalAuthorizedKey(key1), time.Now().String()),
+				NewRows(userKeyColumns).
+				AddRow(23, 42, "janedoe", "active", gossh.MarshalAuthorizedKey(key1), time.Now().String()),
 		)
 
-	res, err := service.AuthenticateUser(auth.AuthenticateUserRequest{
+	res, err := service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
 		Username:  "janedoe",
 		PublicKey: key2,
 	})
@@ -153,31 +225,57 @@ func testAuthUserWithNonMatchingKey(
 	)
 }
 
-func testAuthUserWithNoKeys(
+func testAuthUserWithAnotherUsersKey(
 	t *testing.T,
 	mock sqlmock.Sqlmock,
 	db *sql.DB,
 	service auth.AuthService,
 ) {
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		select k.id_, k.user_id_, k.ssh_public_key_, k.created_at_
-		from keys_ k
-		inner join
-		users_ u
-		on k.user_id_ = u.id_
-		where u.username_ = $username
-		`)).WithArgs("janedoe").
+	key, err := generatePublicKey()
+	if err != nil {
+		t.Errorf("failed to generate public key: %s", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByFingerprintQuery)).
+		WithArgs(gossh.FingerprintSHA256(key)).
 		WillReturnRows(
 			sqlmock.
-				NewRows([]string{"id_", "user_id_", "ssh_public_key_", "created_at_"}),
+				NewRows(userKeyColumns).
+				AddRow(23, 42, "johndoe", "active", gossh.MarshalAuthorizedKey(key), time.Now().String()),
 		)
 
+	res, err := service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
+		Username:  "janedoe",
+		PublicKey: key,
+	})
+
+	require.NoError(t, err)
+
+	require.Equal(
+		t,
+		&auth.AuthenticateUserResponse{
+			Auth: false,
+		},
+		res,
+	)
+}
+
+func testAuthUserWithUnregisteredKey(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+) {
 	key, err := generatePublicKey()
 	if err != nil {
 		t.Errorf("failed to generate public key: %s", err)
 	}
 
-	res, err := service.AuthenticateUser(auth.AuthenticateUserRequest{
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByFingerprintQuery)).
+		WithArgs(gossh.FingerprintSHA256(key)).
+		WillReturnRows(sqlmock.NewRows(userKeyColumns))
+
+	res, err := service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
 		Username:  "janedoe",
 		PublicKey: key,
 	})
@@ -193,6 +291,67 @@ func testAuthUserWithNoKeys(
 	)
 }
 
+func authenticateWithStatus(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	service auth.AuthService,
+	status string,
+) (*auth.AuthenticateUserResponse, error) {
+	key, err := generatePublicKey()
+	if err != nil {
+		t.Errorf("failed to generate public key: %s", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByFingerprintQuery)).
+		WithArgs(gossh.FingerprintSHA256(key)).
+		WillReturnRows(
+			sqlmock.
+				NewRows(userKeyColumns).
+				AddRow(23, 42, "janedoe", status, gossh.MarshalAuthorizedKey(key), time.Now().String()),
+		)
+
+	return service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
+		Username:  "janedoe",
+		PublicKey: key,
+	})
+}
+
+func testAuthSuspendedUser(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+) {
+	res, err := authenticateWithStatus(t, mock, service, "suspended")
+
+	require.Nil(t, res)
+	require.ErrorIs(t, err, serrors.ErrAccountSuspended)
+}
+
+func testAuthLockedUser(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+) {
+	res, err := authenticateWithStatus(t, mock, service, "locked")
+
+	require.Nil(t, res)
+	require.ErrorIs(t, err, serrors.ErrAccountLocked)
+}
+
+func testAuthUnfinishedRegistration(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+) {
+	res, err := authenticateWithStatus(t, mock, service, "creating")
+
+	require.NoError(t, err)
+	require.Equal(t, &auth.AuthenticateUserResponse{Auth: false}, res)
+}
+
 func testAuthUserKeyParsingError(
 	t *testing.T,
 	mock sqlmock.Sqlmock,
@@ -204,21 +363,15 @@ func testAuthUserKeyParsingError(
 		t.Errorf("failed to generate public key: %s", err)
 	}
 
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		select k.id_, k.user_id_, k.ssh_public_key_, k.created_at_
-		from keys_ k
-		inner join
-		users_ u
-		on k.user_id_ = u.id_
-		where u.username_ = $username
-		`)).WithArgs("janedoe").
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByFingerprintQuery)).
+		WithArgs(gossh.FingerprintSHA256(key)).
 		WillReturnRows(
 			sqlmock.
-				NewRows([]string{"id_", "user_id_", "ssh_public_key_", "created_at_"}).
-				AddRow(23, 42, "invalid key", time.Now().String()),
+				NewRows(userKeyColumns).
+				AddRow(23, 42, "janedoe", "active", "invalid key", time.Now().String()),
 		)
 
-	res, err := service.AuthenticateUser(auth.AuthenticateUserRequest{
+	res, err := service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
 		Username:  "janedoe",
 		PublicKey: key,
 	})
@@ -234,22 +387,16 @@ func testAuthUserDBQueryError(
 	db *sql.DB,
 	service auth.AuthService,
 ) {
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		select k.id_, k.user_id_, k.ssh_public_key_, k.created_at_
-		from keys_ k
-		inner join
-		users_ u
-		on k.user_id_ = u.id_
-		where u.username_ = $username
-		`)).WithArgs("janedoe").
-		WillReturnError(errors.New("database_error"))
-
 	key, err := generatePublicKey()
 	if err != nil {
 		t.Errorf("failed to generate public key: %s", err)
 	}
 
-	res, err := service.AuthenticateUser(auth.AuthenticateUserRequest{
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByFingerprintQuery)).
+		WithArgs(gossh.FingerprintSHA256(key)).
+		WillReturnError(errors.New("database_error"))
+
+	res, err := service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
 		Username:  "janedoe",
 		PublicKey: key,
 	})
@@ -270,21 +417,15 @@ func testAuthUserDBScanError(
 		t.Errorf("failed to generate public key: %s", err)
 	}
 
-	mock.ExpectQuery(regexp.QuoteMeta(`
-		select k.id_, k.user_id_, k.ssh_public_key_, k.created_at_
-		from keys_ k
-		inner join
-		users_ u
-		on k.user_id_ = u.id_
-		where u.username_ = $username
-		`)).WithArgs("janedoe").
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByFingerprintQuery)).
+		WithArgs(gossh.FingerprintSHA256(key)).
 		WillReturnRows(
 			sqlmock.
-				NewRows([]string{"id_", "user_id_", "ssh_public_key_", "created_at_"}).
-				AddRow(23, "invalid user id to trigger scan error", gossh.MarshalAuthorizedKey(key), time.Now().String()),
+				NewRows(userKeyColumns).
+				AddRow(23, "invalid user id to trigger scan error", "janedoe", "active", gossh.MarshalAuthorizedKey(key), time.Now().String()),
 		)
 
-	res, err := service.AuthenticateUser(auth.AuthenticateUserRequest{
+	res, err := service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
 		Username:  "janedoe",
 		PublicKey: key,
 	})
@@ -293,3 +434,147 @@ func testAuthUserDBScanError(
 	require.Error(t, err)
 	require.True(t, strings.HasPrefix(err.Error(), "sql: Scan error"))
 }
+
+const getUserKeyByUsernameQuery = `
+	select k.id_, k.user_id_, u.username_, u.status_, k.ssh_public_key_, k.created_at_
+	from keys_ k
+	inner join
+	users_ u
+	on k.user_id_ = u.id_
+	where u.username_ = $username
+	order by k.id_
+	limit 1
+`
+
+func TestAuthCertificate(t *testing.T) {
+	ca := generateSigner(t)
+
+	scenarios := map[string]func(t *testing.T, mock sqlmock.Sqlmock, db *sql.DB, service auth.AuthService, ca gossh.Signer){
+		"test authenticate user with certificate":              testAuthUserWithCertificate,
+		"test authenticate unregistered user with certificate": testAuthUnregisteredUserWithCertificate,
+		"test authenticate suspended user with certificate":    testAuthSuspendedUserWithCertificate,
+		"test authenticate user with untrusted certificate":    testAuthUserWithUntrustedCertificate,
+		"test authenticate user with certificate without ca":   testAuthUserWithCertificateWithoutCA,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+
+			service := auth.NewAuthService(
+				auth.NewSqliteAuthStore(db),
+				validation.New(),
+				auth.WithCertificateAuthority(auth.NewCertificateAuthority(
+					[]gossh.PublicKey{ca.PublicKey()},
+					auth.WithCertificateClock(func() time.Time { return certificateNow }),
+				)),
+			)
+
+			fn(t, mock, db, service, ca)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func authenticateCertificate(
+	service auth.AuthService,
+	cert *gossh.Certificate,
+) (*auth.AuthenticateUserResponse, error) {
+	return service.AuthenticateUser(context.Background(), auth.AuthenticateUserRequest{
+		Username:   "janedoe",
+		PublicKey:  cert,
+		RemoteAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 23234},
+	})
+}
+
+func testAuthUserWithCertificate(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+	ca gossh.Signer,
+) {
+	registeredKey := generateEd25519Key(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByUsernameQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.
+				NewRows(userKeyColumns).
+				AddRow(23, 42, "janedoe", "active", gossh.MarshalAuthorizedKey(registeredKey), time.Now().String()),
+		)
+
+	res, err := authenticateCertificate(service, generateCertificate(t, ca, nil))
+	require.NoError(t, err)
+
+	// the user's known by the key they registered, not the certificate
+	require.Equal(t, &auth.AuthenticateUserResponse{
+		Auth:      true,
+		Verified:  true,
+		PublicKey: registeredKey,
+	}, res)
+}
+
+func testAuthUnregisteredUserWithCertificate(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+	ca gossh.Signer,
+) {
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByUsernameQuery)).
+		WithArgs("janedoe").
+		WillReturnError(sql.ErrNoRows)
+
+	res, err := authenticateCertificate(service, generateCertificate(t, ca, nil))
+	require.NoError(t, err)
+	require.Equal(t, &auth.AuthenticateUserResponse{Auth: false}, res)
+}
+
+func testAuthSuspendedUserWithCertificate(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+	ca gossh.Signer,
+) {
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeyByUsernameQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.
+				NewRows(userKeyColumns).
+				AddRow(23, 42, "janedoe", "suspended", gossh.MarshalAuthorizedKey(generateEd25519Key(t)), time.Now().String()),
+		)
+
+	res, err := authenticateCertificate(service, generateCertificate(t, ca, nil))
+	require.Nil(t, res)
+	require.ErrorIs(t, err, serrors.ErrAccountSuspended)
+}
+
+func testAuthUserWithUntrustedCertificate(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+	ca gossh.Signer,
+) {
+	res, err := authenticateCertificate(service, generateCertificate(t, generateSigner(t), nil))
+	require.NoError(t, err)
+	require.Equal(t, &auth.AuthenticateUserResponse{Auth: false}, res)
+}
+
+func testAuthUserWithCertificateWithoutCA(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	db *sql.DB,
+	service auth.AuthService,
+	ca gossh.Signer,
+) {
+	service = auth.NewAuthService(auth.NewSqliteAuthStore(db), validation.New())
+
+	res, err := authenticateCertificate(service, generateCertificate(t, ca, nil))
+	require.NoError(t, err)
+	require.Equal(t, &auth.AuthenticateUserResponse{Auth: false}, res)
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// SourceAddressOption is the critical option restricting which addresses
+	// a certificate can be used from, as comma-separated CIDRs or addresses.
+	SourceAddressOption = "source-address"
+
+	// ForceCommandOption is the critical option restricting a certificate to
+	// running a single command.
+	ForceCommandOption = "force-command"
+)
+
+// CertificateAuthority checks OpenSSH user certificates signed by trusted CA
+// keys, which authenticate the syringe user their principals map to, rather
+// than users having to register every key they're issued.
+type CertificateAuthority struct {
+	keys []gossh.PublicKey
+	// principals maps principals to usernames. Principals that aren't mapped
+	// are usernames themselves.
+	principals map[string]string
+	now        func() time.Time
+}
+
+type CertificateAuthorityOption func(c *CertificateAuthority)
+
+// WithPrincipals maps certificates' principals to the usernames they
+// authenticate.
+func WithPrincipals(principals map[string]string) CertificateAuthorityOption {
+	return func(c *CertificateAuthority) {
+		c.principals = principals
+	}
+}
+
+// WithCertificateClock sets what the time is, when checking certificates are
+// valid.
+func WithCertificateClock(now func() time.Time) CertificateAuthorityOption {
+	return func(c *CertificateAuthority) {
+		c.now = now
+	}
+}
+
+func NewCertificateAuthority(
+	keys []gossh.PublicKey,
+	options ...CertificateAuthorityOption,
+) *CertificateAuthority {
+	c := &CertificateAuthority{
+		keys:       keys,
+		principals: map[string]string{},
+		now:        time.Now,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// ParseCAKeys parses CA public keys in authorized_keys format, as in
+// OpenSSH's TrustedUserCAKeys file.
+func ParseCAKeys(b []byte) ([]gossh.PublicKey, error) {
+	var keys []gossh.PublicKey
+
+	for len(bytes.TrimSpace(b)) > 0 {
+		key, _, _, rest, err := gossh.ParseAuthorizedKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CA key:\n%w", err)
+		}
+
+		keys = append(keys, key)
+		b = rest
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no CA keys")
+	}
+
+	return keys, nil
+}
+
+// ParsePrincipals parses principal to username mappings, each as
+// 'principal=username'.
+func ParsePrincipals(mappings []string) (map[string]string, error) {
+	principals := map[string]string{}
+
+	for _, mapping := range mappings {
+		principal, username, ok := strings.Cut(mapping, "=")
+		if !ok || principal == "" || username == "" {
+			return nil, fmt.Errorf("invalid principal mapping '%s', expected 'principal=username'", mapping)
+		}
+
+		principals[principal] = username
+	}
+
+	return principals, nil
+}
+
+// Authenticate checks that a certificate authenticates the user: that it's a
+// user certificate signed by a trusted CA key, valid now, for a principal
+// that maps to the username, and used from an address it allows. Any critical
+// option other than 'source-address' and 'force-command' fails it.
+func (c *CertificateAuthority) Authenticate(
+	username string,
+	remoteAddr net.Addr,
+	cert *gossh.Certificate,
+) error {
+	if cert.CertType != gossh.UserCert {
+		return fmt.Errorf("%w: not a user certificate", serrors.ErrCertificateNotTrusted)
+	}
+
+	if !slices.ContainsFunc(c.keys, func(key gossh.PublicKey) bool {
+		return bytes.Equal(key.Marshal(), cert.SignatureKey.Marshal())
+	}) {
+		return fmt.Errorf(
+			"%w: signed by unknown CA %s",
+			serrors.ErrCertificateNotTrusted,
+			gossh.FingerprintSHA256(cert.SignatureKey),
+		)
+	}
+
+	principal, ok := c.principal(username, cert)
+	if !ok {
+		return fmt.Errorf("%w: no principal for user '%s'", serrors.ErrCertificateNotTrusted, username)
+	}
+
+	checker := gossh.CertChecker{
+		SupportedCriticalOptions: []string{SourceAddressOption, ForceCommandOption},
+		Clock:                    c.now,
+	}
+
+	// checks the principal, critical options, validity window and signature
+	if err := checker.CheckCert(principal, cert); err != nil {
+		return fmt.Errorf("%w: %w", serrors.ErrCertificateNotTrusted, err)
+	}
+
+	if sourceAddress, ok := cert.CriticalOptions[SourceAddressOption]; ok {
+		if err := checkSourceAddress(remoteAddr, sourceAddress); err != nil {
+			return fmt.Errorf("%w: %w", serrors.ErrCertificateNotTrusted, err)
+		}
+	}
+
+	return nil
+}
+
+// principal finds the certificate's principal that maps to the username.
+// Certificates without principals, which would be valid for anyone, don't
+// authenticate anyone.
+func (c *CertificateAuthority) principal(username string, cert *gossh.Certificate) (string, bool) {
+	for _, principal := range cert.ValidPrincipals {
+		mapped, ok := c.principals[principal]
+		if !ok {
+			mapped = principal
+		}
+
+		if mapped == username {
+			return principal, true
+		}
+	}
+
+	return "", false
+}
+
+// ForceCommand is the only command a certificate can run, if it's restricted
+// to one.
+func ForceCommand(key gossh.PublicKey) (string, bool) {
+	cert, ok := key.(*gossh.Certificate)
+	if !ok {
+		return "", false
+	}
+
+	command, ok := cert.CriticalOptions[ForceCommandOption]
+
+	return command, ok
+}
+
+func checkSourceAddress(remoteAddr net.Addr, sourceAddress string) error {
+	tcpAddr, ok := remoteAddr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("can't check source address of %v", remoteAddr)
+	}
+
+	for _, source := range strings.Split(sourceAddress, ",") {
+		source = strings.TrimSpace(source)
+
+		if ip := net.ParseIP(source); ip != nil {
+			if ip.Equal(tcpAddr.IP) {
+				return nil
+			}
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(source)
+		if err != nil {
+			return fmt.Errorf("invalid source address '%s'", source)
+		}
+
+		if network.Contains(tcpAddr.IP) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("source address %s not allowed", tcpAddr.IP)
+}
@@ -0,0 +1,221 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nixpig/syringe.sh/internal/auth"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var certificateNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestCertificateAuthority(t *testing.T) {
+	ca := generateSigner(t)
+	otherCA := generateSigner(t)
+
+	scenarios := map[string]struct {
+		cert     func(cert *gossh.Certificate)
+		signer   gossh.Signer
+		username string
+		addr     string
+		err      string
+	}{
+		"test certificate for username": {
+			username: "janedoe",
+		},
+		"test certificate for mapped principal": {
+			cert: func(cert *gossh.Certificate) {
+				cert.ValidPrincipals = []string{"jane.doe@example.org"}
+			},
+			username: "janedoe",
+		},
+		"test certificate for another user": {
+			username: "johndoe",
+			err:      "no principal for user 'johndoe'",
+		},
+		"test certificate for principal mapped to another user": {
+			cert: func(cert *gossh.Certificate) {
+				cert.ValidPrincipals = []string{"john.doe@example.org"}
+			},
+			username: "john.doe@example.org",
+			err:      "no principal",
+		},
+		"test certificate without principals": {
+			cert: func(cert *gossh.Certificate) {
+				cert.ValidPrincipals = nil
+			},
+			username: "janedoe",
+			err:      "no principal",
+		},
+		"test certificate from unknown ca": {
+			signer:   otherCA,
+			username: "janedoe",
+			err:      "signed by unknown CA",
+		},
+		"test host certificate": {
+			cert: func(cert *gossh.Certificate) {
+				cert.CertType = gossh.HostCert
+			},
+			username: "janedoe",
+			err:      "not a user certificate",
+		},
+		"test expired certificate": {
+			cert: func(cert *gossh.Certificate) {
+				cert.ValidBefore = uint64(certificateNow.Add(-time.Minute).Unix())
+			},
+			username: "janedoe",
+			err:      "cert has expired",
+		},
+		"test certificate not yet valid": {
+			cert: func(cert *gossh.Certificate) {
+				cert.ValidAfter = uint64(certificateNow.Add(time.Minute).Unix())
+			},
+			username: "janedoe",
+			err:      "cert is not yet valid",
+		},
+		"test certificate with unsupported critical option": {
+			cert: func(cert *gossh.Certificate) {
+				cert.CriticalOptions = map[string]string{"verify-required": ""}
+			},
+			username: "janedoe",
+			err:      "unsupported critical option",
+		},
+		"test certificate from allowed source address": {
+			cert: func(cert *gossh.Certificate) {
+				cert.CriticalOptions = map[string]string{auth.SourceAddressOption: "10.0.0.0/8,192.168.1.23"}
+			},
+			username: "janedoe",
+			addr:     "192.168.1.23",
+		},
+		"test certificate from disallowed source address": {
+			cert: func(cert *gossh.Certificate) {
+				cert.CriticalOptions = map[string]string{auth.SourceAddressOption: "10.0.0.0/8"}
+			},
+			username: "janedoe",
+			addr:     "192.168.1.23",
+			err:      "source address 192.168.1.23 not allowed",
+		},
+	}
+
+	principals, err := auth.ParsePrincipals([]string{
+		"jane.doe@example.org=janedoe",
+		"john.doe@example.org=johndoe",
+	})
+	require.NoError(t, err)
+
+	certificates := auth.NewCertificateAuthority(
+		[]gossh.PublicKey{ca.PublicKey()},
+		auth.WithPrincipals(principals),
+		auth.WithCertificateClock(func() time.Time { return certificateNow }),
+	)
+
+	for scenario, s := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			signer := s.signer
+			if signer == nil {
+				signer = ca
+			}
+
+			addr := s.addr
+			if addr == "" {
+				addr = "127.0.0.1"
+			}
+
+			cert := generateCertificate(t, signer, s.cert)
+
+			err := certificates.Authenticate(
+				s.username,
+				&net.TCPAddr{IP: net.ParseIP(addr), Port: 23234},
+				cert,
+			)
+
+			if s.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, serrors.ErrCertificateNotTrusted)
+				require.ErrorContains(t, err, s.err)
+			}
+		})
+	}
+}
+
+func TestParseCAKeys(t *testing.T) {
+	first := generateSigner(t).PublicKey()
+	second := generateSigner(t).PublicKey()
+
+	keys, err := auth.ParseCAKeys(append(
+		gossh.MarshalAuthorizedKey(first),
+		gossh.MarshalAuthorizedKey(second)...,
+	))
+	require.NoError(t, err)
+	require.Equal(t, []gossh.PublicKey{first, second}, keys)
+
+	_, err = auth.ParseCAKeys([]byte("\n"))
+	require.ErrorContains(t, err, "no CA keys")
+
+	_, err = auth.ParseCAKeys([]byte("not a key\n"))
+	require.ErrorContains(t, err, "failed to parse CA key")
+}
+
+func TestParsePrincipals(t *testing.T) {
+	principals, err := auth.ParsePrincipals([]string{"jane.doe@example.org=janedoe"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"jane.doe@example.org": "janedoe"}, principals)
+
+	_, err = auth.ParsePrincipals([]string{"janedoe"})
+	require.ErrorContains(t, err, "invalid principal mapping 'janedoe'")
+}
+
+func TestForceCommand(t *testing.T) {
+	cert := generateCertificate(t, generateSigner(t), func(cert *gossh.Certificate) {
+		cert.CriticalOptions = map[string]string{auth.ForceCommandOption: "inject -- ./app"}
+	})
+
+	command, ok := auth.ForceCommand(cert)
+	require.True(t, ok)
+	require.Equal(t, "inject -- ./app", command)
+
+	_, ok = auth.ForceCommand(cert.Key)
+	require.False(t, ok)
+}
+
+func generateSigner(t *testing.T) gossh.Signer {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := gossh.NewSignerFromKey(private)
+	require.NoError(t, err)
+
+	return signer
+}
+
+// generateCertificate signs a certificate for 'janedoe', valid for an hour
+// either side of certificateNow, after making any changes to it.
+func generateCertificate(
+	t *testing.T,
+	ca gossh.Signer,
+	change func(cert *gossh.Certificate),
+) *gossh.Certificate {
+	cert := &gossh.Certificate{
+		Key:             generateSigner(t).PublicKey(),
+		CertType:        gossh.UserCert,
+		KeyId:           "janedoe@laptop",
+		ValidPrincipals: []string{"janedoe"},
+		ValidAfter:      uint64(certificateNow.Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(certificateNow.Add(time.Hour).Unix()),
+	}
+
+	if change != nil {
+		change(cert)
+	}
+
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+
+	return cert
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"slices"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// KeyAlgorithms are the types of key OpenSSH supports that aren't
+// deprecated, including those backed by FIDO security keys.
+var KeyAlgorithms = []string{
+	gossh.KeyAlgoED25519,
+	gossh.KeyAlgoSKED25519,
+	gossh.KeyAlgoECDSA256,
+	gossh.KeyAlgoECDSA384,
+	gossh.KeyAlgoECDSA521,
+	gossh.KeyAlgoSKECDSA256,
+	gossh.KeyAlgoRSA,
+}
+
+// KeyPolicy is which keys users can connect with.
+type KeyPolicy struct {
+	// Algorithms are the types of key allowed, e.g. 'ssh-ed25519'.
+	Algorithms []string
+	// MinRSABits is the size of the smallest RSA key allowed.
+	MinRSABits int
+}
+
+var DefaultKeyPolicy = KeyPolicy{
+	Algorithms: KeyAlgorithms,
+	MinRSABits: 2048,
+}
+
+// Check returns why the key isn't allowed, if it isn't. For certificates,
+// it's the certified key that's checked.
+func (p KeyPolicy) Check(key ssh.PublicKey) error {
+	if cert, ok := key.(*gossh.Certificate); ok {
+		key = cert.Key
+	}
+
+	if !slices.Contains(p.Algorithms, key.Type()) {
+		return fmt.Errorf("%w: %s", serrors.ErrKeyAlgorithm, key.Type())
+	}
+
+	if key.Type() == gossh.KeyAlgoRSA {
+		cryptoKey, ok := key.(gossh.CryptoPublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s", serrors.ErrKeyAlgorithm, key.Type())
+		}
+
+		rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s", serrors.ErrKeyAlgorithm, key.Type())
+		}
+
+		if bits := rsaKey.N.BitLen(); bits < p.MinRSABits {
+			return fmt.Errorf("%w: %d bits, at least %d required", serrors.ErrKeyTooSmall, bits, p.MinRSABits)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/nixpig/syringe.sh/internal/auth"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestKeyPolicy(t *testing.T) {
+	scenarios := map[string]struct {
+		key    func(t *testing.T) ssh.PublicKey
+		policy auth.KeyPolicy
+		err    error
+	}{
+		"test ed25519 key": {
+			key:    generateEd25519Key,
+			policy: auth.DefaultKeyPolicy,
+		},
+		"test ecdsa p256 key": {
+			key:    generateECDSAKey(elliptic.P256()),
+			policy: auth.DefaultKeyPolicy,
+		},
+		"test ecdsa p384 key": {
+			key:    generateECDSAKey(elliptic.P384()),
+			policy: auth.DefaultKeyPolicy,
+		},
+		"test ecdsa p521 key": {
+			key:    generateECDSAKey(elliptic.P521()),
+			policy: auth.DefaultKeyPolicy,
+		},
+		"test security key ed25519 key": {
+			key:    generateSKEd25519Key,
+			policy: auth.DefaultKeyPolicy,
+		},
+		"test security key ecdsa key": {
+			key:    generateSKECDSAKey,
+			policy: auth.DefaultKeyPolicy,
+		},
+		"test rsa key": {
+			key:    generateRSAKey(2048),
+			policy: auth.DefaultKeyPolicy,
+		},
+		"test rsa key too small": {
+			key:    generateRSAKey(1024),
+			policy: auth.DefaultKeyPolicy,
+			err:    serrors.ErrKeyTooSmall,
+		},
+		"test rsa key smaller than custom minimum": {
+			key:    generateRSAKey(2048),
+			policy: auth.KeyPolicy{Algorithms: auth.KeyAlgorithms, MinRSABits: 3072},
+			err:    serrors.ErrKeyTooSmall,
+		},
+		"test algorithm not allowed": {
+			key:    generateRSAKey(2048),
+			policy: auth.KeyPolicy{Algorithms: []string{gossh.KeyAlgoED25519}},
+			err:    serrors.ErrKeyAlgorithm,
+		},
+	}
+
+	for scenario, s := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			err := s.policy.Check(s.key(t))
+
+			if s.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, s.err)
+			}
+		})
+	}
+}
+
+func generateEd25519Key(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := gossh.NewPublicKey(public)
+	require.NoError(t, err)
+
+	return key
+}
+
+func generateECDSAKey(curve elliptic.Curve) func(t *testing.T) ssh.PublicKey {
+	return func(t *testing.T) ssh.PublicKey {
+		private, err := ecdsa.GenerateKey(curve, rand.Reader)
+		require.NoError(t, err)
+
+		key, err := gossh.NewPublicKey(&private.PublicKey)
+		require.NoError(t, err)
+
+		return key
+	}
+}
+
+func generateRSAKey(bits int) func(t *testing.T) ssh.PublicKey {
+	return func(t *testing.T) ssh.PublicKey {
+		private, err := rsa.GenerateKey(rand.Reader, bits)
+		require.NoError(t, err)
+
+		key, err := gossh.NewPublicKey(&private.PublicKey)
+		require.NoError(t, err)
+
+		return key
+	}
+}
+
+// generateSKEd25519Key makes the public half of a security key, in its wire
+// format, since there's no hardware to generate one with.
+func generateSKEd25519Key(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := gossh.ParsePublicKey(gossh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{gossh.KeyAlgoSKED25519, public, "ssh:"}))
+	require.NoError(t, err)
+
+	return key
+}
+
+func generateSKECDSAKey(t *testing.T) ssh.PublicKey {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	key, err := gossh.ParsePublicKey(gossh.Marshal(struct {
+		Name        string
+		ID          string
+		Key         []byte
+		Application string
+	}{
+		gossh.KeyAlgoSKECDSA256,
+		"nistp256",
+		elliptic.Marshal(elliptic.P256(), private.X, private.Y),
+		"ssh:",
+	}))
+	require.NoError(t, err)
+
+	return key
+}
@@ -2,17 +2,20 @@ package auth
 
 import (
 	"errors"
-	"fmt"
 
 	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 )
 
 func PreRunE(cmd *cobra.Command, args []string) error {
 	authenticated, ok := cmd.Context().Value(ctxkeys.Authenticated).(bool)
 	if !ok || !authenticated {
-		fmt.Println("authenticated: ", authenticated)
-		fmt.Println("ok: ", ok)
+		zerolog.Ctx(cmd.Context()).Debug().
+			Bool("authenticated", authenticated).
+			Bool("known", ok).
+			Msg("not authenticated")
+
 		return errors.New("not authenticated")
 	}
 
@@ -16,6 +16,29 @@ import (
 )
 
 func NewHandlerCLI(host string, port int, cmdOut io.Writer) pkg.CobraHandler {
+	return newHandlerCLI(host, port, func(client *ssh.SSHClient, command string) error {
+		return client.Run(command, cmdOut)
+	})
+}
+
+// NewHandlerStdoutCLI is NewHandlerCLI for commands whose output is data,
+// such as an archive, rather than messages. Only the server's stdout is
+// written to cmdOut; its stderr goes to the command's stderr, so that
+// redirecting the output to a file doesn't capture prompts and errors.
+func NewHandlerStdoutCLI(host string, port int, cmdOut io.Writer) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		return newHandlerCLI(host, port, func(client *ssh.SSHClient, command string) error {
+			return client.RunWithStderr(command, cmdOut, cmd.ErrOrStderr())
+		})(cmd, args)
+	}
+}
+
+// newHandlerCLI runs the command on the server with the given run function.
+func newHandlerCLI(
+	host string,
+	port int,
+	run func(client *ssh.SSHClient, command string) error,
+) pkg.CobraHandler {
 	return func(cmd *cobra.Command, args []string) error {
 		var authMethod gossh.AuthMethod
 
@@ -65,7 +88,7 @@ func NewHandlerCLI(host string, port int, cmdOut io.Writer) pkg.CobraHandler {
 				return
 			}
 
-			flags = fmt.Sprintf("%s --%s %s", flags, flag.Name, flag.Value)
+			flags = fmt.Sprintf("%s --%s=%s", flags, flag.Name, flag.Value)
 		})
 
 		scmd := []string{
@@ -74,7 +97,14 @@ func NewHandlerCLI(host string, port int, cmdOut io.Writer) pkg.CobraHandler {
 			flags,
 		}
 
-		if err := client.Run(strings.Join(scmd, " "), cmdOut); err != nil {
+		if err := run(client, strings.Join(scmd, " ")); err != nil {
+			// the server has already written out why the command failed
+			var exitErr *gossh.ExitError
+			if errors.As(err, &exitErr) {
+				cmd.SilenceErrors = true
+				cmd.SilenceUsage = true
+			}
+
 			return err
 		}
 
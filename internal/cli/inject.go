@@ -7,13 +7,18 @@ import (
 	"strings"
 
 	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/nixpig/syringe.sh/pkg/ssh"
 	"github.com/spf13/cobra"
 )
 
 func NewHandlerInjectCLI(host string, port int, cmdOut io.Writer) pkg.CobraHandler {
 	return func(cmd *cobra.Command, args []string) error {
 		w := bytes.NewBufferString("")
-		injectHandler := NewHandlerCLI(host, port, w)
+
+		// warnings go to stderr so they don't end up in the injected environment
+		injectHandler := newHandlerCLI(host, port, func(client *ssh.SSHClient, command string) error {
+			return client.RunWithStderr(command, w, cmd.ErrOrStderr())
+		})
 
 		if err := injectHandler(cmd, args); err != nil {
 			return err
@@ -0,0 +1,381 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/nixpig/syringe.sh/internal/admin"
+	"github.com/nixpig/syringe.sh/internal/audit"
+	"github.com/nixpig/syringe.sh/internal/auth"
+	"github.com/nixpig/syringe.sh/internal/cli"
+	"github.com/nixpig/syringe.sh/internal/environment"
+	"github.com/nixpig/syringe.sh/internal/inject"
+	"github.com/nixpig/syringe.sh/internal/org"
+	"github.com/nixpig/syringe.sh/internal/policy"
+	"github.com/nixpig/syringe.sh/internal/project"
+	"github.com/nixpig/syringe.sh/internal/secret"
+	"github.com/nixpig/syringe.sh/internal/user"
+	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/nixpig/syringe.sh/pkg/helpers"
+	"github.com/spf13/cobra"
+)
+
+// Command is a command in the tree that both the CLI and the server build,
+// so that a command's added once and the two can't drift apart.
+type Command struct {
+	// New makes the command, which runs handler. Commands that only group
+	// others are given nil.
+	New func(handler pkg.CobraHandler) *cobra.Command
+	// Local makes the handler that runs the command on the server.
+	Local func(s Services) pkg.CobraHandler
+	// Output is how the CLI handles the output of the command when it's run
+	// on the server.
+	Output Output
+	// PreRunE guards the command, and any commands it groups, on the server,
+	// where what it checks is known.
+	PreRunE pkg.CobraHandler
+	// Subcommands are the commands it groups.
+	Subcommands []Command
+}
+
+// Output is how the CLI handles a command's output.
+type Output int
+
+const (
+	// OutputMessages writes everything the server writes.
+	OutputMessages Output = iota
+	// OutputData writes only the server's stdout, such as an archive, so that
+	// redirecting it doesn't capture prompts and errors.
+	OutputData
+	// OutputInject runs a local command with the secrets the server writes.
+	OutputInject
+)
+
+// Services are what commands are run against on the server.
+type Services struct {
+	User user.UserService
+	// Account is User with the user's own database, for commands that read
+	// it.
+	Account          user.UserService
+	Org              org.OrgService
+	Project          project.ProjectService
+	Environment      environment.EnvironmentService
+	Share            environment.ShareService
+	Secret           secret.SecretService
+	InjectableSecret secret.SecretService
+	Policy           policy.PolicyService
+	Audit            audit.AuditService
+	Admin            admin.AdminService
+}
+
+// Handlers make the handlers commands run, and their guards.
+type Handlers interface {
+	Handler(c Command) pkg.CobraHandler
+	PreRunE(c Command) pkg.CobraHandler
+}
+
+// Local runs commands on the server, against its services.
+func Local(s Services) Handlers {
+	return local{services: s}
+}
+
+type local struct {
+	services Services
+}
+
+func (l local) Handler(c Command) pkg.CobraHandler {
+	return c.Local(l.services)
+}
+
+func (l local) PreRunE(c Command) pkg.CobraHandler {
+	return c.PreRunE
+}
+
+// Remote runs commands from the CLI on the server at host and port, writing
+// their output to out.
+func Remote(host string, port int, out io.Writer) Handlers {
+	return remote{
+		OutputMessages: cli.NewHandlerCLI(host, port, out),
+		OutputData:     cli.NewHandlerStdoutCLI(host, port, out),
+		OutputInject:   cli.NewHandlerInjectCLI(host, port, out),
+	}
+}
+
+type remote map[Output]pkg.CobraHandler
+
+func (r remote) Handler(c Command) pkg.CobraHandler {
+	return r[c.Output]
+}
+
+// PreRunE doesn't guard commands, since the server does once they're run
+// there.
+func (r remote) PreRunE(c Command) pkg.CobraHandler {
+	return nil
+}
+
+// Build adds the commands in the tree to cmdRoot, running the handlers made
+// by handlers.
+func Build(cmdRoot *cobra.Command, handlers Handlers) {
+	for _, c := range Tree() {
+		cmdRoot.AddCommand(build(c, handlers))
+	}
+
+	helpers.WalkCmd(cmdRoot, func(c *cobra.Command) {
+		c.Flags().BoolP("help", "h", false, fmt.Sprintf("Help for the '%s' command", c.Name()))
+		c.Flags().BoolP("version", "v", false, "Print version information")
+	})
+}
+
+func build(c Command, handlers Handlers) *cobra.Command {
+	if len(c.Subcommands) == 0 {
+		cmd := c.New(handlers.Handler(c))
+		if preRunE := handlers.PreRunE(c); preRunE != nil {
+			cmd.PreRunE = preRunE
+		}
+
+		return cmd
+	}
+
+	cmd := c.New(nil)
+	if preRunE := handlers.PreRunE(c); preRunE != nil {
+		cmd.PersistentPreRunE = preRunE
+	}
+
+	for _, subcommand := range c.Subcommands {
+		cmd.AddCommand(build(subcommand, handlers))
+	}
+
+	return cmd
+}
+
+// group makes a command that only groups others.
+func group(newCmd func() *cobra.Command) func(pkg.CobraHandler) *cobra.Command {
+	return func(pkg.CobraHandler) *cobra.Command {
+		return newCmd()
+	}
+}
+
+// Tree is every command below the root.
+func Tree() []Command {
+	return []Command{
+		{
+			New: group(user.NewCmdUser),
+			Subcommands: []Command{
+				{
+					New:   user.NewCmdUserRegister,
+					Local: func(s Services) pkg.CobraHandler { return user.NewHandlerUserRegister(s.User) },
+				},
+				{
+					New:     user.NewCmdUserVerify,
+					Local:   func(s Services) pkg.CobraHandler { return user.NewHandlerUserVerify(s.User) },
+					PreRunE: auth.PreRunE,
+				},
+				{
+					New:     user.NewCmdUserExport,
+					Local:   func(s Services) pkg.CobraHandler { return user.NewHandlerUserExport(s.Account) },
+					Output:  OutputData,
+					PreRunE: auth.PreRunE,
+				},
+				{
+					New:     user.NewCmdUserDelete,
+					Local:   func(s Services) pkg.CobraHandler { return user.NewHandlerUserDelete(s.Account) },
+					Output:  OutputData,
+					PreRunE: auth.PreRunE,
+				},
+			},
+		},
+		{
+			New:     group(org.NewCmdOrg),
+			PreRunE: auth.PreRunE,
+			Subcommands: []Command{
+				{
+					New:   org.NewCmdOrgCreate,
+					Local: func(s Services) pkg.CobraHandler { return org.NewHandlerOrgCreate(s.Org) },
+				},
+				{
+					New:   org.NewCmdOrgInvite,
+					Local: func(s Services) pkg.CobraHandler { return org.NewHandlerOrgInvite(s.Org) },
+				},
+				{
+					New:   org.NewCmdOrgRemove,
+					Local: func(s Services) pkg.CobraHandler { return org.NewHandlerOrgRemove(s.Org) },
+				},
+				{
+					New:   org.NewCmdOrgList,
+					Local: func(s Services) pkg.CobraHandler { return org.NewHandlerOrgList(s.Org) },
+				},
+			},
+		},
+		{
+			New: group(project.NewCmdProject),
+			Subcommands: []Command{
+				{
+					New:   project.NewCmdProjectAdd,
+					Local: func(s Services) pkg.CobraHandler { return project.NewHandlerProjectAdd(s.Project) },
+				},
+				{
+					New:   project.NewCmdProjectRemove,
+					Local: func(s Services) pkg.CobraHandler { return project.NewHandlerProjectRemove(s.Project) },
+				},
+				{
+					New:   project.NewCmdProjectRename,
+					Local: func(s Services) pkg.CobraHandler { return project.NewHandlerProjectRename(s.Project) },
+				},
+				{
+					New:   project.NewCmdProjectList,
+					Local: func(s Services) pkg.CobraHandler { return project.NewHandlerProjectList(s.Project) },
+				},
+			},
+		},
+		{
+			New: group(environment.NewCmdEnvironment),
+			Subcommands: []Command{
+				{
+					New:   environment.NewCmdEnvironmentAdd,
+					Local: func(s Services) pkg.CobraHandler { return environment.NewHandlerEnvironmentAdd(s.Environment) },
+				},
+				{
+					New:   environment.NewCmdEnvironmentRemove,
+					Local: func(s Services) pkg.CobraHandler { return environment.NewHandlerEnvironmentRemove(s.Environment) },
+				},
+				{
+					New:   environment.NewCmdEnvironmentRename,
+					Local: func(s Services) pkg.CobraHandler { return environment.NewHandlerEnvironmentRename(s.Environment) },
+				},
+				{
+					New:   environment.NewCmdEnvironmentConfigure,
+					Local: func(s Services) pkg.CobraHandler { return environment.NewHandlerEnvironmentConfigure(s.Environment) },
+				},
+				{
+					New:   environment.NewCmdEnvironmentList,
+					Local: func(s Services) pkg.CobraHandler { return environment.NewHandlerEnvironmentList(s.Environment) },
+				},
+				{
+					New:     environment.NewCmdEnvironmentShare,
+					Local:   func(s Services) pkg.CobraHandler { return environment.NewHandlerEnvironmentShare(s.Share) },
+					PreRunE: auth.PreRunE,
+				},
+				{
+					New:     environment.NewCmdEnvironmentUnshare,
+					Local:   func(s Services) pkg.CobraHandler { return environment.NewHandlerEnvironmentUnshare(s.Share) },
+					PreRunE: auth.PreRunE,
+				},
+				{
+					New:     environment.NewCmdEnvironmentShares,
+					Local:   func(s Services) pkg.CobraHandler { return environment.NewHandlerEnvironmentShares(s.Share) },
+					PreRunE: auth.PreRunE,
+				},
+			},
+		},
+		{
+			New: group(secret.NewCmdSecret),
+			Subcommands: []Command{
+				{
+					New:   secret.NewCmdSecretSet,
+					Local: func(s Services) pkg.CobraHandler { return secret.NewHandlerSecretSet(s.Secret) },
+				},
+				{
+					New:   secret.NewCmdSecretGet,
+					Local: func(s Services) pkg.CobraHandler { return secret.NewHandlerSecretGet(s.Secret) },
+				},
+				{
+					New:   secret.NewCmdSecretList,
+					Local: func(s Services) pkg.CobraHandler { return secret.NewHandlerSecretList(s.Secret) },
+				},
+				{
+					New:   secret.NewCmdSecretStale,
+					Local: func(s Services) pkg.CobraHandler { return secret.NewHandlerSecretStale(s.Secret) },
+				},
+				{
+					New:   secret.NewCmdSecretRemove,
+					Local: func(s Services) pkg.CobraHandler { return secret.NewHandlerSecretRemove(s.Secret) },
+				},
+			},
+		},
+		{
+			New:    inject.NewCmdInject,
+			Local:  func(s Services) pkg.CobraHandler { return inject.NewHandlerInject(s.InjectableSecret) },
+			Output: OutputInject,
+		},
+		{
+			New: group(policy.NewCmdRole),
+			Subcommands: []Command{
+				{
+					New:   policy.NewCmdRoleGrant,
+					Local: func(s Services) pkg.CobraHandler { return policy.NewHandlerRoleGrant(s.Policy) },
+				},
+				{
+					New:   policy.NewCmdRoleRevoke,
+					Local: func(s Services) pkg.CobraHandler { return policy.NewHandlerRoleRevoke(s.Policy) },
+				},
+				{
+					New:   policy.NewCmdRoleList,
+					Local: func(s Services) pkg.CobraHandler { return policy.NewHandlerRoleList(s.Policy) },
+				},
+			},
+		},
+		{
+			New:     group(audit.NewCmdAudit),
+			PreRunE: auth.PreRunE,
+			Subcommands: []Command{
+				{
+					New:   audit.NewCmdAuditList,
+					Local: func(s Services) pkg.CobraHandler { return audit.NewHandlerAuditList(s.Audit) },
+				},
+				{
+					New:   audit.NewCmdAuditVerify,
+					Local: func(s Services) pkg.CobraHandler { return audit.NewHandlerAuditVerify(s.Audit) },
+				},
+			},
+		},
+		{
+			New:     group(admin.NewCmdAdmin),
+			PreRunE: admin.PreRunE,
+			Subcommands: []Command{
+				{
+					New: group(admin.NewCmdAdminUsers),
+					Subcommands: []Command{
+						{
+							New:   admin.NewCmdAdminUsersList,
+							Local: func(s Services) pkg.CobraHandler { return admin.NewHandlerAdminUsersList(s.Admin) },
+						},
+						{
+							New:   admin.NewCmdAdminUsersShow,
+							Local: func(s Services) pkg.CobraHandler { return admin.NewHandlerAdminUsersShow(s.Admin) },
+						},
+						{
+							New:   admin.NewCmdAdminUsersSuspend,
+							Local: func(s Services) pkg.CobraHandler { return admin.NewHandlerAdminUsersSuspend(s.Admin) },
+						},
+					},
+				},
+				{
+					New: group(admin.NewCmdAdminKeys),
+					Subcommands: []Command{
+						{
+							New:   admin.NewCmdAdminKeysRevoke,
+							Local: func(s Services) pkg.CobraHandler { return admin.NewHandlerAdminKeysRevoke(s.Admin) },
+						},
+					},
+				},
+				{
+					New: group(admin.NewCmdAdminDatabases),
+					Subcommands: []Command{
+						{
+							New:   admin.NewCmdAdminDatabasesList,
+							Local: func(s Services) pkg.CobraHandler { return admin.NewHandlerAdminDatabasesList(s.Admin) },
+						},
+						{
+							New:   admin.NewCmdAdminDatabasesOrphans,
+							Local: func(s Services) pkg.CobraHandler { return admin.NewHandlerAdminDatabasesOrphans(s.Admin) },
+						},
+					},
+				},
+				{
+					New:   admin.NewCmdAdminStats,
+					Local: func(s Services) pkg.CobraHandler { return admin.NewHandlerAdminStats(s.Admin) },
+				},
+			},
+		},
+	}
+}
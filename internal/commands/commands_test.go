@@ -0,0 +1,148 @@
+package commands_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nixpig/syringe.sh/internal/commands"
+	"github.com/nixpig/syringe.sh/internal/root"
+	"github.com/nixpig/syringe.sh/pkg/helpers"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommands(t *testing.T) {
+	scenarios := map[string]func(t *testing.T, local, remote *cobra.Command){
+		"test local and remote trees are identical": testCommandsTreesIdentical,
+		"test every command has a handler":          testCommandsEveryCommandHasHandler,
+		"test commands are only guarded locally":    testCommandsGuardedLocally,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			local := root.New(context.Background())
+			commands.Build(local, commands.Local(commands.Services{}))
+
+			remote := root.New(context.Background())
+			commands.Build(remote, commands.Remote("localhost", 23234, io.Discard))
+
+			fn(t, local, remote)
+		})
+	}
+}
+
+// describe lists what users see of each command in the tree: its path,
+// usage, help, arguments and flags.
+func describe(cmdRoot *cobra.Command) []string {
+	var description []string
+
+	helpers.WalkCmd(cmdRoot, func(c *cobra.Command) {
+		description = append(description, fmt.Sprintf(
+			"%s | %s | %v | %s | %s | %s | runnable=%t hidden=%t args=%t",
+			c.CommandPath(),
+			c.Use,
+			c.Aliases,
+			c.Short,
+			c.Long,
+			c.Example,
+			c.Runnable(),
+			c.Hidden,
+			c.Args != nil,
+		))
+
+		describeFlag := func(kind string) func(f *pflag.Flag) {
+			return func(f *pflag.Flag) {
+				description = append(description, fmt.Sprintf(
+					"%s %s flag --%s -%s %s %q %q",
+					c.CommandPath(),
+					kind,
+					f.Name,
+					f.Shorthand,
+					f.Value.Type(),
+					f.DefValue,
+					f.Usage,
+				))
+			}
+		}
+
+		c.LocalNonPersistentFlags().VisitAll(describeFlag("local"))
+		c.PersistentFlags().VisitAll(describeFlag("persistent"))
+	})
+
+	return description
+}
+
+func testCommandsTreesIdentical(t *testing.T, local, remote *cobra.Command) {
+	require.Equal(t, describe(local), describe(remote))
+	require.NotEmpty(t, local.Commands())
+}
+
+func testCommandsEveryCommandHasHandler(t *testing.T, local, remote *cobra.Command) {
+	var walk func(path string, tree []commands.Command)
+
+	walk = func(path string, tree []commands.Command) {
+		for i, c := range tree {
+			name := fmt.Sprintf("%s[%d]", path, i)
+
+			require.NotNil(t, c.New, name)
+
+			if len(c.Subcommands) == 0 {
+				require.NotNil(t, c.Local, name)
+				continue
+			}
+
+			require.Nil(t, c.Local, name)
+			walk(name, c.Subcommands)
+		}
+	}
+
+	walk("tree", commands.Tree())
+
+	helpers.WalkCmd(remote, func(c *cobra.Command) {
+		if !c.HasSubCommands() && c != remote {
+			require.NotNil(t, c.RunE, c.CommandPath())
+		}
+	})
+}
+
+func testCommandsGuardedLocally(t *testing.T, local, remote *cobra.Command) {
+	scenarios := map[string]bool{
+		"user register":       false,
+		"user verify":         true,
+		"org list":            true,
+		"secret get":          false,
+		"environment share":   true,
+		"admin users list":    true,
+		"audit verify":        true,
+		"project add":         false,
+		"inject":              false,
+		"user export":         true,
+		"environment unshare": true,
+	}
+
+	guarded := func(c *cobra.Command) bool {
+		for ; c != nil; c = c.Parent() {
+			if c.PreRunE != nil || c.PersistentPreRunE != nil {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for command, expected := range scenarios {
+		args := strings.Fields(command)
+
+		localCmd, _, err := local.Find(args)
+		require.NoError(t, err)
+		require.Equal(t, expected, guarded(localCmd), command)
+
+		remoteCmd, _, err := remote.Find(args)
+		require.NoError(t, err)
+		require.False(t, guarded(remoteCmd), command)
+	}
+}
@@ -0,0 +1,484 @@
+package database
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/nixpig/syringe.sh/pkg/resilience"
+	"github.com/nixpig/syringe.sh/pkg/tracing"
+	"github.com/nixpig/syringe.sh/pkg/turso"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var tracer = otel.Tracer("github.com/nixpig/syringe.sh/internal/database")
+
+const (
+	DefaultTokenExpiration = time.Hour
+	DefaultRefreshBefore   = 5 * time.Minute
+	DefaultIdleTimeout     = 10 * time.Minute
+)
+
+// ConnectionManager hands out connections to user databases. Each database
+// has a single pooled *sql.DB, shared by every session using it, which is
+// reopened with a new token shortly before the old one expires and closed
+// once it's been idle for a while.
+type ConnectionManager struct {
+	api          turso.TursoDatabaseAPI
+	organization string
+
+	tokenExpiration time.Duration
+	refreshBefore   time.Duration
+	idleTimeout     time.Duration
+	connect         func(ctx context.Context, databaseURL, token string) (*sql.DB, error)
+	url             func(hostName string) string
+	retry           *resilience.Policy
+	breaker         func() *resilience.Breaker
+	migrate         func(ctx context.Context, db *sql.DB) error
+	now             func() time.Time
+
+	mu    sync.Mutex
+	slots map[string]*connectionSlot
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// connectionSlot holds the connection to a database. Its lock is held while
+// connecting, so that concurrent sessions for the same database wait for a
+// single connection rather than each creating their own. It's removed from
+// the manager once it has no connection, no session is connecting with it
+// and its breaker has settled.
+type connectionSlot struct {
+	mu      sync.Mutex
+	conn    *userConnection
+	breaker *resilience.Breaker
+
+	// connecting is the number of calls to Connect using the slot, guarded
+	// by the manager's lock.
+	connecting int
+}
+
+type userConnection struct {
+	db        *sql.DB
+	expiresAt time.Time
+	lastUsed  time.Time
+	refs      int
+	stale     bool
+}
+
+// ConnectionStats are counts of how connections have been handed out.
+type ConnectionStats struct {
+	// Hits is the number of times a pooled connection was reused.
+	Hits int64
+	// Misses is the number of times a token was created and a connection
+	// opened, because there wasn't one or its token was about to expire.
+	Misses int64
+	// Evictions is the number of connections closed for being idle.
+	Evictions int64
+	// Open is the number of connections currently pooled.
+	Open int
+}
+
+type ConnectionManagerOption func(m *ConnectionManager)
+
+// WithTokenExpiration sets how long created tokens last.
+func WithTokenExpiration(d time.Duration) ConnectionManagerOption {
+	return func(m *ConnectionManager) {
+		m.tokenExpiration = d
+	}
+}
+
+// WithRefreshBefore sets how long before its token expires that a
+// connection is replaced.
+func WithRefreshBefore(d time.Duration) ConnectionManagerOption {
+	return func(m *ConnectionManager) {
+		m.refreshBefore = d
+	}
+}
+
+// WithIdleTimeout sets how long a connection goes unused before it's closed.
+func WithIdleTimeout(d time.Duration) ConnectionManagerOption {
+	return func(m *ConnectionManager) {
+		m.idleTimeout = d
+	}
+}
+
+// WithConnector sets how connections are opened, e.g. to avoid connecting
+// to a real database in tests. It defaults to Connection.
+func WithConnector(
+	connect func(ctx context.Context, databaseURL, token string) (*sql.DB, error),
+) ConnectionManagerOption {
+	return func(m *ConnectionManager) {
+		m.connect = connect
+	}
+}
+
+// WithURL sets how the connection URL for a database host is made, e.g. to
+// connect to the fake Turso API's databases. It defaults to URL.
+func WithURL(url func(hostName string) string) ConnectionManagerOption {
+	return func(m *ConnectionManager) {
+		m.url = url
+	}
+}
+
+// WithRetry retries connecting to a database when it fails transiently, as
+// the policy describes.
+func WithRetry(policy resilience.Policy) ConnectionManagerOption {
+	return func(m *ConnectionManager) {
+		m.retry = &policy
+	}
+}
+
+// WithBreaker stops connecting to a database for the cooldown once it's
+// failed threshold times in a row. Each database has its own breaker, so one
+// that's broken doesn't stop any other being connected to.
+func WithBreaker(threshold int, cooldown time.Duration) ConnectionManagerOption {
+	return func(m *ConnectionManager) {
+		m.breaker = func() *resilience.Breaker {
+			return resilience.NewBreaker(
+				threshold,
+				cooldown,
+				resilience.WithBreakerClock(func() time.Time { return m.now() }),
+			)
+		}
+	}
+}
+
+// WithMigrate runs migrate on each database when a connection to it is
+// opened, to bring databases created by older versions up to date.
+func WithMigrate(migrate func(ctx context.Context, db *sql.DB) error) ConnectionManagerOption {
+	return func(m *ConnectionManager) {
+		m.migrate = migrate
+	}
+}
+
+// WithClock sets the source of the current time.
+func WithClock(now func() time.Time) ConnectionManagerOption {
+	return func(m *ConnectionManager) {
+		m.now = now
+	}
+}
+
+func NewConnectionManager(
+	api turso.TursoDatabaseAPI,
+	organization string,
+	options ...ConnectionManagerOption,
+) *ConnectionManager {
+	m := &ConnectionManager{
+		api:             api,
+		organization:    organization,
+		tokenExpiration: DefaultTokenExpiration,
+		refreshBefore:   DefaultRefreshBefore,
+		idleTimeout:     DefaultIdleTimeout,
+		connect:         Connection,
+		url:             URL,
+		now:             time.Now,
+		slots:           map[string]*connectionSlot{},
+		stop:            make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
+}
+
+// UserDBName is the name of the database belonging to the user with a public
+// key.
+func UserDBName(publicKey ssh.PublicKey) string {
+	marshalledKey := gossh.MarshalAuthorizedKey(publicKey)
+
+	return fmt.Sprintf("%x", sha1.Sum(marshalledKey))
+}
+
+// ConnectUser connects to the database belonging to the user with a public
+// key. See Connect.
+func (m *ConnectionManager) ConnectUser(
+	ctx context.Context,
+	publicKey ssh.PublicKey,
+) (*sql.DB, func(), error) {
+	return m.Connect(ctx, UserDBName(publicKey))
+}
+
+// Connect connects to the named database in the Turso organisation. The
+// connection is shared, so mustn't be closed; call release when done with
+// it instead. Cancelling the context only stops connecting; it doesn't
+// affect the connection once it's been made.
+func (m *ConnectionManager) Connect(
+	ctx context.Context,
+	name string,
+) (db *sql.DB, release func(), err error) {
+	ctx, span := tracer.Start(ctx, "database.Connect")
+	span.SetAttributes(attribute.String("db.namespace", name))
+	defer func() { tracing.End(span, err) }()
+
+	m.mu.Lock()
+	slot, ok := m.slots[name]
+	if !ok {
+		slot = &connectionSlot{}
+		if m.breaker != nil {
+			slot.breaker = m.breaker()
+		}
+		m.slots[name] = slot
+	}
+	slot.connecting++
+	m.mu.Unlock()
+
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		slot.connecting--
+		m.removeUnused(name, slot)
+	}()
+
+	m.mu.Lock()
+	if conn := slot.conn; conn != nil && m.now().Before(conn.expiresAt.Add(-m.refreshBefore)) {
+		conn.refs++
+		conn.lastUsed = m.now()
+		m.mu.Unlock()
+
+		m.hits.Add(1)
+		span.SetAttributes(attribute.Bool("syringe.pooled", true))
+
+		return conn.db, m.releaser(conn), nil
+	}
+	m.mu.Unlock()
+
+	m.misses.Add(1)
+	span.SetAttributes(attribute.Bool("syringe.pooled", false))
+
+	expiresAt := m.now().Add(m.tokenExpiration)
+
+	token, err := m.api.CreateToken(
+		ctx,
+		name,
+		fmt.Sprintf("%ds", int(m.tokenExpiration.Seconds())),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create token:\n%w", err)
+	}
+
+	databaseURL := m.url(name + "-" + m.organization + ".turso.io")
+
+	connect := func(ctx context.Context) error {
+		db, err = m.connect(ctx, databaseURL, token.Jwt)
+		return err
+	}
+
+	openCtx, openSpan := tracer.Start(ctx, "database.Open")
+
+	policy := resilience.Policy{MaxAttempts: 1}
+	if m.retry != nil {
+		policy = *m.retry
+	}
+
+	if slot.breaker != nil {
+		policy.Breaker = slot.breaker
+	}
+
+	err = resilience.Retry(openCtx, policy, connect)
+
+	tracing.End(openSpan, err)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating database connection:\n%w", err)
+	}
+
+	if m.migrate != nil {
+		if err := m.migrate(ctx, db); err != nil {
+			db.Close()
+			return nil, nil, fmt.Errorf("failed to migrate database:\n%w", err)
+		}
+	}
+
+	conn := &userConnection{
+		db:        db,
+		expiresAt: expiresAt,
+		lastUsed:  m.now(),
+		refs:      1,
+	}
+
+	m.mu.Lock()
+	if old := slot.conn; old != nil {
+		// sessions still using the old connection keep it until they release it
+		old.stale = true
+		if old.refs == 0 {
+			old.db.Close()
+		}
+	}
+	slot.conn = conn
+	m.mu.Unlock()
+
+	return conn.db, m.releaser(conn), nil
+}
+
+func (m *ConnectionManager) releaser(conn *userConnection) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			conn.refs--
+			conn.lastUsed = m.now()
+
+			if conn.refs == 0 && conn.stale {
+				conn.db.Close()
+			}
+		})
+	}
+}
+
+// Invalidate drops the connection to the named database, e.g. once it's
+// been deleted, so it's neither reused nor kept open until it's idle.
+// Sessions still using it keep it until they release it.
+func (m *ConnectionManager) Invalidate(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	slot, ok := m.slots[name]
+	if !ok {
+		return
+	}
+
+	if conn := slot.conn; conn != nil {
+		conn.stale = true
+		if conn.refs == 0 {
+			conn.db.Close()
+		}
+
+		slot.conn = nil
+	}
+
+	// its breaker's failures were for a database that's gone
+	if slot.connecting == 0 {
+		delete(m.slots, name)
+	}
+}
+
+// EvictIdle closes every connection that isn't in use and hasn't been for
+// longer than the idle timeout, returning how many were closed.
+func (m *ConnectionManager) EvictIdle() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	evicted := 0
+
+	for name, slot := range m.slots {
+		conn := slot.conn
+		if conn == nil {
+			// e.g. left behind by a database whose breaker has since closed
+			m.removeUnused(name, slot)
+			continue
+		}
+
+		if conn.refs > 0 || m.now().Sub(conn.lastUsed) < m.idleTimeout {
+			continue
+		}
+
+		conn.stale = true
+		conn.db.Close()
+		slot.conn = nil
+		m.removeUnused(name, slot)
+
+		evicted++
+	}
+
+	m.evictions.Add(int64(evicted))
+
+	return evicted
+}
+
+// EvictIdleEvery calls EvictIdle on an interval until the manager is closed.
+func (m *ConnectionManager) EvictIdleEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.EvictIdle()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *ConnectionManager) Stats() ConnectionStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	open := 0
+	for _, slot := range m.slots {
+		if slot.conn != nil {
+			open++
+		}
+	}
+
+	return ConnectionStats{
+		Hits:      m.hits.Load(),
+		Misses:    m.misses.Load(),
+		Evictions: m.evictions.Load(),
+		Open:      open,
+	}
+}
+
+// Close closes every pooled connection, whether or not it's in use.
+func (m *ConnectionManager) Close() error {
+	m.stopOnce.Do(func() { close(m.stop) })
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var err error
+
+	for name, slot := range m.slots {
+		if slot.conn != nil {
+			slot.conn.stale = true
+			if closeErr := slot.conn.db.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+
+			slot.conn = nil
+		}
+
+		m.removeUnused(name, slot)
+	}
+
+	return err
+}
+
+// removeUnused removes a slot that has no connection and isn't being
+// connected with, so that databases no longer used don't accumulate. Slots
+// whose breaker is still counting failures are kept until it settles, so
+// that a failing database isn't tried again before its cooldown. The
+// manager's lock must be held.
+func (m *ConnectionManager) removeUnused(name string, slot *connectionSlot) {
+	if slot.conn != nil || slot.connecting > 0 || m.slots[name] != slot {
+		return
+	}
+
+	if slot.breaker != nil && !slot.breaker.Settled() {
+		return
+	}
+
+	delete(m.slots, name)
+}
@@ -0,0 +1,351 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/nixpig/syringe.sh/internal/database"
+	"github.com/nixpig/syringe.sh/pkg/resilience"
+	"github.com/nixpig/syringe.sh/pkg/turso"
+	"github.com/stretchr/testify/require"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestConnectionManager(t *testing.T) {
+	scenarios := map[string]func(
+		t *testing.T,
+		connections *database.ConnectionManager,
+		clock *testClock,
+		connects *int,
+	){
+		"test reuse connection":               testReuseConnection,
+		"test refresh connection near expiry": testRefreshConnectionNearExpiry,
+		"test evict idle connection":          testEvictIdleConnection,
+		"test keep connection in use":         testKeepConnectionInUse,
+		"test connect to missing database":    testConnectToMissingDatabase,
+		"test close connections":              testCloseConnections,
+		"test invalidate connection":          testInvalidateConnection,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			server := turso.NewFakeServer("my_cool_org", "api_token")
+			defer server.Close()
+
+			api := server.TursoClient()
+
+			_, err := api.CreateDatabase(context.Background(), "my_cool_db", "default")
+			require.NoError(t, err)
+
+			clock := &testClock{now: time.Now()}
+			connects := 0
+
+			connections := database.NewConnectionManager(
+				&api,
+				"my_cool_org",
+				database.WithTokenExpiration(time.Hour),
+				database.WithRefreshBefore(5*time.Minute),
+				database.WithIdleTimeout(10*time.Minute),
+				database.WithClock(clock.Now),
+				database.WithConnector(func(ctx context.Context, databaseURL, token string) (*sql.DB, error) {
+					require.Equal(t, "libsql://my_cool_db-my_cool_org.turso.io", databaseURL)
+					require.True(t, server.ValidToken("my_cool_db", token))
+
+					connects++
+
+					db, mock, err := sqlmock.New()
+					if err != nil {
+						return nil, err
+					}
+
+					mock.ExpectClose()
+
+					return db, nil
+				}),
+			)
+			defer connections.Close()
+
+			fn(t, connections, clock, &connects)
+		})
+	}
+}
+
+func requireClosed(t *testing.T, db *sql.DB) {
+	require.ErrorContains(t, db.Ping(), "database is closed")
+}
+
+func testReuseConnection(
+	t *testing.T,
+	connections *database.ConnectionManager,
+	clock *testClock,
+	connects *int,
+) {
+	db1, release1, err := connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+	release1()
+
+	clock.Advance(time.Minute)
+
+	db2, release2, err := connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+	defer release2()
+
+	require.Same(t, db1, db2)
+	require.NoError(t, db2.Ping())
+	require.Equal(t, 1, *connects)
+	require.Equal(t, database.ConnectionStats{Hits: 1, Misses: 1, Open: 1}, connections.Stats())
+}
+
+func testRefreshConnectionNearExpiry(
+	t *testing.T,
+	connections *database.ConnectionManager,
+	clock *testClock,
+	connects *int,
+) {
+	db1, release1, err := connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+
+	clock.Advance(56 * time.Minute)
+
+	db2, release2, err := connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+	defer release2()
+
+	require.NotSame(t, db1, db2)
+	require.Equal(t, 2, *connects)
+
+	// the old connection is still in use
+	require.NoError(t, db1.Ping())
+
+	release1()
+	requireClosed(t, db1)
+	require.Equal(t, 1, connections.Slots())
+
+	require.Equal(t, database.ConnectionStats{Misses: 2, Open: 1}, connections.Stats())
+}
+
+func testEvictIdleConnection(
+	t *testing.T,
+	connections *database.ConnectionManager,
+	clock *testClock,
+	connects *int,
+) {
+	db, release, err := connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+	release()
+
+	clock.Advance(9 * time.Minute)
+	require.Equal(t, 0, connections.EvictIdle())
+
+	clock.Advance(time.Minute)
+	require.Equal(t, 1, connections.EvictIdle())
+	requireClosed(t, db)
+
+	require.Equal(t, database.ConnectionStats{Misses: 1, Evictions: 1}, connections.Stats())
+	require.Equal(t, 0, connections.Slots())
+
+	_, release, err = connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+	defer release()
+
+	require.Equal(t, 2, *connects)
+}
+
+func testKeepConnectionInUse(
+	t *testing.T,
+	connections *database.ConnectionManager,
+	clock *testClock,
+	connects *int,
+) {
+	db, release, err := connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+
+	clock.Advance(time.Hour)
+	require.Equal(t, 0, connections.EvictIdle())
+	require.NoError(t, db.Ping())
+
+	// releasing more than once has no effect
+	release()
+	release()
+
+	clock.Advance(10 * time.Minute)
+	require.Equal(t, 1, connections.EvictIdle())
+}
+
+func testConnectToMissingDatabase(
+	t *testing.T,
+	connections *database.ConnectionManager,
+	clock *testClock,
+	connects *int,
+) {
+	_, _, err := connections.Connect(context.Background(), "my_missing_db")
+
+	require.ErrorContains(t, err, "failed to create token")
+	require.Equal(t, 0, *connects)
+	require.Equal(t, database.ConnectionStats{Misses: 1}, connections.Stats())
+	require.Equal(t, 0, connections.Slots())
+}
+
+func testCloseConnections(
+	t *testing.T,
+	connections *database.ConnectionManager,
+	clock *testClock,
+	connects *int,
+) {
+	db, release, err := connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+	defer release()
+
+	require.NoError(t, connections.Close())
+	requireClosed(t, db)
+	require.Equal(t, 0, connections.Stats().Open)
+	require.Equal(t, 0, connections.Slots())
+}
+
+func testInvalidateConnection(
+	t *testing.T,
+	connections *database.ConnectionManager,
+	clock *testClock,
+	connects *int,
+) {
+	db, release, err := connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+
+	connections.Invalidate("my_cool_db")
+	require.Equal(t, 0, connections.Stats().Open)
+	require.Equal(t, 0, connections.Slots())
+
+	// the session using it keeps it until it's released
+	require.NoError(t, db.Ping())
+
+	release()
+	requireClosed(t, db)
+
+	_, release, err = connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+	defer release()
+
+	require.Equal(t, 2, *connects)
+}
+
+func TestConnectionManagerMigrate(t *testing.T) {
+	server := turso.NewFakeServer("my_cool_org", "api_token")
+	defer server.Close()
+
+	api := server.TursoClient()
+
+	_, err := api.CreateDatabase(context.Background(), "my_cool_db", "default")
+	require.NoError(t, err)
+
+	migrations := 0
+	clock := &testClock{now: time.Now()}
+
+	connections := database.NewConnectionManager(
+		&api,
+		"my_cool_org",
+		database.WithClock(clock.Now),
+		database.WithConnector(func(ctx context.Context, databaseURL, token string) (*sql.DB, error) {
+			db, _, err := sqlmock.New()
+			return db, err
+		}),
+		database.WithMigrate(func(ctx context.Context, db *sql.DB) error {
+			migrations++
+
+			if migrations > 1 {
+				return errors.New("migration failed")
+			}
+
+			return nil
+		}),
+	)
+	defer connections.Close()
+
+	_, release, err := connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+	release()
+
+	// pooled connections have already been migrated
+	_, release, err = connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+	release()
+
+	require.Equal(t, 1, migrations)
+
+	clock.Advance(time.Hour)
+
+	_, _, err = connections.Connect(context.Background(), "my_cool_db")
+	require.ErrorContains(t, err, "failed to migrate database")
+	require.Equal(t, 2, migrations)
+}
+
+func TestConnectionManagerBreaker(t *testing.T) {
+	server := turso.NewFakeServer("my_cool_org", "api_token")
+	defer server.Close()
+
+	api := server.TursoClient()
+
+	for _, name := range []string{"my_cool_db", "my_broken_db"} {
+		_, err := api.CreateDatabase(context.Background(), name, "default")
+		require.NoError(t, err)
+	}
+
+	connects := map[string]int{}
+	clock := &testClock{now: time.Now()}
+
+	connections := database.NewConnectionManager(
+		&api,
+		"my_cool_org",
+		database.WithClock(clock.Now),
+		database.WithBreaker(2, time.Minute),
+		database.WithConnector(func(ctx context.Context, databaseURL, token string) (*sql.DB, error) {
+			connects[databaseURL]++
+
+			if databaseURL == "libsql://my_broken_db-my_cool_org.turso.io" {
+				return nil, syscall.ECONNREFUSED
+			}
+
+			db, _, err := sqlmock.New()
+			return db, err
+		}),
+	)
+	defer connections.Close()
+
+	for range 2 {
+		_, _, err := connections.Connect(context.Background(), "my_broken_db")
+		require.ErrorIs(t, err, syscall.ECONNREFUSED)
+	}
+
+	_, _, err := connections.Connect(context.Background(), "my_broken_db")
+	require.ErrorIs(t, err, resilience.ErrCircuitOpen)
+	require.Equal(t, 2, connects["libsql://my_broken_db-my_cool_org.turso.io"])
+
+	// other databases are still connected to
+	_, release, err := connections.Connect(context.Background(), "my_cool_db")
+	require.NoError(t, err)
+	release()
+
+	// the broken database is remembered until its cooldown is over
+	require.Equal(t, 2, connections.Slots())
+
+	clock.Advance(time.Minute)
+
+	_, _, err = connections.Connect(context.Background(), "my_broken_db")
+	require.ErrorIs(t, err, syscall.ECONNREFUSED)
+	require.Equal(t, 3, connects["libsql://my_broken_db-my_cool_org.turso.io"])
+}
@@ -1,32 +1,48 @@
 package database
 
 import (
-	"crypto/sha1"
+	"context"
 	"database/sql"
-	"fmt"
-	"net/http"
-	"os"
+	"strings"
 
-	"github.com/charmbracelet/ssh"
 	"github.com/nixpig/syringe.sh/pkg/serrors"
 	"github.com/nixpig/syringe.sh/pkg/turso"
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
-	gossh "golang.org/x/crypto/ssh"
 )
 
 type DBConfig struct {
 	Location string
 }
 
-func Connection(databaseURL, databaseToken string) (*sql.DB, error) {
-	databaseConnectionString := databaseURL + "?authToken=" + databaseToken
+// URL is the connection URL for a database host.
+func URL(hostName string) string {
+	return "libsql://" + hostName
+}
+
+// FakeURL returns the connection URLs for database hosts with the fake
+// Turso API keeping databases in dir, which are the SQLite files it keeps
+// them in.
+func FakeURL(dir string) func(hostName string) string {
+	return func(hostName string) string {
+		return turso.FakeDatabaseURL(dir, hostName)
+	}
+}
+
+func Connection(ctx context.Context, databaseURL, databaseToken string) (*sql.DB, error) {
+	databaseConnectionString := databaseURL
+
+	// local files are opened directly, so don't take a token
+	if !strings.HasPrefix(databaseURL, "file:") {
+		databaseConnectionString += "?authToken=" + databaseToken
+	}
 
 	db, err := sql.Open("libsql", databaseConnectionString)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -34,6 +50,31 @@ func Connection(databaseURL, databaseToken string) (*sql.DB, error) {
 }
 
 func MigrateAppDB(db *sql.DB) error {
+	dropVerificationsTable := `drop table if exists verifications_`
+	if _, err := db.Exec(dropVerificationsTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	dropSharesTable := `drop table if exists shares_`
+	if _, err := db.Exec(dropSharesTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	dropRolesTable := `drop table if exists roles_`
+	if _, err := db.Exec(dropRolesTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	dropOrgMembersTable := `drop table if exists org_members_`
+	if _, err := db.Exec(dropOrgMembersTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	dropOrgsTable := `drop table if exists orgs_`
+	if _, err := db.Exec(dropOrgsTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
 	dropKeysTable := `drop table if exists keys_`
 	if _, err := db.Exec(dropKeysTable); err != nil {
 		return serrors.ErrDatabaseExec(err)
@@ -47,7 +88,7 @@ func MigrateAppDB(db *sql.DB) error {
 	createUsersTable := `
 		create table if not exists users_ (
 			id_ integer primary key autoincrement,
-			username_ varchar(256) not null,
+			username_ varchar(256) unique not null,
 			email_ varchar(256) not null,
 			created_at_ datetime without time zone default current_timestamp,
 			status_ varchar(8) not null
@@ -58,6 +99,7 @@ func MigrateAppDB(db *sql.DB) error {
 		create table if not exists keys_ (
 			id_ integer primary key autoincrement,
 			ssh_public_key_ varchar(1024) not null,
+			fingerprint_ varchar(64) unique not null,
 			user_id_ integer not null,
 			created_at_ datetime without time zone default current_timestamp,
 
@@ -65,6 +107,75 @@ func MigrateAppDB(db *sql.DB) error {
 		)
 	`
 
+	createOrgsTable := `
+		create table if not exists orgs_ (
+			id_ integer primary key autoincrement,
+			name_ varchar(256) unique not null,
+			database_name_ varchar(256) not null,
+			created_at_ datetime without time zone default current_timestamp
+		)
+	`
+
+	createOrgMembersTable := `
+		create table if not exists org_members_ (
+			id_ integer primary key autoincrement,
+			org_id_ integer not null,
+			user_id_ integer not null,
+			role_ varchar(8) not null,
+			created_at_ datetime without time zone default current_timestamp,
+
+			unique (org_id_, user_id_),
+			foreign key (org_id_) references orgs_(id_) on delete cascade,
+			foreign key (user_id_) references users_(id_)
+		)
+	`
+
+	createRolesTable := `
+		create table if not exists roles_ (
+			id_ integer primary key autoincrement,
+			org_id_ integer not null,
+			user_id_ integer not null,
+			project_ varchar(256) not null,
+			environment_ varchar(256) not null default '',
+			role_ varchar(8) not null,
+			created_at_ datetime without time zone default current_timestamp,
+
+			unique (org_id_, user_id_, project_, environment_),
+			foreign key (org_id_) references orgs_(id_) on delete cascade,
+			foreign key (user_id_) references users_(id_)
+		)
+	`
+
+	createSharesTable := `
+		create table if not exists shares_ (
+			id_ integer primary key autoincrement,
+			owner_id_ integer not null,
+			recipient_id_ integer not null,
+			project_ varchar(256) not null,
+			environment_ varchar(256) not null,
+			read_only_ boolean not null default true,
+			expires_at_ datetime without time zone,
+			created_at_ datetime without time zone default current_timestamp,
+
+			unique (owner_id_, recipient_id_, project_, environment_),
+			foreign key (owner_id_) references users_(id_),
+			foreign key (recipient_id_) references users_(id_)
+		)
+	`
+
+	createVerificationsTable := `
+		create table if not exists verifications_ (
+			id_ integer primary key autoincrement,
+			user_id_ integer unique not null,
+			code_hash_ varchar(64) not null,
+			expires_at_ varchar(32) not null,
+			attempts_ integer not null default 0,
+			created_at_ datetime without time zone default current_timestamp,
+
+			foreign key (user_id_) references users_(id_)
+		)
+	`
+
 	if _, err := db.Exec(createUsersTable); err != nil {
 		return serrors.ErrDatabaseExec(err)
 	}
@@ -73,33 +184,73 @@ func MigrateAppDB(db *sql.DB) error {
 		return serrors.ErrDatabaseExec(err)
 	}
 
-	return nil
-}
+	if _, err := db.Exec(createOrgsTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	if _, err := db.Exec(createOrgMembersTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	if _, err := db.Exec(createRolesTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
 
-func NewUserDBConnection(publicKey ssh.PublicKey) (*sql.DB, error) {
-	api := turso.New(
-		os.Getenv("DATABASE_ORG"),
-		os.Getenv("API_TOKEN"),
-		http.Client{},
-	)
+	if _, err := db.Exec(createSharesTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
 
-	marshalledKey := gossh.MarshalAuthorizedKey(publicKey)
+	if _, err := db.Exec(createVerificationsTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
 
-	hashedKey := fmt.Sprintf("%x", sha1.Sum(marshalledKey))
-	expiration := "30s"
+	// the audit log is never dropped, and can't be changed once written
+	createAuditTable := `
+		create table if not exists audit_ (
+			id_ integer primary key autoincrement,
+			scope_ varchar(256) not null,
+			actor_ varchar(256) not null,
+			key_fingerprint_ varchar(256) not null,
+			remote_address_ varchar(256) not null,
+			session_id_ varchar(256) not null,
+			command_ varchar(256) not null,
+			project_ varchar(256) not null,
+			environment_ varchar(256) not null,
+			secret_key_ varchar(256) not null,
+			outcome_ varchar(8) not null,
+			created_at_ varchar(32) not null,
+			prev_hash_ varchar(64) not null,
+			hash_ varchar(64) not null
+		)
+	`
 
-	token, err := api.CreateToken(hashedKey, expiration)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create token:\n%s", err)
+	createAuditNoUpdateTrigger := `
+		create trigger if not exists audit_no_update_
+		before update on audit_
+		begin
+			select raise(abort, 'audit log is append-only');
+		end
+	`
+
+	createAuditNoDeleteTrigger := `
+		create trigger if not exists audit_no_delete_
+		before delete on audit_
+		begin
+			select raise(abort, 'audit log is append-only');
+		end
+	`
+
+	if _, err := db.Exec(createAuditTable); err != nil {
+		return serrors.ErrDatabaseExec(err)
 	}
 
-	db, err := Connection(
-		"libsql://"+hashedKey+"-"+os.Getenv("DATABASE_ORG")+".turso.io",
-		string(token.Jwt),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("error creating database connection:\n%s", err)
+	if _, err := db.Exec(createAuditNoUpdateTrigger); err != nil {
+		return serrors.ErrDatabaseExec(err)
 	}
 
-	return db, nil
+	if _, err := db.Exec(createAuditNoDeleteTrigger); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
 }
@@ -28,13 +28,18 @@ func TestDatabase(t *testing.T) {
 }
 
 func testMigrateAppDBHappyPath(t *testing.T, db *sql.DB, mock sqlmock.Sqlmock) {
+	dropVerificationsTable := `drop table if exists verifications_`
+	dropSharesTable := `drop table if exists shares_`
+	dropRolesTable := `drop table if exists roles_`
+	dropOrgMembersTable := `drop table if exists org_members_`
+	dropOrgsTable := `drop table if exists orgs_`
 	dropKeysTable := `drop table if exists keys_`
 	dropUsersTable := `drop table if exists users_`
 
 	createUsersTable := `
 		create table if not exists users_ (
 			id_ integer primary key autoincrement,
-			username_ varchar(256) not null,
+			username_ varchar(256) unique not null,
 			email_ varchar(256) not null,
 			created_at_ datetime without time zone default current_timestamp,
 			status_ varchar(8) not null
@@ -45,6 +50,7 @@ func testMigrateAppDBHappyPath(t *testing.T, db *sql.DB, mock sqlmock.Sqlmock) {
 		create table if not exists keys_ (
 			id_ integer primary key autoincrement,
 			ssh_public_key_ varchar(1024) not null,
+			fingerprint_ varchar(64) unique not null,
 			user_id_ integer not null,
 			created_at_ datetime without time zone default current_timestamp,
 
@@ -52,13 +58,148 @@ func testMigrateAppDBHappyPath(t *testing.T, db *sql.DB, mock sqlmock.Sqlmock) {
 		)
 	`
 
+	createOrgsTable := `
+		create table if not exists orgs_ (
+			id_ integer primary key autoincrement,
+			name_ varchar(256) unique not null,
+			database_name_ varchar(256) not null,
+			created_at_ datetime without time zone default current_timestamp
+		)
+	`
+
+	createOrgMembersTable := `
+		create table if not exists org_members_ (
+			id_ integer primary key autoincrement,
+			org_id_ integer not null,
+			user_id_ integer not null,
+			role_ varchar(8) not null,
+			created_at_ datetime without time zone default current_timestamp,
+
+			unique (org_id_, user_id_),
+			foreign key (org_id_) references orgs_(id_) on delete cascade,
+			foreign key (user_id_) references users_(id_)
+		)
+	`
+
+	createRolesTable := `
+		create table if not exists roles_ (
+			id_ integer primary key autoincrement,
+			org_id_ integer not null,
+			user_id_ integer not null,
+			project_ varchar(256) not null,
+			environment_ varchar(256) not null default '',
+			role_ varchar(8) not null,
+			created_at_ datetime without time zone default current_timestamp,
+
+			unique (org_id_, user_id_, project_, environment_),
+			foreign key (org_id_) references orgs_(id_) on delete cascade,
+			foreign key (user_id_) references users_(id_)
+		)
+	`
+
+	createSharesTable := `
+		create table if not exists shares_ (
+			id_ integer primary key autoincrement,
+			owner_id_ integer not null,
+			recipient_id_ integer not null,
+			project_ varchar(256) not null,
+			environment_ varchar(256) not null,
+			read_only_ boolean not null default true,
+			expires_at_ datetime without time zone,
+			created_at_ datetime without time zone default current_timestamp,
+
+			unique (owner_id_, recipient_id_, project_, environment_),
+			foreign key (owner_id_) references users_(id_),
+			foreign key (recipient_id_) references users_(id_)
+		)
+	`
+
+	createVerificationsTable := `
+		create table if not exists verifications_ (
+			id_ integer primary key autoincrement,
+			user_id_ integer unique not null,
+			code_hash_ varchar(64) not null,
+			expires_at_ varchar(32) not null,
+			attempts_ integer not null default 0,
+			created_at_ datetime without time zone default current_timestamp,
+
+			foreign key (user_id_) references users_(id_)
+		)
+	`
+
+	createAuditTable := `
+		create table if not exists audit_ (
+			id_ integer primary key autoincrement,
+			scope_ varchar(256) not null,
+			actor_ varchar(256) not null,
+			key_fingerprint_ varchar(256) not null,
+			remote_address_ varchar(256) not null,
+			session_id_ varchar(256) not null,
+			command_ varchar(256) not null,
+			project_ varchar(256) not null,
+			environment_ varchar(256) not null,
+			secret_key_ varchar(256) not null,
+			outcome_ varchar(8) not null,
+			created_at_ varchar(32) not null,
+			prev_hash_ varchar(64) not null,
+			hash_ varchar(64) not null
+		)
+	`
+
+	createAuditNoUpdateTrigger := `
+		create trigger if not exists audit_no_update_
+		before update on audit_
+		begin
+			select raise(abort, 'audit log is append-only');
+		end
+	`
+
+	createAuditNoDeleteTrigger := `
+		create trigger if not exists audit_no_delete_
+		before delete on audit_
+		begin
+			select raise(abort, 'audit log is append-only');
+		end
+	`
+
+	mock.ExpectExec(regexp.QuoteMeta(dropVerificationsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(dropSharesTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(dropRolesTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(dropOrgMembersTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(dropOrgsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec(regexp.QuoteMeta(dropKeysTable)).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec(regexp.QuoteMeta(dropUsersTable)).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec(regexp.QuoteMeta(createUsersTable)).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec(regexp.QuoteMeta(createKeysTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(createOrgsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(createOrgMembersTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(createRolesTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(createSharesTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(createVerificationsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(createAuditTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(createAuditNoUpdateTrigger)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(createAuditNoDeleteTrigger)).WillReturnResult(sqlmock.NewResult(0, 0))
 
 	err := database.MigrateAppDB(db)
 
 	require.NoError(t, err)
 	require.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestURL(t *testing.T) {
+	t.Run("test turso database", func(t *testing.T) {
+		require.Equal(
+			t,
+			"libsql://my_cool_db-my_cool_org.turso.io",
+			database.URL("my_cool_db-my_cool_org.turso.io"),
+		)
+	})
+
+	t.Run("test fake turso database", func(t *testing.T) {
+		require.Equal(
+			t,
+			"file:/tmp/turso/my_cool_db-my_cool_org.turso.io.db",
+			database.FakeURL("/tmp/turso")("my_cool_db-my_cool_org.turso.io"),
+		)
+	})
+}
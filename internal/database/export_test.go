@@ -0,0 +1,9 @@
+package database
+
+// Slots is the number of databases the manager is holding a slot for.
+func (m *ConnectionManager) Slots() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.slots)
+}
@@ -61,6 +61,24 @@ func NewCmdEnvironmentRename(handler pkg.CobraHandler) *cobra.Command {
 	return cmd
 }
 
+func NewCmdEnvironmentConfigure(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "configure [flags] ENVIRONMENT_NAME",
+		Aliases: []string{"c"},
+		Short:   "Configure an environment",
+		Example: "syringe environment configure -p my_cool_project --on-expired refuse prod",
+		Args:    cobra.MatchAll(cobra.ExactArgs(1)),
+		RunE:    handler,
+	}
+
+	addFlags(cmd)
+
+	cmd.Flags().String("on-expired", "", "What inject does when secrets have expired, either 'warn' or 'refuse'")
+	cmd.MarkFlagRequired("on-expired")
+
+	return cmd
+}
+
 func NewCmdEnvironmentList(handler pkg.CobraHandler) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "list [flags]",
@@ -80,3 +98,62 @@ func addFlags(cmd *cobra.Command) {
 	cmd.Flags().StringP("project", "p", "", "Project name")
 	cmd.MarkFlagRequired("project")
 }
+
+func NewCmdEnvironmentShare(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "share [flags]",
+		Aliases: []string{"sh"},
+		Short:   "Share an environment with another user",
+		Long:    "Share an environment with another registered user. They can then use it by passing '--shared-by YOUR_USERNAME' to secret and inject commands.",
+		Example: "syringe environment share -p my_cool_project -e staging --user janedoe --read-only --expires 7d",
+		Args:    cobra.MatchAll(cobra.ExactArgs(0)),
+		RunE:    handler,
+	}
+
+	addShareFlags(cmd)
+
+	cmd.Flags().Bool("read-only", false, "Only allow reading secrets")
+	cmd.Flags().String("expires", "", "Expire the share after a duration, e.g. 12h or 7d")
+
+	return cmd
+}
+
+func NewCmdEnvironmentUnshare(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "unshare [flags]",
+		Aliases: []string{"us"},
+		Short:   "Stop sharing an environment with another user",
+		Example: "syringe environment unshare -p my_cool_project -e staging --user janedoe",
+		Args:    cobra.MatchAll(cobra.ExactArgs(0)),
+		RunE:    handler,
+	}
+
+	addShareFlags(cmd)
+
+	return cmd
+}
+
+func NewCmdEnvironmentShares(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "shares [flags]",
+		Aliases: []string{"ls"},
+		Short:   "List environment shares",
+		Example: "syringe environment shares",
+		Args:    cobra.MatchAll(cobra.ExactArgs(0)),
+		RunE:    handler,
+	}
+
+	cmd.Flags().Bool("received", false, "List environments shared with you instead")
+
+	return cmd
+}
+
+func addShareFlags(cmd *cobra.Command) {
+	addFlags(cmd)
+
+	cmd.Flags().StringP("environment", "e", "", "Environment name")
+	cmd.MarkFlagRequired("environment")
+
+	cmd.Flags().String("user", "", "Username to share with")
+	cmd.MarkFlagRequired("user")
+}
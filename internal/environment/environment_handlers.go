@@ -3,8 +3,12 @@ package environment
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/nixpig/syringe.sh/pkg/helpers"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
 	"github.com/spf13/cobra"
 )
 
@@ -14,7 +18,7 @@ func NewHandlerEnvironmentAdd(environmentService EnvironmentService) pkg.CobraHa
 
 		project, _ := cmd.Flags().GetString("project")
 
-		if err := environmentService.Add(AddEnvironmentRequest{
+		if err := environmentService.Add(cmd.Context(), AddEnvironmentRequest{
 			Name:    environmentName,
 			Project: project,
 		}); err != nil {
@@ -33,7 +37,7 @@ func NewHandlerEnvironmentRemove(environmentService EnvironmentService) pkg.Cobr
 
 		project, _ := cmd.Flags().GetString("project")
 
-		if err := environmentService.Remove(RemoveEnvironmentRequest{
+		if err := environmentService.Remove(cmd.Context(), RemoveEnvironmentRequest{
 			Name:    environmentName,
 			Project: project,
 		}); err != nil {
@@ -53,7 +57,7 @@ func NewHandlerEnvironmentRename(environmentService EnvironmentService) pkg.Cobr
 
 		project, _ := cmd.Flags().GetString("project")
 
-		if err := environmentService.Rename(RenameEnvironmentRequest{
+		if err := environmentService.Rename(cmd.Context(), RenameEnvironmentRequest{
 			Name:    name,
 			NewName: newName,
 			Project: project,
@@ -67,11 +71,32 @@ func NewHandlerEnvironmentRename(environmentService EnvironmentService) pkg.Cobr
 	}
 }
 
+func NewHandlerEnvironmentConfigure(environmentService EnvironmentService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		environmentName := args[0]
+
+		project, _ := cmd.Flags().GetString("project")
+		onExpired, _ := cmd.Flags().GetString("on-expired")
+
+		if err := environmentService.Configure(cmd.Context(), ConfigureEnvironmentRequest{
+			Name:      environmentName,
+			Project:   project,
+			OnExpired: onExpired,
+		}); err != nil {
+			return err
+		}
+
+		cmd.Println(fmt.Sprintf("Environment '%s' configured in project '%s'", environmentName, project))
+
+		return nil
+	}
+}
+
 func NewHandlerEnvironmentList(environmentService EnvironmentService) pkg.CobraHandler {
 	return func(cmd *cobra.Command, args []string) error {
 		project, _ := cmd.Flags().GetString("project")
 
-		environments, err := environmentService.List(ListEnvironmentRequest{
+		environments, err := environmentService.List(cmd.Context(), ListEnvironmentRequest{
 			Project: project,
 		})
 		if err != nil {
@@ -88,3 +113,132 @@ func NewHandlerEnvironmentList(environmentService EnvironmentService) pkg.CobraH
 		return nil
 	}
 }
+
+func NewHandlerEnvironmentShare(shareService ShareService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		project, _ := cmd.Flags().GetString("project")
+		environment, _ := cmd.Flags().GetString("environment")
+		recipient, _ := cmd.Flags().GetString("user")
+		readOnly, _ := cmd.Flags().GetBool("read-only")
+		expires, _ := cmd.Flags().GetString("expires")
+
+		owner, err := shareOwner(cmd)
+		if err != nil {
+			return err
+		}
+
+		var expiresIn time.Duration
+		if expires != "" {
+			expiresIn, err = helpers.ParseDuration(expires)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := shareService.Share(cmd.Context(), ShareEnvironmentRequest{
+			Owner:       owner,
+			Recipient:   recipient,
+			Project:     project,
+			Environment: environment,
+			ReadOnly:    readOnly,
+			Expires:     expiresIn,
+		}); err != nil {
+			return err
+		}
+
+		cmd.Println(fmt.Sprintf("Environment '%s' in project '%s' shared with '%s'", environment, project, recipient))
+
+		return nil
+	}
+}
+
+func NewHandlerEnvironmentUnshare(shareService ShareService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		project, _ := cmd.Flags().GetString("project")
+		environment, _ := cmd.Flags().GetString("environment")
+		recipient, _ := cmd.Flags().GetString("user")
+
+		owner, err := shareOwner(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := shareService.Unshare(cmd.Context(), UnshareEnvironmentRequest{
+			Owner:       owner,
+			Recipient:   recipient,
+			Project:     project,
+			Environment: environment,
+		}); err != nil {
+			return err
+		}
+
+		cmd.Println(fmt.Sprintf("Environment '%s' in project '%s' no longer shared with '%s'", environment, project, recipient))
+
+		return nil
+	}
+}
+
+func NewHandlerEnvironmentShares(shareService ShareService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		received, _ := cmd.Flags().GetBool("received")
+
+		username, ok := cmd.Context().Value(ctxkeys.Username).(string)
+		if !ok {
+			return fmt.Errorf("unable to get username from context")
+		}
+
+		shares, err := shareService.List(cmd.Context(), ListSharesRequest{
+			Username: username,
+			Received: received,
+		})
+		if err != nil {
+			return err
+		}
+
+		sharesList := make([]string, len(shares.Shares))
+		for i, s := range shares.Shares {
+			user := s.Recipient
+			if received {
+				user = s.Owner
+			}
+
+			access := "read-write"
+			if s.ReadOnly {
+				access = "read-only"
+			}
+
+			expires := "never"
+			if s.ExpiresAt != "" {
+				expires = s.ExpiresAt
+			}
+			if s.Expired {
+				expires = fmt.Sprintf("%s (expired)", expires)
+			}
+
+			sharesList[i] = fmt.Sprintf("%s/%s %s %s expires: %s", s.Project, s.Environment, user, access, expires)
+		}
+
+		cmd.Print(strings.Join(sharesList, "\n"))
+
+		return nil
+	}
+}
+
+// shareOwner is the user sharing their environment, who must be working in
+// their own database rather than an organisation's or someone else's.
+func shareOwner(cmd *cobra.Command) (string, error) {
+	if org, _ := cmd.Flags().GetString("org"); org != "" {
+		return "", serrors.ErrShareInOrg
+	}
+
+	if sharedBy, _ := cmd.Flags().GetString("shared-by"); sharedBy != "" {
+		return "", serrors.ErrShareInOrg
+	}
+
+	username, ok := cmd.Context().Value(ctxkeys.Username).(string)
+	if !ok {
+		return "", fmt.Errorf("unable to get username from context")
+	}
+
+	return username, nil
+}
@@ -1,6 +1,12 @@
 package environment
 
 import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/ssh"
 	"github.com/nixpig/syringe.sh/pkg/serrors"
 	"github.com/nixpig/syringe.sh/pkg/validation"
 )
@@ -21,6 +27,12 @@ type RenameEnvironmentRequest struct {
 	Project string `name:"project name" validate:"required,min=1,max=256"`
 }
 
+type ConfigureEnvironmentRequest struct {
+	Name      string `name:"environment name" validate:"required,min=1,max=256"`
+	Project   string `name:"project name" validate:"required,min=1,max=256"`
+	OnExpired string `name:"on expired" validate:"required,oneof=warn refuse"`
+}
+
 type ListEnvironmentRequest struct {
 	Project string `name:"project name" validate:"required,min=1,max=256"`
 }
@@ -36,10 +48,11 @@ type ListEnvironmentsResponse struct {
 }
 
 type EnvironmentService interface {
-	Add(environment AddEnvironmentRequest) error
-	Remove(environment RemoveEnvironmentRequest) error
-	Rename(environment RenameEnvironmentRequest) error
-	List(project ListEnvironmentRequest) (*ListEnvironmentsResponse, error)
+	Add(ctx context.Context, environment AddEnvironmentRequest) error
+	Remove(ctx context.Context, environment RemoveEnvironmentRequest) error
+	Rename(ctx context.Context, environment RenameEnvironmentRequest) error
+	Configure(ctx context.Context, environment ConfigureEnvironmentRequest) error
+	List(ctx context.Context, project ListEnvironmentRequest) (*ListEnvironmentsResponse, error)
 }
 
 func NewEnvironmentServiceImpl(
@@ -58,6 +71,7 @@ type EnvironmentServiceImpl struct {
 }
 
 func (e EnvironmentServiceImpl) Add(
+	ctx context.Context,
 	environment AddEnvironmentRequest,
 ) error {
 	if err := e.validate.Struct(environment); err != nil {
@@ -65,6 +79,7 @@ func (e EnvironmentServiceImpl) Add(
 	}
 
 	if err := e.store.Add(
+		ctx,
 		environment.Name,
 		environment.Project,
 	); err != nil {
@@ -75,6 +90,7 @@ func (e EnvironmentServiceImpl) Add(
 }
 
 func (e EnvironmentServiceImpl) Remove(
+	ctx context.Context,
 	environment RemoveEnvironmentRequest,
 ) error {
 	if err := e.validate.Struct(environment); err != nil {
@@ -82,6 +98,7 @@ func (e EnvironmentServiceImpl) Remove(
 	}
 
 	if err := e.store.Remove(
+		ctx,
 		environment.Name,
 		environment.Project,
 	); err != nil {
@@ -92,6 +109,7 @@ func (e EnvironmentServiceImpl) Remove(
 }
 
 func (e EnvironmentServiceImpl) Rename(
+	ctx context.Context,
 	environment RenameEnvironmentRequest,
 ) error {
 	if err := e.validate.Struct(environment); err != nil {
@@ -99,6 +117,7 @@ func (e EnvironmentServiceImpl) Rename(
 	}
 
 	if err := e.store.Rename(
+		ctx,
 		environment.Name,
 		environment.NewName,
 		environment.Project,
@@ -109,14 +128,35 @@ func (e EnvironmentServiceImpl) Rename(
 	return nil
 }
 
+func (e EnvironmentServiceImpl) Configure(
+	ctx context.Context,
+	environment ConfigureEnvironmentRequest,
+) error {
+	if err := e.validate.Struct(environment); err != nil {
+		return serrors.ValidationError(err)
+	}
+
+	if err := e.store.SetOnExpired(
+		ctx,
+		environment.Name,
+		environment.Project,
+		environment.OnExpired,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (e EnvironmentServiceImpl) List(
+	ctx context.Context,
 	request ListEnvironmentRequest,
 ) (*ListEnvironmentsResponse, error) {
 	if err := e.validate.Struct(request); err != nil {
 		return nil, serrors.ValidationError(err)
 	}
 
-	environments, err := e.store.List(request.Project)
+	environments, err := e.store.List(ctx, request.Project)
 	if err != nil {
 		return nil, err
 	}
@@ -136,3 +176,172 @@ func (e EnvironmentServiceImpl) List(
 		Environments: environmentsResponseList,
 	}, nil
 }
+
+type ShareEnvironmentRequest struct {
+	Owner       string        `name:"username" validate:"required,min=1,max=256"`
+	Recipient   string        `name:"recipient username" validate:"required,min=1,max=256"`
+	Project     string        `name:"project name" validate:"required,min=1,max=256"`
+	Environment string        `name:"environment name" validate:"required,min=1,max=256"`
+	ReadOnly    bool          `name:"read only"`
+	Expires     time.Duration `name:"expires" validate:"min=0"`
+}
+
+type UnshareEnvironmentRequest struct {
+	Owner       string `name:"username" validate:"required,min=1,max=256"`
+	Recipient   string `name:"recipient username" validate:"required,min=1,max=256"`
+	Project     string `name:"project name" validate:"required,min=1,max=256"`
+	Environment string `name:"environment name" validate:"required,min=1,max=256"`
+}
+
+type ListSharesRequest struct {
+	Username string `name:"username" validate:"required,min=1,max=256"`
+	Received bool
+}
+
+type ShareResponse struct {
+	Owner       string
+	Recipient   string
+	Project     string
+	Environment string
+	ReadOnly    bool
+	ExpiresAt   string
+	Expired     bool
+}
+
+type ListSharesResponse struct {
+	Shares []ShareResponse
+}
+
+type ShareService interface {
+	Share(ctx context.Context, request ShareEnvironmentRequest) error
+	Unshare(ctx context.Context, request UnshareEnvironmentRequest) error
+	List(ctx context.Context, request ListSharesRequest) (*ListSharesResponse, error)
+	GetOwnerPublicKey(ctx context.Context, owner, recipient string) (ssh.PublicKey, error)
+}
+
+func NewShareServiceImpl(
+	store ShareStore,
+	environmentStore EnvironmentStore,
+	validate validation.Validator,
+) ShareService {
+	return ShareServiceImpl{
+		store:            store,
+		environmentStore: environmentStore,
+		validate:         validate,
+	}
+}
+
+type ShareServiceImpl struct {
+	store            ShareStore
+	environmentStore EnvironmentStore
+	validate         validation.Validator
+}
+
+func (s ShareServiceImpl) Share(ctx context.Context, request ShareEnvironmentRequest) error {
+	if err := s.validate.Struct(request); err != nil {
+		return serrors.ValidationError(err)
+	}
+
+	if request.Owner == request.Recipient {
+		return serrors.ErrShareWithSelf
+	}
+
+	environments, err := s.environmentStore.List(ctx, request.Project)
+	if err != nil {
+		return err
+	}
+
+	if !slices.ContainsFunc(*environments, func(e Environment) bool {
+		return e.Name == request.Environment
+	}) {
+		return serrors.ErrEnvironmentNotFound
+	}
+
+	var expiresAt sql.NullString
+	if request.Expires > 0 {
+		expiresAt = sql.NullString{
+			String: time.Now().UTC().Add(request.Expires).Format(time.DateTime),
+			Valid:  true,
+		}
+	}
+
+	if err := s.store.Add(
+		ctx,
+		request.Owner,
+		request.Recipient,
+		request.Project,
+		request.Environment,
+		request.ReadOnly,
+		expiresAt,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s ShareServiceImpl) Unshare(ctx context.Context, request UnshareEnvironmentRequest) error {
+	if err := s.validate.Struct(request); err != nil {
+		return serrors.ValidationError(err)
+	}
+
+	if err := s.store.Remove(
+		ctx,
+		request.Owner,
+		request.Recipient,
+		request.Project,
+		request.Environment,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s ShareServiceImpl) List(ctx context.Context, request ListSharesRequest) (*ListSharesResponse, error) {
+	if err := s.validate.Struct(request); err != nil {
+		return nil, serrors.ValidationError(err)
+	}
+
+	var shares *[]Share
+	var err error
+
+	if request.Received {
+		shares, err = s.store.ListReceived(ctx, request.Username)
+	} else {
+		shares, err = s.store.ListGranted(ctx, request.Username)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sharesResponseList []ShareResponse
+
+	for _, sv := range *shares {
+		sharesResponseList = append(sharesResponseList, ShareResponse{
+			Owner:       sv.Owner,
+			Recipient:   sv.Recipient,
+			Project:     sv.Project,
+			Environment: sv.Environment,
+			ReadOnly:    sv.ReadOnly,
+			ExpiresAt:   sv.ExpiresAt.String,
+			Expired:     sv.Expired,
+		})
+	}
+
+	return &ListSharesResponse{Shares: sharesResponseList}, nil
+}
+
+func (s ShareServiceImpl) GetOwnerPublicKey(ctx context.Context, owner, recipient string) (ssh.PublicKey, error) {
+	publicKey, err := s.store.GetOwnerPublicKey(ctx, owner, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
@@ -1,6 +1,7 @@
 package environment
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/nixpig/syringe.sh/pkg/serrors"
@@ -13,10 +14,11 @@ type Environment struct {
 }
 
 type EnvironmentStore interface {
-	Add(name, projectName string) error
-	Remove(name, projectName string) error
-	Rename(originalName, newName, projectName string) error
-	List(projectName string) (*[]Environment, error)
+	Add(ctx context.Context, name, projectName string) error
+	Remove(ctx context.Context, name, projectName string) error
+	Rename(ctx context.Context, originalName, newName, projectName string) error
+	List(ctx context.Context, projectName string) (*[]Environment, error)
+	SetOnExpired(ctx context.Context, name, projectName, onExpired string) error
 }
 
 type SqliteEnvironmentStore struct {
@@ -27,7 +29,7 @@ func NewSqliteEnvironmentStore(db *sql.DB) EnvironmentStore {
 	return SqliteEnvironmentStore{db}
 }
 
-func (s SqliteEnvironmentStore) Add(name, projectName string) error {
+func (s SqliteEnvironmentStore) Add(ctx context.Context, name, projectName string) error {
 	query := `
 		insert into environments_ (name_, project_id_) values (
 			$name,
@@ -35,7 +37,8 @@ func (s SqliteEnvironmentStore) Add(name, projectName string) error {
 		)
 	`
 
-	if _, err := s.db.Exec(
+	if _, err := s.db.ExecContext(
+		ctx,
 		query,
 		sql.Named("name", name),
 		sql.Named("projectName", projectName),
@@ -46,7 +49,7 @@ func (s SqliteEnvironmentStore) Add(name, projectName string) error {
 	return nil
 }
 
-func (s SqliteEnvironmentStore) Remove(name, projectName string) error {
+func (s SqliteEnvironmentStore) Remove(ctx context.Context, name, projectName string) error {
 	query := `
 		delete from environments_ 
 		where id_ in (
@@ -59,7 +62,8 @@ func (s SqliteEnvironmentStore) Remove(name, projectName string) error {
 		)
 	`
 
-	res, err := s.db.Exec(
+	res, err := s.db.ExecContext(
+		ctx,
 		query,
 		sql.Named("name", name),
 		sql.Named("projectName", projectName),
@@ -80,7 +84,7 @@ func (s SqliteEnvironmentStore) Remove(name, projectName string) error {
 	return nil
 }
 
-func (s SqliteEnvironmentStore) Rename(originalName, newName, projectName string) error {
+func (s SqliteEnvironmentStore) Rename(ctx context.Context, originalName, newName, projectName string) error {
 	query := `
 		update environments_ set name_ = $newName
 		where name_ = $originalName 
@@ -94,7 +98,8 @@ func (s SqliteEnvironmentStore) Rename(originalName, newName, projectName string
 		)
 	`
 
-	res, err := s.db.Exec(
+	res, err := s.db.ExecContext(
+		ctx,
 		query,
 		sql.Named("originalName", originalName),
 		sql.Named("newName", newName),
@@ -117,7 +122,7 @@ func (s SqliteEnvironmentStore) Rename(originalName, newName, projectName string
 	return nil
 }
 
-func (s SqliteEnvironmentStore) List(projectName string) (*[]Environment, error) {
+func (s SqliteEnvironmentStore) List(ctx context.Context, projectName string) (*[]Environment, error) {
 	query := `
 		select e.id_, e.name_, p.name_ from environments_ e
 		inner join projects_ p
@@ -125,7 +130,8 @@ func (s SqliteEnvironmentStore) List(projectName string) (*[]Environment, error)
 		where p.name_ = $projectName
 	`
 
-	rows, err := s.db.Query(
+	rows, err := s.db.QueryContext(
+		ctx,
 		query,
 		sql.Named("projectName", projectName),
 	)
@@ -154,3 +160,276 @@ func (s SqliteEnvironmentStore) List(projectName string) (*[]Environment, error)
 
 	return &environments, nil
 }
+
+func (s SqliteEnvironmentStore) SetOnExpired(ctx context.Context, name, projectName, onExpired string) error {
+	query := `
+		update environments_ set on_expired_ = $onExpired
+		where id_ in (
+			select e.id_ from environments_ e
+			inner join
+			projects_ p
+			on e.project_id_ = p.id_
+			where p.name_ = $projectName
+			and e.name_ = $name
+		)
+	`
+
+	res, err := s.db.ExecContext(
+		ctx,
+		query,
+		sql.Named("name", name),
+		sql.Named("projectName", projectName),
+		sql.Named("onExpired", onExpired),
+	)
+	if err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return serrors.ErrEnvironmentNotFound
+	}
+
+	return nil
+}
+
+type Share struct {
+	ID          int
+	Owner       string
+	Recipient   string
+	Project     string
+	Environment string
+	ReadOnly    bool
+	ExpiresAt   sql.NullString
+	CreatedAt   string
+	Expired     bool
+}
+
+type ShareStore interface {
+	Add(ctx context.Context, owner, recipient, project, environment string, readOnly bool, expiresAt sql.NullString) error
+	Remove(ctx context.Context, owner, recipient, project, environment string) error
+	ListGranted(ctx context.Context, owner string) (*[]Share, error)
+	ListReceived(ctx context.Context, recipient string) (*[]Share, error)
+	GetOwnerPublicKey(ctx context.Context, owner, recipient string) (string, error)
+}
+
+// SqliteShareStore keeps shares in the app database, since they cross the
+// boundary between one user's database and another's.
+type SqliteShareStore struct {
+	appDB *sql.DB
+}
+
+func NewSqliteShareStore(appDB *sql.DB) ShareStore {
+	return SqliteShareStore{appDB}
+}
+
+func (s SqliteShareStore) Add(
+	ctx context.Context,
+	owner, recipient, project, environment string,
+	readOnly bool,
+	expiresAt sql.NullString,
+) error {
+	query := `
+		insert into shares_ (owner_id_, recipient_id_, project_, environment_, read_only_, expires_at_)
+		select o.id_, r.id_, $project, $environment, $readOnly, $expiresAt
+		from users_ o, users_ r
+		where o.username_ = $owner
+		and r.username_ = $recipient
+		and exists (select 1 from keys_ k where k.user_id_ = r.id_)
+		on conflict(owner_id_, recipient_id_, project_, environment_)
+		do update set read_only_ = $readOnly, expires_at_ = $expiresAt
+	`
+
+	res, err := s.appDB.ExecContext(
+		ctx,
+		query,
+		sql.Named("owner", owner),
+		sql.Named("recipient", recipient),
+		sql.Named("project", project),
+		sql.Named("environment", environment),
+		sql.Named("readOnly", readOnly),
+		sql.Named("expiresAt", expiresAt),
+	)
+	if err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return serrors.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s SqliteShareStore) Remove(ctx context.Context, owner, recipient, project, environment string) error {
+	query := `
+		delete from shares_
+		where id_ in (
+			select s.id_ from shares_ s
+			inner join
+			users_ o
+			on s.owner_id_ = o.id_
+			inner join
+			users_ r
+			on s.recipient_id_ = r.id_
+			where o.username_ = $owner
+			and r.username_ = $recipient
+			and s.project_ = $project
+			and s.environment_ = $environment
+		)
+	`
+
+	res, err := s.appDB.ExecContext(
+		ctx,
+		query,
+		sql.Named("owner", owner),
+		sql.Named("recipient", recipient),
+		sql.Named("project", project),
+		sql.Named("environment", environment),
+	)
+	if err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return serrors.ErrShareNotFound
+	}
+
+	return nil
+}
+
+func (s SqliteShareStore) ListGranted(ctx context.Context, owner string) (*[]Share, error) {
+	query := `
+		select s.id_, o.username_, r.username_, s.project_, s.environment_, s.read_only_, s.expires_at_, s.created_at_,
+		coalesce(s.expires_at_ <= current_timestamp, false)
+		from shares_ s
+		inner join
+		users_ o
+		on s.owner_id_ = o.id_
+		inner join
+		users_ r
+		on s.recipient_id_ = r.id_
+		where o.username_ = $owner
+		order by s.project_, s.environment_, r.username_
+	`
+
+	rows, err := s.appDB.QueryContext(ctx, query, sql.Named("owner", owner))
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return scanShares(rows)
+}
+
+func (s SqliteShareStore) ListReceived(ctx context.Context, recipient string) (*[]Share, error) {
+	query := `
+		select s.id_, o.username_, r.username_, s.project_, s.environment_, s.read_only_, s.expires_at_, s.created_at_,
+		coalesce(s.expires_at_ <= current_timestamp, false)
+		from shares_ s
+		inner join
+		users_ o
+		on s.owner_id_ = o.id_
+		inner join
+		users_ r
+		on s.recipient_id_ = r.id_
+		where r.username_ = $recipient
+		order by o.username_, s.project_, s.environment_
+	`
+
+	rows, err := s.appDB.QueryContext(ctx, query, sql.Named("recipient", recipient))
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return scanShares(rows)
+}
+
+// GetOwnerPublicKey returns the key the owner registered with (which their
+// database is named after), but only while they have an unexpired share
+// with the recipient.
+func (s SqliteShareStore) GetOwnerPublicKey(ctx context.Context, owner, recipient string) (string, error) {
+	query := `
+		select k.ssh_public_key_
+		from keys_ k
+		inner join
+		users_ o
+		on k.user_id_ = o.id_
+		where o.username_ = $owner
+		and exists (
+			select 1 from shares_ s
+			inner join
+			users_ r
+			on s.recipient_id_ = r.id_
+			where s.owner_id_ = o.id_
+			and r.username_ = $recipient
+			and (s.expires_at_ is null or s.expires_at_ > current_timestamp)
+		)
+		order by k.id_
+		limit 1
+	`
+
+	row := s.appDB.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("owner", owner),
+		sql.Named("recipient", recipient),
+	)
+
+	var publicKey string
+
+	if err := row.Scan(&publicKey); err != nil {
+		if err == sql.ErrNoRows {
+			return "", serrors.ErrShareNotFound
+		}
+
+		return "", serrors.ErrDatabaseQuery(err)
+	}
+
+	return publicKey, nil
+}
+
+func scanShares(rows *sql.Rows) (*[]Share, error) {
+	defer rows.Close()
+
+	var shares []Share
+
+	for rows.Next() {
+		var share Share
+
+		if err := rows.Scan(
+			&share.ID,
+			&share.Owner,
+			&share.Recipient,
+			&share.Project,
+			&share.Environment,
+			&share.ReadOnly,
+			&share.ExpiresAt,
+			&share.CreatedAt,
+			&share.Expired,
+		); err != nil {
+			return nil, err
+		}
+
+		shares = append(shares, share)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return &shares, nil
+}
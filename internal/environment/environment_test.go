@@ -2,6 +2,7 @@ package environment_test
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/nixpig/syringe.sh/internal/environment"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
 	"github.com/nixpig/syringe.sh/pkg/validation"
 	"github.com/nixpig/syringe.sh/test"
 	"github.com/spf13/cobra"
@@ -52,6 +54,9 @@ func TestEnvironmentCmd(t *testing.T) {
 		"test environment list command validation errors":    testEnvironmentListCmdValidationError,
 		"test environment list command missing project flag": testEnvironmentListCmdMissingProjectFlag,
 		"test environment list command with too many args":   testEnvironmentListCmdWithTooManyArgs,
+
+		"test environment configure command happy path":         testEnvironmentConfigureCmdHappyPath,
+		"test environment configure command invalid on expired": testEnvironmentConfigureCmdInvalidOnExpired,
 	}
 
 	for scenario, fn := range scenarios {
@@ -717,6 +722,79 @@ func testEnvironmentRenameCmdHappyPath(
 	require.NoError(t, mock.ExpectationsWereMet())
 }
 
+func testEnvironmentConfigureCmdHappyPath(
+	t *testing.T,
+	cmd *cobra.Command,
+	service environment.EnvironmentService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(environment.NewCmdEnvironmentConfigure(
+		environment.NewHandlerEnvironmentConfigure(service),
+	))
+	cmd.SetArgs([]string{
+		"configure",
+		"-p",
+		"my_cool_project",
+		"--on-expired",
+		"refuse",
+		"prod",
+	})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	mock.ExpectExec(regexp.QuoteMeta(`
+		update environments_ set on_expired_ = $onExpired
+	`)).WithArgs(
+		"prod",
+		"my_cool_project",
+		"refuse",
+	).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.Equal(
+		t,
+		"Environment 'prod' configured in project 'my_cool_project'\n",
+		cmdOut.String(),
+	)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testEnvironmentConfigureCmdInvalidOnExpired(
+	t *testing.T,
+	cmd *cobra.Command,
+	service environment.EnvironmentService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(environment.NewCmdEnvironmentConfigure(
+		environment.NewHandlerEnvironmentConfigure(service),
+	))
+	cmd.SetArgs([]string{
+		"configure",
+		"-p",
+		"my_cool_project",
+		"--on-expired",
+		"ignore",
+		"prod",
+	})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.Equal(t, test.ErrorMsg(`"on expired" must be one of: warn, refuse`+"\n"), errOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func testEnvironmentRenameCmdDatabaseError(
 	t *testing.T,
 	cmd *cobra.Command,
@@ -1307,3 +1385,245 @@ func testEnvironmentRemoveCmdZeroAffectedRows(
 
 	require.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestEnvironmentShareCmd(t *testing.T) {
+	scenarios := map[string]func(
+		t *testing.T,
+		cmd *cobra.Command,
+		service environment.ShareService,
+		mock sqlmock.Sqlmock,
+	){
+		"test environment share command happy path":           testEnvironmentShareCmdHappyPath,
+		"test environment share command with self":            testEnvironmentShareCmdWithSelf,
+		"test environment share command unknown environment":  testEnvironmentShareCmdUnknownEnvironment,
+		"test environment share command invalid expiry":       testEnvironmentShareCmdInvalidExpiry,
+		"test environment unshare command share not found":    testEnvironmentUnshareCmdShareNotFound,
+		"test environment shares command lists granted share": testEnvironmentSharesCmdListsGranted,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			service := environment.NewShareServiceImpl(
+				environment.NewSqliteShareStore(db),
+				environment.NewSqliteEnvironmentStore(db),
+				validation.New(),
+			)
+
+			cmd := environment.NewCmdEnvironment()
+			cmd.SetContext(context.WithValue(context.Background(), ctxkeys.Username, "janedoe"))
+
+			fn(t, cmd, service, mock)
+		})
+	}
+}
+
+func expectEnvironments(mock sqlmock.Sqlmock, names ...string) {
+	rows := sqlmock.NewRows([]string{"id_", "name_", "project_name_"})
+	for i, name := range names {
+		rows.AddRow(i+1, name, "my_cool_project")
+	}
+
+	mock.
+		ExpectQuery(regexp.QuoteMeta(`
+			select e.id_, e.name_, p.name_ from environments_ e
+			inner join projects_ p
+			on e.project_id_ = p.id_
+			where p.name_ = $projectName
+		`)).
+		WithArgs("my_cool_project").
+		WillReturnRows(rows)
+}
+
+func testEnvironmentShareCmdHappyPath(
+	t *testing.T,
+	cmd *cobra.Command,
+	service environment.ShareService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(environment.NewCmdEnvironmentShare(
+		environment.NewHandlerEnvironmentShare(service),
+	))
+	cmd.SetArgs([]string{
+		"share",
+		"-p", "my_cool_project",
+		"-e", "staging",
+		"--user", "johndoe",
+		"--read-only",
+		"--expires", "7d",
+	})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	expectEnvironments(mock, "dev", "staging")
+
+	mock.
+		ExpectExec(regexp.QuoteMeta(`
+			insert into shares_ (owner_id_, recipient_id_, project_, environment_, read_only_, expires_at_)
+		`)).
+		WithArgs("janedoe", "johndoe", "my_cool_project", "staging", true, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.Equal(
+		t,
+		"Environment 'staging' in project 'my_cool_project' shared with 'johndoe'\n",
+		cmdOut.String(),
+	)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testEnvironmentShareCmdWithSelf(
+	t *testing.T,
+	cmd *cobra.Command,
+	service environment.ShareService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(environment.NewCmdEnvironmentShare(
+		environment.NewHandlerEnvironmentShare(service),
+	))
+	cmd.SetArgs([]string{"share", "-p", "my_cool_project", "-e", "staging", "--user", "janedoe"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.Equal(t, test.ErrorMsg("cannot share an environment with yourself\n"), errOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testEnvironmentShareCmdUnknownEnvironment(
+	t *testing.T,
+	cmd *cobra.Command,
+	service environment.ShareService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(environment.NewCmdEnvironmentShare(
+		environment.NewHandlerEnvironmentShare(service),
+	))
+	cmd.SetArgs([]string{"share", "-p", "my_cool_project", "-e", "prod", "--user", "johndoe"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	expectEnvironments(mock, "dev", "staging")
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.Equal(t, test.ErrorMsg("environment not found\n"), errOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testEnvironmentShareCmdInvalidExpiry(
+	t *testing.T,
+	cmd *cobra.Command,
+	service environment.ShareService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(environment.NewCmdEnvironmentShare(
+		environment.NewHandlerEnvironmentShare(service),
+	))
+	cmd.SetArgs([]string{
+		"share",
+		"-p", "my_cool_project",
+		"-e", "staging",
+		"--user", "johndoe",
+		"--expires", "soon",
+	})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testEnvironmentUnshareCmdShareNotFound(
+	t *testing.T,
+	cmd *cobra.Command,
+	service environment.ShareService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(environment.NewCmdEnvironmentUnshare(
+		environment.NewHandlerEnvironmentUnshare(service),
+	))
+	cmd.SetArgs([]string{"unshare", "-p", "my_cool_project", "-e", "staging", "--user", "johndoe"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	mock.
+		ExpectExec(regexp.QuoteMeta(`delete from shares_`)).
+		WithArgs("janedoe", "johndoe", "my_cool_project", "staging").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.Equal(t, test.ErrorMsg("share not found\n"), errOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testEnvironmentSharesCmdListsGranted(
+	t *testing.T,
+	cmd *cobra.Command,
+	service environment.ShareService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(environment.NewCmdEnvironmentShares(
+		environment.NewHandlerEnvironmentShares(service),
+	))
+	cmd.SetArgs([]string{"shares"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	mock.
+		ExpectQuery(regexp.QuoteMeta(`where o.username_ = $owner`)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"id_", "owner_", "recipient_", "project_", "environment_",
+				"read_only_", "expires_at_", "created_at_", "expired_",
+			}).
+				AddRow(1, "janedoe", "johndoe", "my_cool_project", "staging", true, "2024-06-08 00:00:00", "2024-06-01 00:00:00", true).
+				AddRow(2, "janedoe", "jimdoe", "my_cool_project", "dev", false, nil, "2024-06-01 00:00:00", false),
+		)
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.Equal(
+		t,
+		"my_cool_project/staging johndoe read-only expires: 2024-06-08 00:00:00 (expired)\n"+
+			"my_cool_project/dev jimdoe read-write expires: never",
+		cmdOut.String(),
+	)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/nixpig/syringe.sh/internal/secret"
 	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
 	"github.com/spf13/cobra"
 )
 
@@ -14,7 +15,7 @@ func NewHandlerInject(secretService secret.SecretService) pkg.CobraHandler {
 		project, _ := cmd.Flags().GetString("project")
 		environment, _ := cmd.Flags().GetString("environment")
 
-		secrets, err := secretService.List(secret.ListSecretsRequest{
+		secrets, err := secretService.List(cmd.Context(), secret.ListSecretsRequest{
 			Project:     project,
 			Environment: environment,
 		})
@@ -22,6 +23,14 @@ func NewHandlerInject(secretService secret.SecretService) pkg.CobraHandler {
 			return err
 		}
 
+		if expired := secrets.ExpiredKeys(); len(expired) > 0 {
+			if secrets.OnExpired == secret.OnExpiredRefuse {
+				return fmt.Errorf("%w: %s", serrors.ErrSecretsExpired, strings.Join(expired, ", "))
+			}
+
+			cmd.PrintErrln(fmt.Sprintf("Warning: %s: %s", serrors.ErrSecretsExpired, strings.Join(expired, ", ")))
+		}
+
 		secretsList := make([]string, len(secrets.Secrets))
 		for i, s := range secrets.Secrets {
 
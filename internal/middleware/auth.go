@@ -1,9 +1,14 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/charmbracelet/ssh"
 	"github.com/nixpig/syringe.sh/internal/auth"
 	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
 	"github.com/rs/zerolog"
 )
 
@@ -13,12 +18,33 @@ func NewMiddlewareAuth(
 ) func(next ssh.Handler) ssh.Handler {
 	return func(next ssh.Handler) ssh.Handler {
 		return func(sess ssh.Session) {
-			user, err := authService.AuthenticateUser(auth.AuthenticateUserRequest{
-				Username:  sess.User(),
-				PublicKey: sess.PublicKey(),
+			logger := sessionLogger(sess, logger)
+
+			if command, ok := auth.ForceCommand(sess.PublicKey()); ok &&
+				strings.Join(sess.Command(), " ") != command {
+				logger.Warn().Msg("command not allowed by certificate")
+
+				sess.Stderr().Write([]byte(fmt.Sprintf("Your certificate only allows running '%s'.\n", command)))
+				sess.Exit(1)
+
+				return
+			}
+
+			user, err := authService.AuthenticateUser(sess.Context(), auth.AuthenticateUserRequest{
+				Username:   sess.User(),
+				PublicKey:  sess.PublicKey(),
+				RemoteAddr: sess.RemoteAddr(),
 			})
+			if errors.Is(err, serrors.ErrAccountSuspended) || errors.Is(err, serrors.ErrAccountLocked) {
+				logger.Warn().Err(err).Msg("user cut off")
+
+				sess.Stderr().Write([]byte(accountStatusMsg(err)))
+				sess.Exit(1)
+
+				return
+			}
 			if err != nil {
-				logger.Warn().Msg("user not authenticated")
+				logger.Warn().Err(err).Msg("user not authenticated")
 
 				sess.Write([]byte("Public key not recognised.\n"))
 
@@ -26,8 +52,21 @@ func NewMiddlewareAuth(
 			}
 
 			sess.Context().SetValue(ctxkeys.Authenticated, user.Auth)
+			sess.Context().SetValue(ctxkeys.Verified, user.Verified)
+
+			if user.PublicKey != nil {
+				sess.Context().SetValue(ctxkeys.RegisteredKey, user.PublicKey)
+			}
 
 			next(sess)
 		}
 	}
 }
+
+func accountStatusMsg(err error) string {
+	if errors.Is(err, serrors.ErrAccountLocked) {
+		return "Your account has been locked, as it may have been compromised. Contact the operator of this service to have it unlocked.\n"
+	}
+
+	return "Your account has been suspended. Contact the operator of this service to have it reactivated.\n"
+}
@@ -6,32 +6,47 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/charmbracelet/ssh"
-	"github.com/nixpig/syringe.sh/internal/auth"
+	"github.com/nixpig/syringe.sh/internal/admin"
+	"github.com/nixpig/syringe.sh/internal/audit"
+	"github.com/nixpig/syringe.sh/internal/commands"
 	"github.com/nixpig/syringe.sh/internal/database"
 	"github.com/nixpig/syringe.sh/internal/environment"
-	"github.com/nixpig/syringe.sh/internal/inject"
+	"github.com/nixpig/syringe.sh/internal/org"
+	"github.com/nixpig/syringe.sh/internal/policy"
 	"github.com/nixpig/syringe.sh/internal/project"
 	"github.com/nixpig/syringe.sh/internal/root"
 	"github.com/nixpig/syringe.sh/internal/secret"
 	"github.com/nixpig/syringe.sh/internal/user"
 	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
-	"github.com/nixpig/syringe.sh/pkg/helpers"
+	"github.com/nixpig/syringe.sh/pkg/mailer"
+	"github.com/nixpig/syringe.sh/pkg/turso"
 	"github.com/nixpig/syringe.sh/pkg/validation"
 	"github.com/rs/zerolog"
-	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel/trace"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 func NewMiddlewareCommand(
 	logger *zerolog.Logger,
 	appDB *sql.DB,
 	validate validation.Validator,
+	connections *database.ConnectionManager,
+	tursoAPISettings user.TursoAPISettings,
+	httpClient http.Client,
+	mailer mailer.Mailer,
+	admins admin.Allowlist,
+	timeout func(command []string) time.Duration,
 ) func(next ssh.Handler) ssh.Handler {
 	return func(next ssh.Handler) ssh.Handler {
 		return func(sess ssh.Session) {
+			logger := sessionLogger(sess, logger)
+
 			var userDB *sql.DB
+			var releaseUserDB func()
 			var err error
 
 			ctx, ok := sess.Context().(context.Context)
@@ -41,169 +56,297 @@ func NewMiddlewareCommand(
 				return
 			}
 
+			// the command, and the queries and calls it makes, are cancelled
+			// once it runs out of time, or when the session ends
+			limit := timeout(sess.Command())
+			if limit > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, limit)
+				defer cancel()
+			}
+
+			// users authenticated by a certificate are known by the key they
+			// registered with, which their database is named from
+			publicKey := sess.PublicKey()
+			if registeredKey, ok := sess.Context().Value(ctxkeys.RegisteredKey).(ssh.PublicKey); ok {
+				publicKey = registeredKey
+			}
+
+			if span, ok := sess.Context().Value(ctxkeys.Span).(trace.Span); ok {
+				ctx = trace.ContextWithSpan(ctx, span)
+			}
+
 			ctx = context.WithValue(ctx, ctxkeys.Username, sess.User())
-			ctx = context.WithValue(ctx, ctxkeys.PublicKey, sess.PublicKey())
+			ctx = context.WithValue(ctx, ctxkeys.PublicKey, publicKey)
+			ctx = context.WithValue(ctx, ctxkeys.Admin, admins.Allows(sess.PublicKey()))
 
 			authenticated, ok := sess.Context().Value(ctxkeys.Authenticated).(bool)
 			if !ok {
-				logger.Warn().
-					Str("session", sess.Context().SessionID()).
-					Msg("failed to get authentication status from context")
+				logger.Warn().Msg("failed to get authentication status from context")
 				sess.Stderr().Write([]byte("Failed to establish authentication status"))
 				return
 			}
 
-			if authenticated {
-				userDB, err = database.NewUserDBConnection(sess.PublicKey())
-				if err != nil {
-					logger.Error().Err(err).
-						Str("session", sess.Context().SessionID()).
-						Msg("failed to obtain user database connection")
-					sess.Stderr().Write([]byte("Failed to obtain database connection using the provided public key"))
-					return
-				}
-
-				// database connection is tightly coupled to, and lasts only for the duration of, the request
-				defer userDB.Close()
-			}
-
-			// -- COMMANDS
-			cmdRoot := root.New(ctx)
-
-			// -- USER CMD
-			cmdUser := user.NewCmdUser()
-
 			userService := user.NewUserServiceImpl(
 				user.NewSqliteUserStore(appDB),
 				validate,
-				http.Client{},
-				user.TursoAPISettings{
-					URL:   os.Getenv("API_BASE_URL"),
-					Token: os.Getenv("API_TOKEN"),
-				},
+				httpClient,
+				tursoAPISettings,
+				user.WithMailer(mailer),
+				user.WithConnections(connections),
 			)
 
-			handlerUserRegister := user.NewHandlerUserRegister(userService)
-			cmdUser.AddCommand(user.NewCmdUserRegister(handlerUserRegister))
-			cmdRoot.AddCommand(cmdUser)
-
-			// -- PROJECT CMD
-			cmdProject := project.NewCmdProject()
-			cmdProject.PersistentPreRunE = auth.PreRunE
-
-			projectService := project.NewProjectServiceImpl(
-				project.NewSqliteProjectStore(userDB),
+			orgService := org.NewOrgServiceImpl(
+				org.NewSqliteOrgStore(appDB),
 				validate,
+				userService,
 			)
 
-			handlerProjectAdd := project.NewHandlerProjectAdd(projectService)
-			cmdProjectAdd := project.NewCmdProjectAdd(handlerProjectAdd)
-			cmdProject.AddCommand(cmdProjectAdd)
-
-			handlerProjectRemove := project.NewHandlerProjectRemove(projectService)
-			cmdProjectRemove := project.NewCmdProjectRemove(handlerProjectRemove)
-			cmdProject.AddCommand(cmdProjectRemove)
-
-			handlerProjectRename := project.NewHandlerProjectRename(projectService)
-			cmdProjectRename := project.NewCmdProjectRename(handlerProjectRename)
-			cmdProject.AddCommand(cmdProjectRename)
+			orgName := flagFromArgs(sess.Command(), "org")
+			sharedBy := flagFromArgs(sess.Command(), "shared-by")
 
-			handlerProjectList := project.NewHandlerProjectList(projectService)
-			cmdProjectList := project.NewCmdProjectList(handlerProjectList)
-			cmdProject.AddCommand(cmdProjectList)
+			if orgName != "" && sharedBy != "" {
+				sess.Stderr().Write([]byte("Only one of '--org' and '--shared-by' can be used"))
+				return
+			}
 
-			cmdRoot.AddCommand(cmdProject)
+			shareStore := environment.NewSqliteShareStore(appDB)
 
-			// -- ENVIRONMENT CMD
-			cmdEnvironment := environment.NewCmdEnvironment()
-			cmdEnvironment.PersistentPreRunE = auth.PreRunE
+			policyService := policy.NewPolicyServiceImpl(
+				policy.NewSqlitePolicyStore(appDB),
+				validate,
+			)
 
-			environmentService := environment.NewEnvironmentServiceImpl(
-				environment.NewSqliteEnvironmentStore(userDB),
+			auditService := audit.NewAuditServiceImpl(
+				audit.NewSqliteAuditStore(appDB),
 				validate,
+				policyService,
 			)
 
-			handlerEnvironmentAdd := environment.NewHandlerEnvironmentAdd(environmentService)
-			cmdEnvironmentAdd := environment.NewCmdEnvironmentAdd(handlerEnvironmentAdd)
-			cmdEnvironment.AddCommand(cmdEnvironmentAdd)
+			actor := audit.Actor{
+				Username:      sess.User(),
+				RemoteAddress: sess.RemoteAddr().String(),
+				SessionID:     sess.Context().SessionID(),
+			}
 
-			handlerEnvironmentRemove := environment.NewHandlerEnvironmentRemove(environmentService)
-			cmdEnvironmentRemove := environment.NewCmdEnvironmentRemove(handlerEnvironmentRemove)
-			cmdEnvironment.AddCommand(cmdEnvironmentRemove)
+			if sess.PublicKey() != nil {
+				actor.KeyFingerprint = gossh.FingerprintSHA256(sess.PublicKey())
+			}
 
-			handlerEnvironmentRename := environment.NewHandlerEnvironmentRename(environmentService)
-			cmdEnvironmentRename := environment.NewCmdEnvironmentRename(handlerEnvironmentRename)
-			cmdEnvironment.AddCommand(cmdEnvironmentRename)
+			verified, _ := sess.Context().Value(ctxkeys.Verified).(bool)
 
-			handlerEnvironmentList := environment.NewHandlerEnvironmentList(environmentService)
-			cmdEnvironmentList := environment.NewCmdEnvironmentList(handlerEnvironmentList)
-			cmdEnvironment.AddCommand(cmdEnvironmentList)
+			subject := policy.Subject{
+				Username:      sess.User(),
+				Org:           orgName,
+				SharedBy:      sharedBy,
+				Authenticated: authenticated,
+				Verified:      verified,
+			}
 
-			cmdRoot.AddCommand(cmdEnvironment)
+			if authenticated {
+				if orgName != "" {
+					var membership *org.OrgResponse
+
+					membership, err = orgService.GetMemberOrg(ctx, org.GetMemberOrgRequest{
+						Org:      orgName,
+						Username: sess.User(),
+					})
+					if err != nil {
+						logger.Warn().Err(err).
+							Str("org", orgName).
+							Msg("failed to get organisation membership")
+						sess.Stderr().Write([]byte(fmt.Sprintf("Unable to access organisation '%s'", orgName)))
+						return
+					}
+
+					userDB, releaseUserDB, err = connections.Connect(ctx, membership.DatabaseName)
+				} else if sharedBy != "" {
+					var ownerPublicKey ssh.PublicKey
+
+					// the owner's database isn't connected yet, and only the share lookup is needed to connect it
+					ownerPublicKey, err = environment.
+						NewShareServiceImpl(shareStore, nil, validate).
+						GetOwnerPublicKey(ctx, sharedBy, sess.User())
+					if err != nil {
+						logger.Warn().Err(err).
+							Str("shared_by", sharedBy).
+							Msg("failed to get shared environment owner")
+						sess.Stderr().Write([]byte(fmt.Sprintf("No environments shared by '%s'", sharedBy)))
+						return
+					}
+
+					userDB, releaseUserDB, err = connections.ConnectUser(ctx, ownerPublicKey)
+				} else {
+					userDB, releaseUserDB, err = connections.ConnectUser(ctx, publicKey)
+				}
+				if err != nil {
+					logger.Error().Err(err).Msg("failed to obtain user database connection")
+					sess.Stderr().Write([]byte("Failed to obtain database connection using the provided public key"))
+					return
+				}
 
-			// -- SECRET CMD
-			cmdSecret := secret.NewCmdSecret()
-			cmdSecret.PersistentPreRunE = auth.PreRunE
+				// the connection is pooled, so is handed back rather than closed at the end of the request
+				defer releaseUserDB()
+			}
 
-			secretService := secret.NewSecretServiceImpl(
-				secret.NewSqliteSecretStore(userDB),
+			// -- SERVICES
+			// exporting reads the user's own database, which is only connected once they're authenticated
+			accountService := user.NewUserServiceImpl(
+				user.NewSqliteUserStore(appDB),
 				validate,
+				httpClient,
+				tursoAPISettings,
+				user.WithDataStore(user.NewSqliteDataStore(userDB)),
+			)
+
+			projectService := policy.NewProjectService(
+				project.NewProjectServiceImpl(
+					project.NewSqliteProjectStore(userDB),
+					validate,
+				),
+				policyService,
+				subject,
 			)
 
-			handlerSecretSet := secret.NewHandlerSecretSet(secretService)
-			cmdSecretSet := secret.NewCmdSecretSet(handlerSecretSet)
-			cmdSecret.AddCommand(cmdSecretSet)
+			environmentService := policy.NewEnvironmentService(
+				environment.NewEnvironmentServiceImpl(
+					environment.NewSqliteEnvironmentStore(userDB),
+					validate,
+				),
+				policyService,
+				subject,
+			)
+
+			shareService := environment.NewShareServiceImpl(
+				shareStore,
+				environment.NewSqliteEnvironmentStore(userDB),
+				validate,
+			)
 
-			handlerSecretGet := secret.NewHandlerSecretGet(secretService)
-			cmdSecretGet := secret.NewCmdSecretGet(handlerSecretGet)
-			cmdSecret.AddCommand(cmdSecretGet)
+			secretStore := secret.NewSqliteSecretStore(userDB)
+
+			secretService := audit.NewSecretService(
+				policy.NewSecretService(
+					secret.NewSecretServiceImpl(secretStore, validate),
+					policyService,
+					subject,
+				),
+				auditService,
+				actor,
+				subject,
+				"",
+			)
 
-			handlerSecretList := secret.NewHandlerSecretList(secretService)
-			cmdSecretList := secret.NewCmdSecretList(handlerSecretList)
-			cmdSecret.AddCommand(cmdSecretList)
+			injectableSecretService := audit.NewSecretService(
+				policy.NewInjectableSecretService(
+					secret.NewSecretServiceImpl(secretStore, validate),
+					policyService,
+					subject,
+				),
+				auditService,
+				actor,
+				subject,
+				"inject",
+			)
 
-			handlerSecretRemove := secret.NewHandlerSecretRemove(secretService)
-			cmdSecretRemove := secret.NewCmdSecretRemove(handlerSecretRemove)
-			cmdSecret.AddCommand(cmdSecretRemove)
+			tursoOptions := []turso.Option{turso.WithBaseURL(tursoAPISettings.URL)}
+			if tursoAPISettings.Retry != nil {
+				tursoOptions = append(tursoOptions, turso.WithRetry(*tursoAPISettings.Retry))
+			}
 
-			cmdRoot.AddCommand(cmdSecret)
+			tursoAPI := turso.New(
+				tursoAPISettings.Organization,
+				tursoAPISettings.Token,
+				httpClient,
+				tursoOptions...,
+			)
 
-			// -- INJECT CMD
-			handlerInject := inject.NewHandlerInject(secretService)
-			cmdInject := inject.NewCmdInject(handlerInject)
-			cmdInject.PersistentPreRunE = auth.PreRunE
-			cmdRoot.AddCommand(cmdInject)
+			adminService := admin.NewAdminServiceImpl(
+				admin.NewSqliteAdminStore(appDB),
+				validate,
+				userService,
+				&tursoAPI,
+				admin.WithConnections(connections),
+			)
 
-			helpers.WalkCmd(cmdRoot, func(c *cobra.Command) {
-				c.Flags().BoolP("help", "h", false, fmt.Sprintf("Help for the '%s' command", c.Name()))
-				c.Flags().BoolP("version", "v", false, "Print version information")
-			})
+			// -- COMMANDS
+			cmdRoot := root.New(ctx)
+
+			commands.Build(cmdRoot, commands.Local(commands.Services{
+				User:             userService,
+				Account:          accountService,
+				Org:              orgService,
+				Project:          projectService,
+				Environment:      environmentService,
+				Share:            shareService,
+				Secret:           secretService,
+				InjectableSecret: injectableSecretService,
+				Policy:           policyService,
+				Audit:            auditService,
+				Admin:            adminService,
+			}))
 
 			// --------------------------------------
 
 			cmdRoot.SetArgs(sess.Command())
+
+			// the command is logged by its path, since its arguments can
+			// include secrets, and handlers log with the session's logger
+			if found, _, err := cmdRoot.Find(sess.Command()); err == nil {
+				commandLogger := logger.With().Str("command", found.CommandPath()).Logger()
+				logger = &commandLogger
+			}
+
+			ctx = logger.WithContext(ctx)
+
 			cmdRoot.SetIn(sess)
 			cmdRoot.SetOut(sess)
 			cmdRoot.SetErr(sess.Stderr())
 			cmdRoot.CompletionOptions.DisableDefaultCmd = true
 
-			if err := cmdRoot.ExecuteContext(ctx); err != nil {
-				logger.Error().
-					Err(err).
-					Str("session", sess.Context().SessionID()).
-					Any("command", sess.Command()).
-					Msg("failed to execute command")
+			// the command that ran, without its arguments, is recorded for the
+			// metrics middleware
+			executed, err := cmdRoot.ExecuteContextC(ctx)
+			if executed != nil {
+				sess.Context().SetValue(ctxkeys.CommandPath, executed.CommandPath())
+			}
+
+			if err != nil {
+				sess.Context().SetValue(ctxkeys.CommandFailed, true)
+
+				logger.Error().Err(err).Msg("failed to execute command")
+
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					logger.Warn().Dur("timeout", limit).Msg("command timed out")
+					sess.Stderr().Write([]byte(fmt.Sprintf("Command timed out after %s\n", limit)))
+				}
+
+				// lets clients tell that the command failed, e.g. so inject doesn't run
+				sess.Exit(1)
 
 				next(sess)
 				return
 			}
 
-			logger.Info().
-				Str("session", sess.Context().SessionID()).
-				Any("command", sess.Command()).
-				Msg("executed command")
+			logger.Info().Msg("executed command")
 
 			next(sess)
 		}
 	}
 }
+
+// flagFromArgs picks a flag, such as '--org', out of the raw session command,
+// so the right database can be connected before the command tree is built.
+func flagFromArgs(args []string, name string) string {
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	flags.Usage = func() {}
+
+	value := flags.String(name, "", "")
+
+	if err := flags.Parse(args); err != nil {
+		return ""
+	}
+
+	return *value
+}
@@ -2,17 +2,34 @@ package middleware
 
 import (
 	"github.com/charmbracelet/ssh"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// NewMiddlewareLogging logs each session connecting and disconnecting, and
+// gives the middleware after it a logger for the session, which includes
+// the session's ID, user and address, and trace if it's being traced.
 func NewMiddlewareLogging(logger *zerolog.Logger) func(next ssh.Handler) ssh.Handler {
 	return func(next ssh.Handler) ssh.Handler {
 		return func(sess ssh.Session) {
-			// log incoming connection
-			logger.Info().
+			logContext := logger.With().
 				Str("session", sess.Context().SessionID()).
 				Str("user", sess.User()).
-				Str("address", sess.RemoteAddr().String()).
+				Str("address", sess.RemoteAddr().String())
+
+			if span, ok := sess.Context().Value(ctxkeys.Span).(trace.Span); ok && span.SpanContext().IsValid() {
+				logContext = logContext.
+					Str("trace_id", span.SpanContext().TraceID().String()).
+					Str("span_id", span.SpanContext().SpanID().String())
+			}
+
+			sessionLogger := logContext.Logger()
+
+			sess.Context().SetValue(ctxkeys.Logger, &sessionLogger)
+
+			// log incoming connection
+			sessionLogger.Info().
 				Bool("publickey", sess.PublicKey() != nil).
 				Str("client", sess.Context().ClientVersion()).
 				Msg("connect")
@@ -20,9 +37,17 @@ func NewMiddlewareLogging(logger *zerolog.Logger) func(next ssh.Handler) ssh.Han
 			next(sess)
 
 			// log end of connection
-			logger.Info().
-				Str("session", sess.Context().SessionID()).
-				Msg("disconnect")
+			sessionLogger.Info().Msg("disconnect")
 		}
 	}
 }
+
+// sessionLogger is the logger the logging middleware made for the session,
+// or logger if there isn't one.
+func sessionLogger(sess ssh.Session, logger *zerolog.Logger) *zerolog.Logger {
+	if l, ok := sess.Context().Value(ctxkeys.Logger).(*zerolog.Logger); ok {
+		return l
+	}
+
+	return logger
+}
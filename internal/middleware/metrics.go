@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/nixpig/syringe.sh/pkg/metrics"
+)
+
+// SessionMetrics are what the metrics middleware records.
+type SessionMetrics struct {
+	Active *metrics.Gauge
+	Total  *metrics.Counter
+	// RateLimited counts sessions refused by the rate limit middleware.
+	RateLimited *metrics.Counter
+	// Auth is labelled by result: 'success', 'anonymous' for keys that
+	// aren't registered, or 'failure'.
+	Auth *metrics.Counter
+	// Commands is labelled by command path, e.g. 'syringe secret set', and
+	// result: 'ok' or 'error'.
+	Commands *metrics.Counter
+	// CommandDuration is labelled by command path.
+	CommandDuration *metrics.Histogram
+}
+
+// NewMiddlewareMetrics records sessions, how they authenticated, and the
+// commands they ran. It has to run before every other middleware, to see
+// what they did.
+func NewMiddlewareMetrics(sessionMetrics SessionMetrics) func(next ssh.Handler) ssh.Handler {
+	return func(next ssh.Handler) ssh.Handler {
+		return func(sess ssh.Session) {
+			sessionMetrics.Active.Inc()
+			sessionMetrics.Total.Inc()
+
+			start := time.Now()
+
+			next(sess)
+
+			sessionMetrics.Active.Dec()
+
+			if rateLimited, _ := sess.Context().Value(ctxkeys.RateLimited).(bool); rateLimited {
+				sessionMetrics.RateLimited.Inc()
+				return
+			}
+
+			switch authenticated, ok := sess.Context().Value(ctxkeys.Authenticated).(bool); {
+			case !ok:
+				sessionMetrics.Auth.Inc("failure")
+			case authenticated:
+				sessionMetrics.Auth.Inc("success")
+			default:
+				sessionMetrics.Auth.Inc("anonymous")
+			}
+
+			commandPath, ok := sess.Context().Value(ctxkeys.CommandPath).(string)
+			if !ok {
+				return
+			}
+
+			result := "ok"
+			if failed, _ := sess.Context().Value(ctxkeys.CommandFailed).(bool); failed {
+				result = "error"
+			}
+
+			sessionMetrics.Commands.Inc(commandPath, result)
+			sessionMetrics.CommandDuration.Observe(time.Since(start).Seconds(), commandPath)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/nixpig/syringe.sh/pkg/ratelimit"
+	"github.com/rs/zerolog"
+)
+
+// NewMiddlewareRateLimit limits how often each address can run commands, and
+// counts sessions that don't authenticate towards banning it. It has to run
+// before the auth middleware.
+func NewMiddlewareRateLimit(
+	logger *zerolog.Logger,
+	guard *ratelimit.Guard,
+) func(next ssh.Handler) ssh.Handler {
+	return func(next ssh.Handler) ssh.Handler {
+		return func(sess ssh.Session) {
+			if err := guard.Session(sess.RemoteAddr()); err != nil {
+				rateLimited(sess, logger, err)
+				return
+			}
+
+			next(sess)
+
+			// whether the user's authenticated is unset when the auth middleware
+			// couldn't tell, e.g. when their account's been cut off, and false
+			// when the key isn't theirs
+			if authenticated, _ := sess.Context().Value(ctxkeys.Authenticated).(bool); !authenticated {
+				guard.Fail(sess.RemoteAddr())
+			}
+		}
+	}
+}
+
+// NewMiddlewareUserRateLimit limits how often each user can connect and run
+// commands. It has to run after the auth middleware, so that users' limits
+// are only used up by sessions that are theirs.
+func NewMiddlewareUserRateLimit(
+	logger *zerolog.Logger,
+	guard *ratelimit.Guard,
+) func(next ssh.Handler) ssh.Handler {
+	return func(next ssh.Handler) ssh.Handler {
+		return func(sess ssh.Session) {
+			if authenticated, _ := sess.Context().Value(ctxkeys.Authenticated).(bool); authenticated {
+				if err := guard.User(sess.User()); err != nil {
+					rateLimited(sess, logger, err)
+					return
+				}
+			}
+
+			next(sess)
+		}
+	}
+}
+
+func rateLimited(sess ssh.Session, logger *zerolog.Logger, err error) {
+	sessionLogger(sess, logger).Warn().
+		Err(err).
+		Msg("rate limited")
+
+	sess.Context().SetValue(ctxkeys.RateLimited, true)
+	sess.Stderr().Write([]byte(rateLimitedMsg(err)))
+	sess.Exit(1)
+}
+
+func rateLimitedMsg(err error) string {
+	var limited *ratelimit.LimitedError
+	if !errors.As(err, &limited) {
+		return "Rate limited.\n"
+	}
+
+	if limited.Banned {
+		return fmt.Sprintf("Rate limited after too many failed attempts. Try again in %s.\n", limited.RetryIn())
+	}
+
+	return fmt.Sprintf("Rate limited. Try again in %s.\n", limited.RetryIn())
+}
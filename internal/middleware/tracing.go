@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"github.com/charmbracelet/ssh"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/nixpig/syringe.sh/internal/middleware")
+
+// NewMiddlewareTracing traces each session in a span, which the command
+// middleware makes the parent of the spans its command creates. It has to
+// run before the other middleware, so that their work is in the span.
+func NewMiddlewareTracing() func(next ssh.Handler) ssh.Handler {
+	return func(next ssh.Handler) ssh.Handler {
+		return func(sess ssh.Session) {
+			_, span := tracer.Start(
+				sess.Context(),
+				"ssh.session",
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("ssh.session.id", sess.Context().SessionID()),
+					attribute.String("ssh.user", sess.User()),
+					attribute.String("ssh.client.version", sess.Context().ClientVersion()),
+					attribute.String("client.address", sess.RemoteAddr().String()),
+				),
+			)
+			defer span.End()
+
+			// the session's context can't be replaced, so the span is passed on
+			// as a value
+			sess.Context().SetValue(ctxkeys.Span, span)
+
+			next(sess)
+
+			rateLimited, _ := sess.Context().Value(ctxkeys.RateLimited).(bool)
+			authenticated, _ := sess.Context().Value(ctxkeys.Authenticated).(bool)
+
+			span.SetAttributes(
+				attribute.Bool("syringe.rate_limited", rateLimited),
+				attribute.Bool("syringe.authenticated", authenticated),
+			)
+
+			if commandPath, ok := sess.Context().Value(ctxkeys.CommandPath).(string); ok {
+				span.SetAttributes(attribute.String("syringe.command", commandPath))
+			}
+
+			if failed, _ := sess.Context().Value(ctxkeys.CommandFailed).(bool); failed {
+				span.SetStatus(codes.Error, "command failed")
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package org
+
+import (
+	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/spf13/cobra"
+)
+
+func NewCmdOrg() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "org",
+		Aliases: []string{"o"},
+		Short:   "Manage organisations",
+		Long:    "Manage organisations and their members. Pass '--org ORG_NAME' to project, environment, secret and inject commands to work with an organisation's shared projects.",
+	}
+
+	return cmd
+}
+
+func NewCmdOrgCreate(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "create [flags] ORG_NAME",
+		Aliases: []string{"c"},
+		Short:   "Create an organisation",
+		Example: "syringe org create my_cool_org",
+		Args:    cobra.MatchAll(cobra.ExactArgs(1)),
+		RunE:    handler,
+	}
+
+	return cmd
+}
+
+func NewCmdOrgInvite(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "invite [flags] ORG_NAME USERNAME",
+		Aliases: []string{"i"},
+		Short:   "Add a user to an organisation",
+		Example: "syringe org invite my_cool_org janedoe",
+		Args:    cobra.MatchAll(cobra.ExactArgs(2)),
+		RunE:    handler,
+	}
+
+	return cmd
+}
+
+func NewCmdOrgRemove(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "remove [flags] ORG_NAME USERNAME",
+		Aliases: []string{"r"},
+		Short:   "Remove a user from an organisation",
+		Example: "syringe org remove my_cool_org janedoe",
+		Args:    cobra.MatchAll(cobra.ExactArgs(2)),
+		RunE:    handler,
+	}
+
+	return cmd
+}
+
+func NewCmdOrgList(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list [flags]",
+		Aliases: []string{"l"},
+		Short:   "List organisations you are a member of",
+		Example: "syringe org list",
+		Args:    cobra.NoArgs,
+		RunE:    handler,
+	}
+
+	return cmd
+}
@@ -0,0 +1,105 @@
+package org
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/spf13/cobra"
+)
+
+func NewHandlerOrgCreate(orgService OrgService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		orgName := args[0]
+
+		username, ok := cmd.Context().Value(ctxkeys.Username).(string)
+		if !ok {
+			return fmt.Errorf("unable to get username from context")
+		}
+
+		if _, err := orgService.Create(cmd.Context(), CreateOrgRequest{
+			Name:     orgName,
+			Username: username,
+		}); err != nil {
+			return err
+		}
+
+		cmd.Println(fmt.Sprintf("Organisation '%s' created", orgName))
+
+		return nil
+	}
+}
+
+func NewHandlerOrgInvite(orgService OrgService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		orgName := args[0]
+		member := args[1]
+
+		username, ok := cmd.Context().Value(ctxkeys.Username).(string)
+		if !ok {
+			return fmt.Errorf("unable to get username from context")
+		}
+
+		if err := orgService.Invite(cmd.Context(), InviteMemberRequest{
+			Org:      orgName,
+			Member:   member,
+			Username: username,
+		}); err != nil {
+			return err
+		}
+
+		cmd.Println(fmt.Sprintf("User '%s' added to organisation '%s'", member, orgName))
+
+		return nil
+	}
+}
+
+func NewHandlerOrgRemove(orgService OrgService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		orgName := args[0]
+		member := args[1]
+
+		username, ok := cmd.Context().Value(ctxkeys.Username).(string)
+		if !ok {
+			return fmt.Errorf("unable to get username from context")
+		}
+
+		if err := orgService.Remove(cmd.Context(), RemoveMemberRequest{
+			Org:      orgName,
+			Member:   member,
+			Username: username,
+		}); err != nil {
+			return err
+		}
+
+		cmd.Println(fmt.Sprintf("User '%s' removed from organisation '%s'", member, orgName))
+
+		return nil
+	}
+}
+
+func NewHandlerOrgList(orgService OrgService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		username, ok := cmd.Context().Value(ctxkeys.Username).(string)
+		if !ok {
+			return fmt.Errorf("unable to get username from context")
+		}
+
+		orgs, err := orgService.List(cmd.Context(), ListOrgsRequest{
+			Username: username,
+		})
+		if err != nil {
+			return err
+		}
+
+		orgNames := make([]string, len(orgs.Orgs))
+		for i, o := range orgs.Orgs {
+			orgNames[i] = fmt.Sprintf("%s (%s)", o.Name, o.Role)
+		}
+
+		cmd.Print(strings.Join(orgNames, "\n"))
+
+		return nil
+	}
+}
@@ -0,0 +1,241 @@
+package org
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+
+	"github.com/nixpig/syringe.sh/internal/user"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/nixpig/syringe.sh/pkg/validation"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleMember = "member"
+)
+
+type CreateOrgRequest struct {
+	Name     string `name:"organisation name" validate:"required,min=1,max=256"`
+	Username string `name:"username" validate:"required,min=1,max=256"`
+}
+
+type CreateOrgResponse struct {
+	ID           int
+	Name         string
+	DatabaseName string
+}
+
+type InviteMemberRequest struct {
+	Org      string `name:"organisation name" validate:"required,min=1,max=256"`
+	Member   string `name:"member username" validate:"required,min=1,max=256"`
+	Username string `name:"username" validate:"required,min=1,max=256"`
+}
+
+type RemoveMemberRequest struct {
+	Org      string `name:"organisation name" validate:"required,min=1,max=256"`
+	Member   string `name:"member username" validate:"required,min=1,max=256"`
+	Username string `name:"username" validate:"required,min=1,max=256"`
+}
+
+type ListOrgsRequest struct {
+	Username string `name:"username" validate:"required,min=1,max=256"`
+}
+
+type OrgResponse struct {
+	ID           int
+	Name         string
+	DatabaseName string
+	Role         string
+}
+
+type ListOrgsResponse struct {
+	Orgs []OrgResponse
+}
+
+type GetMemberOrgRequest struct {
+	Org      string `name:"organisation name" validate:"required,min=1,max=256"`
+	Username string `name:"username" validate:"required,min=1,max=256"`
+}
+
+type OrgService interface {
+	Create(ctx context.Context, request CreateOrgRequest) (*CreateOrgResponse, error)
+	Invite(ctx context.Context, request InviteMemberRequest) error
+	Remove(ctx context.Context, request RemoveMemberRequest) error
+	List(ctx context.Context, request ListOrgsRequest) (*ListOrgsResponse, error)
+	GetMemberOrg(ctx context.Context, request GetMemberOrgRequest) (*OrgResponse, error)
+}
+
+func NewOrgServiceImpl(
+	store OrgStore,
+	validate validation.Validator,
+	userService user.UserService,
+) OrgService {
+	return OrgServiceImpl{
+		store:       store,
+		validate:    validate,
+		userService: userService,
+	}
+}
+
+type OrgServiceImpl struct {
+	store       OrgStore
+	validate    validation.Validator
+	userService user.UserService
+}
+
+func (o OrgServiceImpl) Create(
+	ctx context.Context,
+	request CreateOrgRequest,
+) (*CreateOrgResponse, error) {
+	if err := o.validate.Struct(request); err != nil {
+		return nil, serrors.ValidationError(err)
+	}
+
+	databaseName := DatabaseName(request.Name)
+
+	insertedOrg, err := o.store.Insert(ctx, request.Name, databaseName)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := o.userService.CreateDatabase(ctx, user.CreateDatabaseRequest{
+		Name: databaseName,
+	}); err != nil {
+		// don't leave behind an organisation that has nowhere to store its data
+		return nil, o.undoCreate(ctx, request.Name, "", err)
+	}
+
+	if err := o.store.AddMember(ctx, request.Name, request.Username, RoleOwner); err != nil {
+		// nor one that nobody owns
+		return nil, o.undoCreate(ctx, request.Name, databaseName, err)
+	}
+
+	return &CreateOrgResponse{
+		ID:           insertedOrg.ID,
+		Name:         insertedOrg.Name,
+		DatabaseName: insertedOrg.DatabaseName,
+	}, nil
+}
+
+// undoCreate deletes what a failed create made, returning the error it
+// failed with along with any from undoing it. It carries on if the session
+// ends, so as not to leave the organisation half made.
+func (o OrgServiceImpl) undoCreate(
+	ctx context.Context,
+	name string,
+	createdDatabase string,
+	err error,
+) error {
+	ctx = context.WithoutCancel(ctx)
+
+	errs := []error{err}
+
+	if createdDatabase != "" {
+		errs = append(errs, o.userService.DeleteDatabase(ctx, user.DeleteDatabaseRequest{
+			Name: createdDatabase,
+		}))
+	}
+
+	errs = append(errs, o.store.Delete(ctx, name))
+
+	return errors.Join(errs...)
+}
+
+func (o OrgServiceImpl) Invite(ctx context.Context, request InviteMemberRequest) error {
+	if err := o.validate.Struct(request); err != nil {
+		return serrors.ValidationError(err)
+	}
+
+	if err := o.requireOwner(ctx, request.Org, request.Username); err != nil {
+		return err
+	}
+
+	if err := o.store.AddMember(ctx, request.Org, request.Member, RoleMember); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o OrgServiceImpl) Remove(ctx context.Context, request RemoveMemberRequest) error {
+	if err := o.validate.Struct(request); err != nil {
+		return serrors.ValidationError(err)
+	}
+
+	if err := o.requireOwner(ctx, request.Org, request.Username); err != nil {
+		return err
+	}
+
+	if request.Member == request.Username {
+		return serrors.ErrOrgOwnerRemoval
+	}
+
+	if err := o.store.RemoveMember(ctx, request.Org, request.Member); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (o OrgServiceImpl) List(ctx context.Context, request ListOrgsRequest) (*ListOrgsResponse, error) {
+	if err := o.validate.Struct(request); err != nil {
+		return nil, serrors.ValidationError(err)
+	}
+
+	orgs, err := o.store.ListForUser(ctx, request.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	var orgsResponseList []OrgResponse
+
+	for _, ov := range *orgs {
+		orgsResponseList = append(orgsResponseList, OrgResponse{
+			ID:           ov.ID,
+			Name:         ov.Name,
+			DatabaseName: ov.DatabaseName,
+			Role:         ov.Role,
+		})
+	}
+
+	return &ListOrgsResponse{Orgs: orgsResponseList}, nil
+}
+
+func (o OrgServiceImpl) GetMemberOrg(ctx context.Context, request GetMemberOrgRequest) (*OrgResponse, error) {
+	if err := o.validate.Struct(request); err != nil {
+		return nil, serrors.ValidationError(err)
+	}
+
+	member, err := o.store.GetMember(ctx, request.Org, request.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrgResponse{
+		ID:           member.ID,
+		Name:         member.Name,
+		DatabaseName: member.DatabaseName,
+		Role:         member.Role,
+	}, nil
+}
+
+func (o OrgServiceImpl) requireOwner(ctx context.Context, orgName, username string) error {
+	member, err := o.store.GetMember(ctx, orgName, username)
+	if err != nil {
+		return err
+	}
+
+	if member.Role != RoleOwner {
+		return serrors.ErrOrgPermissionDenied
+	}
+
+	return nil
+}
+
+// DatabaseName is the name of the database that holds an organisation's
+// projects, environments and secrets.
+func DatabaseName(orgName string) string {
+	return fmt.Sprintf("org-%x", sha1.Sum([]byte(orgName)))
+}
@@ -0,0 +1,260 @@
+package org
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+)
+
+type Org struct {
+	ID           int
+	Name         string
+	DatabaseName string
+	CreatedAt    string
+}
+
+type Membership struct {
+	ID           int
+	Name         string
+	DatabaseName string
+	Role         string
+}
+
+type OrgStore interface {
+	Insert(ctx context.Context, name, databaseName string) (*Org, error)
+	Delete(ctx context.Context, name string) error
+	AddMember(ctx context.Context, orgName, username, role string) error
+	RemoveMember(ctx context.Context, orgName, username string) error
+	GetMember(ctx context.Context, orgName, username string) (*Membership, error)
+	ListForUser(ctx context.Context, username string) (*[]Membership, error)
+}
+
+type SqliteOrgStore struct {
+	appDB *sql.DB
+}
+
+func NewSqliteOrgStore(appDB *sql.DB) SqliteOrgStore {
+	return SqliteOrgStore{appDB}
+}
+
+func (s SqliteOrgStore) Insert(ctx context.Context, name, databaseName string) (*Org, error) {
+	query := `
+		insert into orgs_ (name_, database_name_)
+		values ($name, $databaseName)
+		returning id_, name_, database_name_, created_at_
+	`
+
+	row := s.appDB.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("name", name),
+		sql.Named("databaseName", databaseName),
+	)
+
+	var insertedOrg Org
+
+	if err := row.Scan(
+		&insertedOrg.ID,
+		&insertedOrg.Name,
+		&insertedOrg.DatabaseName,
+		&insertedOrg.CreatedAt,
+	); err != nil {
+		return nil, serrors.ErrDatabaseExec(err)
+	}
+
+	return &insertedOrg, nil
+}
+
+func (s SqliteOrgStore) Delete(ctx context.Context, name string) error {
+	query := `
+		delete from orgs_ where name_ = $name
+	`
+
+	if _, err := s.appDB.ExecContext(ctx, query, sql.Named("name", name)); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+func (s SqliteOrgStore) AddMember(ctx context.Context, orgName, username, role string) error {
+	query := `
+		insert into org_members_ (org_id_, user_id_, role_)
+		select o.id_, u.id_, $role
+		from orgs_ o, users_ u
+		where o.name_ = $orgName
+		and u.username_ = $username
+	`
+
+	res, err := s.appDB.ExecContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+		sql.Named("username", username),
+		sql.Named("role", role),
+	)
+	if err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return s.missingMember(ctx, orgName)
+	}
+
+	return nil
+}
+
+// missingMember works out why a member couldn't be added: either the
+// organisation or the user doesn't exist.
+func (s SqliteOrgStore) missingMember(ctx context.Context, orgName string) error {
+	query := `
+		select count(*) from orgs_ where name_ = $orgName
+	`
+
+	var orgs int
+
+	if err := s.appDB.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+	).Scan(&orgs); err != nil {
+		return serrors.ErrDatabaseQuery(err)
+	}
+
+	if orgs == 0 {
+		return serrors.ErrOrgNotFound
+	}
+
+	return serrors.ErrUserNotFound
+}
+
+func (s SqliteOrgStore) RemoveMember(ctx context.Context, orgName, username string) error {
+	query := `
+		delete from org_members_
+		where id_ in (
+			select m.id_ from org_members_ m
+			inner join
+			orgs_ o
+			on m.org_id_ = o.id_
+			inner join
+			users_ u
+			on m.user_id_ = u.id_
+			where o.name_ = $orgName
+			and u.username_ = $username
+		)
+	`
+
+	res, err := s.appDB.ExecContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+		sql.Named("username", username),
+	)
+	if err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return serrors.ErrNotOrgMember
+	}
+
+	return nil
+}
+
+func (s SqliteOrgStore) GetMember(ctx context.Context, orgName, username string) (*Membership, error) {
+	query := `
+		select o.id_, o.name_, o.database_name_, m.role_
+		from org_members_ m
+		inner join
+		orgs_ o
+		on m.org_id_ = o.id_
+		inner join
+		users_ u
+		on m.user_id_ = u.id_
+		where o.name_ = $orgName
+		and u.username_ = $username
+	`
+
+	row := s.appDB.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+		sql.Named("username", username),
+	)
+
+	var membership Membership
+
+	if err := row.Scan(
+		&membership.ID,
+		&membership.Name,
+		&membership.DatabaseName,
+		&membership.Role,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, serrors.ErrNotOrgMember
+		}
+
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return &membership, nil
+}
+
+func (s SqliteOrgStore) ListForUser(ctx context.Context, username string) (*[]Membership, error) {
+	query := `
+		select o.id_, o.name_, o.database_name_, m.role_
+		from org_members_ m
+		inner join
+		orgs_ o
+		on m.org_id_ = o.id_
+		inner join
+		users_ u
+		on m.user_id_ = u.id_
+		where u.username_ = $username
+	`
+
+	rows, err := s.appDB.QueryContext(
+		ctx,
+		query,
+		sql.Named("username", username),
+	)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	defer rows.Close()
+
+	var memberships []Membership
+
+	for rows.Next() {
+		var membership Membership
+
+		if err := rows.Scan(
+			&membership.ID,
+			&membership.Name,
+			&membership.DatabaseName,
+			&membership.Role,
+		); err != nil {
+			return nil, err
+		}
+
+		memberships = append(memberships, membership)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return &memberships, nil
+}
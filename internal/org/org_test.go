@@ -0,0 +1,450 @@
+package org_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/nixpig/syringe.sh/internal/org"
+	"github.com/nixpig/syringe.sh/internal/user"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/nixpig/syringe.sh/pkg/validation"
+	"github.com/nixpig/syringe.sh/test"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+type mockUserService struct {
+	user.UserService
+	createDatabaseErr error
+	createdDatabases  []string
+	deletedDatabases  []string
+}
+
+func (m *mockUserService) CreateDatabase(
+	ctx context.Context,
+	databaseDetails user.CreateDatabaseRequest,
+) (*user.CreateDatabaseResponse, error) {
+	if m.createDatabaseErr != nil {
+		return nil, m.createDatabaseErr
+	}
+
+	m.createdDatabases = append(m.createdDatabases, databaseDetails.Name)
+
+	return &user.CreateDatabaseResponse{Name: databaseDetails.Name}, nil
+}
+
+func (m *mockUserService) DeleteDatabase(
+	ctx context.Context,
+	databaseDetails user.DeleteDatabaseRequest,
+) error {
+	m.deletedDatabases = append(m.deletedDatabases, databaseDetails.Name)
+
+	return nil
+}
+
+func TestOrgCmd(t *testing.T) {
+	scenarios := map[string]func(
+		t *testing.T,
+		cmd *cobra.Command,
+		mock sqlmock.Sqlmock,
+		userService *mockUserService,
+		service org.OrgService,
+	){
+		"test org create command happy path":            testOrgCreateCmdHappyPath,
+		"test org create command database create error": testOrgCreateCmdDatabaseCreateError,
+		"test org create command add owner error":       testOrgCreateCmdAddOwnerError,
+		"test org create command with no args":          testOrgCreateCmdWithNoArgs,
+		"test org invite command happy path":            testOrgInviteCmdHappyPath,
+		"test org invite command not owner":             testOrgInviteCmdNotOwner,
+		"test org invite command unknown user":          testOrgInviteCmdUnknownUser,
+		"test org remove command happy path":            testOrgRemoveCmdHappyPath,
+		"test org remove command owner removes self":    testOrgRemoveCmdOwnerRemovesSelf,
+		"test org list command happy path":              testOrgListCmdHappyPath,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			userService := &mockUserService{}
+
+			service := org.NewOrgServiceImpl(
+				org.NewSqliteOrgStore(db),
+				validation.New(),
+				userService,
+			)
+
+			cmd := org.NewCmdOrg()
+			cmd.SetContext(context.WithValue(context.Background(), ctxkeys.Username, "janedoe"))
+
+			fn(t, cmd, mock, userService, service)
+		})
+	}
+}
+
+const getMemberQuery = `
+	select o.id_, o.name_, o.database_name_, m.role_
+	from org_members_ m
+	inner join
+	orgs_ o
+	on m.org_id_ = o.id_
+	inner join
+	users_ u
+	on m.user_id_ = u.id_
+	where o.name_ = $orgName
+	and u.username_ = $username
+`
+
+const addMemberQuery = `
+	insert into org_members_ (org_id_, user_id_, role_)
+	select o.id_, u.id_, $role
+	from orgs_ o, users_ u
+	where o.name_ = $orgName
+	and u.username_ = $username
+`
+
+func expectMember(mock sqlmock.Sqlmock, orgName, username, role string) {
+	mock.ExpectQuery(regexp.QuoteMeta(getMemberQuery)).
+		WithArgs(orgName, username).
+		WillReturnRows(
+			sqlmock.
+				NewRows([]string{"id_", "name_", "database_name_", "role_"}).
+				AddRow(1, orgName, org.DatabaseName(orgName), role),
+		)
+}
+
+func testOrgCreateCmdHappyPath(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	userService *mockUserService,
+	service org.OrgService,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(org.NewCmdOrgCreate(org.NewHandlerOrgCreate(service)))
+	cmd.SetArgs([]string{"create", "my_cool_org"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`
+		insert into orgs_ (name_, database_name_)
+		values ($name, $databaseName)
+		returning id_, name_, database_name_, created_at_
+	`)).
+		WithArgs("my_cool_org", org.DatabaseName("my_cool_org")).
+		WillReturnRows(
+			sqlmock.
+				NewRows([]string{"id_", "name_", "database_name_", "created_at_"}).
+				AddRow(1, "my_cool_org", org.DatabaseName("my_cool_org"), "2024-06-01 00:00:00"),
+		)
+
+	mock.ExpectExec(regexp.QuoteMeta(addMemberQuery)).
+		WithArgs("my_cool_org", "janedoe", org.RoleOwner).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.Equal(t, "Organisation 'my_cool_org' created\n", cmdOut.String())
+	require.Equal(t, []string{org.DatabaseName("my_cool_org")}, userService.createdDatabases)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testOrgCreateCmdDatabaseCreateError(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	userService *mockUserService,
+	service org.OrgService,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	userService.createDatabaseErr = errors.New("turso_error")
+
+	cmd.AddCommand(org.NewCmdOrgCreate(org.NewHandlerOrgCreate(service)))
+	cmd.SetArgs([]string{"create", "my_cool_org"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`
+		insert into orgs_ (name_, database_name_)
+	`)).
+		WillReturnRows(
+			sqlmock.
+				NewRows([]string{"id_", "name_", "database_name_", "created_at_"}).
+				AddRow(1, "my_cool_org", org.DatabaseName("my_cool_org"), "2024-06-01 00:00:00"),
+		)
+
+	mock.ExpectExec(regexp.QuoteMeta(`
+		delete from orgs_ where name_ = $name
+	`)).
+		WithArgs("my_cool_org").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.Equal(t, test.ErrorMsg("turso_error\n"), errOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testOrgCreateCmdAddOwnerError(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	userService *mockUserService,
+	service org.OrgService,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(org.NewCmdOrgCreate(org.NewHandlerOrgCreate(service)))
+	cmd.SetArgs([]string{"create", "my_cool_org"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`
+		insert into orgs_ (name_, database_name_)
+	`)).
+		WillReturnRows(
+			sqlmock.
+				NewRows([]string{"id_", "name_", "database_name_", "created_at_"}).
+				AddRow(1, "my_cool_org", org.DatabaseName("my_cool_org"), "2024-06-01 00:00:00"),
+		)
+
+	mock.ExpectExec(regexp.QuoteMeta(addMemberQuery)).
+		WithArgs("my_cool_org", "janedoe", org.RoleOwner).
+		WillReturnError(errors.New("database_error"))
+
+	mock.ExpectExec(regexp.QuoteMeta(`
+		delete from orgs_ where name_ = $name
+	`)).
+		WithArgs("my_cool_org").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.Equal(t, test.ErrorMsg("database exec error\n"), errOut.String())
+	require.Equal(t, userService.createdDatabases, userService.deletedDatabases)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testOrgCreateCmdWithNoArgs(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	userService *mockUserService,
+	service org.OrgService,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmdCreate := org.NewCmdOrgCreate(org.NewHandlerOrgCreate(service))
+
+	cmd.AddCommand(cmdCreate)
+	cmd.SetArgs([]string{"create"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.Equal(t, test.IncorrectNumberOfArgsErrorMsg(1, 0), errOut.String())
+	require.Equal(t, fmt.Sprintf("%s\n", cmdCreate.UsageString()), cmdOut.String())
+}
+
+func testOrgInviteCmdHappyPath(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	userService *mockUserService,
+	service org.OrgService,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(org.NewCmdOrgInvite(org.NewHandlerOrgInvite(service)))
+	cmd.SetArgs([]string{"invite", "my_cool_org", "johndoe"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	expectMember(mock, "my_cool_org", "janedoe", org.RoleOwner)
+
+	mock.ExpectExec(regexp.QuoteMeta(addMemberQuery)).
+		WithArgs("my_cool_org", "johndoe", org.RoleMember).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.Equal(t, "User 'johndoe' added to organisation 'my_cool_org'\n", cmdOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testOrgInviteCmdNotOwner(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	userService *mockUserService,
+	service org.OrgService,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(org.NewCmdOrgInvite(org.NewHandlerOrgInvite(service)))
+	cmd.SetArgs([]string{"invite", "my_cool_org", "johndoe"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	expectMember(mock, "my_cool_org", "janedoe", org.RoleMember)
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.Equal(t, test.ErrorMsg("only organisation owners can manage members\n"), errOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testOrgInviteCmdUnknownUser(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	userService *mockUserService,
+	service org.OrgService,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(org.NewCmdOrgInvite(org.NewHandlerOrgInvite(service)))
+	cmd.SetArgs([]string{"invite", "my_cool_org", "nobody"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	expectMember(mock, "my_cool_org", "janedoe", org.RoleOwner)
+
+	mock.ExpectExec(regexp.QuoteMeta(addMemberQuery)).
+		WithArgs("my_cool_org", "nobody", org.RoleMember).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectQuery(regexp.QuoteMeta(`select count(*) from orgs_ where name_ = $orgName`)).
+		WithArgs("my_cool_org").
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(1))
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.Equal(t, test.ErrorMsg("user not found\n"), errOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testOrgRemoveCmdHappyPath(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	userService *mockUserService,
+	service org.OrgService,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(org.NewCmdOrgRemove(org.NewHandlerOrgRemove(service)))
+	cmd.SetArgs([]string{"remove", "my_cool_org", "johndoe"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	expectMember(mock, "my_cool_org", "janedoe", org.RoleOwner)
+
+	mock.ExpectExec(regexp.QuoteMeta(`
+		delete from org_members_
+		where id_ in (
+	`)).
+		WithArgs("my_cool_org", "johndoe").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.Equal(t, "User 'johndoe' removed from organisation 'my_cool_org'\n", cmdOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testOrgRemoveCmdOwnerRemovesSelf(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	userService *mockUserService,
+	service org.OrgService,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(org.NewCmdOrgRemove(org.NewHandlerOrgRemove(service)))
+	cmd.SetArgs([]string{"remove", "my_cool_org", "janedoe"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	expectMember(mock, "my_cool_org", "janedoe", org.RoleOwner)
+
+	err := cmd.Execute()
+
+	require.Error(t, err)
+	require.Equal(t, test.ErrorMsg("organisation owners cannot remove themselves\n"), errOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testOrgListCmdHappyPath(
+	t *testing.T,
+	cmd *cobra.Command,
+	mock sqlmock.Sqlmock,
+	userService *mockUserService,
+	service org.OrgService,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(org.NewCmdOrgList(org.NewHandlerOrgList(service)))
+	cmd.SetArgs([]string{"list"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`
+		select o.id_, o.name_, o.database_name_, m.role_
+		from org_members_ m
+		inner join
+		orgs_ o
+		on m.org_id_ = o.id_
+		inner join
+		users_ u
+		on m.user_id_ = u.id_
+		where u.username_ = $username
+	`)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.
+				NewRows([]string{"id_", "name_", "database_name_", "role_"}).
+				AddRow(1, "my_cool_org", org.DatabaseName("my_cool_org"), org.RoleOwner).
+				AddRow(2, "my_other_org", org.DatabaseName("my_other_org"), org.RoleMember),
+		)
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.Equal(t, "my_cool_org (owner)\nmy_other_org (member)", cmdOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
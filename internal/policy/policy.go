@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/spf13/cobra"
+)
+
+func NewCmdRole() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "role",
+		Aliases: []string{"ro"},
+		Short:   "Manage roles",
+		Long: `Manage the roles of organisation members on projects and environments.
+
+Roles are one of:
+  viewer  list secret keys
+  reader  read secret values
+  writer  set and remove secrets
+  admin   manage environments and roles
+
+A role granted for an environment takes precedence over the role granted for its project.`,
+	}
+
+	return cmd
+}
+
+func NewCmdRoleGrant(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "grant [flags] USERNAME ROLE",
+		Aliases: []string{"g"},
+		Short:   "Grant a role to a user",
+		Example: "syringe role grant --org my_cool_org -p my_cool_project -e prod janedoe reader",
+		Args:    cobra.MatchAll(cobra.ExactArgs(2)),
+		RunE:    handler,
+	}
+
+	addFlags(cmd)
+
+	return cmd
+}
+
+func NewCmdRoleRevoke(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "revoke [flags] USERNAME",
+		Aliases: []string{"r"},
+		Short:   "Revoke a user's role",
+		Example: "syringe role revoke --org my_cool_org -p my_cool_project -e prod janedoe",
+		Args:    cobra.MatchAll(cobra.ExactArgs(1)),
+		RunE:    handler,
+	}
+
+	addFlags(cmd)
+
+	return cmd
+}
+
+func NewCmdRoleList(handler pkg.CobraHandler) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list [flags]",
+		Aliases: []string{"l"},
+		Short:   "List roles on a project",
+		Example: "syringe role list --org my_cool_org -p my_cool_project",
+		Args:    cobra.MatchAll(cobra.ExactArgs(0)),
+		RunE:    handler,
+	}
+
+	cmd.Flags().StringP("project", "p", "", "Project name")
+	cmd.MarkFlagRequired("project")
+
+	return cmd
+}
+
+func addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("project", "p", "", "Project name")
+	cmd.MarkFlagRequired("project")
+
+	cmd.Flags().StringP("environment", "e", "", "Environment name (if not provided, applies to the whole project)")
+}
@@ -0,0 +1,307 @@
+package policy
+
+import (
+	"context"
+
+	"github.com/nixpig/syringe.sh/internal/environment"
+	"github.com/nixpig/syringe.sh/internal/project"
+	"github.com/nixpig/syringe.sh/internal/secret"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+)
+
+// The services below wrap the project, environment and secret services so
+// that every call is authorised for the subject before it reaches the store.
+// Roles and shares refer to projects and environments by name, so they're
+// moved or removed along with them.
+
+func NewProjectService(
+	next project.ProjectService,
+	policyService PolicyService,
+	subject Subject,
+) project.ProjectService {
+	return projectService{
+		next:          next,
+		policyService: policyService,
+		subject:       subject,
+	}
+}
+
+type projectService struct {
+	next          project.ProjectService
+	policyService PolicyService
+	subject       Subject
+}
+
+func (p projectService) Add(ctx context.Context, request project.AddProjectRequest) error {
+	if err := p.authorize(ctx, request.Name, ActionAdmin); err != nil {
+		return err
+	}
+
+	// only users who've verified their email address can add projects
+	if !p.subject.Verified {
+		return serrors.ErrNotVerified
+	}
+
+	return p.next.Add(ctx, request)
+}
+
+func (p projectService) Remove(ctx context.Context, request project.RemoveProjectRequest) error {
+	if err := p.authorize(ctx, request.Name, ActionAdmin); err != nil {
+		return err
+	}
+
+	if err := p.next.Remove(ctx, request); err != nil {
+		return err
+	}
+
+	return p.policyService.RemoveBindings(ctx, RemoveBindingsRequest{
+		Subject: p.subject,
+		Project: request.Name,
+	})
+}
+
+func (p projectService) Rename(ctx context.Context, request project.RenameProjectRequest) error {
+	if err := p.authorize(ctx, request.Name, ActionAdmin); err != nil {
+		return err
+	}
+
+	if err := p.next.Rename(ctx, request); err != nil {
+		return err
+	}
+
+	return p.policyService.RenameBindings(ctx, RenameBindingsRequest{
+		Subject: p.subject,
+		Project: request.Name,
+		NewName: request.NewName,
+	})
+}
+
+func (p projectService) List(ctx context.Context) (*project.ListProjectsResponse, error) {
+	if !p.subject.Authenticated {
+		return nil, p.authorize(ctx, "", ActionList)
+	}
+
+	projects, err := p.next.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := projects.Projects[:0]
+
+	for _, pv := range projects.Projects {
+		if err := p.authorize(ctx, pv.Name, ActionList); err == nil {
+			visible = append(visible, pv)
+		}
+	}
+
+	return &project.ListProjectsResponse{Projects: visible}, nil
+}
+
+func (p projectService) authorize(ctx context.Context, projectName string, action Action) error {
+	return p.policyService.Authorize(ctx, AuthorizeRequest{
+		Subject: p.subject,
+		Project: projectName,
+		Action:  action,
+	})
+}
+
+func NewEnvironmentService(
+	next environment.EnvironmentService,
+	policyService PolicyService,
+	subject Subject,
+) environment.EnvironmentService {
+	return environmentService{
+		next:          next,
+		policyService: policyService,
+		subject:       subject,
+	}
+}
+
+type environmentService struct {
+	next          environment.EnvironmentService
+	policyService PolicyService
+	subject       Subject
+}
+
+func (e environmentService) Add(ctx context.Context, request environment.AddEnvironmentRequest) error {
+	if err := e.authorize(ctx, request.Project, ActionAdmin); err != nil {
+		return err
+	}
+
+	return e.next.Add(ctx, request)
+}
+
+func (e environmentService) Remove(ctx context.Context, request environment.RemoveEnvironmentRequest) error {
+	if err := e.authorize(ctx, request.Project, ActionAdmin); err != nil {
+		return err
+	}
+
+	if err := e.next.Remove(ctx, request); err != nil {
+		return err
+	}
+
+	return e.policyService.RemoveBindings(ctx, RemoveBindingsRequest{
+		Subject:     e.subject,
+		Project:     request.Project,
+		Environment: request.Name,
+	})
+}
+
+func (e environmentService) Rename(ctx context.Context, request environment.RenameEnvironmentRequest) error {
+	if err := e.authorize(ctx, request.Project, ActionAdmin); err != nil {
+		return err
+	}
+
+	if err := e.next.Rename(ctx, request); err != nil {
+		return err
+	}
+
+	return e.policyService.RenameBindings(ctx, RenameBindingsRequest{
+		Subject:     e.subject,
+		Project:     request.Project,
+		Environment: request.Name,
+		NewName:     request.NewName,
+	})
+}
+
+func (e environmentService) Configure(ctx context.Context, request environment.ConfigureEnvironmentRequest) error {
+	if err := e.authorize(ctx, request.Project, ActionAdmin); err != nil {
+		return err
+	}
+
+	return e.next.Configure(ctx, request)
+}
+
+func (e environmentService) List(
+	ctx context.Context,
+	request environment.ListEnvironmentRequest,
+) (*environment.ListEnvironmentsResponse, error) {
+	if err := e.authorize(ctx, request.Project, ActionList); err != nil {
+		return nil, err
+	}
+
+	return e.next.List(ctx, request)
+}
+
+func (e environmentService) authorize(ctx context.Context, projectName string, action Action) error {
+	return e.policyService.Authorize(ctx, AuthorizeRequest{
+		Subject: e.subject,
+		Project: projectName,
+		Action:  action,
+	})
+}
+
+// NewSecretService authorises secret calls. Subjects that may only list
+// secrets get their keys back from List with the values blanked out.
+func NewSecretService(
+	next secret.SecretService,
+	policyService PolicyService,
+	subject Subject,
+) secret.SecretService {
+	return secretService{
+		next:          next,
+		policyService: policyService,
+		subject:       subject,
+		listAction:    ActionList,
+	}
+}
+
+// NewInjectableSecretService authorises secret calls like NewSecretService,
+// but List requires permission to read values since they are injected.
+func NewInjectableSecretService(
+	next secret.SecretService,
+	policyService PolicyService,
+	subject Subject,
+) secret.SecretService {
+	return secretService{
+		next:          next,
+		policyService: policyService,
+		subject:       subject,
+		listAction:    ActionRead,
+	}
+}
+
+type secretService struct {
+	next          secret.SecretService
+	policyService PolicyService
+	subject       Subject
+	listAction    Action
+}
+
+func (s secretService) CreateTables(ctx context.Context) error {
+	return s.next.CreateTables(ctx)
+}
+
+func (s secretService) Set(ctx context.Context, request secret.SetSecretRequest) error {
+	if err := s.authorize(ctx, request.Project, request.Environment, ActionWrite); err != nil {
+		return err
+	}
+
+	return s.next.Set(ctx, request)
+}
+
+func (s secretService) Get(ctx context.Context, request secret.GetSecretRequest) (*secret.GetSecretResponse, error) {
+	if err := s.authorize(ctx, request.Project, request.Environment, ActionRead); err != nil {
+		return nil, err
+	}
+
+	return s.next.Get(ctx, request)
+}
+
+func (s secretService) List(ctx context.Context, request secret.ListSecretsRequest) (*secret.ListSecretsResponse, error) {
+	if err := s.authorize(ctx, request.Project, request.Environment, s.listAction); err != nil {
+		return nil, err
+	}
+
+	secrets, err := s.next.List(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.authorize(ctx, request.Project, request.Environment, ActionRead); err != nil {
+		for i := range secrets.Secrets {
+			secrets.Secrets[i].Value = ""
+		}
+	}
+
+	return secrets, nil
+}
+
+// ListOverdue only reports secrets in environments the subject may list.
+func (s secretService) ListOverdue(ctx context.Context) (*secret.ListOverdueSecretsResponse, error) {
+	if !s.subject.Authenticated {
+		return nil, s.authorize(ctx, "", "", ActionList)
+	}
+
+	secrets, err := s.next.ListOverdue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := secrets.Secrets[:0]
+
+	for _, sv := range secrets.Secrets {
+		if err := s.authorize(ctx, sv.Project, sv.Environment, ActionList); err == nil {
+			visible = append(visible, sv)
+		}
+	}
+
+	return &secret.ListOverdueSecretsResponse{Secrets: visible}, nil
+}
+
+func (s secretService) Remove(ctx context.Context, request secret.RemoveSecretRequest) error {
+	if err := s.authorize(ctx, request.Project, request.Environment, ActionWrite); err != nil {
+		return err
+	}
+
+	return s.next.Remove(ctx, request)
+}
+
+func (s secretService) authorize(ctx context.Context, projectName, environmentName string, action Action) error {
+	return s.policyService.Authorize(ctx, AuthorizeRequest{
+		Subject:     s.subject,
+		Project:     projectName,
+		Environment: environmentName,
+		Action:      action,
+	})
+}
@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/spf13/cobra"
+)
+
+func NewHandlerRoleGrant(policyService PolicyService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		username := args[0]
+		role := args[1]
+
+		project, _ := cmd.Flags().GetString("project")
+		environment, _ := cmd.Flags().GetString("environment")
+
+		if err := policyService.Grant(cmd.Context(), GrantRoleRequest{
+			Subject:     SubjectFromCmd(cmd),
+			Username:    username,
+			Project:     project,
+			Environment: environment,
+			Role:        role,
+		}); err != nil {
+			return err
+		}
+
+		cmd.Println(fmt.Sprintf("Role '%s' granted to '%s' on %s", role, username, scope(project, environment)))
+
+		return nil
+	}
+}
+
+func NewHandlerRoleRevoke(policyService PolicyService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		username := args[0]
+
+		project, _ := cmd.Flags().GetString("project")
+		environment, _ := cmd.Flags().GetString("environment")
+
+		if err := policyService.Revoke(cmd.Context(), RevokeRoleRequest{
+			Subject:     SubjectFromCmd(cmd),
+			Username:    username,
+			Project:     project,
+			Environment: environment,
+		}); err != nil {
+			return err
+		}
+
+		cmd.Println(fmt.Sprintf("Role revoked from '%s' on %s", username, scope(project, environment)))
+
+		return nil
+	}
+}
+
+func NewHandlerRoleList(policyService PolicyService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		project, _ := cmd.Flags().GetString("project")
+
+		roles, err := policyService.List(cmd.Context(), ListRolesRequest{
+			Subject: SubjectFromCmd(cmd),
+			Project: project,
+		})
+		if err != nil {
+			return err
+		}
+
+		rolesList := make([]string, len(roles.Roles))
+		for i, r := range roles.Roles {
+			environment := r.Environment
+			if environment == "" {
+				environment = "*"
+			}
+
+			rolesList[i] = fmt.Sprintf("%s %s %s", r.Username, environment, r.Role)
+		}
+
+		cmd.Print(strings.Join(rolesList, "\n"))
+
+		return nil
+	}
+}
+
+// SubjectFromCmd builds the subject for a command from the authenticated
+// session details in its context and the '--org' flag.
+func SubjectFromCmd(cmd *cobra.Command) Subject {
+	username, _ := cmd.Context().Value(ctxkeys.Username).(string)
+	authenticated, _ := cmd.Context().Value(ctxkeys.Authenticated).(bool)
+	org, _ := cmd.Flags().GetString("org")
+
+	return Subject{
+		Username:      username,
+		Org:           org,
+		Authenticated: authenticated,
+	}
+}
+
+func scope(project, environment string) string {
+	if environment == "" {
+		return fmt.Sprintf("project '%s'", project)
+	}
+
+	return fmt.Sprintf("environment '%s' in project '%s'", environment, project)
+}
@@ -0,0 +1,347 @@
+package policy
+
+import (
+	"context"
+	"slices"
+
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/nixpig/syringe.sh/pkg/validation"
+)
+
+type Action string
+
+const (
+	ActionList  Action = "list"
+	ActionRead  Action = "read"
+	ActionWrite Action = "write"
+	ActionAdmin Action = "admin"
+)
+
+const (
+	RoleViewer = "viewer"
+	RoleReader = "reader"
+	RoleWriter = "writer"
+	RoleAdmin  = "admin"
+)
+
+// roleActions lists what each role is permitted to do. Roles are cumulative,
+// so each one includes every action of the roles before it.
+var roleActions = map[string][]Action{
+	RoleViewer: {ActionList},
+	RoleReader: {ActionList, ActionRead},
+	RoleWriter: {ActionList, ActionRead, ActionWrite},
+	RoleAdmin:  {ActionList, ActionRead, ActionWrite, ActionAdmin},
+}
+
+// Subject is the caller that actions are authorised for. An empty Org and
+// SharedBy means the subject is working in their own database, where they
+// can do anything.
+type Subject struct {
+	Username      string
+	Org           string
+	SharedBy      string
+	Authenticated bool
+	// Verified is whether the subject has verified their email address.
+	Verified bool
+}
+
+type AuthorizeRequest struct {
+	Subject     Subject
+	Project     string
+	Environment string
+	Action      Action
+}
+
+type GrantRoleRequest struct {
+	Subject     Subject
+	Username    string `name:"username" validate:"required,min=1,max=256"`
+	Project     string `name:"project name" validate:"required,min=1,max=256"`
+	Environment string `name:"environment name" validate:"max=256"`
+	Role        string `name:"role" validate:"required,oneof=viewer reader writer admin"`
+}
+
+type RevokeRoleRequest struct {
+	Subject     Subject
+	Username    string `name:"username" validate:"required,min=1,max=256"`
+	Project     string `name:"project name" validate:"required,min=1,max=256"`
+	Environment string `name:"environment name" validate:"max=256"`
+}
+
+type ListRolesRequest struct {
+	Subject Subject
+	Project string `name:"project name" validate:"required,min=1,max=256"`
+}
+
+// RenameBindingsRequest moves roles and shares to a project or environment's
+// new name. An empty Environment renames the project.
+type RenameBindingsRequest struct {
+	Subject     Subject
+	Project     string
+	Environment string
+	NewName     string
+}
+
+// RemoveBindingsRequest removes the roles and shares of a project or
+// environment. An empty Environment removes the whole project's.
+type RemoveBindingsRequest struct {
+	Subject     Subject
+	Project     string
+	Environment string
+}
+
+type RoleResponse struct {
+	Username    string
+	Environment string
+	Role        string
+}
+
+type ListRolesResponse struct {
+	Project string
+	Roles   []RoleResponse
+}
+
+type PolicyService interface {
+	Authorize(ctx context.Context, request AuthorizeRequest) error
+	Grant(ctx context.Context, request GrantRoleRequest) error
+	Revoke(ctx context.Context, request RevokeRoleRequest) error
+	List(ctx context.Context, request ListRolesRequest) (*ListRolesResponse, error)
+	RenameBindings(ctx context.Context, request RenameBindingsRequest) error
+	RemoveBindings(ctx context.Context, request RemoveBindingsRequest) error
+}
+
+func NewPolicyServiceImpl(
+	store PolicyStore,
+	validate validation.Validator,
+) PolicyService {
+	return PolicyServiceImpl{
+		store:    store,
+		validate: validate,
+	}
+}
+
+type PolicyServiceImpl struct {
+	store    PolicyStore
+	validate validation.Validator
+}
+
+func (p PolicyServiceImpl) Authorize(ctx context.Context, request AuthorizeRequest) error {
+	if !request.Subject.Authenticated {
+		return serrors.ErrNotAuthenticated
+	}
+
+	var bindings *[]Binding
+	var err error
+
+	switch {
+	case request.Subject.SharedBy != "":
+		bindings, err = p.store.GetShareBindings(
+			ctx,
+			request.Subject.SharedBy,
+			request.Subject.Username,
+			request.Project,
+		)
+		if err != nil {
+			return err
+		}
+
+	case request.Subject.Org != "":
+		orgRole, err := p.store.GetOrgRole(ctx, request.Subject.Org, request.Subject.Username)
+		if err != nil {
+			return err
+		}
+
+		// organisation owners administer every project in the organisation
+		if orgRole == "owner" {
+			return nil
+		}
+
+		bindings, err = p.store.GetBindings(
+			ctx,
+			request.Subject.Org,
+			request.Subject.Username,
+			request.Project,
+		)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return nil
+	}
+
+	role := resolveRole(*bindings, request.Environment)
+
+	if role == "" {
+		// any role anywhere in a project is enough to see its environments,
+		// but not what's in an environment the role doesn't cover
+		if request.Action == ActionList && request.Environment == "" && len(*bindings) > 0 {
+			return nil
+		}
+
+		return serrors.ErrPermissionDenied
+	}
+
+	if !slices.Contains(roleActions[role], request.Action) {
+		return serrors.ErrPermissionDenied
+	}
+
+	return nil
+}
+
+func (p PolicyServiceImpl) Grant(ctx context.Context, request GrantRoleRequest) error {
+	if err := p.validate.Struct(request); err != nil {
+		return serrors.ValidationError(err)
+	}
+
+	if err := p.authorizeAdmin(ctx, request.Subject, request.Project); err != nil {
+		return err
+	}
+
+	if err := p.store.SetBinding(
+		ctx,
+		request.Subject.Org,
+		request.Username,
+		request.Project,
+		request.Environment,
+		request.Role,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p PolicyServiceImpl) Revoke(ctx context.Context, request RevokeRoleRequest) error {
+	if err := p.validate.Struct(request); err != nil {
+		return serrors.ValidationError(err)
+	}
+
+	if err := p.authorizeAdmin(ctx, request.Subject, request.Project); err != nil {
+		return err
+	}
+
+	if err := p.store.DeleteBinding(
+		ctx,
+		request.Subject.Org,
+		request.Username,
+		request.Project,
+		request.Environment,
+	); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p PolicyServiceImpl) List(ctx context.Context, request ListRolesRequest) (*ListRolesResponse, error) {
+	if err := p.validate.Struct(request); err != nil {
+		return nil, serrors.ValidationError(err)
+	}
+
+	if err := p.authorizeAdmin(ctx, request.Subject, request.Project); err != nil {
+		return nil, err
+	}
+
+	bindings, err := p.store.ListBindings(ctx, request.Subject.Org, request.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolesResponseList []RoleResponse
+
+	for _, b := range *bindings {
+		rolesResponseList = append(rolesResponseList, RoleResponse{
+			Username:    b.Username,
+			Environment: b.Environment,
+			Role:        b.Role,
+		})
+	}
+
+	return &ListRolesResponse{
+		Project: request.Project,
+		Roles:   rolesResponseList,
+	}, nil
+}
+
+// RenameBindings follows a project or environment that's been renamed, since
+// roles and shares refer to it by name. Callers must already be authorised
+// to rename it.
+func (p PolicyServiceImpl) RenameBindings(ctx context.Context, request RenameBindingsRequest) error {
+	if request.Subject.Org != "" {
+		return p.store.RenameBindings(
+			ctx,
+			request.Subject.Org,
+			request.Project,
+			request.Environment,
+			request.NewName,
+		)
+	}
+
+	return p.store.RenameShareBindings(
+		ctx,
+		owner(request.Subject),
+		request.Project,
+		request.Environment,
+		request.NewName,
+	)
+}
+
+// RemoveBindings removes the roles and shares of a project or environment
+// that's been removed, so that they aren't inherited by one created later
+// with the same name. Callers must already be authorised to remove it.
+func (p PolicyServiceImpl) RemoveBindings(ctx context.Context, request RemoveBindingsRequest) error {
+	if request.Subject.Org != "" {
+		return p.store.DeleteBindings(
+			ctx,
+			request.Subject.Org,
+			request.Project,
+			request.Environment,
+		)
+	}
+
+	return p.store.DeleteShareBindings(
+		ctx,
+		owner(request.Subject),
+		request.Project,
+		request.Environment,
+	)
+}
+
+// owner is the user whose own database the subject is working in.
+func owner(subject Subject) string {
+	if subject.SharedBy != "" {
+		return subject.SharedBy
+	}
+
+	return subject.Username
+}
+
+func (p PolicyServiceImpl) authorizeAdmin(ctx context.Context, subject Subject, project string) error {
+	if subject.Authenticated && (subject.Org == "" || subject.SharedBy != "") {
+		return serrors.ErrOrgRequired
+	}
+
+	return p.Authorize(ctx, AuthorizeRequest{
+		Subject: subject,
+		Project: project,
+		Action:  ActionAdmin,
+	})
+}
+
+// resolveRole picks the role that applies to an environment. A binding for
+// the environment itself narrows (or widens) the project-wide binding.
+func resolveRole(bindings []Binding, environment string) string {
+	var projectRole string
+
+	for _, b := range bindings {
+		if environment != "" && b.Environment == environment {
+			return b.Role
+		}
+
+		if b.Environment == "" {
+			projectRole = b.Role
+		}
+	}
+
+	return projectRole
+}
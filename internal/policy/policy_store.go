@@ -0,0 +1,372 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+)
+
+type Binding struct {
+	ID          int
+	Username    string
+	Project     string
+	Environment string
+	Role        string
+}
+
+type PolicyStore interface {
+	GetOrgRole(ctx context.Context, orgName, username string) (string, error)
+	GetBindings(ctx context.Context, orgName, username, project string) (*[]Binding, error)
+	GetShareBindings(ctx context.Context, owner, recipient, project string) (*[]Binding, error)
+	ListBindings(ctx context.Context, orgName, project string) (*[]Binding, error)
+	SetBinding(ctx context.Context, orgName, username, project, environment, role string) error
+	DeleteBinding(ctx context.Context, orgName, username, project, environment string) error
+	RenameBindings(ctx context.Context, orgName, project, environment, newName string) error
+	DeleteBindings(ctx context.Context, orgName, project, environment string) error
+	RenameShareBindings(ctx context.Context, owner, project, environment, newName string) error
+	DeleteShareBindings(ctx context.Context, owner, project, environment string) error
+}
+
+type SqlitePolicyStore struct {
+	appDB *sql.DB
+}
+
+func NewSqlitePolicyStore(appDB *sql.DB) SqlitePolicyStore {
+	return SqlitePolicyStore{appDB}
+}
+
+func (s SqlitePolicyStore) GetOrgRole(ctx context.Context, orgName, username string) (string, error) {
+	query := `
+		select m.role_
+		from org_members_ m
+		inner join
+		orgs_ o
+		on m.org_id_ = o.id_
+		inner join
+		users_ u
+		on m.user_id_ = u.id_
+		where o.name_ = $orgName
+		and u.username_ = $username
+	`
+
+	row := s.appDB.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+		sql.Named("username", username),
+	)
+
+	var role string
+
+	if err := row.Scan(&role); err != nil {
+		if err == sql.ErrNoRows {
+			return "", serrors.ErrNotOrgMember
+		}
+
+		return "", serrors.ErrDatabaseQuery(err)
+	}
+
+	return role, nil
+}
+
+func (s SqlitePolicyStore) GetBindings(ctx context.Context, orgName, username, project string) (*[]Binding, error) {
+	query := `
+		select r.id_, u.username_, r.project_, r.environment_, r.role_
+		from roles_ r
+		inner join
+		orgs_ o
+		on r.org_id_ = o.id_
+		inner join
+		users_ u
+		on r.user_id_ = u.id_
+		where o.name_ = $orgName
+		and u.username_ = $username
+		and r.project_ = $project
+	`
+
+	rows, err := s.appDB.QueryContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+		sql.Named("username", username),
+		sql.Named("project", project),
+	)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return scanBindings(rows)
+}
+
+// GetShareBindings treats each unexpired environment share as a binding, so
+// shares are authorised the same way as roles.
+func (s SqlitePolicyStore) GetShareBindings(ctx context.Context, owner, recipient, project string) (*[]Binding, error) {
+	query := `
+		select s.id_, r.username_, s.project_, s.environment_,
+		case when s.read_only_ then 'reader' else 'writer' end
+		from shares_ s
+		inner join
+		users_ o
+		on s.owner_id_ = o.id_
+		inner join
+		users_ r
+		on s.recipient_id_ = r.id_
+		where o.username_ = $owner
+		and r.username_ = $recipient
+		and s.project_ = $project
+		and (s.expires_at_ is null or s.expires_at_ > current_timestamp)
+	`
+
+	rows, err := s.appDB.QueryContext(
+		ctx,
+		query,
+		sql.Named("owner", owner),
+		sql.Named("recipient", recipient),
+		sql.Named("project", project),
+	)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return scanBindings(rows)
+}
+
+func (s SqlitePolicyStore) ListBindings(ctx context.Context, orgName, project string) (*[]Binding, error) {
+	query := `
+		select r.id_, u.username_, r.project_, r.environment_, r.role_
+		from roles_ r
+		inner join
+		orgs_ o
+		on r.org_id_ = o.id_
+		inner join
+		users_ u
+		on r.user_id_ = u.id_
+		where o.name_ = $orgName
+		and r.project_ = $project
+		order by u.username_, r.environment_
+	`
+
+	rows, err := s.appDB.QueryContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+		sql.Named("project", project),
+	)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return scanBindings(rows)
+}
+
+func (s SqlitePolicyStore) SetBinding(ctx context.Context, orgName, username, project, environment, role string) error {
+	query := `
+		insert into roles_ (org_id_, user_id_, project_, environment_, role_)
+		select o.id_, u.id_, $project, $environment, $role
+		from orgs_ o
+		inner join
+		org_members_ m
+		on m.org_id_ = o.id_
+		inner join
+		users_ u
+		on m.user_id_ = u.id_
+		where o.name_ = $orgName
+		and u.username_ = $username
+		on conflict(org_id_, user_id_, project_, environment_)
+		do update set role_ = $role
+	`
+
+	res, err := s.appDB.ExecContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+		sql.Named("username", username),
+		sql.Named("project", project),
+		sql.Named("environment", environment),
+		sql.Named("role", role),
+	)
+	if err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return serrors.ErrNotOrgMember
+	}
+
+	return nil
+}
+
+func (s SqlitePolicyStore) DeleteBinding(ctx context.Context, orgName, username, project, environment string) error {
+	query := `
+		delete from roles_
+		where id_ in (
+			select r.id_ from roles_ r
+			inner join
+			orgs_ o
+			on r.org_id_ = o.id_
+			inner join
+			users_ u
+			on r.user_id_ = u.id_
+			where o.name_ = $orgName
+			and u.username_ = $username
+			and r.project_ = $project
+			and r.environment_ = $environment
+		)
+	`
+
+	res, err := s.appDB.ExecContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+		sql.Named("username", username),
+		sql.Named("project", project),
+		sql.Named("environment", environment),
+	)
+	if err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return serrors.ErrRoleNotFound
+	}
+
+	return nil
+}
+
+// RenameBindings moves the organisation's roles in a project, or in one of
+// its environments if one is given, to a new name. Any roles left behind
+// under the new name by something that no longer exists are replaced.
+func (s SqlitePolicyStore) RenameBindings(ctx context.Context, orgName, project, environment, newName string) error {
+	query := `
+		update or replace roles_
+		set project_ = case when $environment = '' then $newName else project_ end,
+		environment_ = case when $environment = '' then environment_ else $newName end
+		where org_id_ = (select id_ from orgs_ where name_ = $orgName)
+		and project_ = $project
+		and ($environment = '' or environment_ = $environment)
+	`
+
+	if _, err := s.appDB.ExecContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+		sql.Named("project", project),
+		sql.Named("environment", environment),
+		sql.Named("newName", newName),
+	); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+// DeleteBindings deletes the organisation's roles in a project, or in one of
+// its environments if one is given.
+func (s SqlitePolicyStore) DeleteBindings(ctx context.Context, orgName, project, environment string) error {
+	query := `
+		delete from roles_
+		where org_id_ = (select id_ from orgs_ where name_ = $orgName)
+		and project_ = $project
+		and ($environment = '' or environment_ = $environment)
+	`
+
+	if _, err := s.appDB.ExecContext(
+		ctx,
+		query,
+		sql.Named("orgName", orgName),
+		sql.Named("project", project),
+		sql.Named("environment", environment),
+	); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+// RenameShareBindings moves what the owner has shared of a project, or of
+// one of its environments if one is given, to a new name.
+func (s SqlitePolicyStore) RenameShareBindings(ctx context.Context, owner, project, environment, newName string) error {
+	query := `
+		update or replace shares_
+		set project_ = case when $environment = '' then $newName else project_ end,
+		environment_ = case when $environment = '' then environment_ else $newName end
+		where owner_id_ = (select id_ from users_ where username_ = $owner)
+		and project_ = $project
+		and ($environment = '' or environment_ = $environment)
+	`
+
+	if _, err := s.appDB.ExecContext(
+		ctx,
+		query,
+		sql.Named("owner", owner),
+		sql.Named("project", project),
+		sql.Named("environment", environment),
+		sql.Named("newName", newName),
+	); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+// DeleteShareBindings deletes what the owner has shared of a project, or of
+// one of its environments if one is given.
+func (s SqlitePolicyStore) DeleteShareBindings(ctx context.Context, owner, project, environment string) error {
+	query := `
+		delete from shares_
+		where owner_id_ = (select id_ from users_ where username_ = $owner)
+		and project_ = $project
+		and ($environment = '' or environment_ = $environment)
+	`
+
+	if _, err := s.appDB.ExecContext(
+		ctx,
+		query,
+		sql.Named("owner", owner),
+		sql.Named("project", project),
+		sql.Named("environment", environment),
+	); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+func scanBindings(rows *sql.Rows) (*[]Binding, error) {
+	defer rows.Close()
+
+	var bindings []Binding
+
+	for rows.Next() {
+		var binding Binding
+
+		if err := rows.Scan(
+			&binding.ID,
+			&binding.Username,
+			&binding.Project,
+			&binding.Environment,
+			&binding.Role,
+		); err != nil {
+			return nil, err
+		}
+
+		bindings = append(bindings, binding)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return &bindings, nil
+}
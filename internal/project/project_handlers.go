@@ -1,16 +1,18 @@
 package project
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
 	"github.com/spf13/cobra"
 )
 
 func NewHandlerProjectList(projectService ProjectService) pkg.CobraHandler {
 	return func(cmd *cobra.Command, args []string) error {
-		projects, err := projectService.List()
+		projects, err := projectService.List(cmd.Context())
 		if err != nil {
 			return err
 		}
@@ -30,9 +32,13 @@ func NewHandlerProjectAdd(projectService ProjectService) pkg.CobraHandler {
 	return func(cmd *cobra.Command, args []string) error {
 		projectName := args[0]
 
-		if err := projectService.Add(AddProjectRequest{
+		if err := projectService.Add(cmd.Context(), AddProjectRequest{
 			Name: projectName,
 		}); err != nil {
+			if errors.Is(err, serrors.ErrNotVerified) {
+				return fmt.Errorf("%w, run 'syringe user verify CODE' with the code you were emailed before adding projects", err)
+			}
+
 			return err
 		}
 
@@ -46,7 +52,7 @@ func NewHandlerProjectRemove(projectService ProjectService) pkg.CobraHandler {
 	return func(cmd *cobra.Command, args []string) error {
 		projectName := args[0]
 
-		if err := projectService.Remove(RemoveProjectRequest{
+		if err := projectService.Remove(cmd.Context(), RemoveProjectRequest{
 			Name: projectName,
 		}); err != nil {
 			return err
@@ -63,7 +69,7 @@ func NewHandlerProjectRename(projectService ProjectService) pkg.CobraHandler {
 		name := args[0]
 		newName := args[1]
 
-		if err := projectService.Rename(RenameProjectRequest{
+		if err := projectService.Rename(cmd.Context(), RenameProjectRequest{
 			Name:    name,
 			NewName: newName,
 		}); err != nil {
@@ -1,6 +1,8 @@
 package project
 
 import (
+	"context"
+
 	"github.com/nixpig/syringe.sh/pkg/serrors"
 	"github.com/nixpig/syringe.sh/pkg/validation"
 )
@@ -26,10 +28,10 @@ type ListProjectsResponse struct {
 }
 
 type ProjectService interface {
-	Add(project AddProjectRequest) error
-	Remove(project RemoveProjectRequest) error
-	Rename(project RenameProjectRequest) error
-	List() (*ListProjectsResponse, error)
+	Add(ctx context.Context, project AddProjectRequest) error
+	Remove(ctx context.Context, project RemoveProjectRequest) error
+	Rename(ctx context.Context, project RenameProjectRequest) error
+	List(ctx context.Context) (*ListProjectsResponse, error)
 }
 
 func NewProjectServiceImpl(
@@ -47,36 +49,37 @@ type ProjectServiceImpl struct {
 	validate validation.Validator
 }
 
-func (p ProjectServiceImpl) Add(project AddProjectRequest) error {
+func (p ProjectServiceImpl) Add(ctx context.Context, project AddProjectRequest) error {
 	if err := p.validate.Struct(project); err != nil {
 		return serrors.ValidationError(err)
 	}
 
-	if err := p.store.Add(project.Name); err != nil {
+	if err := p.store.Add(ctx, project.Name); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (p ProjectServiceImpl) Remove(project RemoveProjectRequest) error {
+func (p ProjectServiceImpl) Remove(ctx context.Context, project RemoveProjectRequest) error {
 	if err := p.validate.Struct(project); err != nil {
 		return serrors.ValidationError(err)
 	}
 
-	if err := p.store.Remove(project.Name); err != nil {
+	if err := p.store.Remove(ctx, project.Name); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (p ProjectServiceImpl) Rename(project RenameProjectRequest) error {
+func (p ProjectServiceImpl) Rename(ctx context.Context, project RenameProjectRequest) error {
 	if err := p.validate.Struct(project); err != nil {
 		return serrors.ValidationError(err)
 	}
 
 	if err := p.store.Rename(
+		ctx,
 		project.Name,
 		project.NewName,
 	); err != nil {
@@ -86,8 +89,8 @@ func (p ProjectServiceImpl) Rename(project RenameProjectRequest) error {
 	return nil
 }
 
-func (p ProjectServiceImpl) List() (*ListProjectsResponse, error) {
-	projects, err := p.store.List()
+func (p ProjectServiceImpl) List(ctx context.Context) (*ListProjectsResponse, error) {
+	projects, err := p.store.List(ctx)
 	if err != nil {
 		return nil, err
 	}
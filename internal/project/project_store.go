@@ -1,8 +1,8 @@
 package project
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
 
 	"github.com/nixpig/syringe.sh/pkg/serrors"
 )
@@ -13,10 +13,10 @@ type Project struct {
 }
 
 type ProjectStore interface {
-	Add(name string) error
-	Remove(name string) error
-	Rename(originalName, newName string) error
-	List() (*[]Project, error)
+	Add(ctx context.Context, name string) error
+	Remove(ctx context.Context, name string) error
+	Rename(ctx context.Context, originalName, newName string) error
+	List(ctx context.Context) (*[]Project, error)
 }
 
 type SqliteProjectStore struct {
@@ -27,26 +27,25 @@ func NewSqliteProjectStore(db *sql.DB) SqliteProjectStore {
 	return SqliteProjectStore{db}
 }
 
-func (s SqliteProjectStore) Add(name string) error {
+func (s SqliteProjectStore) Add(ctx context.Context, name string) error {
 	query := `
 		insert into projects_ (name_) values ($name)
 	`
 
-	if _, err := s.db.Exec(query, sql.Named("name", name)); err != nil {
+	if _, err := s.db.ExecContext(ctx, query, sql.Named("name", name)); err != nil {
 		return serrors.ErrDatabaseExec(err)
 	}
 
 	return nil
 }
 
-func (s SqliteProjectStore) Remove(name string) error {
+func (s SqliteProjectStore) Remove(ctx context.Context, name string) error {
 	query := `
 		delete from projects_ where name_ = $name
 	`
 
-	res, err := s.db.Exec(query, sql.Named("name", name))
+	res, err := s.db.ExecContext(ctx, query, sql.Named("name", name))
 	if err != nil {
-		fmt.Println(err)
 		return serrors.ErrDatabaseExec(err)
 	}
 
@@ -62,12 +61,13 @@ func (s SqliteProjectStore) Remove(name string) error {
 	return nil
 }
 
-func (s SqliteProjectStore) Rename(originalName, newName string) error {
+func (s SqliteProjectStore) Rename(ctx context.Context, originalName, newName string) error {
 	query := `
 		update projects_ set name_ = $newName where name_ = $originalName
 	`
 
-	if _, err := s.db.Exec(
+	if _, err := s.db.ExecContext(
+		ctx,
 		query,
 		sql.Named("originalName", originalName),
 		sql.Named("newName", newName),
@@ -78,12 +78,12 @@ func (s SqliteProjectStore) Rename(originalName, newName string) error {
 	return nil
 }
 
-func (s SqliteProjectStore) List() (*[]Project, error) {
+func (s SqliteProjectStore) List(ctx context.Context) (*[]Project, error) {
 	query := `
 		select id_, name_ from projects_
 	`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err == sql.ErrNoRows {
 		return nil, serrors.ErrNoProjectsFound
 	}
@@ -55,6 +55,9 @@ For more help on how to use syringe.sh, go to https://syringe.sh/help
 			"  \033[33m~\033[0m You probably (almost certainly!) don't want to use this software just yet.\033[0m\n",
 	)
 
+	rootCmd.PersistentFlags().String("org", "", "Organisation name (work with the organisation's shared projects)")
+	rootCmd.PersistentFlags().String("shared-by", "", "Username of the user who shared an environment with you")
+
 	rootCmd.SetContext(ctx)
 
 	return rootCmd
@@ -3,8 +3,10 @@ package secret
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/nixpig/syringe.sh/pkg"
+	"github.com/nixpig/syringe.sh/pkg/helpers"
 	"github.com/spf13/cobra"
 )
 
@@ -15,12 +17,37 @@ func NewHandlerSecretSet(secretService SecretService) pkg.CobraHandler {
 
 		project, _ := cmd.Flags().GetString("project")
 		environment, _ := cmd.Flags().GetString("environment")
+		expiresAt, _ := cmd.Flags().GetString("expires-at")
+		noExpiry, _ := cmd.Flags().GetBool("no-expiry")
+		rotateEvery, _ := cmd.Flags().GetString("rotate-every")
+
+		var expiresAtTime time.Time
+		var rotateEveryDuration time.Duration
+		var err error
+
+		if expiresAt != "" {
+			expiresAtTime, err = parseExpiresAt(expiresAt)
+			if err != nil {
+				return err
+			}
+		}
 
-		if err := secretService.Set(SetSecretRequest{
-			Project:     project,
-			Environment: environment,
-			Key:         key,
-			Value:       value,
+		if rotateEvery != "" {
+			rotateEveryDuration, err = helpers.ParseDuration(rotateEvery)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := secretService.Set(cmd.Context(), SetSecretRequest{
+			Project:       project,
+			Environment:   environment,
+			Key:           key,
+			Value:         value,
+			ExpiresAt:     expiresAtTime,
+			RotateEvery:   rotateEveryDuration,
+			ClearExpiry:   noExpiry,
+			ClearRotation: rotateEvery != "" && rotateEveryDuration == 0,
 		}); err != nil {
 			return err
 		}
@@ -37,7 +64,7 @@ func NewHandlerSecretGet(secretService SecretService) pkg.CobraHandler {
 		project, _ := cmd.Flags().GetString("project")
 		environment, _ := cmd.Flags().GetString("environment")
 
-		secret, err := secretService.Get(GetSecretRequest{
+		secret, err := secretService.Get(cmd.Context(), GetSecretRequest{
 			Project:     project,
 			Environment: environment,
 			Key:         key,
@@ -57,7 +84,7 @@ func NewHandlerSecretList(secretService SecretService) pkg.CobraHandler {
 		project, _ := cmd.Flags().GetString("project")
 		environment, _ := cmd.Flags().GetString("environment")
 
-		secrets, err := secretService.List(ListSecretsRequest{
+		secrets, err := secretService.List(cmd.Context(), ListSecretsRequest{
 			Project:     project,
 			Environment: environment,
 		})
@@ -67,7 +94,40 @@ func NewHandlerSecretList(secretService SecretService) pkg.CobraHandler {
 
 		secretsList := make([]string, len(secrets.Secrets))
 		for i, s := range secrets.Secrets {
-			secretsList[i] = fmt.Sprintf("%s=%s", s.Key, s.Value)
+			// values are withheld from callers who may only list keys
+			if s.Value == "" {
+				secretsList[i] = s.Key
+			} else {
+				secretsList[i] = fmt.Sprintf("%s=%s", s.Key, s.Value)
+			}
+
+			if status := Status(s.Expired, s.Stale); status != "" {
+				secretsList[i] = fmt.Sprintf("%s (%s)", secretsList[i], status)
+			}
+		}
+
+		cmd.Print(strings.Join(secretsList, "\n"))
+
+		return nil
+	}
+}
+
+func NewHandlerSecretStale(secretService SecretService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		secrets, err := secretService.ListOverdue(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		secretsList := make([]string, len(secrets.Secrets))
+		for i, s := range secrets.Secrets {
+			secretsList[i] = fmt.Sprintf(
+				"%s %s %s (%s)",
+				s.Project,
+				s.Environment,
+				s.Key,
+				Status(s.Expired, s.Stale),
+			)
 		}
 
 		cmd.Print(strings.Join(secretsList, "\n"))
@@ -83,7 +143,7 @@ func NewHandlerSecretRemove(secretService SecretService) pkg.CobraHandler {
 		project, _ := cmd.Flags().GetString("project")
 		environment, _ := cmd.Flags().GetString("environment")
 
-		if err := secretService.Remove(RemoveSecretRequest{
+		if err := secretService.Remove(cmd.Context(), RemoveSecretRequest{
 			Project:     project,
 			Environment: environment,
 			Key:         key,
@@ -96,3 +156,18 @@ func NewHandlerSecretRemove(secretService SecretService) pkg.CobraHandler {
 		return nil
 	}
 }
+
+// parseExpiresAt accepts a date, e.g. '2006-01-02', or a date and time, e.g.
+// '2006-01-02T15:04:05Z'.
+func parseExpiresAt(expiresAt string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, expiresAt); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q (use a date, e.g. 2006-01-02, or a date and time, e.g. 2006-01-02T15:04:05Z)", expiresAt)
+	}
+
+	return t, nil
+}
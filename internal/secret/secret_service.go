@@ -1,8 +1,26 @@
 package secret
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/nixpig/syringe.sh/pkg/tracing"
 	"github.com/nixpig/syringe.sh/pkg/validation"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/nixpig/syringe.sh/internal/secret")
+
+// What inject does when an environment has expired secrets.
+const (
+	OnExpiredWarn   = "warn"
+	OnExpiredRefuse = "refuse"
 )
 
 type SetSecretRequest struct {
@@ -10,6 +28,13 @@ type SetSecretRequest struct {
 	Environment string `name:"environment name" validate:"required,min=1,max=256"`
 	Key         string `name:"secret key" validate:"required,min=1,max=256"`
 	Value       string `name:"secret name" validate:"required,min=1,max=256"`
+	ExpiresAt   time.Time
+	RotateEvery time.Duration `name:"rotation period" validate:"min=0"`
+
+	// ClearExpiry and ClearRotation remove an existing secret's expiry and
+	// rotation period, which are otherwise kept when they aren't given.
+	ClearExpiry   bool
+	ClearRotation bool
 }
 
 type GetSecretRequest struct {
@@ -40,19 +65,35 @@ type GetSecretResponse struct {
 type ListSecretsResponse struct {
 	Project     string
 	Environment string
+	OnExpired   string
 	Secrets     []struct {
-		ID    int
-		Key   string
-		Value string
+		ID      int
+		Key     string
+		Value   string
+		Expired bool
+		Stale   bool
 	}
 }
 
+type OverdueSecretResponse struct {
+	Project     string
+	Environment string
+	Key         string
+	Expired     bool
+	Stale       bool
+}
+
+type ListOverdueSecretsResponse struct {
+	Secrets []OverdueSecretResponse
+}
+
 type SecretService interface {
-	CreateTables() error
-	Set(secret SetSecretRequest) error
-	Get(request GetSecretRequest) (*GetSecretResponse, error)
-	List(request ListSecretsRequest) (*ListSecretsResponse, error)
-	Remove(request RemoveSecretRequest) error
+	CreateTables(ctx context.Context) error
+	Set(ctx context.Context, secret SetSecretRequest) error
+	Get(ctx context.Context, request GetSecretRequest) (*GetSecretResponse, error)
+	List(ctx context.Context, request ListSecretsRequest) (*ListSecretsResponse, error)
+	ListOverdue(ctx context.Context) (*ListOverdueSecretsResponse, error)
+	Remove(ctx context.Context, request RemoveSecretRequest) error
 }
 
 type SecretServiceImpl struct {
@@ -70,24 +111,53 @@ func NewSecretServiceImpl(
 	}
 }
 
-func (s SecretServiceImpl) CreateTables() error {
-	if err := s.store.CreateTables(); err != nil {
+func (s SecretServiceImpl) CreateTables(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "secret.CreateTables")
+	defer func() { tracing.End(span, err) }()
+
+	if err := s.store.CreateTables(ctx); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s SecretServiceImpl) Set(secret SetSecretRequest) error {
+func (s SecretServiceImpl) Set(ctx context.Context, secret SetSecretRequest) (err error) {
+	ctx, span := tracer.Start(ctx, "secret.Set", withScope(secret.Project, secret.Environment))
+	defer func() { tracing.End(span, err) }()
+
 	if err := s.validate.Struct(secret); err != nil {
 		return serrors.ValidationError(err)
 	}
 
+	var expiresAt, rotateEvery sql.NullString
+
+	if !secret.ExpiresAt.IsZero() {
+		expiresAt = sql.NullString{
+			String: secret.ExpiresAt.UTC().Format(time.DateTime),
+			Valid:  true,
+		}
+	}
+
+	// stored as an sqlite date modifier, so it can be added to the time the
+	// secret was last set
+	if secret.RotateEvery > 0 {
+		rotateEvery = sql.NullString{
+			String: fmt.Sprintf("+%d seconds", int(secret.RotateEvery.Seconds())),
+			Valid:  true,
+		}
+	}
+
 	if err := s.store.Set(
+		ctx,
 		secret.Project,
 		secret.Environment,
 		secret.Key,
 		secret.Value,
+		expiresAt,
+		rotateEvery,
+		secret.ClearExpiry,
+		secret.ClearRotation,
 	); err != nil {
 		return err
 	}
@@ -95,12 +165,16 @@ func (s SecretServiceImpl) Set(secret SetSecretRequest) error {
 	return nil
 }
 
-func (s SecretServiceImpl) Get(request GetSecretRequest) (*GetSecretResponse, error) {
+func (s SecretServiceImpl) Get(ctx context.Context, request GetSecretRequest) (_ *GetSecretResponse, err error) {
+	ctx, span := tracer.Start(ctx, "secret.Get", withScope(request.Project, request.Environment))
+	defer func() { tracing.End(span, err) }()
+
 	if err := s.validate.Struct(request); err != nil {
 		return nil, serrors.ValidationError(err)
 	}
 
 	secret, err := s.store.Get(
+		ctx,
 		request.Project,
 		request.Environment,
 		request.Key,
@@ -118,47 +192,91 @@ func (s SecretServiceImpl) Get(request GetSecretRequest) (*GetSecretResponse, er
 	}, nil
 }
 
-func (s SecretServiceImpl) List(request ListSecretsRequest) (*ListSecretsResponse, error) {
+func (s SecretServiceImpl) List(ctx context.Context, request ListSecretsRequest) (_ *ListSecretsResponse, err error) {
+	ctx, span := tracer.Start(ctx, "secret.List", withScope(request.Project, request.Environment))
+	defer func() { tracing.End(span, err) }()
+
 	if err := s.validate.Struct(request); err != nil {
 		return nil, serrors.ValidationError(err)
 	}
 
-	secrets, err := s.store.List(request.Project, request.Environment)
+	secrets, err := s.store.List(ctx, request.Project, request.Environment)
 	if err != nil {
 		return nil, err
 	}
 
 	var secretsResponseList []struct {
-		ID    int
-		Key   string
-		Value string
+		ID      int
+		Key     string
+		Value   string
+		Expired bool
+		Stale   bool
 	}
 
+	onExpired := OnExpiredWarn
+
 	for _, sv := range *secrets {
 		secretsResponseList = append(secretsResponseList, struct {
-			ID    int
-			Key   string
-			Value string
+			ID      int
+			Key     string
+			Value   string
+			Expired bool
+			Stale   bool
 		}{
-			ID:    sv.ID,
-			Key:   sv.Key,
-			Value: sv.Value,
+			ID:      sv.ID,
+			Key:     sv.Key,
+			Value:   sv.Value,
+			Expired: sv.Expired,
+			Stale:   sv.Stale,
 		})
+
+		if sv.OnExpired != "" {
+			onExpired = sv.OnExpired
+		}
 	}
 
 	return &ListSecretsResponse{
 		Project:     request.Project,
 		Environment: request.Environment,
+		OnExpired:   onExpired,
 		Secrets:     secretsResponseList,
 	}, nil
 }
 
-func (s SecretServiceImpl) Remove(request RemoveSecretRequest) error {
+func (s SecretServiceImpl) ListOverdue(ctx context.Context) (_ *ListOverdueSecretsResponse, err error) {
+	ctx, span := tracer.Start(ctx, "secret.ListOverdue")
+	defer func() { tracing.End(span, err) }()
+
+	secrets, err := s.store.ListOverdue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var secretsResponseList []OverdueSecretResponse
+
+	for _, sv := range *secrets {
+		secretsResponseList = append(secretsResponseList, OverdueSecretResponse{
+			Project:     sv.Project,
+			Environment: sv.Environment,
+			Key:         sv.Key,
+			Expired:     sv.Expired,
+			Stale:       sv.Stale,
+		})
+	}
+
+	return &ListOverdueSecretsResponse{Secrets: secretsResponseList}, nil
+}
+
+func (s SecretServiceImpl) Remove(ctx context.Context, request RemoveSecretRequest) (err error) {
+	ctx, span := tracer.Start(ctx, "secret.Remove", withScope(request.Project, request.Environment))
+	defer func() { tracing.End(span, err) }()
+
 	if err := s.validate.Struct(request); err != nil {
 		return serrors.ValidationError(err)
 	}
 
 	if err := s.store.Remove(
+		ctx,
 		request.Project,
 		request.Environment,
 		request.Key,
@@ -168,3 +286,40 @@ func (s SecretServiceImpl) Remove(request RemoveSecretRequest) error {
 
 	return nil
 }
+
+// withScope names the project and environment a span is about. Secret keys
+// and values are never recorded.
+func withScope(projectName, environmentName string) trace.SpanStartOption {
+	return trace.WithAttributes(
+		attribute.String("syringe.project", projectName),
+		attribute.String("syringe.environment", environmentName),
+	)
+}
+
+// ExpiredKeys lists the keys of secrets that have expired.
+func (l ListSecretsResponse) ExpiredKeys() []string {
+	var keys []string
+
+	for _, s := range l.Secrets {
+		if s.Expired {
+			keys = append(keys, s.Key)
+		}
+	}
+
+	return keys
+}
+
+// Status describes whether a secret needs attention, or is empty if not.
+func Status(expired, stale bool) string {
+	var status []string
+
+	if expired {
+		status = append(status, "expired")
+	}
+
+	if stale {
+		status = append(status, "rotation due")
+	}
+
+	return strings.Join(status, ", ")
+}
@@ -3,8 +3,12 @@ package secret
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
 	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/nixpig/syringe.sh/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Secret struct {
@@ -13,14 +17,23 @@ type Secret struct {
 	Value       string
 	Project     string
 	Environment string
+	Expired     bool
+	Stale       bool
+	OnExpired   string
 }
 
 type SecretStore interface {
-	CreateTables() error
-	Set(project, environment, key, value string) error
-	Get(project, environment, key string) (*Secret, error)
-	List(project, environment string) (*[]Secret, error)
-	Remove(project, environment, key string) error
+	CreateTables(ctx context.Context) error
+	Set(
+		ctx context.Context,
+		project, environment, key, value string,
+		expiresAt, rotateEvery sql.NullString,
+		clearExpiry, clearRotation bool,
+	) error
+	Get(ctx context.Context, project, environment, key string) (*Secret, error)
+	List(ctx context.Context, project, environment string) (*[]Secret, error)
+	ListOverdue(ctx context.Context) (*[]Secret, error)
+	Remove(ctx context.Context, project, environment, key string) error
 }
 
 type SqliteSecretStore struct {
@@ -31,7 +44,13 @@ func NewSqliteSecretStore(db *sql.DB) SqliteSecretStore {
 	return SqliteSecretStore{db}
 }
 
-func (s SqliteSecretStore) CreateTables() error {
+// CreateTables creates the tables secrets are kept in, and adds columns
+// added since to the tables of databases created before them. It's safe to
+// run on a database that's already up to date.
+func (s SqliteSecretStore) CreateTables(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "CreateTables")
+	defer func() { tracing.End(span, err) }()
+
 	projectsQuery := `
 		create table if not exists projects_ (
 			id_ integer primary key autoincrement,
@@ -44,43 +63,198 @@ func (s SqliteSecretStore) CreateTables() error {
 			id_ integer primary key autoincrement,
 			name_ varchar(256) not null,
 			project_id_ integer not null,
+			on_expired_ varchar(8) not null default 'warn',
 
 			foreign key (project_id_) references projects_(id_) on delete cascade
 		)
 	`
-	secretsQuery := `
-		create table if not exists secrets_ (
+	trx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer trx.Rollback()
+
+	for _, query := range []string{projectsQuery, environmentsQuery, secretsTableQuery("secrets_")} {
+		if _, err := trx.ExecContext(ctx, query); err != nil {
+			return serrors.ErrDatabaseExec(err)
+		}
+	}
+
+	if err := migrate(ctx, trx); err != nil {
+		return err
+	}
+
+	if err := migrateSecretsKey(ctx, trx); err != nil {
+		return err
+	}
+
+	if err := trx.Commit(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// secretsTableQuery creates a table for secrets with the given name. Keys
+// are unique within an environment, so the same key can be set in each.
+func secretsTableQuery(name string) string {
+	return fmt.Sprintf(`
+		create table if not exists %s (
 			id_ integer primary key autoincrement,
-			key_ text not null unique,
+			key_ text not null,
 			value_ text not null,
 			environment_id_ integer not null,
+			expires_at_ datetime without time zone,
+			rotate_every_ varchar(32),
+			updated_at_ datetime without time zone default current_timestamp,
 
+			unique (environment_id_, key_),
 			foreign key (environment_id_) references environments_(id_) on delete cascade
 		)
+	`, name)
+}
+
+// migration is a column added to a table after databases were first created
+// with it. SQLite can't add columns with a non-constant default, so any
+// existing rows are backfilled.
+type migration struct {
+	table      string
+	column     string
+	definition string
+	backfill   string
+}
+
+var migrations = []migration{
+	{table: "environments_", column: "on_expired_", definition: "varchar(8) not null default 'warn'"},
+	{table: "secrets_", column: "expires_at_", definition: "datetime without time zone"},
+	{table: "secrets_", column: "rotate_every_", definition: "varchar(32)"},
+	{
+		table:      "secrets_",
+		column:     "updated_at_",
+		definition: "datetime without time zone",
+		backfill:   "update secrets_ set updated_at_ = current_timestamp where updated_at_ is null",
+	},
+}
+
+// migrate adds the columns that are missing from the tables.
+func migrate(ctx context.Context, trx *sql.Tx) error {
+	columns := map[string]map[string]bool{}
+
+	for _, m := range migrations {
+		if _, ok := columns[m.table]; !ok {
+			tableColumns, err := tableColumns(ctx, trx, m.table)
+			if err != nil {
+				return err
+			}
+
+			columns[m.table] = tableColumns
+		}
+
+		if columns[m.table][m.column] {
+			continue
+		}
+
+		if _, err := trx.ExecContext(
+			ctx,
+			fmt.Sprintf("alter table %s add column %s %s", m.table, m.column, m.definition),
+		); err != nil {
+			return serrors.ErrDatabaseExec(err)
+		}
+
+		if m.backfill != "" {
+			if _, err := trx.ExecContext(ctx, m.backfill); err != nil {
+				return serrors.ErrDatabaseExec(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateSecretsKey makes keys unique per environment in databases created
+// when they were unique across every project and environment. SQLite can't
+// drop a constraint, so the table is rebuilt without it. It must run after
+// the table's columns have been migrated.
+func migrateSecretsKey(ctx context.Context, trx *sql.Tx) error {
+	// a unique index on the key alone is the old constraint
+	query := `
+		select count(*) from pragma_index_list('secrets_') l
+		where l."unique"
+		and (select group_concat(name) from pragma_index_info(l.name)) = 'key_'
 	`
 
-	ctx := context.Background()
+	var keyIndexes int
 
-	trx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return err
+	if err := trx.QueryRowContext(ctx, query).Scan(&keyIndexes); err != nil {
+		return serrors.ErrDatabaseQuery(err)
 	}
 
-	trx.Exec(projectsQuery)
-	trx.Exec(environmentsQuery)
-	trx.Exec(secretsQuery)
+	if keyIndexes == 0 {
+		return nil
+	}
 
-	if err := trx.Commit(); err != nil {
-		return err
+	for _, query := range []string{
+		secretsTableQuery("secrets_new_"),
+		`
+			insert into secrets_new_
+			(id_, key_, value_, environment_id_, expires_at_, rotate_every_, updated_at_)
+			select id_, key_, value_, environment_id_, expires_at_, rotate_every_, updated_at_
+			from secrets_
+		`,
+		`drop table secrets_`,
+		`alter table secrets_new_ rename to secrets_`,
+	} {
+		if _, err := trx.ExecContext(ctx, query); err != nil {
+			return serrors.ErrDatabaseExec(err)
+		}
 	}
 
 	return nil
 }
 
-func (s SqliteSecretStore) Set(project, environment, key, value string) error {
+func tableColumns(ctx context.Context, trx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := trx.QueryContext(ctx, fmt.Sprintf("pragma table_info(%s)", table))
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	defer rows.Close()
+
+	columns := map[string]bool{}
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, dataType   string
+			defaultValue     sql.NullString
+		)
+
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
+
+// Set adds or updates a secret. Setting the value of an existing secret
+// restarts its rotation period, and keeps its expiry and rotation period
+// unless new ones are given or they're cleared.
+func (s SqliteSecretStore) Set(
+	ctx context.Context,
+	project, environment, key, value string,
+	expiresAt, rotateEvery sql.NullString,
+	clearExpiry, clearRotation bool,
+) (err error) {
+	ctx, span := startSpan(ctx, "Set")
+	defer func() { tracing.End(span, err) }()
+
 	query := `
 		insert into secrets_ 
-		(key_, value_, environment_id_) 
+		(key_, value_, environment_id_, expires_at_, rotate_every_, updated_at_) 
 		values (
 			$key,
 			$value,
@@ -92,18 +266,29 @@ func (s SqliteSecretStore) Set(project, environment, key, value string) error {
 					on e.project_id_ = p.id_ 
 					where p.name_ = $project 
 					and e.name_ = $environment
-			)
+			),
+			$expiresAt,
+			$rotateEvery,
+			current_timestamp
 		) 
-		on conflict(key_)
-		do update set value_ = $value
+		on conflict(environment_id_, key_)
+		do update set value_ = $value,
+		expires_at_ = case when $clearExpiry then null else coalesce($expiresAt, expires_at_) end,
+		rotate_every_ = case when $clearRotation then null else coalesce($rotateEvery, rotate_every_) end,
+		updated_at_ = current_timestamp
 	`
 
-	if _, err := s.db.Exec(
+	if _, err := s.db.ExecContext(
+		ctx,
 		query,
 		sql.Named("project", project),
 		sql.Named("environment", environment),
 		sql.Named("key", key),
 		sql.Named("value", value),
+		sql.Named("expiresAt", expiresAt),
+		sql.Named("rotateEvery", rotateEvery),
+		sql.Named("clearExpiry", clearExpiry),
+		sql.Named("clearRotation", clearRotation),
 	); err != nil {
 		return serrors.ErrDatabaseExec(err)
 	}
@@ -111,7 +296,10 @@ func (s SqliteSecretStore) Set(project, environment, key, value string) error {
 	return nil
 }
 
-func (s SqliteSecretStore) Get(project, environment, key string) (*Secret, error) {
+func (s SqliteSecretStore) Get(ctx context.Context, project, environment, key string) (_ *Secret, err error) {
+	ctx, span := startSpan(ctx, "Get")
+	defer func() { tracing.End(span, err) }()
+
 	query := `
 		select s.id_, s.key_, s.value_, p.name_, e.name_
 		from secrets_ s
@@ -126,7 +314,8 @@ func (s SqliteSecretStore) Get(project, environment, key string) (*Secret, error
 		and s.key_ = $key
 	`
 
-	row := s.db.QueryRow(
+	row := s.db.QueryRowContext(
+		ctx,
 		query,
 		sql.Named("project", project),
 		sql.Named("environment", environment),
@@ -148,9 +337,15 @@ func (s SqliteSecretStore) Get(project, environment, key string) (*Secret, error
 	return &secret, nil
 }
 
-func (s SqliteSecretStore) List(project, environment string) (*[]Secret, error) {
+func (s SqliteSecretStore) List(ctx context.Context, project, environment string) (_ *[]Secret, err error) {
+	ctx, span := startSpan(ctx, "List")
+	defer func() { tracing.End(span, err) }()
+
 	query := `
-		select s.id_, s.key_, s.value_, p.name_, e.name_
+		select s.id_, s.key_, s.value_, p.name_, e.name_,
+		coalesce(s.expires_at_ <= current_timestamp, false),
+		coalesce(datetime(s.updated_at_, s.rotate_every_) <= current_timestamp, false),
+		e.on_expired_
 		from secrets_ s
 		inner join
 		environments_ e
@@ -162,7 +357,8 @@ func (s SqliteSecretStore) List(project, environment string) (*[]Secret, error)
 		and e.name_ = $environment
 	`
 
-	rows, err := s.db.Query(
+	rows, err := s.db.QueryContext(
+		ctx,
 		query,
 		sql.Named("project", project),
 		sql.Named("environment", environment),
@@ -174,6 +370,8 @@ func (s SqliteSecretStore) List(project, environment string) (*[]Secret, error)
 		return nil, serrors.ErrDatabaseQuery(err)
 	}
 
+	defer rows.Close()
+
 	var secrets []Secret
 
 	for rows.Next() {
@@ -185,6 +383,9 @@ func (s SqliteSecretStore) List(project, environment string) (*[]Secret, error)
 			&secret.Value,
 			&secret.Project,
 			&secret.Environment,
+			&secret.Expired,
+			&secret.Stale,
+			&secret.OnExpired,
 		); err != nil {
 			return nil, err
 		}
@@ -192,10 +393,74 @@ func (s SqliteSecretStore) List(project, environment string) (*[]Secret, error)
 		secrets = append(secrets, secret)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
 	return &secrets, nil
 }
 
-func (s SqliteSecretStore) Remove(project, environment, key string) error {
+// ListOverdue lists secrets in every project that have expired or are due
+// to be rotated. Values are not returned.
+func (s SqliteSecretStore) ListOverdue(ctx context.Context) (_ *[]Secret, err error) {
+	ctx, span := startSpan(ctx, "ListOverdue")
+	defer func() { tracing.End(span, err) }()
+
+	query := `
+		select id_, key_, project_, environment_, expired_, stale_
+		from (
+			select s.id_ as id_, s.key_ as key_, p.name_ as project_, e.name_ as environment_,
+			coalesce(s.expires_at_ <= current_timestamp, false) as expired_,
+			coalesce(datetime(s.updated_at_, s.rotate_every_) <= current_timestamp, false) as stale_
+			from secrets_ s
+			inner join
+			environments_ e
+			on s.environment_id_ = e.id_
+			inner join
+			projects_ p
+			on p.id_ = e.project_id_
+		)
+		where expired_ or stale_
+		order by project_, environment_, key_
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	defer rows.Close()
+
+	var secrets []Secret
+
+	for rows.Next() {
+		var secret Secret
+
+		if err := rows.Scan(
+			&secret.ID,
+			&secret.Key,
+			&secret.Project,
+			&secret.Environment,
+			&secret.Expired,
+			&secret.Stale,
+		); err != nil {
+			return nil, err
+		}
+
+		secrets = append(secrets, secret)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return &secrets, nil
+}
+
+func (s SqliteSecretStore) Remove(ctx context.Context, project, environment, key string) (err error) {
+	ctx, span := startSpan(ctx, "Remove")
+	defer func() { tracing.End(span, err) }()
+
 	query := `
 		delete from secrets_ 
 		where id_ in (
@@ -212,7 +477,8 @@ func (s SqliteSecretStore) Remove(project, environment, key string) error {
 		)
 	`
 
-	res, err := s.db.Exec(
+	res, err := s.db.ExecContext(
+		ctx,
 		query,
 		sql.Named("projectName", project),
 		sql.Named("environmentName", environment),
@@ -233,3 +499,16 @@ func (s SqliteSecretStore) Remove(project, environment, key string) error {
 
 	return nil
 }
+
+// startSpan starts a span around a query of the user's database.
+func startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracer.Start(
+		ctx,
+		"secret.store."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.operation.name", op),
+		),
+	)
+}
@@ -2,6 +2,7 @@ package secret_test
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -46,6 +47,12 @@ func TestSecretCmd(t *testing.T) {
 		// "test secret list command validation error":    testSecretListCmdValidationError,
 
 		"test secret remove command happy path": testSecretRemoveCmdHappyPath,
+
+		"test secret set command with expiry and rotation":  testSecretSetCmdWithExpiryAndRotation,
+		"test secret set command clear expiry and rotation": testSecretSetCmdClearExpiryAndRotation,
+		"test secret set command invalid expiry":            testSecretSetCmdInvalidExpiry,
+		"test secret list command flags overdue secrets":    testSecretListCmdFlagsOverdueSecrets,
+		"test secret stale command":                         testSecretStaleCmd,
 		// "test secret remove command zero results":        testSecretRemoveCmdZeroResults,
 		// "test secret remove command database error":      testSecretRemoveCmdDatabaseError,
 		// "test secret remove command missing project":     testSecretRemoveCmdMissingProject,
@@ -103,7 +110,7 @@ func testSecretSetCmdHappyPath(
 
 	query := `
 		insert into secrets_
-		(key_, value_, environment_id_)
+		(key_, value_, environment_id_, expires_at_, rotate_every_, updated_at_)
 		values (
 			$key,
 			$value,
@@ -115,7 +122,10 @@ func testSecretSetCmdHappyPath(
 					on e.project_id_ = p.id_
 					where p.name_ = $project
 					and e.name_ = $environment
-			)
+			),
+			$expiresAt,
+			$rotateEvery,
+			current_timestamp
 		)
 	`
 
@@ -125,6 +135,10 @@ func testSecretSetCmdHappyPath(
 			"staging",
 			"secret_key",
 			"secret_value",
+			nil,
+			nil,
+			false,
+			false,
 		).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
@@ -331,7 +345,7 @@ func testSecretSetCmdDatabaseError(
 
 	query := `
 		insert into secrets_
-		(key_, value_, environment_id_)
+		(key_, value_, environment_id_, expires_at_, rotate_every_, updated_at_)
 		values (
 			$key,
 			$value,
@@ -343,7 +357,10 @@ func testSecretSetCmdDatabaseError(
 					on e.project_id_ = p.id_
 					where p.name_ = $project
 					and e.name_ = $environment
-			)
+			),
+			$expiresAt,
+			$rotateEvery,
+			current_timestamp
 		)
 	`
 
@@ -353,6 +370,10 @@ func testSecretSetCmdDatabaseError(
 			"staging",
 			"secret_key",
 			"secret_value",
+			nil,
+			nil,
+			false,
+			false,
 		).
 		WillReturnError(fmt.Errorf("database_error"))
 
@@ -767,7 +788,10 @@ func testSecretListCmdHappyPath(
 	cmd.SetErr(errOut)
 
 	query := `
-		select s.id_, s.key_, s.value_, p.name_, e.name_
+		select s.id_, s.key_, s.value_, p.name_, e.name_,
+		coalesce(s.expires_at_ <= current_timestamp, false),
+		coalesce(datetime(s.updated_at_, s.rotate_every_) <= current_timestamp, false),
+		e.on_expired_
 		from secrets_ s
 		inner join
 		environments_ e
@@ -789,9 +813,12 @@ func testSecretListCmdHappyPath(
 				"value_",
 				"project_name_",
 				"environment_name_",
+				"expired_",
+				"stale_",
+				"on_expired_",
 			}).
-				AddRow(1, "key_1", "value_1", "my_cool_project", "staging").
-				AddRow(2, "key_2", "value_2", "my_cool_project", "staging"),
+				AddRow(1, "key_1", "value_1", "my_cool_project", "staging", false, false, "warn").
+				AddRow(2, "key_2", "value_2", "my_cool_project", "staging", false, false, "warn"),
 		)
 
 	err := cmd.Execute()
@@ -880,7 +907,10 @@ func testSecretListCmdDatabaseError(
 	errOut := bytes.NewBufferString("")
 
 	query := `
-		select s.id_, s.key_, s.value_, p.name_, e.name_
+		select s.id_, s.key_, s.value_, p.name_, e.name_,
+		coalesce(s.expires_at_ <= current_timestamp, false),
+		coalesce(datetime(s.updated_at_, s.rotate_every_) <= current_timestamp, false),
+		e.on_expired_
 		from secrets_ s
 		inner join
 		environments_ e
@@ -1284,7 +1314,10 @@ func testSecretListCmdZeroResults(
 	cmd.SetErr(errOut)
 
 	query := `
-		select s.id_, s.key_, s.value_, p.name_, e.name_
+		select s.id_, s.key_, s.value_, p.name_, e.name_,
+		coalesce(s.expires_at_ <= current_timestamp, false),
+		coalesce(datetime(s.updated_at_, s.rotate_every_) <= current_timestamp, false),
+		e.on_expired_
 		from secrets_ s
 		inner join
 		environments_ e
@@ -1373,3 +1406,306 @@ func testSecretListCmdZeroResults(
 //
 // 	require.NoError(t, mock.ExpectationsWereMet())
 // }
+
+func testSecretSetCmdWithExpiryAndRotation(
+	t *testing.T,
+	cmd *cobra.Command,
+	service secret.SecretService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(secret.NewCmdSecretSet(secret.NewHandlerSecretSet(service)))
+	cmd.SetArgs([]string{
+		"set",
+		"-p", "my_cool_project",
+		"-e", "staging",
+		"--expires-at", "2030-01-02",
+		"--rotate-every", "90d",
+		"secret_key",
+		"secret_value",
+	})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	query := `
+		on conflict(environment_id_, key_)
+		do update set value_ = $value,
+		expires_at_ = case when $clearExpiry then null else coalesce($expiresAt, expires_at_) end,
+		rotate_every_ = case when $clearRotation then null else coalesce($rotateEvery, rotate_every_) end,
+		updated_at_ = current_timestamp
+	`
+
+	mock.ExpectExec(regexp.QuoteMeta(query)).
+		WithArgs(
+			"my_cool_project",
+			"staging",
+			"secret_key",
+			"secret_value",
+			"2030-01-02 00:00:00",
+			"+7776000 seconds",
+			false,
+			false,
+		).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testSecretSetCmdClearExpiryAndRotation(
+	t *testing.T,
+	cmd *cobra.Command,
+	service secret.SecretService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(secret.NewCmdSecretSet(secret.NewHandlerSecretSet(service)))
+	cmd.SetArgs([]string{
+		"set",
+		"-p", "my_cool_project",
+		"-e", "staging",
+		"--no-expiry",
+		"--rotate-every", "0",
+		"secret_key",
+		"secret_value",
+	})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	query := `
+		on conflict(environment_id_, key_)
+		do update set value_ = $value,
+		expires_at_ = case when $clearExpiry then null else coalesce($expiresAt, expires_at_) end,
+		rotate_every_ = case when $clearRotation then null else coalesce($rotateEvery, rotate_every_) end,
+		updated_at_ = current_timestamp
+	`
+
+	mock.ExpectExec(regexp.QuoteMeta(query)).
+		WithArgs(
+			"my_cool_project",
+			"staging",
+			"secret_key",
+			"secret_value",
+			nil,
+			nil,
+			true,
+			true,
+		).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testSecretSetCmdInvalidExpiry(
+	t *testing.T,
+	cmd *cobra.Command,
+	service secret.SecretService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(secret.NewCmdSecretSet(secret.NewHandlerSecretSet(service)))
+	cmd.SetArgs([]string{
+		"set",
+		"-p", "my_cool_project",
+		"-e", "staging",
+		"--expires-at", "next week",
+		"secret_key",
+		"secret_value",
+	})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	err := cmd.Execute()
+
+	require.ErrorContains(t, err, `invalid expiry "next week"`)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testSecretListCmdFlagsOverdueSecrets(
+	t *testing.T,
+	cmd *cobra.Command,
+	service secret.SecretService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(secret.NewCmdSecretList(secret.NewHandlerSecretList(service)))
+	cmd.SetArgs([]string{"list", "-p", "my_cool_project", "-e", "staging"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	query := `
+		coalesce(s.expires_at_ <= current_timestamp, false),
+		coalesce(datetime(s.updated_at_, s.rotate_every_) <= current_timestamp, false),
+		e.on_expired_
+	`
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WithArgs("my_cool_project", "staging").
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"id_", "key_", "value_", "project_name_", "environment_name_",
+				"expired_", "stale_", "on_expired_",
+			}).
+				AddRow(1, "key_1", "value_1", "my_cool_project", "staging", true, false, "warn").
+				AddRow(2, "key_2", "value_2", "my_cool_project", "staging", false, true, "warn").
+				AddRow(3, "key_3", "value_3", "my_cool_project", "staging", false, false, "warn"),
+		)
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.Equal(
+		t,
+		"key_1=value_1 (expired)\nkey_2=value_2 (rotation due)\nkey_3=value_3",
+		cmdOut.String(),
+	)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testSecretStaleCmd(
+	t *testing.T,
+	cmd *cobra.Command,
+	service secret.SecretService,
+	mock sqlmock.Sqlmock,
+) {
+	cmdOut := bytes.NewBufferString("")
+	errOut := bytes.NewBufferString("")
+
+	cmd.AddCommand(secret.NewCmdSecretStale(secret.NewHandlerSecretStale(service)))
+	cmd.SetArgs([]string{"stale"})
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(errOut)
+
+	query := `
+		where expired_ or stale_
+		order by project_, environment_, key_
+	`
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "key_", "project_", "environment_", "expired_", "stale_"}).
+				AddRow(1, "key_1", "my_cool_project", "prod", true, true).
+				AddRow(4, "key_4", "my_other_project", "dev", false, true),
+		)
+
+	err := cmd.Execute()
+
+	require.NoError(t, err)
+	require.Empty(t, errOut.String())
+	require.Equal(
+		t,
+		"my_cool_project prod key_1 (expired, rotation due)\nmy_other_project dev key_4 (rotation due)",
+		cmdOut.String(),
+	)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSecretStoreCreateTables(t *testing.T) {
+	scenarios := map[string]func(t *testing.T, store secret.SecretStore, mock sqlmock.Sqlmock){
+		"test create tables migrates old schema":   testCreateTablesMigratesOldSchema,
+		"test create tables leaves current schema": testCreateTablesLeavesCurrentSchema,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			fn(t, secret.NewSqliteSecretStore(db), mock)
+		})
+	}
+}
+
+func expectCreateTables(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`create table if not exists projects_`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`create table if not exists environments_`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`create table if not exists secrets_`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectTableInfo(mock sqlmock.Sqlmock, table string, columns ...string) {
+	rows := sqlmock.NewRows([]string{"cid", "name", "type", "notnull", "dflt_value", "pk"})
+	for i, column := range columns {
+		rows.AddRow(i, column, "text", 0, nil, 0)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("pragma table_info(" + table + ")")).
+		WillReturnRows(rows)
+}
+
+func expectKeyIndexes(mock sqlmock.Sqlmock, indexes int) {
+	mock.ExpectQuery(regexp.QuoteMeta(`select count(*) from pragma_index_list('secrets_') l`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(indexes))
+}
+
+func testCreateTablesMigratesOldSchema(t *testing.T, store secret.SecretStore, mock sqlmock.Sqlmock) {
+	expectCreateTables(mock)
+
+	// as databases were created before secrets could expire or be rotated
+	expectTableInfo(mock, "environments_", "id_", "name_", "project_id_")
+	mock.ExpectExec(regexp.QuoteMeta(`alter table environments_ add column on_expired_ varchar(8) not null default 'warn'`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	expectTableInfo(mock, "secrets_", "id_", "key_", "value_", "environment_id_")
+	mock.ExpectExec(regexp.QuoteMeta(`alter table secrets_ add column expires_at_ datetime without time zone`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`alter table secrets_ add column rotate_every_ varchar(32)`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`alter table secrets_ add column updated_at_ datetime without time zone`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`update secrets_ set updated_at_ = current_timestamp where updated_at_ is null`)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	// and keys were unique across every environment
+	expectKeyIndexes(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta(`create table if not exists secrets_new_`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`
+		insert into secrets_new_
+		(id_, key_, value_, environment_id_, expires_at_, rotate_every_, updated_at_)
+		select id_, key_, value_, environment_id_, expires_at_, rotate_every_, updated_at_
+		from secrets_
+	`)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(`drop table secrets_`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`alter table secrets_new_ rename to secrets_`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectCommit()
+
+	require.NoError(t, store.CreateTables(context.Background()))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func testCreateTablesLeavesCurrentSchema(t *testing.T, store secret.SecretStore, mock sqlmock.Sqlmock) {
+	expectCreateTables(mock)
+	expectTableInfo(mock, "environments_", "id_", "name_", "project_id_", "on_expired_")
+	expectTableInfo(mock, "secrets_", "id_", "key_", "value_", "environment_id_", "expires_at_", "rotate_every_", "updated_at_")
+	expectKeyIndexes(mock, 0)
+	mock.ExpectCommit()
+
+	require.NoError(t, store.CreateTables(context.Background()))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -20,10 +20,97 @@ func NewCmdUserRegister(handler pkg.CobraHandler) *cobra.Command {
 		Use:     "register [flags] [USERNAME]",
 		Aliases: []string{"r"},
 		Short:   "Register user",
-		Example: "syringe user register",
+		Long:    "Register the SSH key you connect with to the username you connect as. A verification code is emailed to you, which you'll need to verify your email address with before you can add projects.",
+		Example: "syringe user register --email jane@example.org",
 		Args:    cobra.NoArgs,
 		RunE:    handler,
 	}
 
+	registerCmd.Flags().String("email", "", "Email address, which a verification code is sent to")
+	registerCmd.MarkFlagRequired("email")
+
 	return registerCmd
 }
+
+func NewCmdUserVerify(handler pkg.CobraHandler) *cobra.Command {
+	verifyCmd := &cobra.Command{
+		Use:     "verify [flags] CODE",
+		Aliases: []string{"v"},
+		Short:   "Verify email address",
+		Long:    "Verify your email address with the code sent to it when you registered.",
+		Example: "syringe user verify 123456",
+		Args:    cobra.ExactArgs(1),
+		RunE:    handler,
+	}
+
+	return verifyCmd
+}
+
+func NewCmdUserExport(handler pkg.CobraHandler) *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:     "export [flags]",
+		Aliases: []string{"e"},
+		Short:   "Export user data",
+		Long:    "Export your account, keys, projects, environments and (still encrypted) secrets as a gzipped tarball written to stdout.",
+		Example: "syringe user export > syringe-export.tar.gz",
+		Args:    cobra.NoArgs,
+		RunE:    handler,
+	}
+
+	return exportCmd
+}
+
+func NewCmdUserDelete(handler pkg.CobraHandler) *cobra.Command {
+	deleteCmd := &cobra.Command{
+		Use:     "delete [flags]",
+		Aliases: []string{"d"},
+		Short:   "Delete user",
+		Long:    "Delete your account, keys and database, after exporting all of your data as with 'user export'. You'll be asked to type your username to confirm.",
+		Example: "syringe user delete --confirm janedoe > syringe-export.tar.gz",
+		Args:    cobra.NoArgs,
+		RunE:    handler,
+	}
+
+	deleteCmd.Flags().String("confirm", "", "Your username, to confirm without being asked")
+
+	return deleteCmd
+}
+
+func NewCmdUserSuspend(handler pkg.CobraHandler) *cobra.Command {
+	suspendCmd := &cobra.Command{
+		Use:     "suspend [flags] USERNAME",
+		Short:   "Suspend user",
+		Long:    "Suspend a user, e.g. for abusing the service, so they can no longer connect. Their data is kept, and they can be reactivated.",
+		Example: "syringeserver user suspend janedoe",
+		Args:    cobra.ExactArgs(1),
+		RunE:    handler,
+	}
+
+	return suspendCmd
+}
+
+func NewCmdUserLock(handler pkg.CobraHandler) *cobra.Command {
+	lockCmd := &cobra.Command{
+		Use:     "lock [flags] USERNAME",
+		Short:   "Lock user",
+		Long:    "Lock a user whose account may have been compromised, so nobody can connect as them. Their data is kept, and they can be reactivated.",
+		Example: "syringeserver user lock janedoe",
+		Args:    cobra.ExactArgs(1),
+		RunE:    handler,
+	}
+
+	return lockCmd
+}
+
+func NewCmdUserReactivate(handler pkg.CobraHandler) *cobra.Command {
+	reactivateCmd := &cobra.Command{
+		Use:     "reactivate [flags] USERNAME",
+		Short:   "Reactivate user",
+		Long:    "Reactivate a suspended or locked user, so they can connect again.",
+		Example: "syringeserver user reactivate janedoe",
+		Args:    cobra.ExactArgs(1),
+		RunE:    handler,
+	}
+
+	return reactivateCmd
+}
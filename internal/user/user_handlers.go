@@ -1,10 +1,19 @@
 package user
 
 import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
 	"github.com/nixpig/syringe.sh/pkg"
 	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
 )
@@ -21,17 +30,233 @@ func NewHandlerUserRegister(userService UserService) pkg.CobraHandler {
 			return fmt.Errorf("unable to get public key from context")
 		}
 
-		user, err := userService.RegisterUser(RegisterUserRequest{
+		email, _ := cmd.Flags().GetString("email")
+
+		user, err := userService.RegisterUser(cmd.Context(), RegisterUserRequest{
 			Username:  username,
-			Email:     "not_used_yet@example.org",
+			Email:     email,
 			PublicKey: publicKey,
 		})
-		if err != nil {
+		switch {
+		case errors.Is(err, serrors.ErrUsernameTaken):
+			return fmt.Errorf(
+				"unable to register user: username '%s' is already taken, connect with a different username to register",
+				username,
+			)
+		case errors.Is(err, serrors.ErrKeyRegistered):
+			return fmt.Errorf(
+				"unable to register user: public key is already registered to another user, connect with a different key to register as '%s'",
+				username,
+			)
+		case err != nil:
 			return fmt.Errorf("unable to register user: %w", err)
 		}
 
-		cmd.Print(user)
+		if user.Status == StatusActive {
+			cmd.Println(fmt.Sprintf("User '%s' is already registered", user.Username))
+			return nil
+		}
+
+		cmd.Println(fmt.Sprintf(
+			"User '%s' registered. A verification code has been sent to '%s', run 'syringe user verify CODE' with it to verify your email address.",
+			user.Username,
+			user.Email,
+		))
+
+		return nil
+	}
+}
+
+func NewHandlerUserVerify(userService UserService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		username, ok := cmd.Context().Value(ctxkeys.Username).(string)
+		if !ok {
+			return fmt.Errorf("unable to get username from context")
+		}
+
+		if err := userService.VerifyUser(cmd.Context(), VerifyUserRequest{
+			Username: username,
+			Code:     args[0],
+		}); err != nil {
+			if errors.Is(err, serrors.ErrInvalidCode) {
+				return fmt.Errorf(
+					"unable to verify email address: %w (run 'syringe user register --email EMAIL' to be sent a new one)",
+					err,
+				)
+			}
+
+			return fmt.Errorf("unable to verify email address: %w", err)
+		}
+
+		cmd.Println("Email address verified")
+
+		return nil
+	}
+}
+
+func NewHandlerUserSuspend(userService UserService) pkg.CobraHandler {
+	return newHandlerUserStatus(userService, StatusSuspended, "suspend")
+}
+
+func NewHandlerUserLock(userService UserService) pkg.CobraHandler {
+	return newHandlerUserStatus(userService, StatusLocked, "lock")
+}
+
+func NewHandlerUserReactivate(userService UserService) pkg.CobraHandler {
+	return newHandlerUserStatus(userService, StatusActive, "reactivate")
+}
+
+func newHandlerUserStatus(userService UserService, status, action string) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		user, err := userService.SetUserStatus(cmd.Context(), SetUserStatusRequest{
+			Username: args[0],
+			Status:   status,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to %s user: %w", action, err)
+		}
+
+		cmd.Println(fmt.Sprintf("User '%s' is now %s", user.Username, user.Status))
 
 		return nil
 	}
 }
+
+func NewHandlerUserExport(userService UserService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		username, ok := cmd.Context().Value(ctxkeys.Username).(string)
+		if !ok {
+			return fmt.Errorf("unable to get username from context")
+		}
+
+		if err := ownDatabase(cmd); err != nil {
+			return err
+		}
+
+		export, err := userService.ExportUser(cmd.Context(), ExportUserRequest{
+			Username: username,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to export user: %w", err)
+		}
+
+		return writeArchive(cmd.OutOrStdout(), export)
+	}
+}
+
+func NewHandlerUserDelete(userService UserService) pkg.CobraHandler {
+	return func(cmd *cobra.Command, args []string) error {
+		username, ok := cmd.Context().Value(ctxkeys.Username).(string)
+		if !ok {
+			return fmt.Errorf("unable to get username from context")
+		}
+
+		publicKey, ok := cmd.Context().Value(ctxkeys.PublicKey).(ssh.PublicKey)
+		if !ok {
+			return fmt.Errorf("unable to get public key from context")
+		}
+
+		if err := ownDatabase(cmd); err != nil {
+			return err
+		}
+
+		confirmation, _ := cmd.Flags().GetString("confirm")
+		if confirmation == "" {
+			// stdout is kept for the archive, so the prompt goes to stderr
+			cmd.PrintErr("Type your username to confirm deleting your account and all of its data: ")
+
+			line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+
+			confirmation = strings.TrimSpace(line)
+		}
+
+		if confirmation != username {
+			return fmt.Errorf(
+				"confirmation doesn't match username '%s', nothing was deleted (type it when asked, or pass '--confirm %s')",
+				username,
+				username,
+			)
+		}
+
+		// the user's data is exported before anything is deleted, so a failed
+		// export leaves everything as it was
+		export, err := userService.ExportUser(cmd.Context(), ExportUserRequest{
+			Username: username,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to export user, nothing was deleted: %w", err)
+		}
+
+		if err := writeArchive(cmd.OutOrStdout(), export); err != nil {
+			return fmt.Errorf("unable to write export, nothing was deleted: %w", err)
+		}
+
+		if err := userService.DeleteUser(cmd.Context(), DeleteUserRequest{
+			Username:  username,
+			PublicKey: publicKey,
+		}); err != nil {
+			return fmt.Errorf("unable to delete user: %w", err)
+		}
+
+		cmd.PrintErrln(fmt.Sprintf("User '%s' deleted", username))
+
+		return nil
+	}
+}
+
+// ownDatabase checks that the command is working with the user's own
+// database, rather than an organisation's or one shared with them.
+func ownDatabase(cmd *cobra.Command) error {
+	org, _ := cmd.Flags().GetString("org")
+	sharedBy, _ := cmd.Flags().GetString("shared-by")
+
+	if org != "" || sharedBy != "" {
+		return fmt.Errorf("'%s' can't be used with '--org' or '--shared-by'", cmd.CommandPath())
+	}
+
+	return nil
+}
+
+// writeArchive writes an export as a gzipped tarball of JSON files: the user
+// and their keys, and their projects with environments and secrets.
+func writeArchive(w io.Writer, export *ExportUserResponse) error {
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"user.json", export.User},
+		{"projects.json", export.Projects},
+	}
+
+	for _, file := range files {
+		content, err := json.MarshalIndent(file.content, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if err := archive.WriteHeader(&tar.Header{
+			Name:    file.name,
+			Mode:    0600,
+			Size:    int64(len(content)),
+			ModTime: time.Now(),
+		}); err != nil {
+			return err
+		}
+
+		if _, err := archive.Write(content); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
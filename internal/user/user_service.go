@@ -1,16 +1,23 @@
 package user
 
 import (
-	"crypto/sha1"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
-	"os"
-	"slices"
 	"time"
 
 	"github.com/charmbracelet/ssh"
 	"github.com/nixpig/syringe.sh/internal/database"
 	"github.com/nixpig/syringe.sh/internal/secret"
+	"github.com/nixpig/syringe.sh/pkg/mailer"
+	"github.com/nixpig/syringe.sh/pkg/resilience"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
 	"github.com/nixpig/syringe.sh/pkg/turso"
 	"github.com/nixpig/syringe.sh/pkg/validation"
 	gossh "golang.org/x/crypto/ssh"
@@ -18,7 +25,7 @@ import (
 
 type RegisterUserRequest struct {
 	Username  string
-	Email     string
+	Email     string `name:"email" validate:"required,email,max=256"`
 	PublicKey ssh.PublicKey
 }
 
@@ -29,6 +36,22 @@ type RegisterUserResponse struct {
 	CreatedAt    string
 	PublicKey    string
 	DatabaseName string
+	Status       string
+}
+
+type VerifyUserRequest struct {
+	Username string `name:"username" validate:"required"`
+	Code     string `name:"code" validate:"required"`
+}
+
+type SetUserStatusRequest struct {
+	Username string `name:"username" validate:"required"`
+	Status   string `name:"status" validate:"required,oneof=active suspended locked"`
+}
+
+type SetUserStatusResponse struct {
+	Username string
+	Status   string
 }
 
 type AddPublicKeyRequest struct {
@@ -44,26 +67,86 @@ type AddPublicKeyResponse struct {
 }
 
 type CreateDatabaseRequest struct {
-	Name          string
-	UserID        int
-	DatabaseGroup string
-	DatabaseOrg   string
+	Name   string
+	UserID int
 }
 
 type CreateDatabaseResponse struct {
 	Name     string
 	HostName string
+	// Created is false if the database already existed.
+	Created bool
+}
+
+type DeleteDatabaseRequest struct {
+	Name string
+}
+
+type ExportUserRequest struct {
+	Username string `name:"username" validate:"required"`
+}
+
+// ExportUserResponse is everything held about a user. Their secrets are as
+// stored, so still encrypted with their key.
+type ExportUserResponse struct {
+	User     ExportedUser  `json:"user"`
+	Projects []ProjectData `json:"projects"`
 }
 
+type ExportedUser struct {
+	Username  string        `json:"username"`
+	Email     string        `json:"email"`
+	CreatedAt string        `json:"created_at"`
+	Keys      []ExportedKey `json:"keys"`
+}
+
+type ExportedKey struct {
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type DeleteUserRequest struct {
+	Username  string `name:"username" validate:"required"`
+	PublicKey ssh.PublicKey
+}
+
+// TursoAPISettings are how databases are created in, and connected to, the
+// Turso organisation.
 type TursoAPISettings struct {
 	URL   string
 	Token string
+	Retry *resilience.Policy
+	// Organization is the Turso organisation databases are created in.
+	Organization string
+	// Group is the group in the organisation databases are created in.
+	Group string
+	// DatabaseURL makes the connection URL for a database host. It defaults
+	// to database.URL.
+	DatabaseURL func(hostName string) string
 }
 
+// databaseReadyTimeout is how long to wait for a new database to become
+// reachable.
+const databaseReadyTimeout = 60 * time.Second
+
+const (
+	// verificationExpiry is how long a verification code can be used for.
+	verificationExpiry = time.Hour
+	// maxVerificationAttempts is how many wrong codes can be tried before a
+	// new one has to be sent.
+	maxVerificationAttempts = 5
+)
+
 type UserService interface {
-	RegisterUser(user RegisterUserRequest) (*RegisterUserResponse, error)
-	AddPublicKey(publicKey AddPublicKeyRequest) (*AddPublicKeyResponse, error)
-	CreateDatabase(databaseDetails CreateDatabaseRequest) (*CreateDatabaseResponse, error)
+	RegisterUser(ctx context.Context, user RegisterUserRequest) (*RegisterUserResponse, error)
+	AddPublicKey(ctx context.Context, publicKey AddPublicKeyRequest) (*AddPublicKeyResponse, error)
+	CreateDatabase(ctx context.Context, databaseDetails CreateDatabaseRequest) (*CreateDatabaseResponse, error)
+	DeleteDatabase(ctx context.Context, databaseDetails DeleteDatabaseRequest) error
+	VerifyUser(ctx context.Context, verifyDetails VerifyUserRequest) error
+	SetUserStatus(ctx context.Context, statusDetails SetUserStatusRequest) (*SetUserStatusResponse, error)
+	ExportUser(ctx context.Context, exportDetails ExportUserRequest) (*ExportUserResponse, error)
+	DeleteUser(ctx context.Context, deleteDetails DeleteUserRequest) error
 }
 
 type UserServiceImpl struct {
@@ -71,6 +154,46 @@ type UserServiceImpl struct {
 	validate         validation.Validator
 	httpClient       http.Client
 	tursoAPISettings TursoAPISettings
+	connect          func(ctx context.Context, databaseURL, token string) (*sql.DB, error)
+	data             DataStore
+	mailer           mailer.Mailer
+	connections      *database.ConnectionManager
+}
+
+type Option func(u *UserServiceImpl)
+
+// WithConnector sets how connections to new databases are opened, e.g. to
+// avoid connecting to a real database in tests. It defaults to
+// database.Connection.
+func WithConnector(
+	connect func(ctx context.Context, databaseURL, token string) (*sql.DB, error),
+) Option {
+	return func(u *UserServiceImpl) {
+		u.connect = connect
+	}
+}
+
+// WithDataStore sets the store for the user's own database, which exporting
+// a user reads from.
+func WithDataStore(data DataStore) Option {
+	return func(u *UserServiceImpl) {
+		u.data = data
+	}
+}
+
+// WithMailer sets the mailer verification codes are sent with.
+func WithMailer(m mailer.Mailer) Option {
+	return func(u *UserServiceImpl) {
+		u.mailer = m
+	}
+}
+
+// WithConnections drops pooled connections to databases once they're
+// deleted.
+func WithConnections(connections *database.ConnectionManager) Option {
+	return func(u *UserServiceImpl) {
+		u.connections = connections
+	}
 }
 
 func NewUserServiceImpl(
@@ -78,70 +201,385 @@ func NewUserServiceImpl(
 	validate validation.Validator,
 	httpClient http.Client,
 	tursoAPISettings TursoAPISettings,
+	options ...Option,
 ) UserServiceImpl {
-	return UserServiceImpl{
+	u := UserServiceImpl{
 		store:            store,
 		validate:         validate,
 		httpClient:       httpClient,
 		tursoAPISettings: tursoAPISettings,
+		connect:          database.Connection,
 	}
+
+	for _, option := range options {
+		option(&u)
+	}
+
+	return u
 }
 
+// RegisterUser registers a user, their public key and their database, then
+// sends them a code to verify their email address with. The user is
+// creating until every step has succeeded, and anything done is undone if a
+// step fails, so it can safely be run again. Running it again for a user
+// whose registration was interrupted before it could be undone picks up
+// where it left off, for a user who hasn't verified their email address
+// sends them a new code, and for a verified user returns their
+// registration. Usernames are unique, as are keys, so it fails with
+// serrors.ErrUsernameTaken or serrors.ErrKeyRegistered if either belongs to
+// someone else.
 func (u UserServiceImpl) RegisterUser(
+	ctx context.Context,
 	user RegisterUserRequest,
 ) (*RegisterUserResponse, error) {
 	if err := u.validate.Struct(user); err != nil {
+		return nil, serrors.ValidationError(err)
+	}
+
+	// certificates are short-lived, so authenticate users who've registered
+	// a key rather than being registered themselves
+	if _, ok := user.PublicKey.(*gossh.Certificate); ok {
+		return nil, serrors.ErrCertificateRegistration
+	}
+
+	marshalledKey := string(gossh.MarshalAuthorizedKey(user.PublicKey))
+	fingerprint := gossh.FingerprintSHA256(user.PublicKey)
+
+	registeredUser, registeredKey, err := u.store.GetUserByKeyFingerprint(ctx, fingerprint)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return nil, err
 	}
 
-	insertedUser, err := u.store.InsertUser(
-		user.Username,
-		user.Email,
-		"active",
-	)
+	if registeredUser != nil && registeredUser.Username != user.Username {
+		return nil, serrors.ErrKeyRegistered
+	}
+
+	if registeredUser != nil {
+		if err := StatusError(registeredUser.Status); err != nil {
+			return nil, err
+		}
+	}
+
+	if registeredUser == nil {
+		registeredUser, registeredKey, err = u.store.InsertNewUser(
+			ctx,
+			user.Username,
+			user.Email,
+			marshalledKey,
+			fingerprint,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	databaseName := database.UserDBName(user.PublicKey)
+
+	if registeredUser.Status == StatusCreating {
+		createdDatabase, err := u.CreateDatabase(ctx, CreateDatabaseRequest{
+			Name:   databaseName,
+			UserID: registeredUser.ID,
+		})
+		if err != nil {
+			return nil, u.undoRegistration(ctx, registeredUser.ID, nil, err)
+		}
+
+		if err := u.store.SetUserStatus(ctx, registeredUser.ID, StatusPending); err != nil {
+			return nil, u.undoRegistration(ctx, registeredUser.ID, createdDatabase, err)
+		}
+
+		registeredUser.Status = StatusPending
+	}
+
+	if registeredUser.Status == StatusPending {
+		// the email address can be corrected by registering again
+		if registeredUser.Email != user.Email {
+			if err := u.store.SetUserEmail(ctx, registeredUser.ID, user.Email); err != nil {
+				return nil, err
+			}
+
+			registeredUser.Email = user.Email
+		}
+
+		if err := u.sendVerification(ctx, registeredUser); err != nil {
+			return nil, fmt.Errorf(
+				"registered, but unable to send verification code (register again to resend it): %w",
+				err,
+			)
+		}
+	}
+
+	return &RegisterUserResponse{
+		ID:           registeredUser.ID,
+		Username:     registeredUser.Username,
+		Email:        registeredUser.Email,
+		CreatedAt:    registeredUser.CreatedAt,
+		PublicKey:    registeredKey.PublicKey,
+		DatabaseName: databaseName,
+		Status:       registeredUser.Status,
+	}, nil
+}
+
+// sendVerification emails a user a new code to verify their email address
+// with, replacing any they were sent before.
+func (u UserServiceImpl) sendVerification(ctx context.Context, user *User) error {
+	if u.mailer == nil {
+		return errors.New("no mailer configured")
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	marshalledKey := gossh.MarshalAuthorizedKey(user.PublicKey)
+	code := fmt.Sprintf("%06d", n)
+
+	if err := u.store.SetVerification(ctx, Verification{
+		UserID:    user.ID,
+		CodeHash:  hashCode(code),
+		ExpiresAt: time.Now().Add(verificationExpiry).UTC().Format(time.RFC3339),
+	}); err != nil {
+		return err
+	}
 
-	insertedKey, err := u.AddPublicKey(AddPublicKeyRequest{
-		UserID:    insertedUser.ID,
-		PublicKey: string(marshalledKey),
+	return u.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your syringe.sh email address",
+		Body: fmt.Sprintf(
+			"Your verification code is %s\n\nRun 'syringe user verify %s' to verify your email address. The code expires in %s.",
+			code,
+			code,
+			verificationExpiry,
+		),
 	})
+}
+
+// VerifyUser verifies a user's email address with the code they were sent,
+// making them active. It fails with serrors.ErrInvalidCode if the code is
+// wrong or has expired, and after too many wrong codes until a new one is
+// sent.
+func (u UserServiceImpl) VerifyUser(
+	ctx context.Context,
+	verifyDetails VerifyUserRequest,
+) error {
+	if err := u.validate.Struct(verifyDetails); err != nil {
+		return serrors.ValidationError(err)
+	}
+
+	user, err := u.store.GetUser(ctx, verifyDetails.Username)
+	if err != nil {
+		return err
+	}
+
+	if user.Status == StatusActive {
+		return nil
+	}
+
+	verification, err := u.store.GetVerification(ctx, user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return serrors.ErrInvalidCode
+	}
+	if err != nil {
+		return err
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, verification.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	if verification.Attempts >= maxVerificationAttempts || time.Now().After(expiresAt) {
+		return serrors.ErrInvalidCode
+	}
+
+	if subtle.ConstantTimeCompare(
+		[]byte(hashCode(verifyDetails.Code)),
+		[]byte(verification.CodeHash),
+	) != 1 {
+		if err := u.store.AddVerificationAttempt(ctx, user.ID); err != nil {
+			return err
+		}
+
+		return serrors.ErrInvalidCode
+	}
+
+	return u.store.VerifyUser(ctx, user.ID)
+}
+
+// SetUserStatus suspends, locks or reactivates a user. Reactivating a user
+// who's yet to verify their email address leaves them pending, and users
+// whose registration hasn't finished can't be changed, since they'd only
+// need to register again to carry on. It fails with serrors.ErrUserNotFound
+// if there's no such user.
+func (u UserServiceImpl) SetUserStatus(
+	ctx context.Context,
+	statusDetails SetUserStatusRequest,
+) (*SetUserStatusResponse, error) {
+	if err := u.validate.Struct(statusDetails); err != nil {
+		return nil, serrors.ValidationError(err)
+	}
+
+	user, err := u.store.GetUser(ctx, statusDetails.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, serrors.ErrUserNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	insertedDatabase, err := u.CreateDatabase(
-		CreateDatabaseRequest{
-			Name:          fmt.Sprintf("%x", sha1.Sum(marshalledKey)),
-			UserID:        insertedUser.ID,
-			DatabaseOrg:   os.Getenv("DATABASE_ORG"),
-			DatabaseGroup: os.Getenv("DATABASE_GROUP"),
-		})
+	if user.Status == StatusCreating {
+		return nil, fmt.Errorf("user '%s' hasn't finished registering", user.Username)
+	}
+
+	status := statusDetails.Status
+
+	if status == StatusActive {
+		// a code is only outstanding until the email address is verified
+		_, err := u.store.GetVerification(ctx, user.ID)
+		if err == nil {
+			status = StatusPending
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	if err := u.store.SetUserStatus(ctx, user.ID, status); err != nil {
+		return nil, err
+	}
+
+	return &SetUserStatusResponse{
+		Username: user.Username,
+		Status:   status,
+	}, nil
+}
+
+func hashCode(code string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(code)))
+}
+
+// undoRegistration deletes what a failed registration created, returning the
+// error it failed with along with any from undoing it. It carries on if the
+// session ends, so as not to leave the registration half done.
+func (u UserServiceImpl) undoRegistration(
+	ctx context.Context,
+	userID int,
+	createdDatabase *CreateDatabaseResponse,
+	err error,
+) error {
+	ctx = context.WithoutCancel(ctx)
+
+	errs := []error{err}
+
+	if createdDatabase != nil && createdDatabase.Created {
+		errs = append(errs, u.DeleteDatabase(ctx, DeleteDatabaseRequest{
+			Name: createdDatabase.Name,
+		}))
+	}
+
+	errs = append(errs, u.store.DeleteUser(ctx, userID))
+
+	return errors.Join(errs...)
+}
+
+// ExportUser gets everything held about a user, from the app database and
+// their own.
+func (u UserServiceImpl) ExportUser(
+	ctx context.Context,
+	exportDetails ExportUserRequest,
+) (*ExportUserResponse, error) {
+	if err := u.validate.Struct(exportDetails); err != nil {
+		return nil, err
+	}
+
+	if u.data == nil {
+		return nil, errors.New("user database not connected")
+	}
+
+	user, err := u.store.GetUser(ctx, exportDetails.Username)
 	if err != nil {
 		return nil, err
 	}
 
-	return &RegisterUserResponse{
-		ID:           insertedUser.ID,
-		Username:     insertedUser.Username,
-		Email:        insertedUser.Email,
-		CreatedAt:    insertedUser.CreatedAt,
-		PublicKey:    insertedKey.PublicKey,
-		DatabaseName: insertedDatabase.Name,
+	keys, err := u.store.GetUserKeys(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	projects, err := u.data.GetProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exportedKeys := make([]ExportedKey, len(keys))
+	for i, key := range keys {
+		exportedKeys[i] = ExportedKey{
+			PublicKey:   key.PublicKey,
+			Fingerprint: key.Fingerprint,
+			CreatedAt:   key.CreatedAt,
+		}
+	}
+
+	return &ExportUserResponse{
+		User: ExportedUser{
+			Username:  user.Username,
+			Email:     user.Email,
+			CreatedAt: user.CreatedAt,
+			Keys:      exportedKeys,
+		},
+		Projects: projects,
 	}, nil
 }
 
+// DeleteUser deletes a user, their keys and their database, along with any
+// pooled connection to it. The user is deleted first, so that if deleting
+// the database fails it's left orphaned rather than leaving the user unable
+// to connect to it or try again.
+func (u UserServiceImpl) DeleteUser(
+	ctx context.Context,
+	deleteDetails DeleteUserRequest,
+) error {
+	if err := u.validate.Struct(deleteDetails); err != nil {
+		return err
+	}
+
+	user, err := u.store.GetUser(ctx, deleteDetails.Username)
+	if err != nil {
+		return err
+	}
+
+	if err := u.store.DeleteUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	// carries on if the session ends, since the user is already gone
+	if err := u.DeleteDatabase(context.WithoutCancel(ctx), DeleteDatabaseRequest{
+		Name: database.UserDBName(deleteDetails.PublicKey),
+	}); err != nil && !errors.As(err, &turso.ErrNotFound{}) {
+		return fmt.Errorf("user deleted, but failed to delete database: %w", err)
+	}
+
+	return nil
+}
+
 func (u UserServiceImpl) AddPublicKey(
+	ctx context.Context,
 	addKeyDetails AddPublicKeyRequest,
 ) (*AddPublicKeyResponse, error) {
 	if err := u.validate.Struct(addKeyDetails); err != nil {
 		return nil, err
 	}
 
-	addedKeyDetails, err := u.store.InsertKey(addKeyDetails.UserID, addKeyDetails.PublicKey)
+	publicKey, _, _, _, err := gossh.ParseAuthorizedKey([]byte(addKeyDetails.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	addedKeyDetails, err := u.store.InsertKey(
+		ctx,
+		addKeyDetails.UserID,
+		addKeyDetails.PublicKey,
+		gossh.FingerprintSHA256(publicKey),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -155,63 +593,119 @@ func (u UserServiceImpl) AddPublicKey(
 }
 
 func (u UserServiceImpl) CreateDatabase(
+	ctx context.Context,
 	databaseDetails CreateDatabaseRequest,
 ) (*CreateDatabaseResponse, error) {
 	if err := u.validate.Struct(databaseDetails); err != nil {
 		return nil, err
 	}
 
-	api := turso.New(databaseDetails.DatabaseOrg, u.tursoAPISettings.Token, u.httpClient)
+	api := u.tursoAPI()
+
+	created := true
 
-	list, err := api.ListDatabases()
+	createdDatabaseDetails, err := api.CreateDatabase(ctx, databaseDetails.Name, u.tursoAPISettings.Group)
+	if errors.As(err, &turso.ErrConflict{}) {
+		// left behind by an earlier attempt, so is reused rather than failed on
+		created = false
+		createdDatabaseDetails, err = api.RetrieveDatabase(ctx, databaseDetails.Name)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	exists := slices.IndexFunc(list.Databases, func(db turso.TursoDatabase) bool {
-		return db.Name == databaseDetails.Name
-	})
+	if err := u.createTables(ctx, api, createdDatabaseDetails.Database); err != nil {
+		if created {
+			deleteErr := api.DeleteDatabase(context.WithoutCancel(ctx), createdDatabaseDetails.Database.Name)
+			return nil, errors.Join(err, deleteErr)
+		}
 
-	if exists != -1 {
-		return nil, fmt.Errorf("database already exists in returned list")
+		return nil, err
 	}
 
-	createdDatabaseDetails, err := api.CreateDatabase(databaseDetails.Name, databaseDetails.DatabaseGroup)
-	if err != nil {
-		return nil, err
+	return &CreateDatabaseResponse{
+		Name:     createdDatabaseDetails.Database.Name,
+		HostName: createdDatabaseDetails.Database.HostName,
+		Created:  created,
+	}, nil
+}
+
+func (u UserServiceImpl) DeleteDatabase(
+	ctx context.Context,
+	databaseDetails DeleteDatabaseRequest,
+) error {
+	if err := u.validate.Struct(databaseDetails); err != nil {
+		return err
 	}
 
-	createdToken, err := api.CreateToken(createdDatabaseDetails.Database.Name, "5m")
-	if err != nil {
-		return nil, err
+	api := u.tursoAPI()
+
+	err := api.DeleteDatabase(ctx, databaseDetails.Name)
+	if u.connections != nil && (err == nil || errors.As(err, &turso.ErrNotFound{})) {
+		u.connections.Invalidate(databaseDetails.Name)
 	}
 
-	userDB, err := database.Connection(
-		"libsql://"+createdDatabaseDetails.Database.HostName,
-		createdToken.Jwt,
-	)
+	return err
+}
+
+func (u UserServiceImpl) tursoAPI() *turso.TursoClient {
+	options := []turso.Option{turso.WithBaseURL(u.tursoAPISettings.URL)}
+	if u.tursoAPISettings.Retry != nil {
+		options = append(options, turso.WithRetry(*u.tursoAPISettings.Retry))
+	}
+
+	api := turso.New(u.tursoAPISettings.Organization, u.tursoAPISettings.Token, u.httpClient, options...)
+
+	return &api
+}
+
+func (u UserServiceImpl) createTables(
+	ctx context.Context,
+	api *turso.TursoClient,
+	userDatabase turso.TursoDatabase,
+) error {
+	createdToken, err := api.CreateToken(ctx, userDatabase.Name, "5m")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	envStore := secret.NewSqliteSecretStore(userDB)
-	envService := secret.NewSecretServiceImpl(envStore, validation.New())
+	// a new database takes a while to become reachable, and until it is fails
+	// in ways that wouldn't otherwise be worth retrying
+	readyCtx, cancel := context.WithTimeout(ctx, databaseReadyTimeout)
+	defer cancel()
+
+	readyPolicy := resilience.Policy{
+		InitialDelay: time.Second,
+		MaxDelay:     5 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.2,
+		Retryable:    func(err error) bool { return true },
+	}
 
-	var count time.Duration
-	increment := time.Second * 5
-	timeout := time.Second * 60
-	for err := envService.CreateTables(); err != nil; err = envService.CreateTables() {
-		time.Sleep(increment)
-		count = count + increment
-		if count >= timeout {
-			return nil, fmt.Errorf(
-				fmt.Sprintf(
-					"timed out after %d seconds trying to create tables",
-					timeout/time.Second,
-				),
-			)
+	databaseURL := database.URL
+	if u.tursoAPISettings.DatabaseURL != nil {
+		databaseURL = u.tursoAPISettings.DatabaseURL
+	}
+
+	if err := resilience.Retry(readyCtx, readyPolicy, func(ctx context.Context) error {
+		userDB, err := u.connect(
+			ctx,
+			databaseURL(userDatabase.HostName),
+			createdToken.Jwt,
+		)
+		if err != nil {
+			return err
 		}
+
+		defer userDB.Close()
+
+		return secret.NewSecretServiceImpl(
+			secret.NewSqliteSecretStore(userDB),
+			validation.New(),
+		).CreateTables(ctx)
+	}); err != nil {
+		return fmt.Errorf("failed to create tables: %w", err)
 	}
 
-	return &CreateDatabaseResponse{Name: createdDatabaseDetails.Database.Name}, nil
+	return nil
 }
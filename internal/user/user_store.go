@@ -1,6 +1,41 @@
 package user
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+)
+
+const (
+	// StatusCreating is the status of a user whose registration hasn't
+	// finished, e.g. because their database couldn't be created.
+	StatusCreating = "creating"
+	// StatusPending is the status of a registered user who hasn't yet verified
+	// their email address.
+	StatusPending = "pending"
+	StatusActive  = "active"
+	// StatusSuspended is the status of a user an operator has cut off, e.g.
+	// for abusing the service.
+	StatusSuspended = "suspended"
+	// StatusLocked is the status of a user an operator has cut off because
+	// their account may have been compromised.
+	StatusLocked = "locked"
+)
+
+// StatusError is the error a user with a status that doesn't allow them to
+// connect gets, or nil if it does.
+func StatusError(status string) error {
+	switch status {
+	case StatusSuspended:
+		return serrors.ErrAccountSuspended
+	case StatusLocked:
+		return serrors.ErrAccountLocked
+	}
+
+	return nil
+}
 
 type User struct {
 	ID        int
@@ -11,15 +46,36 @@ type User struct {
 }
 
 type Key struct {
-	ID        int
-	PublicKey string
+	ID          int
+	PublicKey   string
+	Fingerprint string
+	UserID      int
+	CreatedAt   string
+}
+
+// Verification is a code sent to a user to verify their email address, kept
+// hashed.
+type Verification struct {
 	UserID    int
-	CreatedAt string
+	CodeHash  string
+	ExpiresAt string
+	Attempts  int
 }
 
 type UserStore interface {
-	InsertUser(username, email, status string) (*User, error)
-	InsertKey(userID int, publicKey string) (*Key, error)
+	InsertUser(ctx context.Context, username, email, status string) (*User, error)
+	InsertKey(ctx context.Context, userID int, publicKey, fingerprint string) (*Key, error)
+	InsertNewUser(ctx context.Context, username, email, publicKey, fingerprint string) (*User, *Key, error)
+	GetUserByKeyFingerprint(ctx context.Context, fingerprint string) (*User, *Key, error)
+	GetUser(ctx context.Context, username string) (*User, error)
+	GetUserKeys(ctx context.Context, userID int) ([]Key, error)
+	SetUserStatus(ctx context.Context, userID int, status string) error
+	SetUserEmail(ctx context.Context, userID int, email string) error
+	SetVerification(ctx context.Context, verification Verification) error
+	GetVerification(ctx context.Context, userID int) (*Verification, error)
+	AddVerificationAttempt(ctx context.Context, userID int) error
+	VerifyUser(ctx context.Context, userID int) error
+	DeleteUser(ctx context.Context, userID int) error
 }
 
 type SqliteUserStore struct {
@@ -30,14 +86,15 @@ func NewSqliteUserStore(db *sql.DB) SqliteUserStore {
 	return SqliteUserStore{db}
 }
 
-func (s SqliteUserStore) InsertUser(username, email, status string) (*User, error) {
+func (s SqliteUserStore) InsertUser(ctx context.Context, username, email, status string) (*User, error) {
 	query := `
 		insert into users_ (username_, email_, status_) 
 		values ($username, $email, $status) 
 		returning id_, username_, email_, status_, created_at_
 	`
 
-	row := s.db.QueryRow(
+	row := s.db.QueryRowContext(
+		ctx,
 		query,
 		sql.Named("username", username),
 		sql.Named("email", email),
@@ -53,23 +110,25 @@ func (s SqliteUserStore) InsertUser(username, email, status string) (*User, erro
 		&insertedUser.Status,
 		&insertedUser.CreatedAt,
 	); err != nil {
-		return nil, err
+		return nil, uniqueErr(err)
 	}
 
 	return &insertedUser, nil
 }
 
-func (s SqliteUserStore) InsertKey(userID int, publicKey string) (*Key, error) {
+func (s SqliteUserStore) InsertKey(ctx context.Context, userID int, publicKey, fingerprint string) (*Key, error) {
 	query := `
-	insert into keys_ (user_id_, ssh_public_key_)
-	values ($userID, $publicKey)
-	returning id_, user_id_, ssh_public_key_, created_at_
+	insert into keys_ (user_id_, ssh_public_key_, fingerprint_)
+	values ($userID, $publicKey, $fingerprint)
+	returning id_, user_id_, ssh_public_key_, fingerprint_, created_at_
 	`
 
-	row := s.db.QueryRow(
+	row := s.db.QueryRowContext(
+		ctx,
 		query,
 		sql.Named("userID", userID),
 		sql.Named("publicKey", publicKey),
+		sql.Named("fingerprint", fingerprint),
 	)
 
 	var insertedKey Key
@@ -78,10 +137,516 @@ func (s SqliteUserStore) InsertKey(userID int, publicKey string) (*Key, error) {
 		&insertedKey.ID,
 		&insertedKey.UserID,
 		&insertedKey.PublicKey,
+		&insertedKey.Fingerprint,
 		&insertedKey.CreatedAt,
 	); err != nil {
-		return nil, err
+		return nil, uniqueErr(err)
 	}
 
 	return &insertedKey, nil
 }
+
+// InsertNewUser inserts a user with the creating status together with their
+// public key, so that neither is left without the other.
+func (s SqliteUserStore) InsertNewUser(
+	ctx context.Context,
+	username, email, publicKey, fingerprint string,
+) (*User, *Key, error) {
+	userQuery := `
+		insert into users_ (username_, email_, status_)
+		values ($username, $email, $status)
+		returning id_, username_, email_, status_, created_at_
+	`
+
+	keyQuery := `
+		insert into keys_ (user_id_, ssh_public_key_, fingerprint_)
+		values ($userID, $publicKey, $fingerprint)
+		returning id_, user_id_, ssh_public_key_, fingerprint_, created_at_
+	`
+
+	trx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, serrors.ErrDatabaseExec(err)
+	}
+
+	defer trx.Rollback()
+
+	var insertedUser User
+
+	if err := trx.QueryRowContext(
+		ctx,
+		userQuery,
+		sql.Named("username", username),
+		sql.Named("email", email),
+		sql.Named("status", StatusCreating),
+	).Scan(
+		&insertedUser.ID,
+		&insertedUser.Username,
+		&insertedUser.Email,
+		&insertedUser.Status,
+		&insertedUser.CreatedAt,
+	); err != nil {
+		return nil, nil, insertErr(err)
+	}
+
+	var insertedKey Key
+
+	if err := trx.QueryRowContext(
+		ctx,
+		keyQuery,
+		sql.Named("userID", insertedUser.ID),
+		sql.Named("publicKey", publicKey),
+		sql.Named("fingerprint", fingerprint),
+	).Scan(
+		&insertedKey.ID,
+		&insertedKey.UserID,
+		&insertedKey.PublicKey,
+		&insertedKey.Fingerprint,
+		&insertedKey.CreatedAt,
+	); err != nil {
+		return nil, nil, insertErr(err)
+	}
+
+	if err := trx.Commit(); err != nil {
+		return nil, nil, serrors.ErrDatabaseExec(err)
+	}
+
+	return &insertedUser, &insertedKey, nil
+}
+
+// GetUserByKeyFingerprint gets the user a public key is registered to, by
+// the key's SHA256 fingerprint. It returns sql.ErrNoRows if there isn't one.
+func (s SqliteUserStore) GetUserByKeyFingerprint(ctx context.Context, fingerprint string) (*User, *Key, error) {
+	query := `
+		select u.id_, u.username_, u.email_, u.status_, u.created_at_,
+		k.id_, k.user_id_, k.ssh_public_key_, k.fingerprint_, k.created_at_
+		from users_ u
+		inner join
+		keys_ k
+		on k.user_id_ = u.id_
+		where k.fingerprint_ = $fingerprint
+	`
+
+	var user User
+	var key Key
+
+	if err := s.db.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("fingerprint", fingerprint),
+	).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Status,
+		&user.CreatedAt,
+		&key.ID,
+		&key.UserID,
+		&key.PublicKey,
+		&key.Fingerprint,
+		&key.CreatedAt,
+	); err != nil {
+		return nil, nil, err
+	}
+
+	return &user, &key, nil
+}
+
+func (s SqliteUserStore) GetUser(ctx context.Context, username string) (*User, error) {
+	query := `
+		select id_, username_, email_, status_, created_at_
+		from users_
+		where username_ = $username
+	`
+
+	var user User
+
+	if err := s.db.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("username", username),
+	).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Status,
+		&user.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s SqliteUserStore) GetUserKeys(ctx context.Context, userID int) ([]Key, error) {
+	query := `
+		select id_, user_id_, ssh_public_key_, fingerprint_, created_at_
+		from keys_
+		where user_id_ = $userID
+		order by id_
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, sql.Named("userID", userID))
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	defer rows.Close()
+
+	var keys []Key
+
+	for rows.Next() {
+		var key Key
+
+		if err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.PublicKey,
+			&key.Fingerprint,
+			&key.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return keys, nil
+}
+
+func (s SqliteUserStore) SetUserStatus(ctx context.Context, userID int, status string) error {
+	query := `
+		update users_ set status_ = $status where id_ = $userID
+	`
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		query,
+		sql.Named("status", status),
+		sql.Named("userID", userID),
+	); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+func (s SqliteUserStore) SetUserEmail(ctx context.Context, userID int, email string) error {
+	query := `
+		update users_ set email_ = $email where id_ = $userID
+	`
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		query,
+		sql.Named("email", email),
+		sql.Named("userID", userID),
+	); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+// SetVerification sets the code a user has been sent, replacing any they
+// were sent before.
+func (s SqliteUserStore) SetVerification(ctx context.Context, verification Verification) error {
+	query := `
+		insert into verifications_ (user_id_, code_hash_, expires_at_)
+		values ($userID, $codeHash, $expiresAt)
+		on conflict (user_id_) do update
+		set code_hash_ = excluded.code_hash_,
+		expires_at_ = excluded.expires_at_,
+		attempts_ = 0,
+		created_at_ = current_timestamp
+	`
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		query,
+		sql.Named("userID", verification.UserID),
+		sql.Named("codeHash", verification.CodeHash),
+		sql.Named("expiresAt", verification.ExpiresAt),
+	); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+// GetVerification gets the code a user has been sent. It returns
+// sql.ErrNoRows if there isn't one.
+func (s SqliteUserStore) GetVerification(ctx context.Context, userID int) (*Verification, error) {
+	query := `
+		select user_id_, code_hash_, expires_at_, attempts_
+		from verifications_
+		where user_id_ = $userID
+	`
+
+	var verification Verification
+
+	if err := s.db.QueryRowContext(
+		ctx,
+		query,
+		sql.Named("userID", userID),
+	).Scan(
+		&verification.UserID,
+		&verification.CodeHash,
+		&verification.ExpiresAt,
+		&verification.Attempts,
+	); err != nil {
+		return nil, err
+	}
+
+	return &verification, nil
+}
+
+func (s SqliteUserStore) AddVerificationAttempt(ctx context.Context, userID int) error {
+	query := `
+		update verifications_ set attempts_ = attempts_ + 1 where user_id_ = $userID
+	`
+
+	if _, err := s.db.ExecContext(ctx, query, sql.Named("userID", userID)); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+// VerifyUser makes a user active and deletes the code they were sent.
+func (s SqliteUserStore) VerifyUser(ctx context.Context, userID int) error {
+	verificationQuery := `
+		delete from verifications_ where user_id_ = $userID
+	`
+
+	userQuery := `
+		update users_ set status_ = $status where id_ = $userID
+	`
+
+	trx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	defer trx.Rollback()
+
+	if _, err := trx.ExecContext(ctx, verificationQuery, sql.Named("userID", userID)); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	if _, err := trx.ExecContext(
+		ctx,
+		userQuery,
+		sql.Named("status", StatusActive),
+		sql.Named("userID", userID),
+	); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	if err := trx.Commit(); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+// DeleteUser deletes a user together with their public keys, any code they
+// were sent, their organisation memberships and roles, and the environments
+// they've shared or been shared. Users who own an organisation can't be
+// deleted, since it would be left without an owner.
+func (s SqliteUserStore) DeleteUser(ctx context.Context, userID int) error {
+	ownedOrgsQuery := `
+		select count(*) from org_members_
+		where user_id_ = $userID
+		and role_ = 'owner'
+	`
+
+	queries := []string{
+		`delete from verifications_ where user_id_ = $userID`,
+		`delete from keys_ where user_id_ = $userID`,
+		`delete from roles_ where user_id_ = $userID`,
+		`delete from org_members_ where user_id_ = $userID`,
+		`delete from shares_ where owner_id_ = $userID or recipient_id_ = $userID`,
+		`delete from users_ where id_ = $userID`,
+	}
+
+	trx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	defer trx.Rollback()
+
+	var ownedOrgs int
+
+	if err := trx.QueryRowContext(
+		ctx,
+		ownedOrgsQuery,
+		sql.Named("userID", userID),
+	).Scan(&ownedOrgs); err != nil {
+		return serrors.ErrDatabaseQuery(err)
+	}
+
+	if ownedOrgs > 0 {
+		return serrors.ErrOrgOwnerDeletion
+	}
+
+	for _, query := range queries {
+		if _, err := trx.ExecContext(ctx, query, sql.Named("userID", userID)); err != nil {
+			return serrors.ErrDatabaseExec(err)
+		}
+	}
+
+	if err := trx.Commit(); err != nil {
+		return serrors.ErrDatabaseExec(err)
+	}
+
+	return nil
+}
+
+type ProjectData struct {
+	Name         string            `json:"name"`
+	Environments []EnvironmentData `json:"environments"`
+}
+
+type EnvironmentData struct {
+	Name      string       `json:"name"`
+	OnExpired string       `json:"on_expired"`
+	Secrets   []SecretData `json:"secrets"`
+}
+
+// SecretData is a secret as it's stored, so its value is still encrypted
+// with the user's key.
+type SecretData struct {
+	Key         string  `json:"key"`
+	Value       string  `json:"value"`
+	ExpiresAt   *string `json:"expires_at,omitempty"`
+	RotateEvery *string `json:"rotate_every,omitempty"`
+	UpdatedAt   string  `json:"updated_at"`
+}
+
+// DataStore reads everything in a user's own database.
+type DataStore interface {
+	GetProjects(ctx context.Context) ([]ProjectData, error)
+}
+
+type SqliteDataStore struct {
+	db *sql.DB
+}
+
+func NewSqliteDataStore(db *sql.DB) SqliteDataStore {
+	return SqliteDataStore{db}
+}
+
+// GetProjects gets every project in the database, with their environments
+// and secrets.
+func (s SqliteDataStore) GetProjects(ctx context.Context) ([]ProjectData, error) {
+	query := `
+		select p.name_, e.name_, e.on_expired_,
+		s.key_, s.value_, s.expires_at_, s.rotate_every_, s.updated_at_
+		from projects_ p
+		left join
+		environments_ e
+		on e.project_id_ = p.id_
+		left join
+		secrets_ s
+		on s.environment_id_ = e.id_
+		order by p.id_, e.id_, s.id_
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	defer rows.Close()
+
+	projects := []ProjectData{}
+
+	for rows.Next() {
+		var projectName string
+		var environmentName, onExpired, key, value, updatedAt sql.NullString
+		var secret SecretData
+
+		if err := rows.Scan(
+			&projectName,
+			&environmentName,
+			&onExpired,
+			&key,
+			&value,
+			&secret.ExpiresAt,
+			&secret.RotateEvery,
+			&updatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		// rows are ordered, so a new name means a new project or environment
+		if len(projects) == 0 || projects[len(projects)-1].Name != projectName {
+			projects = append(projects, ProjectData{
+				Name:         projectName,
+				Environments: []EnvironmentData{},
+			})
+		}
+
+		project := &projects[len(projects)-1]
+
+		if !environmentName.Valid {
+			continue
+		}
+
+		if len(project.Environments) == 0 ||
+			project.Environments[len(project.Environments)-1].Name != environmentName.String {
+			project.Environments = append(project.Environments, EnvironmentData{
+				Name:      environmentName.String,
+				OnExpired: onExpired.String,
+				Secrets:   []SecretData{},
+			})
+		}
+
+		environment := &project.Environments[len(project.Environments)-1]
+
+		if !key.Valid {
+			continue
+		}
+
+		secret.Key = key.String
+		secret.Value = value.String
+		secret.UpdatedAt = updatedAt.String
+
+		environment.Secrets = append(environment.Secrets, secret)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, serrors.ErrDatabaseQuery(err)
+	}
+
+	return projects, nil
+}
+
+// uniqueErr replaces an error from inserting a username or key fingerprint
+// that's already taken with one saying so. Anything else is returned as is.
+func uniqueErr(err error) error {
+	switch {
+	case strings.Contains(err.Error(), "UNIQUE constraint failed: users_.username_"):
+		return serrors.ErrUsernameTaken
+	case strings.Contains(err.Error(), "UNIQUE constraint failed: keys_.fingerprint_"):
+		return serrors.ErrKeyRegistered
+	}
+
+	return err
+}
+
+// insertErr is uniqueErr for inserts in a transaction, whose other errors are
+// reported as database exec errors.
+func insertErr(err error) error {
+	if uniqueErr := uniqueErr(err); uniqueErr != err {
+		return uniqueErr
+	}
+
+	return serrors.ErrDatabaseExec(err)
+}
@@ -1,10 +1,37 @@
 package user_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/charmbracelet/ssh"
+	"github.com/nixpig/syringe.sh/internal/database"
+	"github.com/nixpig/syringe.sh/internal/user"
+	"github.com/nixpig/syringe.sh/pkg/ctxkeys"
+	"github.com/nixpig/syringe.sh/pkg/mailer"
+	"github.com/nixpig/syringe.sh/pkg/serrors"
+	"github.com/nixpig/syringe.sh/pkg/turso"
+	"github.com/nixpig/syringe.sh/pkg/validation"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 func TestUserCmd(t *testing.T) {
@@ -50,3 +77,1344 @@ func testUserCmdInitialiseCmd(t *testing.T, mock sqlmock.Sqlmock, db *sql.DB) {
 	//
 	// require.NoError(t, err)
 }
+
+// testConnector stands in for connecting to a new user database.
+type testConnector struct {
+	db    *sql.DB
+	url   string
+	calls int
+	// onConnect, if set, is called on each connection, e.g. to end the
+	// session part way through registering
+	onConnect func()
+}
+
+func (c *testConnector) connect(ctx context.Context, databaseURL, token string) (*sql.DB, error) {
+	c.calls++
+	c.url = databaseURL
+
+	if c.onConnect != nil {
+		c.onConnect()
+		return nil, errors.New("database not ready")
+	}
+
+	return c.db, nil
+}
+
+// testMailer keeps the messages it's asked to send.
+type testMailer struct {
+	messages []mailer.Message
+	err      error
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mailer.Message) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	m.messages = append(m.messages, msg)
+
+	return nil
+}
+
+// code is the verification code in the last message sent.
+func (m *testMailer) code(t *testing.T) string {
+	require.NotEmpty(t, m.messages)
+
+	code := regexp.MustCompile(`code is (\d{6})`).FindStringSubmatch(m.messages[len(m.messages)-1].Body)
+	require.Len(t, code, 2)
+
+	return code[1]
+}
+
+func TestRegisterUser(t *testing.T) {
+	scenarios := map[string]func(
+		t *testing.T,
+		mock sqlmock.Sqlmock,
+		userDBMock sqlmock.Sqlmock,
+		connector *testConnector,
+		mailer *testMailer,
+		server *turso.FakeServer,
+		service user.UserService,
+		publicKey ssh.PublicKey,
+	){
+		"test register user happy path":             testRegisterUserHappyPath,
+		"test register user resumes creating user":  testRegisterUserResumesCreatingUser,
+		"test register user resends code":           testRegisterUserResendsCode,
+		"test register user changes email":          testRegisterUserChangesEmail,
+		"test register user invalid email":          testRegisterUserInvalidEmail,
+		"test register user mail error":             testRegisterUserMailError,
+		"test register user already registered":     testRegisterUserAlreadyRegistered,
+		"test register user undone on create error": testRegisterUserUndoneOnCreateError,
+		"test register user undone on session end":  testRegisterUserUndoneOnSessionEnd,
+		"test register user username taken":         testRegisterUserUsernameTaken,
+		"test register user key registered":         testRegisterUserKeyRegistered,
+		"test register user suspended":              testRegisterUserSuspended,
+		"test register user certificate":            testRegisterUserCertificate,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			userDB, userDBMock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			server := turso.NewFakeServer("my_cool_org", "api_token")
+			defer server.Close()
+
+			connector := &testConnector{db: userDB}
+			mailer := &testMailer{}
+
+			service := user.NewUserServiceImpl(
+				user.NewSqliteUserStore(db),
+				validation.New(),
+				*server.Client(),
+				user.TursoAPISettings{
+					URL:          server.BaseURL(),
+					Token:        "api_token",
+					Organization: "my_cool_org",
+					Group:        "default",
+				},
+				user.WithConnector(connector.connect),
+				user.WithMailer(mailer),
+			)
+
+			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			require.NoError(t, err)
+
+			publicKey, err := gossh.NewPublicKey(&privateKey.PublicKey)
+			require.NoError(t, err)
+
+			fn(t, mock, userDBMock, connector, mailer, server, service, publicKey)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			require.NoError(t, userDBMock.ExpectationsWereMet())
+		})
+	}
+}
+
+const (
+	getUserByKeyFingerprintQuery = `
+		select u.id_, u.username_, u.email_, u.status_, u.created_at_,
+		k.id_, k.user_id_, k.ssh_public_key_, k.fingerprint_, k.created_at_
+		from users_ u
+		inner join
+		keys_ k
+		on k.user_id_ = u.id_
+		where k.fingerprint_ = $fingerprint
+	`
+
+	insertUserQuery = `
+		insert into users_ (username_, email_, status_)
+		values ($username, $email, $status)
+		returning id_, username_, email_, status_, created_at_
+	`
+
+	insertKeyQuery = `
+		insert into keys_ (user_id_, ssh_public_key_, fingerprint_)
+		values ($userID, $publicKey, $fingerprint)
+		returning id_, user_id_, ssh_public_key_, fingerprint_, created_at_
+	`
+
+	setUserStatusQuery = `
+		update users_ set status_ = $status where id_ = $userID
+	`
+
+	setUserEmailQuery = `
+		update users_ set email_ = $email where id_ = $userID
+	`
+
+	setVerificationQuery = `
+		insert into verifications_ (user_id_, code_hash_, expires_at_)
+		values ($userID, $codeHash, $expiresAt)
+		on conflict (user_id_) do update
+		set code_hash_ = excluded.code_hash_,
+		expires_at_ = excluded.expires_at_,
+		attempts_ = 0,
+		created_at_ = current_timestamp
+	`
+)
+
+var userWithKeyColumns = []string{
+	"id_", "username_", "email_", "status_", "created_at_",
+	"id_", "user_id_", "ssh_public_key_", "fingerprint_", "created_at_",
+}
+
+// expectGetUserByKeyFingerprint expects the key to be looked up, and found
+// registered to the username with the status, unless the status is empty.
+func expectGetUserByKeyFingerprint(
+	mock sqlmock.Sqlmock,
+	publicKey ssh.PublicKey,
+	username string,
+	status string,
+) {
+	fingerprint := gossh.FingerprintSHA256(publicKey)
+
+	rows := sqlmock.NewRows(userWithKeyColumns)
+	if status != "" {
+		rows.AddRow(
+			1, username, "jane@example.org", status, "2024-06-01",
+			2, 1, string(gossh.MarshalAuthorizedKey(publicKey)), fingerprint, "2024-06-01",
+		)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserByKeyFingerprintQuery)).
+		WithArgs(fingerprint).
+		WillReturnRows(rows)
+}
+
+func expectInsertNewUser(mock sqlmock.Sqlmock, publicKey ssh.PublicKey) {
+	marshalledKey := string(gossh.MarshalAuthorizedKey(publicKey))
+	fingerprint := gossh.FingerprintSHA256(publicKey)
+
+	mock.ExpectBegin()
+
+	mock.ExpectQuery(regexp.QuoteMeta(insertUserQuery)).
+		WithArgs("janedoe", "jane@example.org", "creating").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "username_", "email_", "status_", "created_at_"}).
+				AddRow(1, "janedoe", "jane@example.org", "creating", "2024-06-01"),
+		)
+
+	mock.ExpectQuery(regexp.QuoteMeta(insertKeyQuery)).
+		WithArgs(1, marshalledKey, fingerprint).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "user_id_", "ssh_public_key_", "fingerprint_", "created_at_"}).
+				AddRow(2, 1, marshalledKey, fingerprint, "2024-06-01"),
+		)
+
+	mock.ExpectCommit()
+}
+
+func expectDeleteUser(mock sqlmock.Sqlmock) {
+	expectDeleteUserRows(mock, 0, 0, 0, 0)
+}
+
+// expectDeleteUserRows expects a user who owns no organisations to be
+// deleted, along with their roles, memberships and shares.
+func expectDeleteUserRows(mock sqlmock.Sqlmock, verifications, roles, memberships, shares int64) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`select count(*) from org_members_`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta(`delete from verifications_ where user_id_ = $userID`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, verifications))
+	mock.ExpectExec(regexp.QuoteMeta(`delete from keys_ where user_id_ = $userID`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`delete from roles_ where user_id_ = $userID`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, roles))
+	mock.ExpectExec(regexp.QuoteMeta(`delete from org_members_ where user_id_ = $userID`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, memberships))
+	mock.ExpectExec(regexp.QuoteMeta(`delete from shares_ where owner_id_ = $userID or recipient_id_ = $userID`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, shares))
+	mock.ExpectExec(regexp.QuoteMeta(`delete from users_ where id_ = $userID`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func expectSetVerification(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(setVerificationQuery)).
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func expectCreateTables(userDBMock sqlmock.Sqlmock) {
+	userDBMock.ExpectBegin()
+	userDBMock.ExpectExec(regexp.QuoteMeta(`create table if not exists projects_`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	userDBMock.ExpectExec(regexp.QuoteMeta(`create table if not exists environments_`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	userDBMock.ExpectExec(regexp.QuoteMeta(`create table if not exists secrets_`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// the tables were just created, so have every column
+	for _, table := range []struct {
+		name    string
+		columns []string
+	}{
+		{"environments_", []string{"id_", "name_", "project_id_", "on_expired_"}},
+		{"secrets_", []string{"id_", "key_", "value_", "environment_id_", "expires_at_", "rotate_every_", "updated_at_"}},
+	} {
+		rows := sqlmock.NewRows([]string{"cid", "name", "type", "notnull", "dflt_value", "pk"})
+		for i, column := range table.columns {
+			rows.AddRow(i, column, "text", 0, nil, 0)
+		}
+
+		userDBMock.ExpectQuery(regexp.QuoteMeta("pragma table_info(" + table.name + ")")).
+			WillReturnRows(rows)
+	}
+
+	userDBMock.ExpectQuery(regexp.QuoteMeta(`select count(*) from pragma_index_list('secrets_') l`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+
+	userDBMock.ExpectCommit()
+	userDBMock.ExpectClose()
+}
+
+func registerRequest(publicKey ssh.PublicKey) user.RegisterUserRequest {
+	return user.RegisterUserRequest{
+		Username:  "janedoe",
+		Email:     "jane@example.org",
+		PublicKey: publicKey,
+	}
+}
+
+func testRegisterUserHappyPath(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	marshalledKey := string(gossh.MarshalAuthorizedKey(publicKey))
+	databaseName := database.UserDBName(publicKey)
+
+	expectGetUserByKeyFingerprint(mock, publicKey, "janedoe", "")
+	expectInsertNewUser(mock, publicKey)
+	expectCreateTables(userDBMock)
+
+	mock.ExpectExec(regexp.QuoteMeta(setUserStatusQuery)).
+		WithArgs("pending", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	expectSetVerification(mock)
+
+	registered, err := service.RegisterUser(context.Background(), registerRequest(publicKey))
+	require.NoError(t, err)
+
+	require.Equal(t, &user.RegisterUserResponse{
+		ID:           1,
+		Username:     "janedoe",
+		Email:        "jane@example.org",
+		CreatedAt:    "2024-06-01",
+		PublicKey:    marshalledKey,
+		DatabaseName: databaseName,
+		Status:       "pending",
+	}, registered)
+
+	require.Equal(t, "libsql://"+databaseName+"-my_cool_org.turso.io", connector.url)
+
+	api := server.TursoClient()
+
+	_, err = api.RetrieveDatabase(context.Background(), databaseName)
+	require.NoError(t, err)
+
+	require.Len(t, mailer.messages, 1)
+	require.Equal(t, "jane@example.org", mailer.messages[0].To)
+	require.Contains(t, mailer.messages[0].Body, "syringe user verify "+mailer.code(t))
+}
+
+func testRegisterUserResumesCreatingUser(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	databaseName := database.UserDBName(publicKey)
+
+	// an earlier attempt created the database, but was interrupted
+	api := server.TursoClient()
+	_, err := api.CreateDatabase(context.Background(), databaseName, "default")
+	require.NoError(t, err)
+
+	expectGetUserByKeyFingerprint(mock, publicKey, "janedoe", "creating")
+	expectCreateTables(userDBMock)
+
+	mock.ExpectExec(regexp.QuoteMeta(setUserStatusQuery)).
+		WithArgs("pending", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	expectSetVerification(mock)
+
+	registered, err := service.RegisterUser(context.Background(), registerRequest(publicKey))
+	require.NoError(t, err)
+	require.Equal(t, 1, registered.ID)
+	require.Equal(t, databaseName, registered.DatabaseName)
+	require.Equal(t, "pending", registered.Status)
+
+	databases, err := api.ListDatabases(context.Background())
+	require.NoError(t, err)
+	require.Len(t, databases.Databases, 1)
+	require.Len(t, mailer.messages, 1)
+}
+
+func testRegisterUserResendsCode(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	expectGetUserByKeyFingerprint(mock, publicKey, "janedoe", "pending")
+	expectSetVerification(mock)
+
+	registered, err := service.RegisterUser(context.Background(), registerRequest(publicKey))
+	require.NoError(t, err)
+	require.Equal(t, "pending", registered.Status)
+	require.Equal(t, 0, connector.calls)
+	require.Len(t, mailer.messages, 1)
+}
+
+func testRegisterUserChangesEmail(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	expectGetUserByKeyFingerprint(mock, publicKey, "janedoe", "pending")
+
+	mock.ExpectExec(regexp.QuoteMeta(setUserEmailQuery)).
+		WithArgs("jane@example.com", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	expectSetVerification(mock)
+
+	request := registerRequest(publicKey)
+	request.Email = "jane@example.com"
+
+	registered, err := service.RegisterUser(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, "jane@example.com", registered.Email)
+	require.Len(t, mailer.messages, 1)
+	require.Equal(t, "jane@example.com", mailer.messages[0].To)
+}
+
+func testRegisterUserInvalidEmail(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	request := registerRequest(publicKey)
+	request.Email = "not an email"
+
+	_, err := service.RegisterUser(context.Background(), request)
+	require.EqualError(t, err, `"email" is invalid`)
+	require.Empty(t, mailer.messages)
+}
+
+func testRegisterUserCertificate(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	ca, err := gossh.NewSignerFromKey(private)
+	require.NoError(t, err)
+
+	cert := &gossh.Certificate{
+		Key:             publicKey,
+		CertType:        gossh.UserCert,
+		ValidPrincipals: []string{"janedoe"},
+		ValidBefore:     gossh.CertTimeInfinity,
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+
+	_, err = service.RegisterUser(context.Background(), registerRequest(cert))
+	require.ErrorIs(t, err, serrors.ErrCertificateRegistration)
+	require.Empty(t, mailer.messages)
+}
+
+func testRegisterUserMailError(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	mailer.err = errors.New("connection refused")
+
+	expectGetUserByKeyFingerprint(mock, publicKey, "janedoe", "pending")
+	expectSetVerification(mock)
+
+	// the user stays registered, so can register again to be sent a new code
+	_, err := service.RegisterUser(context.Background(), registerRequest(publicKey))
+	require.ErrorContains(t, err, "register again to resend it")
+	require.ErrorContains(t, err, "connection refused")
+}
+
+func testRegisterUserAlreadyRegistered(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	expectGetUserByKeyFingerprint(mock, publicKey, "janedoe", "active")
+
+	registered, err := service.RegisterUser(context.Background(), registerRequest(publicKey))
+	require.NoError(t, err)
+	require.Equal(t, 1, registered.ID)
+	require.Equal(t, 0, connector.calls)
+	require.Empty(t, mailer.messages)
+}
+
+func testRegisterUserUndoneOnCreateError(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	api := server.TursoClient()
+	require.NoError(t, api.DeleteGroup(context.Background(), "default"))
+
+	expectGetUserByKeyFingerprint(mock, publicKey, "janedoe", "")
+	expectInsertNewUser(mock, publicKey)
+	expectDeleteUser(mock)
+
+	_, err := service.RegisterUser(context.Background(), registerRequest(publicKey))
+	require.ErrorAs(t, err, &turso.ErrNotFound{})
+	require.Equal(t, 0, connector.calls)
+}
+
+func testRegisterUserUndoneOnSessionEnd(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	ctx, cancel := context.WithCancel(context.Background())
+	connector.onConnect = cancel
+
+	expectGetUserByKeyFingerprint(mock, publicKey, "janedoe", "")
+	expectInsertNewUser(mock, publicKey)
+	expectDeleteUser(mock)
+
+	_, err := service.RegisterUser(ctx, registerRequest(publicKey))
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, connector.calls)
+
+	// the database it created is deleted, despite the session having ended
+	api := server.TursoClient()
+
+	databases, err := api.ListDatabases(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, databases.Databases)
+}
+
+func testRegisterUserUsernameTaken(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	expectGetUserByKeyFingerprint(mock, publicKey, "janedoe", "")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(insertUserQuery)).
+		WithArgs("janedoe", "jane@example.org", "creating").
+		WillReturnError(errors.New("SQLite error: UNIQUE constraint failed: users_.username_"))
+	mock.ExpectRollback()
+
+	_, err := service.RegisterUser(context.Background(), registerRequest(publicKey))
+	require.ErrorIs(t, err, serrors.ErrUsernameTaken)
+	require.Equal(t, 0, connector.calls)
+}
+
+func testRegisterUserKeyRegistered(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	expectGetUserByKeyFingerprint(mock, publicKey, "johndoe", "active")
+
+	_, err := service.RegisterUser(context.Background(), registerRequest(publicKey))
+	require.ErrorIs(t, err, serrors.ErrKeyRegistered)
+	require.Equal(t, 0, connector.calls)
+}
+
+func testRegisterUserSuspended(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	connector *testConnector,
+	mailer *testMailer,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	expectGetUserByKeyFingerprint(mock, publicKey, "janedoe", "suspended")
+
+	_, err := service.RegisterUser(context.Background(), registerRequest(publicKey))
+	require.ErrorIs(t, err, serrors.ErrAccountSuspended)
+	require.Equal(t, 0, connector.calls)
+	require.Empty(t, mailer.messages)
+}
+
+func TestUserAccount(t *testing.T) {
+	scenarios := map[string]func(
+		t *testing.T,
+		mock sqlmock.Sqlmock,
+		userDBMock sqlmock.Sqlmock,
+		server *turso.FakeServer,
+		service user.UserService,
+		publicKey ssh.PublicKey,
+	){
+		"test user export cmd":               testUserExportCmd,
+		"test user delete cmd":               testUserDeleteCmd,
+		"test user delete cmd prompts":       testUserDeleteCmdPrompts,
+		"test user delete cmd not confirmed": testUserDeleteCmdNotConfirmed,
+		"test user delete cmd with org":      testUserDeleteCmdWithOrg,
+		"test user delete cmd database gone": testUserDeleteCmdDatabaseGone,
+		"test user delete cmd org member":    testUserDeleteCmdOrgMember,
+		"test user delete cmd org owner":     testUserDeleteCmdOrgOwner,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			userDB, userDBMock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			server := turso.NewFakeServer("my_cool_org", "api_token")
+			defer server.Close()
+
+			service := user.NewUserServiceImpl(
+				user.NewSqliteUserStore(db),
+				validation.New(),
+				*server.Client(),
+				user.TursoAPISettings{
+					URL:          server.BaseURL(),
+					Token:        "api_token",
+					Organization: "my_cool_org",
+					Group:        "default",
+				},
+				user.WithDataStore(user.NewSqliteDataStore(userDB)),
+			)
+
+			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			require.NoError(t, err)
+
+			publicKey, err := gossh.NewPublicKey(&privateKey.PublicKey)
+			require.NoError(t, err)
+
+			fn(t, mock, userDBMock, server, service, publicKey)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+			require.NoError(t, userDBMock.ExpectationsWereMet())
+		})
+	}
+}
+
+const (
+	getUserQuery = `
+		select id_, username_, email_, status_, created_at_
+		from users_
+		where username_ = $username
+	`
+
+	getUserKeysQuery = `
+		select id_, user_id_, ssh_public_key_, fingerprint_, created_at_
+		from keys_
+		where user_id_ = $userID
+		order by id_
+	`
+
+	getProjectsQuery = `
+		select p.name_, e.name_, e.on_expired_,
+		s.key_, s.value_, s.expires_at_, s.rotate_every_, s.updated_at_
+		from projects_ p
+		left join
+		environments_ e
+		on e.project_id_ = p.id_
+		left join
+		secrets_ s
+		on s.environment_id_ = e.id_
+		order by p.id_, e.id_, s.id_
+	`
+)
+
+func expectExport(mock sqlmock.Sqlmock, userDBMock sqlmock.Sqlmock, publicKey ssh.PublicKey) {
+	mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "username_", "email_", "status_", "created_at_"}).
+				AddRow(1, "janedoe", "jane@example.org", "active", "2024-06-01"),
+		)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserKeysQuery)).
+		WithArgs(1).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "user_id_", "ssh_public_key_", "fingerprint_", "created_at_"}).
+				AddRow(2, 1, string(gossh.MarshalAuthorizedKey(publicKey)), gossh.FingerprintSHA256(publicKey), "2024-06-01"),
+		)
+
+	userDBMock.ExpectQuery(regexp.QuoteMeta(getProjectsQuery)).
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"name_", "name_", "on_expired_",
+				"key_", "value_", "expires_at_", "rotate_every_", "updated_at_",
+			}).
+				AddRow("my_cool_project", "dev", "warn", "SECRET_KEY", "encrypted_value", nil, "30d", "2024-06-01").
+				AddRow("my_cool_project", "dev", "warn", "OTHER_KEY", "other_encrypted_value", "2024-07-01", nil, "2024-06-02").
+				AddRow("my_cool_project", "staging", "block", nil, nil, nil, nil, nil).
+				AddRow("my_empty_project", nil, nil, nil, nil, nil, nil, nil),
+		)
+}
+
+func newCmdUserAccount(cmd *cobra.Command, publicKey ssh.PublicKey) *cobra.Command {
+	cmd.Flags().String("org", "", "")
+	cmd.Flags().String("shared-by", "", "")
+
+	ctx := context.WithValue(context.Background(), ctxkeys.Username, "janedoe")
+	ctx = context.WithValue(ctx, ctxkeys.PublicKey, publicKey)
+	cmd.SetContext(ctx)
+
+	return cmd
+}
+
+// readArchive reads the files in an export archive.
+func readArchive(t *testing.T, r io.Reader) map[string]string {
+	gz, err := gzip.NewReader(r)
+	require.NoError(t, err)
+
+	archive := tar.NewReader(gz)
+	files := map[string]string{}
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(archive)
+		require.NoError(t, err)
+
+		files[header.Name] = string(content)
+	}
+
+	return files
+}
+
+func requireExported(t *testing.T, r io.Reader, publicKey ssh.PublicKey) {
+	files := readArchive(t, r)
+
+	var exportedUser user.ExportedUser
+	require.NoError(t, json.Unmarshal([]byte(files["user.json"]), &exportedUser))
+	require.Equal(t, user.ExportedUser{
+		Username:  "janedoe",
+		Email:     "jane@example.org",
+		CreatedAt: "2024-06-01",
+		Keys: []user.ExportedKey{{
+			PublicKey:   string(gossh.MarshalAuthorizedKey(publicKey)),
+			Fingerprint: gossh.FingerprintSHA256(publicKey),
+			CreatedAt:   "2024-06-01",
+		}},
+	}, exportedUser)
+
+	expiresAt := "2024-07-01"
+	rotateEvery := "30d"
+
+	var projects []user.ProjectData
+	require.NoError(t, json.Unmarshal([]byte(files["projects.json"]), &projects))
+	require.Equal(t, []user.ProjectData{
+		{
+			Name: "my_cool_project",
+			Environments: []user.EnvironmentData{
+				{
+					Name:      "dev",
+					OnExpired: "warn",
+					Secrets: []user.SecretData{
+						{Key: "SECRET_KEY", Value: "encrypted_value", RotateEvery: &rotateEvery, UpdatedAt: "2024-06-01"},
+						{Key: "OTHER_KEY", Value: "other_encrypted_value", ExpiresAt: &expiresAt, UpdatedAt: "2024-06-02"},
+					},
+				},
+				{Name: "staging", OnExpired: "block", Secrets: []user.SecretData{}},
+			},
+		},
+		{Name: "my_empty_project", Environments: []user.EnvironmentData{}},
+	}, projects)
+}
+
+func testUserExportCmd(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	expectExport(mock, userDBMock, publicKey)
+
+	cmd := newCmdUserAccount(user.NewCmdUserExport(user.NewHandlerUserExport(service)), publicKey)
+
+	cmdOut := bytes.NewBufferString("")
+	cmd.SetOut(cmdOut)
+	cmd.SetArgs([]string{})
+
+	require.NoError(t, cmd.Execute())
+
+	requireExported(t, cmdOut, publicKey)
+}
+
+// createUserDatabase creates the database a delete is expected to delete.
+func createUserDatabase(t *testing.T, server *turso.FakeServer, publicKey ssh.PublicKey) {
+	api := server.TursoClient()
+
+	_, err := api.CreateDatabase(context.Background(), database.UserDBName(publicKey), "default")
+	require.NoError(t, err)
+}
+
+func requireDatabases(t *testing.T, server *turso.FakeServer, count int) {
+	api := server.TursoClient()
+
+	databases, err := api.ListDatabases(context.Background())
+	require.NoError(t, err)
+	require.Len(t, databases.Databases, count)
+}
+
+func testUserDeleteCmd(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	createUserDatabase(t, server, publicKey)
+
+	expectExport(mock, userDBMock, publicKey)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "username_", "email_", "status_", "created_at_"}).
+				AddRow(1, "janedoe", "jane@example.org", "active", "2024-06-01"),
+		)
+
+	expectDeleteUser(mock)
+
+	cmd := newCmdUserAccount(user.NewCmdUserDelete(user.NewHandlerUserDelete(service)), publicKey)
+
+	cmdOut := bytes.NewBufferString("")
+	cmdErr := bytes.NewBufferString("")
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(cmdErr)
+	cmd.SetArgs([]string{"--confirm", "janedoe"})
+
+	require.NoError(t, cmd.Execute())
+
+	requireExported(t, cmdOut, publicKey)
+	require.Equal(t, "User 'janedoe' deleted\n", cmdErr.String())
+	requireDatabases(t, server, 0)
+}
+
+func testUserDeleteCmdOrgMember(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	createUserDatabase(t, server, publicKey)
+
+	expectExport(mock, userDBMock, publicKey)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "username_", "email_", "status_", "created_at_"}).
+				AddRow(1, "janedoe", "jane@example.org", "active", "2024-06-01"),
+		)
+
+	// a member of one org with a role in it, who has shared an environment
+	// and been shared another
+	expectDeleteUserRows(mock, 0, 1, 1, 2)
+
+	cmd := newCmdUserAccount(user.NewCmdUserDelete(user.NewHandlerUserDelete(service)), publicKey)
+
+	cmdErr := bytes.NewBufferString("")
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(cmdErr)
+	cmd.SetArgs([]string{"--confirm", "janedoe"})
+
+	require.NoError(t, cmd.Execute())
+
+	require.Equal(t, "User 'janedoe' deleted\n", cmdErr.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+	requireDatabases(t, server, 0)
+}
+
+func testUserDeleteCmdOrgOwner(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	createUserDatabase(t, server, publicKey)
+
+	expectExport(mock, userDBMock, publicKey)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "username_", "email_", "status_", "created_at_"}).
+				AddRow(1, "janedoe", "jane@example.org", "active", "2024-06-01"),
+		)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`select count(*) from org_members_`)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(1))
+	mock.ExpectRollback()
+
+	cmd := newCmdUserAccount(user.NewCmdUserDelete(user.NewHandlerUserDelete(service)), publicKey)
+
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs([]string{"--confirm", "janedoe"})
+
+	err := cmd.Execute()
+
+	require.ErrorIs(t, err, serrors.ErrOrgOwnerDeletion)
+	require.NoError(t, mock.ExpectationsWereMet())
+	requireDatabases(t, server, 1)
+}
+
+func testUserDeleteCmdPrompts(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	createUserDatabase(t, server, publicKey)
+
+	expectExport(mock, userDBMock, publicKey)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "username_", "email_", "status_", "created_at_"}).
+				AddRow(1, "janedoe", "jane@example.org", "active", "2024-06-01"),
+		)
+
+	expectDeleteUser(mock)
+
+	cmd := newCmdUserAccount(user.NewCmdUserDelete(user.NewHandlerUserDelete(service)), publicKey)
+
+	cmdErr := bytes.NewBufferString("")
+	cmd.SetIn(strings.NewReader("janedoe\n"))
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(cmdErr)
+	cmd.SetArgs([]string{})
+
+	require.NoError(t, cmd.Execute())
+
+	require.Contains(t, cmdErr.String(), "Type your username to confirm")
+	requireDatabases(t, server, 0)
+}
+
+func testUserDeleteCmdNotConfirmed(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	createUserDatabase(t, server, publicKey)
+
+	cmd := newCmdUserAccount(user.NewCmdUserDelete(user.NewHandlerUserDelete(service)), publicKey)
+
+	cmdOut := bytes.NewBufferString("")
+	cmd.SetIn(strings.NewReader("johndoe\n"))
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs([]string{})
+
+	err := cmd.Execute()
+
+	require.ErrorContains(t, err, "confirmation doesn't match username 'janedoe', nothing was deleted")
+	require.NotContains(t, cmdOut.String(), "encrypted_value")
+	requireDatabases(t, server, 1)
+}
+
+func testUserDeleteCmdWithOrg(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	cmd := newCmdUserAccount(user.NewCmdUserDelete(user.NewHandlerUserDelete(service)), publicKey)
+
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs([]string{"--confirm", "janedoe", "--org", "my_cool_org"})
+
+	err := cmd.Execute()
+
+	require.ErrorContains(t, err, "can't be used with '--org' or '--shared-by'")
+}
+
+func testUserDeleteCmdDatabaseGone(
+	t *testing.T,
+	mock sqlmock.Sqlmock,
+	userDBMock sqlmock.Sqlmock,
+	server *turso.FakeServer,
+	service user.UserService,
+	publicKey ssh.PublicKey,
+) {
+	// the database is already gone, e.g. from an earlier attempt
+	expectExport(mock, userDBMock, publicKey)
+
+	mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "username_", "email_", "status_", "created_at_"}).
+				AddRow(1, "janedoe", "jane@example.org", "active", "2024-06-01"),
+		)
+
+	expectDeleteUser(mock)
+
+	cmd := newCmdUserAccount(user.NewCmdUserDelete(user.NewHandlerUserDelete(service)), publicKey)
+
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs([]string{"--confirm", "janedoe"})
+
+	require.NoError(t, cmd.Execute())
+}
+
+func TestVerifyUser(t *testing.T) {
+	scenarios := map[string]func(t *testing.T, mock sqlmock.Sqlmock, service user.UserService){
+		"test verify user happy path":        testVerifyUserHappyPath,
+		"test verify user wrong code":        testVerifyUserWrongCode,
+		"test verify user expired code":      testVerifyUserExpiredCode,
+		"test verify user too many attempts": testVerifyUserTooManyAttempts,
+		"test verify user no code":           testVerifyUserNoCode,
+		"test verify user already verified":  testVerifyUserAlreadyVerified,
+		"test verify user cmd wrong code":    testVerifyUserCmdWrongCode,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			service := user.NewUserServiceImpl(
+				user.NewSqliteUserStore(db),
+				validation.New(),
+				http.Client{},
+				user.TursoAPISettings{},
+			)
+
+			fn(t, mock, service)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+const getVerificationQuery = `
+	select user_id_, code_hash_, expires_at_, attempts_
+	from verifications_
+	where user_id_ = $userID
+`
+
+func expectGetUser(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id_", "username_", "email_", "status_", "created_at_"}).
+				AddRow(1, "janedoe", "jane@example.org", status, "2024-06-01"),
+		)
+}
+
+// expectGetVerification expects the code sent to the user to be looked up,
+// and found to be 123456 with the expiry and attempts.
+func expectGetVerification(mock sqlmock.Sqlmock, expiresAt time.Time, attempts int) {
+	mock.ExpectQuery(regexp.QuoteMeta(getVerificationQuery)).
+		WithArgs(1).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"user_id_", "code_hash_", "expires_at_", "attempts_"}).
+				AddRow(1, fmt.Sprintf("%x", sha256.Sum256([]byte("123456"))), expiresAt.Format(time.RFC3339), attempts),
+		)
+}
+
+func verifyRequest(code string) user.VerifyUserRequest {
+	return user.VerifyUserRequest{
+		Username: "janedoe",
+		Code:     code,
+	}
+}
+
+func testVerifyUserHappyPath(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "pending")
+	expectGetVerification(mock, time.Now().Add(time.Hour), 0)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`delete from verifications_ where user_id_ = $userID`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(setUserStatusQuery)).
+		WithArgs("active", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, service.VerifyUser(context.Background(), verifyRequest("123456")))
+}
+
+func testVerifyUserWrongCode(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "pending")
+	expectGetVerification(mock, time.Now().Add(time.Hour), 0)
+
+	mock.ExpectExec(regexp.QuoteMeta(`update verifications_ set attempts_ = attempts_ + 1 where user_id_ = $userID`)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := service.VerifyUser(context.Background(), verifyRequest("654321"))
+	require.ErrorIs(t, err, serrors.ErrInvalidCode)
+}
+
+func testVerifyUserExpiredCode(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "pending")
+	expectGetVerification(mock, time.Now().Add(-time.Minute), 0)
+
+	err := service.VerifyUser(context.Background(), verifyRequest("123456"))
+	require.ErrorIs(t, err, serrors.ErrInvalidCode)
+}
+
+func testVerifyUserTooManyAttempts(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "pending")
+	expectGetVerification(mock, time.Now().Add(time.Hour), 5)
+
+	// even the right code is refused until a new one is sent
+	err := service.VerifyUser(context.Background(), verifyRequest("123456"))
+	require.ErrorIs(t, err, serrors.ErrInvalidCode)
+}
+
+func testVerifyUserNoCode(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "pending")
+
+	mock.ExpectQuery(regexp.QuoteMeta(getVerificationQuery)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id_", "code_hash_", "expires_at_", "attempts_"}))
+
+	err := service.VerifyUser(context.Background(), verifyRequest("123456"))
+	require.ErrorIs(t, err, serrors.ErrInvalidCode)
+}
+
+func testVerifyUserAlreadyVerified(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "active")
+
+	require.NoError(t, service.VerifyUser(context.Background(), verifyRequest("123456")))
+}
+
+func testVerifyUserCmdWrongCode(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "pending")
+	expectGetVerification(mock, time.Now().Add(-time.Minute), 0)
+
+	cmd := user.NewCmdUserVerify(user.NewHandlerUserVerify(service))
+	cmd.SetContext(context.WithValue(context.Background(), ctxkeys.Username, "janedoe"))
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs([]string{"123456"})
+
+	err := cmd.Execute()
+	require.EqualError(
+		t,
+		err,
+		"unable to verify email address: verification code is invalid or has expired (run 'syringe user register --email EMAIL' to be sent a new one)",
+	)
+}
+
+func TestSetUserStatus(t *testing.T) {
+	scenarios := map[string]func(t *testing.T, mock sqlmock.Sqlmock, service user.UserService){
+		"test suspend user":                          testSuspendUser,
+		"test reactivate verified user":              testReactivateVerifiedUser,
+		"test reactivate unverified user":            testReactivateUnverifiedUser,
+		"test set status of unfinished registration": testSetStatusOfUnfinishedRegistration,
+		"test set status of missing user":            testSetStatusOfMissingUser,
+		"test set invalid status":                    testSetInvalidStatus,
+		"test lock user cmd":                         testLockUserCmd,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("unable to create mock database:\n%s", err)
+			}
+
+			service := user.NewUserServiceImpl(
+				user.NewSqliteUserStore(db),
+				validation.New(),
+				http.Client{},
+				user.TursoAPISettings{},
+			)
+
+			fn(t, mock, service)
+
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func expectSetUserStatus(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectExec(regexp.QuoteMeta(setUserStatusQuery)).
+		WithArgs(status, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func testSuspendUser(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "active")
+	expectSetUserStatus(mock, "suspended")
+
+	res, err := service.SetUserStatus(context.Background(), user.SetUserStatusRequest{
+		Username: "janedoe",
+		Status:   "suspended",
+	})
+	require.NoError(t, err)
+	require.Equal(t, &user.SetUserStatusResponse{Username: "janedoe", Status: "suspended"}, res)
+}
+
+func testReactivateVerifiedUser(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "suspended")
+
+	mock.ExpectQuery(regexp.QuoteMeta(getVerificationQuery)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id_", "code_hash_", "expires_at_", "attempts_"}))
+
+	expectSetUserStatus(mock, "active")
+
+	res, err := service.SetUserStatus(context.Background(), user.SetUserStatusRequest{
+		Username: "janedoe",
+		Status:   "active",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "active", res.Status)
+}
+
+func testReactivateUnverifiedUser(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "locked")
+	expectGetVerification(mock, time.Now().Add(time.Hour), 0)
+	expectSetUserStatus(mock, "pending")
+
+	res, err := service.SetUserStatus(context.Background(), user.SetUserStatusRequest{
+		Username: "janedoe",
+		Status:   "active",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "pending", res.Status)
+}
+
+func testSetStatusOfUnfinishedRegistration(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "creating")
+
+	_, err := service.SetUserStatus(context.Background(), user.SetUserStatusRequest{
+		Username: "janedoe",
+		Status:   "suspended",
+	})
+	require.EqualError(t, err, "user 'janedoe' hasn't finished registering")
+}
+
+func testSetStatusOfMissingUser(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	mock.ExpectQuery(regexp.QuoteMeta(getUserQuery)).
+		WithArgs("janedoe").
+		WillReturnRows(sqlmock.NewRows([]string{"id_", "username_", "email_", "status_", "created_at_"}))
+
+	_, err := service.SetUserStatus(context.Background(), user.SetUserStatusRequest{
+		Username: "janedoe",
+		Status:   "suspended",
+	})
+	require.ErrorIs(t, err, serrors.ErrUserNotFound)
+}
+
+func testSetInvalidStatus(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	_, err := service.SetUserStatus(context.Background(), user.SetUserStatusRequest{
+		Username: "janedoe",
+		Status:   "creating",
+	})
+	require.EqualError(t, err, `"status" must be one of: active, suspended, locked`)
+}
+
+func testLockUserCmd(t *testing.T, mock sqlmock.Sqlmock, service user.UserService) {
+	expectGetUser(mock, "active")
+	expectSetUserStatus(mock, "locked")
+
+	cmdOut := bytes.NewBufferString("")
+
+	cmd := user.NewCmdUserLock(user.NewHandlerUserLock(service))
+	cmd.SetContext(context.Background())
+	cmd.SetOut(cmdOut)
+	cmd.SetErr(cmdOut)
+	cmd.SetArgs([]string{"janedoe"})
+
+	require.NoError(t, cmd.Execute())
+	require.Equal(t, "User 'janedoe' is now locked\n", cmdOut.String())
+}
@@ -4,6 +4,14 @@ type ContextKey string
 
 const (
 	Authenticated = ContextKey("AUTHENTICATED_CTX")
+	Verified      = ContextKey("VERIFIED_CTX")
 	Username      = ContextKey("USERNAME_CTX")
 	PublicKey     = ContextKey("PUBLIC_KEY_CTX")
+	RegisteredKey = ContextKey("REGISTERED_KEY_CTX")
+	Admin         = ContextKey("ADMIN_CTX")
+	RateLimited   = ContextKey("RATE_LIMITED_CTX")
+	CommandPath   = ContextKey("COMMAND_PATH_CTX")
+	CommandFailed = ContextKey("COMMAND_FAILED_CTX")
+	Span          = ContextKey("SPAN_CTX")
+	Logger        = ContextKey("LOGGER_CTX")
 )
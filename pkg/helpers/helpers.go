@@ -1,6 +1,13 @@
 package helpers
 
-import "github.com/spf13/cobra"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
 
 func WalkCmd(c *cobra.Command, f func(*cobra.Command)) {
 	f(c)
@@ -8,3 +15,18 @@ func WalkCmd(c *cobra.Command, f func(*cobra.Command)) {
 		WalkCmd(c, f)
 	}
 }
+
+// ParseDuration is time.ParseDuration with support for a 'd' (days) unit,
+// e.g. '7d' or '90d'.
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,173 @@
+// Package hostkeys manages a server's SSH host keys: generating any that are
+// missing, and advertising them to clients with the OpenSSH
+// 'hostkeys-00@openssh.com' extension, so that clients learn keys before
+// they're used and keys can be rotated without clients seeing them change.
+//
+// See https://github.com/openssh/openssh-portable/blob/master/PROTOCOL
+// (section 2.5) for the extension.
+package hostkeys
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/keygen"
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	// AdvertiseRequest is the global request a server sends, after
+	// authentication, listing all of its host keys.
+	AdvertiseRequest = "hostkeys-00@openssh.com"
+
+	// ProveRequest is the global request a client sends, listing host keys it
+	// didn't know, for the server to prove it has their private keys.
+	ProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// Algorithms are the algorithms host keys can be generated for.
+var Algorithms = []string{
+	string(keygen.Ed25519),
+	string(keygen.ECDSA),
+	string(keygen.RSA),
+}
+
+// RSABits is the size of generated RSA host keys.
+const RSABits = 3072
+
+type contextKey struct{ name string }
+
+var contextKeyAdvertised = &contextKey{"hostkeys-advertised"}
+
+// HostKeys are the keys a server identifies itself with. Active keys are
+// offered during the handshake. Next keys, being rotated in, are only
+// advertised, until they replace an active key.
+type HostKeys struct {
+	active []gossh.Signer
+	next   []gossh.Signer
+}
+
+// Load loads the active key for each algorithm from 'id_<algorithm>' in dir,
+// generating and writing any that are missing, and the next keys from their
+// paths, which must already exist.
+func Load(dir string, algorithms []string, next []string) (*HostKeys, error) {
+	h := &HostKeys{}
+
+	for _, algorithm := range algorithms {
+		path := filepath.Join(dir, "id_"+algorithm)
+
+		keyPair, err := keygen.New(
+			path,
+			keygen.WithKeyType(keygen.KeyType(algorithm)),
+			keygen.WithBitSize(RSABits),
+			keygen.WithEllipticCurve(elliptic.P256()),
+			keygen.WithWrite(),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load or generate host key '%s':\n%w", path, err)
+		}
+
+		h.active = append(h.active, keyPair.Signer())
+	}
+
+	for _, path := range next {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read next host key:\n%w", err)
+		}
+
+		signer, err := gossh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse next host key '%s':\n%w", path, err)
+		}
+
+		h.next = append(h.next, signer)
+	}
+
+	return h, nil
+}
+
+// PublicKeys are every host key, active and next.
+func (h *HostKeys) PublicKeys() []gossh.PublicKey {
+	var keys []gossh.PublicKey
+
+	for _, signer := range h.signers() {
+		keys = append(keys, signer.PublicKey())
+	}
+
+	return keys
+}
+
+func (h *HostKeys) signers() []gossh.Signer {
+	return append(append([]gossh.Signer{}, h.active...), h.next...)
+}
+
+// Option offers the active keys during the handshake, and answers clients
+// asking for proof of any key.
+func (h *HostKeys) Option() ssh.Option {
+	return func(srv *ssh.Server) error {
+		for _, signer := range h.active {
+			srv.AddHostKey(signer)
+		}
+
+		if srv.RequestHandlers == nil {
+			srv.RequestHandlers = map[string]ssh.RequestHandler{}
+			for k, v := range ssh.DefaultRequestHandlers {
+				srv.RequestHandlers[k] = v
+			}
+		}
+
+		srv.RequestHandlers[ProveRequest] = h.handleProve
+
+		return nil
+	}
+}
+
+// Middleware advertises every key to the client, once per connection.
+// Clients that don't support the extension ignore it.
+func (h *HostKeys) Middleware() wish.Middleware {
+	return func(next ssh.Handler) ssh.Handler {
+		return func(sess ssh.Session) {
+			h.advertise(sess.Context())
+
+			next(sess)
+		}
+	}
+}
+
+func (h *HostKeys) advertise(ctx ssh.Context) {
+	if advertised, _ := ctx.Value(contextKeyAdvertised).(bool); advertised {
+		return
+	}
+
+	ctx.SetValue(contextKeyAdvertised, true)
+
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(gossh.Conn)
+	if !ok {
+		return
+	}
+
+	conn.SendRequest(AdvertiseRequest, false, MarshalKeys(h.PublicKeys()))
+}
+
+func (h *HostKeys) handleProve(
+	ctx ssh.Context,
+	srv *ssh.Server,
+	req *gossh.Request,
+) (bool, []byte) {
+	conn, ok := ctx.Value(ssh.ContextKeyConn).(gossh.Conn)
+	if !ok {
+		return false, nil
+	}
+
+	proofs, err := Prove(h.signers(), conn.SessionID(), req.Payload)
+	if err != nil {
+		return false, nil
+	}
+
+	return true, proofs
+}
@@ -0,0 +1,257 @@
+package hostkeys_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
+	"github.com/nixpig/syringe.sh/pkg/hostkeys"
+	syringessh "github.com/nixpig/syringe.sh/pkg/ssh"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestLoad(t *testing.T) {
+	scenarios := map[string]func(t *testing.T, dir string){
+		"test load generates missing keys": testLoadGeneratesMissingKeys,
+		"test load reuses existing keys":   testLoadReusesExistingKeys,
+		"test load next keys":              testLoadNextKeys,
+		"test load missing next key":       testLoadMissingNextKey,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, t.TempDir())
+		})
+	}
+}
+
+func testLoadGeneratesMissingKeys(t *testing.T, dir string) {
+	hostKeys, err := hostkeys.Load(dir, hostkeys.Algorithms, nil)
+	require.NoError(t, err)
+
+	var types []string
+	for _, key := range hostKeys.PublicKeys() {
+		types = append(types, key.Type())
+	}
+
+	require.Equal(t, []string{
+		gossh.KeyAlgoED25519,
+		gossh.KeyAlgoECDSA256,
+		gossh.KeyAlgoRSA,
+	}, types)
+
+	for _, algorithm := range hostkeys.Algorithms {
+		require.FileExists(t, filepath.Join(dir, "id_"+algorithm))
+	}
+}
+
+func testLoadReusesExistingKeys(t *testing.T, dir string) {
+	first, err := hostkeys.Load(dir, []string{"ed25519"}, nil)
+	require.NoError(t, err)
+
+	second, err := hostkeys.Load(dir, []string{"ed25519"}, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, first.PublicKeys(), second.PublicKeys())
+}
+
+func testLoadNextKeys(t *testing.T, dir string) {
+	next := writeKey(t, dir, "next_ed25519")
+
+	hostKeys, err := hostkeys.Load(dir, []string{"ed25519"}, []string{next})
+	require.NoError(t, err)
+
+	require.Len(t, hostKeys.PublicKeys(), 2)
+}
+
+func testLoadMissingNextKey(t *testing.T, dir string) {
+	_, err := hostkeys.Load(dir, []string{"ed25519"}, []string{filepath.Join(dir, "missing")})
+	require.ErrorContains(t, err, "failed to read next host key")
+}
+
+func TestProve(t *testing.T) {
+	hostKeys, err := hostkeys.Load(t.TempDir(), hostkeys.Algorithms, nil)
+	require.NoError(t, err)
+
+	keys := hostKeys.PublicKeys()
+	sessionID := []byte("my_cool_session")
+
+	t.Run("test prove and verify", func(t *testing.T) {
+		proofs, err := hostkeys.Prove(loadSigners(t, hostKeys), sessionID, hostkeys.MarshalKeys(keys))
+		require.NoError(t, err)
+
+		require.NoError(t, hostkeys.VerifyProofs(keys, sessionID, proofs))
+	})
+
+	t.Run("test verify other session", func(t *testing.T) {
+		proofs, err := hostkeys.Prove(loadSigners(t, hostKeys), sessionID, hostkeys.MarshalKeys(keys))
+		require.NoError(t, err)
+
+		require.ErrorContains(t, hostkeys.VerifyProofs(keys, []byte("other_session"), proofs), "invalid proof")
+	})
+
+	t.Run("test prove unknown key", func(t *testing.T) {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		signer, err := gossh.NewSignerFromKey(private)
+		require.NoError(t, err)
+
+		_, err = hostkeys.Prove(
+			loadSigners(t, hostKeys),
+			sessionID,
+			hostkeys.MarshalKeys([]gossh.PublicKey{signer.PublicKey()}),
+		)
+		require.ErrorContains(t, err, "no host key to prove")
+	})
+
+	t.Run("test parse keys round trip", func(t *testing.T) {
+		parsed, err := hostkeys.ParseKeys(hostkeys.MarshalKeys(keys))
+		require.NoError(t, err)
+		require.Equal(t, keys, parsed)
+
+		_, err = hostkeys.ParseKeys([]byte{0, 0, 0, 9, 1})
+		require.Error(t, err)
+	})
+}
+
+func TestRotation(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	knownHosts := filepath.Join(home, ".ssh", "known_hosts")
+	require.NoError(t, os.MkdirAll(filepath.Dir(knownHosts), 0o700))
+	require.NoError(t, os.WriteFile(knownHosts, nil, 0o600))
+
+	dir := t.TempDir()
+	next := writeKey(t, dir, "next_ed25519")
+
+	// -- BEFORE ROTATION
+	before, err := hostkeys.Load(dir, []string{"ed25519"}, []string{next})
+	require.NoError(t, err)
+
+	addr, stop := serve(t, before, "127.0.0.1:0")
+	run(t, addr)
+	stop()
+
+	requireKnownHosts(t, knownHosts, before.PublicKeys())
+
+	// -- AFTER ROTATION
+	require.NoError(t, os.Rename(next, filepath.Join(dir, "id_ed25519")))
+
+	after, err := hostkeys.Load(dir, []string{"ed25519"}, nil)
+	require.NoError(t, err)
+
+	// the client already knows the new key, so doesn't see the host as changed
+	_, stop = serve(t, after, addr)
+	run(t, addr)
+	stop()
+
+	requireKnownHosts(t, knownHosts, after.PublicKeys())
+}
+
+func writeKey(t *testing.T, dir, name string) string {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	block, err := gossh.MarshalPrivateKey(private, "")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+
+	return path
+}
+
+// loadSigners gets the signers back out of the keys' option, by applying it
+// to a server.
+func loadSigners(t *testing.T, hostKeys *hostkeys.HostKeys) []gossh.Signer {
+	srv := &ssh.Server{}
+	require.NoError(t, srv.SetOption(hostKeys.Option()))
+
+	var signers []gossh.Signer
+	for _, signer := range srv.HostSigners {
+		signers = append(signers, signer)
+	}
+
+	return signers
+}
+
+func serve(t *testing.T, hostKeys *hostkeys.HostKeys, addr string) (string, func()) {
+	server, err := wish.NewServer(
+		hostKeys.Option(),
+		wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+			return true
+		}),
+		wish.WithMiddleware(
+			func(next ssh.Handler) ssh.Handler {
+				return func(sess ssh.Session) {
+					sess.Write([]byte("ok"))
+					next(sess)
+				}
+			},
+			hostKeys.Middleware(),
+		),
+	)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+
+	go server.Serve(listener)
+
+	return listener.Addr().String(), func() { server.Close() }
+}
+
+func run(t *testing.T, addr string) {
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+
+	p, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := gossh.NewSignerFromKey(private)
+	require.NoError(t, err)
+
+	client, err := syringessh.NewSSHClient(host, p, "janedoe", gossh.PublicKeys(signer))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, client.Run("", &out))
+	require.Equal(t, "ok", out.String())
+
+	require.NoError(t, client.Close())
+}
+
+func requireKnownHosts(t *testing.T, path string, keys []gossh.PublicKey) {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var known []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		_, _, key, _, _, err := gossh.ParseKnownHosts([]byte(line))
+		require.NoError(t, err)
+
+		known = append(known, string(gossh.MarshalAuthorizedKey(key)))
+	}
+
+	var expected []string
+	for _, key := range keys {
+		expected = append(expected, string(gossh.MarshalAuthorizedKey(key)))
+	}
+
+	require.ElementsMatch(t, expected, known)
+}
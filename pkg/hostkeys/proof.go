@@ -0,0 +1,163 @@
+package hostkeys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+var errMalformed = errors.New("malformed host keys payload")
+
+// MarshalKeys encodes keys as the payload of both requests: each key's wire
+// format, as a string.
+func MarshalKeys(keys []gossh.PublicKey) []byte {
+	var blobs [][]byte
+
+	for _, key := range keys {
+		blobs = append(blobs, key.Marshal())
+	}
+
+	return marshalStrings(blobs)
+}
+
+// ParseKeys decodes the payload of either request, skipping keys of types
+// that aren't supported.
+func ParseKeys(payload []byte) ([]gossh.PublicKey, error) {
+	blobs, err := parseStrings(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []gossh.PublicKey
+
+	for _, blob := range blobs {
+		key, err := gossh.ParsePublicKey(blob)
+		if err != nil {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Prove signs a proof for each key in a prove request's payload, with the
+// signer for that key, bound to the session.
+func Prove(signers []gossh.Signer, sessionID, payload []byte) ([]byte, error) {
+	blobs, err := parseStrings(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var proofs [][]byte
+
+	for _, blob := range blobs {
+		signer := findSigner(signers, blob)
+		if signer == nil {
+			return nil, fmt.Errorf("no host key to prove: %x", blob)
+		}
+
+		signature, err := sign(signer, proofData(sessionID, blob))
+		if err != nil {
+			return nil, err
+		}
+
+		proofs = append(proofs, gossh.Marshal(signature))
+	}
+
+	return marshalStrings(proofs), nil
+}
+
+// VerifyProofs checks the response to a prove request for keys, in the same
+// order, made in the session.
+func VerifyProofs(keys []gossh.PublicKey, sessionID, response []byte) error {
+	proofs, err := parseStrings(response)
+	if err != nil {
+		return err
+	}
+
+	if len(proofs) != len(keys) {
+		return fmt.Errorf("got %d host key proofs for %d keys", len(proofs), len(keys))
+	}
+
+	for i, key := range keys {
+		var signature gossh.Signature
+		if err := gossh.Unmarshal(proofs[i], &signature); err != nil {
+			return fmt.Errorf("failed to parse host key proof:\n%w", err)
+		}
+
+		if err := key.Verify(proofData(sessionID, key.Marshal()), &signature); err != nil {
+			return fmt.Errorf("invalid proof for host key %s:\n%w", gossh.FingerprintSHA256(key), err)
+		}
+	}
+
+	return nil
+}
+
+// proofData is what's signed to prove a host key: the request name, the
+// session identifier and the key.
+func proofData(sessionID, blob []byte) []byte {
+	return gossh.Marshal(struct {
+		Request   string
+		SessionID []byte
+		Key       []byte
+	}{ProveRequest, sessionID, blob})
+}
+
+func sign(signer gossh.Signer, data []byte) (*gossh.Signature, error) {
+	// RSA keys mustn't be proven with SHA-1 signatures
+	if algorithmSigner, ok := signer.(gossh.AlgorithmSigner); ok &&
+		signer.PublicKey().Type() == gossh.KeyAlgoRSA {
+		return algorithmSigner.SignWithAlgorithm(rand.Reader, data, gossh.KeyAlgoRSASHA512)
+	}
+
+	return signer.Sign(rand.Reader, data)
+}
+
+func findSigner(signers []gossh.Signer, blob []byte) gossh.Signer {
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), blob) {
+			return signer
+		}
+	}
+
+	return nil
+}
+
+func marshalStrings(items [][]byte) []byte {
+	var b []byte
+
+	for _, item := range items {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(item)))
+		b = append(b, item...)
+	}
+
+	return b
+}
+
+func parseStrings(b []byte) ([][]byte, error) {
+	var items [][]byte
+
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errMalformed
+		}
+
+		n := binary.BigEndian.Uint32(b)
+		b = b[4:]
+
+		if uint64(n) > uint64(len(b)) {
+			return nil, errMalformed
+		}
+
+		items = append(items, b[:n])
+		b = b[n:]
+	}
+
+	return items, nil
+}
@@ -0,0 +1,62 @@
+package mailer_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nixpig/syringe.sh/pkg/mailer"
+	"github.com/stretchr/testify/require"
+)
+
+var message = mailer.Message{
+	To:      "jane@example.org",
+	Subject: "Verify your email address",
+	Body:    "Your verification code is 123456\n\nThanks",
+}
+
+func TestMailer(t *testing.T) {
+	scenarios := map[string]func(t *testing.T){
+		"test file mailer": testFileMailer,
+		"test log mailer":  testLogMailer,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, fn)
+	}
+}
+
+func testFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+
+	m := mailer.NewFileMailer(dir, "syringe.sh <noreply@syringe.sh>")
+
+	require.NoError(t, m.Send(context.Background(), message))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*-jane@example.org.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	contents, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+
+	require.Contains(t, string(contents), "From: syringe.sh <noreply@syringe.sh>\r\n")
+	require.Contains(t, string(contents), "To: jane@example.org\r\n")
+	require.Contains(t, string(contents), "Subject: Verify your email address\r\n")
+	require.Contains(t, string(contents), "\r\n\r\nYour verification code is 123456\r\n\r\nThanks\r\n")
+}
+
+func testLogMailer(t *testing.T) {
+	var out bytes.Buffer
+
+	m := mailer.NewLogMailer(&out)
+
+	require.NoError(t, m.Send(context.Background(), message))
+	require.Equal(
+		t,
+		"To: jane@example.org\nSubject: Verify your email address\n\nYour verification code is 123456\n\nThanks\n",
+		out.String(),
+	)
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RoundTripperMetrics are what an instrumented round tripper records.
+type RoundTripperMetrics struct {
+	// Requests is labelled by method and status code, which is 'error' for
+	// requests that got no response.
+	Requests *Counter
+	// Duration is labelled by method.
+	Duration *Histogram
+	// Errors is labelled by method, and counts requests that got no
+	// response, or a 429 or 5xx status.
+	Errors *Counter
+}
+
+type roundTripper struct {
+	metrics RoundTripperMetrics
+	next    http.RoundTripper
+}
+
+// InstrumentRoundTripper records requests sent through next, which is
+// http.DefaultTransport if nil.
+func InstrumentRoundTripper(metrics RoundTripperMetrics, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &roundTripper{metrics: metrics, next: next}
+}
+
+func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+
+	res, err := r.next.RoundTrip(req)
+
+	r.metrics.Duration.Observe(time.Since(start).Seconds(), req.Method)
+
+	if err != nil {
+		r.metrics.Requests.Inc(req.Method, "error")
+		r.metrics.Errors.Inc(req.Method)
+
+		return res, err
+	}
+
+	r.metrics.Requests.Inc(req.Method, strconv.Itoa(res.StatusCode))
+
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		r.metrics.Errors.Inc(req.Method)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,290 @@
+// Package metrics records counters, gauges and histograms, and serves them
+// in the Prometheus text format.
+//
+// See https://prometheus.io/docs/instrumenting/exposition_formats/ for the
+// format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets for durations in seconds, from 5ms
+// to 10s.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics, to be written out together.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	// buckets are the upper bounds of a histogram's buckets
+	buckets []float64
+	// fn is the value of a gauge func
+	fn func() float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// counts are a histogram's observations in each bucket, not cumulative
+	counts []uint64
+	count  uint64
+}
+
+// Counter only goes up.
+type Counter struct{ f *family }
+
+// Gauge goes up and down.
+type Gauge struct{ f *family }
+
+// Histogram counts observations into buckets.
+type Histogram struct{ f *family }
+
+// Counter adds a counter, with the names of its labels, whose values are
+// given in the same order when it's changed.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.add(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.add(&family{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// GaugeFunc adds a gauge whose value is got when it's written.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.add(&family{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// CounterFunc adds a counter whose value is got when it's written, for
+// counts kept elsewhere.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.add(&family{name: name, help: help, kind: "counter", fn: fn})
+}
+
+// Histogram adds a histogram, with the upper bounds of its buckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	return &Histogram{r.add(&family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+func (r *Registry) add(f *family) *family {
+	f.series = map[string]*series{}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.families = append(r.families, f)
+
+	return f
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds to the counter, which mustn't be negative.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.f.update(labelValues, func(s *series) { s.value += v })
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.value = v })
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.value++ })
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.f.update(labelValues, func(s *series) { s.value-- })
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.update(labelValues, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.f.buckets))
+		}
+
+		if i, _ := slices.BinarySearch(h.f.buckets, v); i < len(s.counts) {
+			s.counts[i]++
+		}
+
+		s.count++
+		s.value += v
+	})
+}
+
+func (f *family) update(labelValues []string, fn func(s *series)) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		f.series[key] = s
+	}
+
+	fn(s)
+}
+
+// Handler serves the metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// WriteTo writes the metrics in the Prometheus text format, in the order
+// they were added, with each metric's series sorted by their labels.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	for _, f := range families {
+		f.write(bw)
+	}
+
+	err := bw.Flush()
+
+	return cw.n, err
+}
+
+func (f *family) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	if f.fn != nil {
+		fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+
+		for i, upper := range f.buckets {
+			cumulative += s.counts[i]
+
+			fmt.Fprintf(
+				w,
+				"%s_bucket%s %d\n",
+				f.name,
+				formatLabels(append(slices.Clone(f.labels), "le"), append(slices.Clone(s.labelValues), formatFloat(upper))),
+				cumulative,
+			)
+		}
+
+		fmt.Fprintf(
+			w,
+			"%s_bucket%s %d\n",
+			f.name,
+			formatLabels(append(slices.Clone(f.labels), "le"), append(slices.Clone(s.labelValues), "+Inf")),
+			s.count,
+		)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues), s.count)
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(values[i]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,174 @@
+package metrics_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nixpig/syringe.sh/pkg/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	scenarios := map[string]struct {
+		record   func(r *metrics.Registry)
+		expected string
+	}{
+		"test counter": {
+			record: func(r *metrics.Registry) {
+				c := r.Counter("commands_total", "Commands run.", "command", "result")
+				c.Inc("syringe secret set", "ok")
+				c.Inc("syringe secret set", "ok")
+				c.Add(3, "syringe project add", "error")
+			},
+			expected: `# HELP commands_total Commands run.
+# TYPE commands_total counter
+commands_total{command="syringe project add",result="error"} 3
+commands_total{command="syringe secret set",result="ok"} 2
+`,
+		},
+		"test gauge": {
+			record: func(r *metrics.Registry) {
+				g := r.Gauge("sessions_active", "Sessions in progress.")
+				g.Inc()
+				g.Inc()
+				g.Dec()
+			},
+			expected: `# HELP sessions_active Sessions in progress.
+# TYPE sessions_active gauge
+sessions_active 1
+`,
+		},
+		"test gauge without series": {
+			record: func(r *metrics.Registry) {
+				r.Gauge("sessions_active", "Sessions in progress.")
+			},
+			expected: `# HELP sessions_active Sessions in progress.
+# TYPE sessions_active gauge
+`,
+		},
+		"test histogram": {
+			record: func(r *metrics.Registry) {
+				h := r.Histogram("duration_seconds", "How long it took.", []float64{1, 0.1}, "method")
+				h.Observe(0.05, "GET")
+				h.Observe(0.1, "GET")
+				h.Observe(0.5, "GET")
+				h.Observe(2, "GET")
+			},
+			expected: `# HELP duration_seconds How long it took.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{method="GET",le="0.1"} 2
+duration_seconds_bucket{method="GET",le="1"} 3
+duration_seconds_bucket{method="GET",le="+Inf"} 4
+duration_seconds_sum{method="GET"} 2.65
+duration_seconds_count{method="GET"} 4
+`,
+		},
+		"test funcs": {
+			record: func(r *metrics.Registry) {
+				r.GaugeFunc("connections_open", "Connections open.", func() float64 { return 3 })
+				r.CounterFunc("connection_hits_total", "Connections reused.", func() float64 { return 42 })
+			},
+			expected: `# HELP connections_open Connections open.
+# TYPE connections_open gauge
+connections_open 3
+# HELP connection_hits_total Connections reused.
+# TYPE connection_hits_total counter
+connection_hits_total 42
+`,
+		},
+		"test escaping": {
+			record: func(r *metrics.Registry) {
+				r.Counter("escaped_total", "A \\ help\ntext.", "value").Inc("a \"quoted\"\n\\value")
+			},
+			expected: `# HELP escaped_total A \\ help\ntext.
+# TYPE escaped_total counter
+escaped_total{value="a \"quoted\"\n\\value"} 1
+`,
+		},
+	}
+
+	for scenario, s := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			r := metrics.NewRegistry()
+			s.record(r)
+
+			var out bytes.Buffer
+			n, err := r.WriteTo(&out)
+			require.NoError(t, err)
+
+			require.Equal(t, s.expected, out.String())
+			require.Equal(t, int64(out.Len()), n)
+		})
+	}
+}
+
+func TestRegistryWrongLabels(t *testing.T) {
+	c := metrics.NewRegistry().Counter("commands_total", "Commands run.", "command")
+
+	require.Panics(t, func() { c.Inc() })
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("sessions_total", "Sessions started.").Inc()
+
+	res := httptest.NewRecorder()
+	r.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", res.Header().Get("Content-Type"))
+	require.Contains(t, res.Body.String(), "sessions_total 1\n")
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestInstrumentRoundTripper(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	roundTripperMetrics := metrics.RoundTripperMetrics{
+		Requests: r.Counter("requests_total", "Requests.", "method", "code"),
+		Duration: r.Histogram("request_duration_seconds", "Request durations.", []float64{10}, "method"),
+		Errors:   r.Counter("errors_total", "Errors.", "method"),
+	}
+
+	statuses := []int{http.StatusOK, http.StatusNotFound, http.StatusServiceUnavailable}
+
+	client := http.Client{Transport: metrics.InstrumentRoundTripper(
+		roundTripperMetrics,
+		roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if len(statuses) == 0 {
+				return nil, errors.New("connection refused")
+			}
+
+			status := statuses[0]
+			statuses = statuses[1:]
+
+			return &http.Response{StatusCode: status, Body: http.NoBody, Request: req}, nil
+		}),
+	)}
+
+	for range 4 {
+		res, err := client.Get("http://turso.test/v1/organizations")
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+
+	var out bytes.Buffer
+	_, err := r.WriteTo(&out)
+	require.NoError(t, err)
+
+	require.Contains(t, out.String(), `requests_total{method="GET",code="200"} 1`)
+	require.Contains(t, out.String(), `requests_total{method="GET",code="404"} 1`)
+	require.Contains(t, out.String(), `requests_total{method="GET",code="503"} 1`)
+	require.Contains(t, out.String(), `requests_total{method="GET",code="error"} 1`)
+	require.Contains(t, out.String(), `request_duration_seconds_count{method="GET"} 4`)
+	require.Contains(t, out.String(), `errors_total{method="GET"} 2`)
+}
@@ -0,0 +1,192 @@
+package ratelimit
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Settings are the limits a Guard applies to each address and each user.
+type Settings struct {
+	Connections Limit
+	Commands    Limit
+	// Failures are failed authentications allowed from an address before
+	// it's banned.
+	Failures    Limit
+	BanDuration time.Duration
+}
+
+// Guard limits connection attempts, failed authentications and commands, for
+// each address they come from and each user, and bans addresses for a while
+// after too many failures. Users are only limited once they've
+// authenticated, so that no one can use up, or be banned with, someone
+// else's limits by connecting as them.
+type Guard struct {
+	connections *Limiter
+	commands    *Limiter
+	failures    *Limiter
+	banDuration time.Duration
+	now         func() time.Time
+	stop        chan struct{}
+
+	mu   sync.Mutex
+	bans map[string]time.Time
+}
+
+type GuardOption func(g *Guard)
+
+// WithGuardClock sets the source of the current time.
+func WithGuardClock(now func() time.Time) GuardOption {
+	return func(g *Guard) {
+		g.now = now
+	}
+}
+
+func NewGuard(settings Settings, options ...GuardOption) *Guard {
+	g := &Guard{
+		banDuration: settings.BanDuration,
+		now:         time.Now,
+		stop:        make(chan struct{}),
+		bans:        map[string]time.Time{},
+	}
+
+	for _, option := range options {
+		option(g)
+	}
+
+	g.connections = NewLimiter(settings.Connections, WithClock(g.now))
+	g.commands = NewLimiter(settings.Commands, WithClock(g.now))
+	g.failures = NewLimiter(settings.Failures, WithClock(g.now))
+
+	return g
+}
+
+// Connect checks a connection attempt from an address, before it's known
+// who's connecting.
+func (g *Guard) Connect(addr net.Addr) error {
+	key := addrKey(addr)
+
+	if err := g.banned(key); err != nil {
+		return err
+	}
+
+	return take(g.connections, key)
+}
+
+// Session checks a session from an address running a command, before it's
+// known whether it's authenticated.
+func (g *Guard) Session(addr net.Addr) error {
+	key := addrKey(addr)
+
+	if err := g.banned(key); err != nil {
+		return err
+	}
+
+	return take(g.commands, key)
+}
+
+// User checks a user connecting and running a command, once they've
+// authenticated.
+func (g *Guard) User(username string) error {
+	key := userKey(username)
+
+	if err := take(g.connections, key); err != nil {
+		return err
+	}
+
+	return take(g.commands, key)
+}
+
+// Fail records a failed authentication from an address, banning it if it's
+// failed too many times.
+func (g *Guard) Fail(addr net.Addr) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := addrKey(addr)
+
+	if _, ok := g.failures.Take(key); !ok {
+		g.bans[key] = g.now().Add(g.banDuration)
+	}
+}
+
+// Prune forgets addresses and users that are no longer limited, and
+// addresses that are no longer banned.
+func (g *Guard) Prune() {
+	g.connections.Prune()
+	g.commands.Prune()
+	g.failures.Prune()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key, until := range g.bans {
+		if !g.now().Before(until) {
+			delete(g.bans, key)
+		}
+	}
+}
+
+// PruneEvery prunes on an interval, until the guard is closed.
+func (g *Guard) PruneEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			g.Prune()
+		case <-g.stop:
+			return
+		}
+	}
+}
+
+func (g *Guard) Close() {
+	close(g.stop)
+}
+
+func (g *Guard) banned(key string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	until, ok := g.bans[key]
+	if !ok {
+		return nil
+	}
+
+	if remaining := until.Sub(g.now()); remaining > 0 {
+		return &LimitedError{RetryAfter: remaining, Banned: true}
+	}
+
+	delete(g.bans, key)
+
+	return nil
+}
+
+func take(limiter *Limiter, key string) error {
+	if retryAfter, ok := limiter.Take(key); !ok {
+		return &LimitedError{RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// addrKey keys an address by its IP, since each connection comes from a
+// different port.
+func addrKey(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return "ip:" + tcpAddr.IP.String()
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "ip:" + addr.String()
+	}
+
+	return "ip:" + host
+}
+
+func userKey(username string) string {
+	return "user:" + username
+}
@@ -0,0 +1,144 @@
+// Package ratelimit limits how often something can be done, by key, with
+// token buckets.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+// LimitedError is returned when something can't be done until RetryAfter
+// has passed. It matches ErrRateLimited.
+type LimitedError struct {
+	RetryAfter time.Duration
+	// Banned is whether it's because of too many failures, rather than
+	// being done too often.
+	Banned bool
+}
+
+func (e *LimitedError) Error() string {
+	if e.Banned {
+		return fmt.Sprintf("%s after too many failures, try again in %s", ErrRateLimited, e.RetryIn())
+	}
+
+	return fmt.Sprintf("%s, try again in %s", ErrRateLimited, e.RetryIn())
+}
+
+// RetryIn is RetryAfter in whole seconds, rounded up, to tell users.
+func (e *LimitedError) RetryIn() time.Duration {
+	return max(time.Second, (e.RetryAfter + time.Second - 1).Truncate(time.Second))
+}
+
+func (e *LimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Limit is a token bucket: up to Burst at once, refilled at PerMinute. A
+// PerMinute of zero is no limit.
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter limits each key separately.
+type Limiter struct {
+	limit Limit
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type Option func(l *Limiter)
+
+// WithClock sets the source of the current time.
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+func NewLimiter(limit Limit, options ...Option) *Limiter {
+	l := &Limiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+
+	for _, option := range options {
+		option(l)
+	}
+
+	return l
+}
+
+// Take takes a token for the key, returning how long until there'll be one
+// if there isn't.
+func (l *Limiter) Take(key string) (time.Duration, bool) {
+	if l.limit.PerMinute == 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key)
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate() * float64(time.Second)), false
+	}
+
+	b.tokens--
+
+	return 0, true
+}
+
+// Prune forgets keys whose buckets have refilled, which are the same as
+// keys that have never been seen. It returns how many were forgotten.
+func (l *Limiter) Prune() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	pruned := 0
+
+	for key := range l.buckets {
+		if l.refill(key).tokens >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+			pruned++
+		}
+	}
+
+	return pruned
+}
+
+// refill tops up the key's bucket for the time since it was last used.
+func (l *Limiter) refill(key string) *bucket {
+	now := l.now()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.updated).Seconds()
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+elapsed*l.rate())
+	b.updated = now
+
+	return b
+}
+
+// rate is tokens per second.
+func (l *Limiter) rate() float64 {
+	return float64(l.limit.PerMinute) / 60
+}
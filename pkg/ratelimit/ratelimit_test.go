@@ -0,0 +1,225 @@
+package ratelimit_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nixpig/syringe.sh/pkg/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func TestLimiter(t *testing.T) {
+	scenarios := map[string]func(t *testing.T, c *clock, limiter *ratelimit.Limiter){
+		"test limiter allows burst":           testLimiterAllowsBurst,
+		"test limiter refills":                testLimiterRefills,
+		"test limiter limits keys separately": testLimiterLimitsKeysSeparately,
+		"test limiter prunes refilled keys":   testLimiterPrunesRefilledKeys,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			c := newClock()
+
+			limiter := ratelimit.NewLimiter(
+				ratelimit.Limit{PerMinute: 6, Burst: 3},
+				ratelimit.WithClock(c.Now),
+			)
+
+			fn(t, c, limiter)
+		})
+	}
+
+	t.Run("test limiter without limit", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter(ratelimit.Limit{})
+
+		for range 100 {
+			_, ok := limiter.Take("janedoe")
+			require.True(t, ok)
+		}
+	})
+}
+
+func testLimiterAllowsBurst(t *testing.T, c *clock, limiter *ratelimit.Limiter) {
+	for range 3 {
+		_, ok := limiter.Take("janedoe")
+		require.True(t, ok)
+	}
+
+	retryAfter, ok := limiter.Take("janedoe")
+	require.False(t, ok)
+	require.Equal(t, 10*time.Second, retryAfter)
+}
+
+func testLimiterRefills(t *testing.T, c *clock, limiter *ratelimit.Limiter) {
+	for range 3 {
+		limiter.Take("janedoe")
+	}
+
+	c.Advance(5 * time.Second)
+
+	retryAfter, ok := limiter.Take("janedoe")
+	require.False(t, ok)
+	require.Equal(t, 5*time.Second, retryAfter)
+
+	c.Advance(5 * time.Second)
+
+	_, ok = limiter.Take("janedoe")
+	require.True(t, ok)
+
+	_, ok = limiter.Take("janedoe")
+	require.False(t, ok)
+}
+
+func testLimiterLimitsKeysSeparately(t *testing.T, c *clock, limiter *ratelimit.Limiter) {
+	for range 3 {
+		limiter.Take("janedoe")
+	}
+
+	_, ok := limiter.Take("johndoe")
+	require.True(t, ok)
+}
+
+func testLimiterPrunesRefilledKeys(t *testing.T, c *clock, limiter *ratelimit.Limiter) {
+	limiter.Take("janedoe")
+
+	c.Advance(5 * time.Second)
+	limiter.Take("johndoe")
+
+	c.Advance(5 * time.Second)
+
+	// only janedoe's bucket has refilled
+	require.Equal(t, 1, limiter.Prune())
+
+	c.Advance(5 * time.Second)
+
+	require.Equal(t, 1, limiter.Prune())
+	require.Equal(t, 0, limiter.Prune())
+}
+
+func TestGuard(t *testing.T) {
+	scenarios := map[string]func(t *testing.T, c *clock, guard *ratelimit.Guard){
+		"test guard limits connections by address": testGuardLimitsConnectionsByAddress,
+		"test guard limits commands by user":       testGuardLimitsCommandsByUser,
+		"test guard limits commands by address":    testGuardLimitsCommandsByAddress,
+		"test guard bans after failures":           testGuardBansAfterFailures,
+		"test guard ban expires":                   testGuardBanExpires,
+		"test guard failures don't limit user":     testGuardFailuresDontLimitUser,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			c := newClock()
+
+			guard := ratelimit.NewGuard(
+				ratelimit.Settings{
+					Connections: ratelimit.Limit{PerMinute: 60, Burst: 2},
+					Commands:    ratelimit.Limit{PerMinute: 60, Burst: 2},
+					Failures:    ratelimit.Limit{PerMinute: 1, Burst: 2},
+					BanDuration: 15 * time.Minute,
+				},
+				ratelimit.WithGuardClock(c.Now),
+			)
+
+			fn(t, c, guard)
+		})
+	}
+}
+
+func addr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 54321}
+}
+
+func testGuardLimitsConnectionsByAddress(t *testing.T, c *clock, guard *ratelimit.Guard) {
+	require.NoError(t, guard.Connect(addr("192.168.1.23")))
+	require.NoError(t, guard.Connect(&net.TCPAddr{IP: net.ParseIP("192.168.1.23"), Port: 12345}))
+
+	err := guard.Connect(addr("192.168.1.23"))
+	require.ErrorIs(t, err, ratelimit.ErrRateLimited)
+	require.EqualError(t, err, "rate limited, try again in 1s")
+
+	require.NoError(t, guard.Connect(addr("192.168.1.42")))
+}
+
+func testGuardLimitsCommandsByUser(t *testing.T, c *clock, guard *ratelimit.Guard) {
+	require.NoError(t, guard.User("janedoe"))
+	require.NoError(t, guard.User("janedoe"))
+
+	require.ErrorIs(t, guard.User("janedoe"), ratelimit.ErrRateLimited)
+	require.NoError(t, guard.User("johndoe"))
+}
+
+func testGuardLimitsCommandsByAddress(t *testing.T, c *clock, guard *ratelimit.Guard) {
+	require.NoError(t, guard.Session(addr("192.168.1.23")))
+	require.NoError(t, guard.Session(addr("192.168.1.23")))
+
+	require.ErrorIs(t, guard.Session(addr("192.168.1.23")), ratelimit.ErrRateLimited)
+	require.NoError(t, guard.Session(addr("192.168.1.42")))
+}
+
+func testGuardBansAfterFailures(t *testing.T, c *clock, guard *ratelimit.Guard) {
+	guard.Fail(addr("192.168.1.23"))
+	guard.Fail(addr("192.168.1.23"))
+
+	require.NoError(t, guard.Connect(addr("192.168.1.23")))
+
+	guard.Fail(addr("192.168.1.23"))
+
+	err := guard.Connect(addr("192.168.1.23"))
+	require.EqualError(t, err, "rate limited after too many failures, try again in 15m0s")
+
+	var limited *ratelimit.LimitedError
+	require.ErrorAs(t, err, &limited)
+	require.True(t, limited.Banned)
+
+	require.ErrorIs(t, guard.Session(addr("192.168.1.23")), ratelimit.ErrRateLimited)
+	require.NoError(t, guard.Session(addr("192.168.1.42")))
+}
+
+func testGuardBanExpires(t *testing.T, c *clock, guard *ratelimit.Guard) {
+	for range 3 {
+		guard.Fail(addr("192.168.1.23"))
+	}
+
+	c.Advance(14 * time.Minute)
+	require.ErrorIs(t, guard.Connect(addr("192.168.1.23")), ratelimit.ErrRateLimited)
+
+	c.Advance(time.Minute)
+	require.NoError(t, guard.Connect(addr("192.168.1.23")))
+	require.NoError(t, guard.Session(addr("192.168.1.23")))
+}
+
+func testGuardFailuresDontLimitUser(t *testing.T, c *clock, guard *ratelimit.Guard) {
+	// failing to connect as someone else doesn't ban them
+	for range 3 {
+		guard.Fail(addr("192.168.1.23"))
+	}
+
+	require.ErrorIs(t, guard.Session(addr("192.168.1.23")), ratelimit.ErrRateLimited)
+	require.NoError(t, guard.Session(addr("192.168.1.42")))
+	require.NoError(t, guard.User("janedoe"))
+}
+
+func TestLimitedError(t *testing.T) {
+	err := &ratelimit.LimitedError{RetryAfter: 1500 * time.Millisecond}
+	require.Equal(t, 2*time.Second, err.RetryIn())
+
+	err = &ratelimit.LimitedError{RetryAfter: time.Millisecond}
+	require.Equal(t, time.Second, err.RetryIn())
+}
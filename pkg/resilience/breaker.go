@@ -0,0 +1,105 @@
+package resilience
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit open, too many recent failures")
+
+// Breaker stops calls to a dependency after it fails too many times in a
+// row, so that callers fail fast instead of waiting on it. After a cooldown,
+// a single call is let through to test it; if that succeeds the breaker
+// closes, otherwise it opens again.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	failedAt time.Time
+	openedAt time.Time
+	trial    bool
+}
+
+type BreakerOption func(b *Breaker)
+
+// WithBreakerClock sets the source of the current time.
+func WithBreakerClock(now func() time.Time) BreakerOption {
+	return func(b *Breaker) {
+		b.now = now
+	}
+}
+
+func NewBreaker(threshold int, cooldown time.Duration, options ...BreakerOption) *Breaker {
+	b := &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	return b
+}
+
+// Allow returns ErrCircuitOpen if a call shouldn't be made. Otherwise, the
+// outcome of the call must be passed to Record.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+
+	if b.trial || b.now().Sub(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+
+	b.trial = true
+
+	return nil
+}
+
+// Record counts the outcome of a call.
+func (b *Breaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+
+	if success {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.failedAt = b.now()
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
+
+// Open reports whether calls are currently being refused.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures >= b.threshold && (b.trial || b.now().Sub(b.openedAt) < b.cooldown)
+}
+
+// Settled reports whether the breaker has nothing worth remembering: there
+// have been no failures since the last success, or none for the cooldown.
+// A settled breaker can be replaced by a new one without letting through
+// calls it would have refused.
+func (b *Breaker) Settled() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.failures == 0 || (!b.trial && b.now().Sub(b.failedAt) >= b.cooldown)
+}
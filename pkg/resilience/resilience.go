@@ -0,0 +1,177 @@
+// Package resilience retries operations that fail transiently, backing off
+// exponentially between attempts, and stops calling dependencies that keep
+// failing.
+package resilience
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"regexp"
+	"syscall"
+	"time"
+)
+
+// Policy describes how an operation is retried.
+type Policy struct {
+	// MaxAttempts is the most times the operation is tried, including the
+	// first. Zero or less tries until the context is done.
+	MaxAttempts int
+
+	// InitialDelay is how long to wait before the first retry. Each retry
+	// after waits Multiplier times longer, up to MaxDelay.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+
+	// Jitter is the fraction of each delay, from 0 to 1, that's randomised, so
+	// that clients failing together don't retry together.
+	Jitter float64
+
+	// Retryable reports whether an error is worth retrying. It defaults to
+	// Retryable.
+	Retryable func(err error) bool
+
+	// Repeatable reports whether an operation that failed with a retryable
+	// error is safe to try again, e.g. because the error shows it had no
+	// effect. It defaults to always, so it only needs setting for operations
+	// that mustn't be repeated once they might have taken effect.
+	Repeatable func(err error) bool
+
+	// Breaker, if set, is checked before each attempt and told how it went.
+	Breaker *Breaker
+}
+
+// DefaultPolicy suits a call to a remote API made while a user waits.
+var DefaultPolicy = Policy{
+	MaxAttempts:  4,
+	InitialDelay: 200 * time.Millisecond,
+	MaxDelay:     5 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
+
+// Delay is how long to wait before a retry, counting from 1.
+func (p Policy) Delay(retry int) time.Duration {
+	delay := float64(p.InitialDelay)
+
+	for i := 1; i < retry; i++ {
+		delay *= p.Multiplier
+		if p.MaxDelay > 0 && delay >= float64(p.MaxDelay) {
+			break
+		}
+	}
+
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// Retry calls fn until it succeeds, fails with an error that isn't
+// retryable, runs out of attempts or the context is done. The error from the
+// last attempt is returned.
+func Retry(ctx context.Context, policy Policy, fn func(ctx context.Context) error) error {
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = Retryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := try(ctx, policy.Breaker, retryable, fn)
+		if err == nil || !retryable(err) {
+			return err
+		}
+
+		if (policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts) ||
+			(policy.Repeatable != nil && !policy.Repeatable(err)) {
+			if attempt == 1 {
+				return err
+			}
+
+			return fmt.Errorf("%w (after %d attempts)", err, attempt)
+		}
+
+		timer := time.NewTimer(policy.Delay(attempt))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+func try(
+	ctx context.Context,
+	breaker *Breaker,
+	retryable func(err error) bool,
+	fn func(ctx context.Context) error,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if breaker == nil {
+		return fn(ctx)
+	}
+
+	if err := breaker.Allow(); err != nil {
+		return err
+	}
+
+	err := fn(ctx)
+
+	// only failures of the dependency itself count towards opening it
+	breaker.Record(err == nil || !retryable(err))
+
+	return err
+}
+
+// libsqlStatusError matches the errors libsql returns for an unsuccessful
+// HTTP status, which don't wrap anything that can be inspected.
+var libsqlStatusError = regexp.MustCompile(`error code (429|5\d\d)\b|got (429|5\d\d)\b`)
+
+// Retryable reports whether an error is likely to be transient: a response
+// with status 429 or 5xx, or a network failure talking to an API or database.
+func Retryable(err error) bool {
+	if err == nil ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	var statusErr interface{ Status() int }
+	if errors.As(err, &statusErr) {
+		status := statusErr.Status()
+		return status == http.StatusTooManyRequests || status >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	return libsqlStatusError.MatchString(err.Error())
+}
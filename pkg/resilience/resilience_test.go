@@ -0,0 +1,288 @@
+package resilience_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nixpig/syringe.sh/pkg/resilience"
+	"github.com/nixpig/syringe.sh/pkg/turso"
+	"github.com/stretchr/testify/require"
+)
+
+var testPolicy = resilience.Policy{
+	MaxAttempts:  3,
+	InitialDelay: time.Millisecond,
+	MaxDelay:     5 * time.Millisecond,
+	Multiplier:   2,
+}
+
+var errTransient = turso.WrapErr(http.StatusServiceUnavailable, "unavailable")
+
+func TestRetry(t *testing.T) {
+	scenarios := map[string]func(t *testing.T){
+		"test retry until success":       testRetryUntilSuccess,
+		"test retry not retryable":       testRetryNotRetryable,
+		"test retry out of attempts":     testRetryOutOfAttempts,
+		"test retry cancelled":           testRetryCancelled,
+		"test retry custom retryable":    testRetryCustomRetryable,
+		"test retry not repeatable":      testRetryNotRepeatable,
+		"test retry opens breaker":       testRetryOpensBreaker,
+		"test delay backs off":           testDelayBacksOff,
+		"test delay with jitter":         testDelayWithJitter,
+		"test breaker closes on success": testBreakerClosesOnSuccess,
+		"test breaker settles":           testBreakerSettles,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, fn)
+	}
+}
+
+func testRetryUntilSuccess(t *testing.T) {
+	attempts := 0
+
+	err := resilience.Retry(context.Background(), testPolicy, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errTransient
+		}
+
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts)
+}
+
+func testRetryNotRetryable(t *testing.T) {
+	attempts := 0
+
+	err := resilience.Retry(context.Background(), testPolicy, func(ctx context.Context) error {
+		attempts++
+		return turso.WrapErr(http.StatusNotFound, "not found")
+	})
+
+	require.ErrorAs(t, err, &turso.ErrNotFound{})
+	require.Equal(t, 1, attempts)
+}
+
+func testRetryOutOfAttempts(t *testing.T) {
+	attempts := 0
+
+	err := resilience.Retry(context.Background(), testPolicy, func(ctx context.Context) error {
+		attempts++
+		return errTransient
+	})
+
+	require.ErrorIs(t, err, errTransient)
+	require.ErrorContains(t, err, "after 3 attempts")
+	require.Equal(t, 3, attempts)
+}
+
+func testRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	policy := testPolicy
+	policy.MaxAttempts = 0
+	policy.InitialDelay = time.Hour
+
+	attempts := 0
+
+	err := resilience.Retry(ctx, policy, func(ctx context.Context) error {
+		attempts++
+		cancel()
+		return errTransient
+	})
+
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, err, errTransient)
+	require.Equal(t, 1, attempts)
+}
+
+func testRetryCustomRetryable(t *testing.T) {
+	policy := testPolicy
+	policy.Retryable = func(err error) bool { return true }
+
+	attempts := 0
+
+	err := resilience.Retry(context.Background(), policy, func(ctx context.Context) error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("no such table")
+		}
+
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 2, attempts)
+}
+
+func testRetryNotRepeatable(t *testing.T) {
+	attempts := 0
+
+	policy := testPolicy
+	policy.MaxAttempts = 5
+	policy.Repeatable = func(err error) bool {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+
+	err := resilience.Retry(context.Background(), policy, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return syscall.ECONNREFUSED
+		}
+
+		return syscall.ECONNRESET
+	})
+
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+	require.Equal(t, 3, attempts)
+}
+
+func testRetryOpensBreaker(t *testing.T) {
+	now := time.Now()
+
+	policy := testPolicy
+	policy.Breaker = resilience.NewBreaker(
+		2,
+		time.Minute,
+		resilience.WithBreakerClock(func() time.Time { return now }),
+	)
+
+	attempts := 0
+
+	err := resilience.Retry(context.Background(), policy, func(ctx context.Context) error {
+		attempts++
+		return errTransient
+	})
+
+	require.ErrorIs(t, err, resilience.ErrCircuitOpen)
+	require.Equal(t, 2, attempts)
+	require.True(t, policy.Breaker.Open())
+
+	// failures that aren't the dependency's fault don't count
+	now = now.Add(time.Minute)
+
+	err = resilience.Retry(context.Background(), policy, func(ctx context.Context) error {
+		attempts++
+		return turso.WrapErr(http.StatusBadRequest, "bad request")
+	})
+
+	require.ErrorAs(t, err, &turso.ErrBadRequest{})
+	require.Equal(t, 3, attempts)
+	require.False(t, policy.Breaker.Open())
+}
+
+func testDelayBacksOff(t *testing.T) {
+	policy := resilience.Policy{
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     time.Second,
+		Multiplier:   2,
+	}
+
+	require.Equal(t, 100*time.Millisecond, policy.Delay(1))
+	require.Equal(t, 200*time.Millisecond, policy.Delay(2))
+	require.Equal(t, 800*time.Millisecond, policy.Delay(4))
+	require.Equal(t, time.Second, policy.Delay(5))
+	require.Equal(t, time.Second, policy.Delay(100))
+}
+
+func testDelayWithJitter(t *testing.T) {
+	policy := resilience.Policy{
+		InitialDelay: 100 * time.Millisecond,
+		Multiplier:   2,
+		Jitter:       0.5,
+	}
+
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(2)
+		require.GreaterOrEqual(t, delay, 100*time.Millisecond)
+		require.LessOrEqual(t, delay, 300*time.Millisecond)
+	}
+}
+
+func testBreakerClosesOnSuccess(t *testing.T) {
+	now := time.Now()
+
+	breaker := resilience.NewBreaker(
+		1,
+		time.Minute,
+		resilience.WithBreakerClock(func() time.Time { return now }),
+	)
+
+	require.NoError(t, breaker.Allow())
+	breaker.Record(false)
+	require.ErrorIs(t, breaker.Allow(), resilience.ErrCircuitOpen)
+
+	// after the cooldown, a single trial call is let through
+	now = now.Add(time.Minute)
+	require.NoError(t, breaker.Allow())
+	require.ErrorIs(t, breaker.Allow(), resilience.ErrCircuitOpen)
+
+	breaker.Record(false)
+	require.ErrorIs(t, breaker.Allow(), resilience.ErrCircuitOpen)
+
+	now = now.Add(time.Minute)
+	require.NoError(t, breaker.Allow())
+	breaker.Record(true)
+
+	require.False(t, breaker.Open())
+	require.NoError(t, breaker.Allow())
+}
+
+func testBreakerSettles(t *testing.T) {
+	now := time.Now()
+
+	breaker := resilience.NewBreaker(
+		2,
+		time.Minute,
+		resilience.WithBreakerClock(func() time.Time { return now }),
+	)
+
+	require.True(t, breaker.Settled())
+
+	// a failure short of opening the breaker still counts
+	breaker.Record(false)
+	require.False(t, breaker.Open())
+	require.False(t, breaker.Settled())
+
+	now = now.Add(time.Minute)
+	require.True(t, breaker.Settled())
+
+	breaker.Record(false)
+	breaker.Record(true)
+	require.True(t, breaker.Settled())
+}
+
+func TestRetryable(t *testing.T) {
+	scenarios := map[string]struct {
+		err       error
+		retryable bool
+	}{
+		"too many requests": {turso.WrapErr(http.StatusTooManyRequests, "slow down"), true},
+		"server error":      {turso.WrapErr(http.StatusBadGateway, "bad gateway"), true},
+		"not found":         {turso.WrapErr(http.StatusNotFound, "not found"), false},
+		"network error":     {&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		"bad connection":    {fmt.Errorf("stream is closed: %w", driver.ErrBadConn), true},
+		"libsql status":     {errors.New("error code 503: unavailable"), true},
+		"libsql handshake":  {errors.New("expected handshake response status code 101 but got 502"), true},
+		"cancelled":         {fmt.Errorf("failed: %w", context.Canceled), false},
+		"circuit open":      {resilience.ErrCircuitOpen, false},
+		"sql error":         {errors.New("no such table: secrets_"), false},
+		"nil":               {nil, false},
+	}
+
+	for scenario, s := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			require.Equal(t, s.retryable, resilience.Retryable(s.err))
+		})
+	}
+}
@@ -3,6 +3,7 @@ package serrors
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -40,12 +41,39 @@ func ErrNoProjects(err error) error {
 }
 
 var (
-	ErrNoProjectsFound     = fmt.Errorf("no projects found")
-	ErrNoEnvironmentsFound = fmt.Errorf("no environments found")
-	ErrNoSecretsFound      = fmt.Errorf("no secrets found")
-	ErrProjectNotFound     = fmt.Errorf("project not found")
-	ErrEnvironmentNotFound = fmt.Errorf("environment not found")
-	ErrSecretNotFound      = fmt.Errorf("secret not found")
+	ErrNoProjectsFound         = fmt.Errorf("no projects found")
+	ErrNoEnvironmentsFound     = fmt.Errorf("no environments found")
+	ErrNoSecretsFound          = fmt.Errorf("no secrets found")
+	ErrProjectNotFound         = fmt.Errorf("project not found")
+	ErrEnvironmentNotFound     = fmt.Errorf("environment not found")
+	ErrSecretNotFound          = fmt.Errorf("secret not found")
+	ErrUserNotFound            = fmt.Errorf("user not found")
+	ErrNotOrgMember            = fmt.Errorf("not a member of organisation")
+	ErrOrgNotFound             = fmt.Errorf("organisation not found")
+	ErrOrgPermissionDenied     = fmt.Errorf("only organisation owners can manage members")
+	ErrOrgOwnerRemoval         = fmt.Errorf("organisation owners cannot remove themselves")
+	ErrOrgOwnerDeletion        = fmt.Errorf("organisation owners cannot delete their account")
+	ErrOrgRequired             = fmt.Errorf("roles can only be managed within an organisation (use --org)")
+	ErrNotAuthenticated        = fmt.Errorf("not authenticated")
+	ErrPermissionDenied        = fmt.Errorf("permission denied")
+	ErrRoleNotFound            = fmt.Errorf("role not found")
+	ErrShareNotFound           = fmt.Errorf("share not found")
+	ErrShareWithSelf           = fmt.Errorf("cannot share an environment with yourself")
+	ErrShareInOrg              = fmt.Errorf("only environments in your own projects can be shared")
+	ErrAuditChainBroken        = fmt.Errorf("audit log chain is broken")
+	ErrSecretsExpired          = fmt.Errorf("secrets have expired")
+	ErrUsernameTaken           = fmt.Errorf("username is already taken")
+	ErrKeyRegistered           = fmt.Errorf("public key is already registered to another user")
+	ErrNotVerified             = fmt.Errorf("email address not verified")
+	ErrInvalidCode             = fmt.Errorf("verification code is invalid or has expired")
+	ErrAccountSuspended        = fmt.Errorf("account suspended")
+	ErrAccountLocked           = fmt.Errorf("account locked")
+	ErrKeyNotFound             = fmt.Errorf("key not found")
+	ErrNotAdmin                = fmt.Errorf("admin commands can only be run with an admin key")
+	ErrKeyAlgorithm            = fmt.Errorf("key algorithm not allowed")
+	ErrKeyTooSmall             = fmt.Errorf("key too small")
+	ErrCertificateNotTrusted   = fmt.Errorf("certificate not trusted")
+	ErrCertificateRegistration = fmt.Errorf("certificates can't be registered, register a key first")
 )
 
 type ErrValidation struct{ msg string }
@@ -67,6 +95,22 @@ func ValidationError(err error) error {
 					e.Field(),
 					e.Param(),
 				)})
+			case "oneof":
+				errs = append(errs, ErrValidation{msg: fmt.Sprintf(
+					"\"%s\" must be one of: %s",
+					e.Field(),
+					strings.Join(strings.Fields(e.Param()), ", "),
+				)})
+			case "required":
+				errs = append(errs, ErrValidation{msg: fmt.Sprintf(
+					"\"%s\" is required",
+					e.Field(),
+				)})
+			default:
+				errs = append(errs, ErrValidation{msg: fmt.Sprintf(
+					"\"%s\" is invalid",
+					e.Field(),
+				)})
 			}
 		}
 
@@ -6,7 +6,10 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/nixpig/syringe.sh/pkg/hostkeys"
 	"github.com/skeema/knownhosts"
 	gossh "golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
@@ -15,9 +18,24 @@ import (
 
 type SSHClient struct {
 	client *gossh.Client
+	// flush asks for requests the server has already sent, such as
+	// advertising its host keys, to be handled
+	flush chan chan struct{}
+	done  chan struct{}
 }
 
 func (s *SSHClient) Close() error {
+	// the reply comes after any requests the server sent before it
+	s.client.SendRequest(keepaliveRequest, true, nil)
+
+	ack := make(chan struct{})
+
+	select {
+	case s.flush <- ack:
+		<-ack
+	case <-s.done:
+	}
+
 	if err := s.client.Close(); err != nil {
 		return err
 	}
@@ -48,28 +66,56 @@ func (s *SSHClient) Run(cmd string, w io.Writer) error {
 	return nil
 }
 
+// RunWithStderr runs cmd, keeping its stdout and stderr apart.
+func (s *SSHClient) RunWithStderr(cmd string, stdout, stderr io.Writer) error {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	return session.Run(cmd)
+}
+
+const keepaliveRequest = "keepalive@openssh.com"
+
 func NewSSHClient(
 	host string,
 	port int,
 	username string,
 	authMethod gossh.AuthMethod,
 ) (*SSHClient, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	khPath := filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+
+	var hostKey gossh.PublicKey
+	var addresses []string
+
 	sshConfig := &gossh.ClientConfig{
 		User: username,
 		Auth: []gossh.AuthMethod{authMethod},
 
 		HostKeyCallback: gossh.HostKeyCallback(func(hostname string, remote net.Addr, key gossh.PublicKey) error {
-			khPath := filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
-
 			kh, err := knownhosts.New(khPath)
 			if err != nil {
 				return fmt.Errorf("unable to open knownhosts file: %w", err)
 			}
 
-			err = kh(fmt.Sprintf("%s:%d", host, port), remote, key)
+			hostKey = key
+			addresses = knownHostsAddresses(hostname, remote)
+
+			err = kh(addr, remote, key)
 
 			if knownhosts.IsHostKeyChanged(err) {
-				return fmt.Errorf("remote host identification has changed which may indicate a MITM attack: %w", err)
+				// a host rotating its key is known by more than one key of the same type
+				known, knownErr := knownHostsFile(khPath).knows(addresses, key)
+				if knownErr != nil || !known {
+					return fmt.Errorf("remote host identification has changed which may indicate a MITM attack: %w", err)
+				}
 			}
 
 			if knownhosts.IsHostUnknown(err) {
@@ -91,14 +137,138 @@ func NewSSHClient(
 		}),
 	}
 
-	conn, err := gossh.Dial("tcp", fmt.Sprintf("%s:%d", host, port), sshConfig)
+	// servers offering several host keys are asked for one that's already
+	// known, so they aren't mistaken for having changed
+	if kh, err := knownhosts.New(khPath); err == nil {
+		sshConfig.HostKeyAlgorithms = kh.HostKeyAlgorithms(addr)
+	}
+
+	netConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, chans, reqs, err := gossh.NewClientConn(netConn, addr, sshConfig)
 	if err != nil {
+		netConn.Close()
 		return nil, err
 	}
 
-	return &SSHClient{
-		conn,
-	}, nil
+	forwarded := make(chan *gossh.Request)
+
+	s := &SSHClient{
+		client: gossh.NewClient(conn, chans, forwarded),
+		flush:  make(chan chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go s.handleGlobalRequests(reqs, forwarded, func(payload []byte) {
+		if err := s.learnHostKeys(knownHostsFile(khPath), addresses, hostKey, payload); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to update known hosts: %s\n", err)
+		}
+	})
+
+	return s, nil
+}
+
+// handleGlobalRequests learns the host keys the server advertises, passing
+// any other requests on to the client.
+func (s *SSHClient) handleGlobalRequests(
+	in <-chan *gossh.Request,
+	out chan<- *gossh.Request,
+	learn func(payload []byte),
+) {
+	defer close(s.done)
+	defer close(out)
+
+	handle := func(req *gossh.Request) {
+		if req.Type == hostkeys.AdvertiseRequest {
+			learn(req.Payload)
+			return
+		}
+
+		out <- req
+	}
+
+	for {
+		select {
+		case req, ok := <-in:
+			if !ok {
+				return
+			}
+
+			handle(req)
+
+		case ack := <-s.flush:
+			for drained := false; !drained; {
+				select {
+				case req, ok := <-in:
+					if !ok {
+						close(ack)
+						return
+					}
+
+					handle(req)
+				default:
+					drained = true
+				}
+			}
+
+			close(ack)
+		}
+	}
+}
+
+// learnHostKeys updates known_hosts with the host keys the server
+// advertised, once it's proven it has any that weren't already known. Keys
+// it no longer advertises are forgotten.
+func (s *SSHClient) learnHostKeys(
+	file knownHostsFile,
+	addresses []string,
+	hostKey gossh.PublicKey,
+	payload []byte,
+) error {
+	keys, err := hostkeys.ParseKeys(payload)
+	if err != nil {
+		return err
+	}
+
+	// the key the server has just proven must be one of them
+	if !containsKey(keys, hostKey) {
+		return nil
+	}
+
+	var unknown []gossh.PublicKey
+
+	for _, key := range keys {
+		known, err := file.knows(addresses, key)
+		if err != nil {
+			return err
+		}
+
+		if !known {
+			unknown = append(unknown, key)
+		}
+	}
+
+	if len(unknown) > 0 {
+		ok, response, err := s.client.SendRequest(hostkeys.ProveRequest, true, hostkeys.MarshalKeys(unknown))
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return fmt.Errorf("server refused to prove its host keys")
+		}
+
+		if err := hostkeys.VerifyProofs(unknown, s.client.SessionID(), response); err != nil {
+			return err
+		}
+	}
+
+	_, _, err = file.update(addresses, keys)
+
+	return err
 }
 
 func AgentAuthMethod(sshAuthSock string) (gossh.AuthMethod, error) {
@@ -129,6 +299,14 @@ func IdentityAuthMethod(identity string) (gossh.AuthMethod, error) {
 	if err != nil {
 		_, ok := err.(*gossh.PassphraseMissingError)
 		if !ok {
+			if isSecurityKey(identity) {
+				return nil, fmt.Errorf(
+					"security keys can't be used as an identity file, add it to ssh-agent with 'ssh-add %s' instead:\n%w",
+					identity,
+					err,
+				)
+			}
+
 			return nil, err
 		}
 
@@ -149,3 +327,19 @@ func IdentityAuthMethod(identity string) (gossh.AuthMethod, error) {
 
 	return authMethod, nil
 }
+
+// isSecurityKey reports whether the identity's public key, alongside it, is
+// backed by a FIDO security key, which only ssh-agent can sign with.
+func isSecurityKey(identity string) bool {
+	b, err := os.ReadFile(identity + ".pub")
+	if err != nil {
+		return false
+	}
+
+	key, _, _, _, err := gossh.ParseAuthorizedKey(b)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(key.Type(), "sk-")
+}
@@ -0,0 +1,228 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skeema/knownhosts"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// knownHostsFile is a known_hosts file. Unlike knownhosts.New, which only
+// checks the first key of each type for a host, it considers every key, so
+// a host can be known by both the key it's rotating out and the one it's
+// rotating in.
+type knownHostsFile string
+
+type knownHostsLine struct {
+	raw string
+	// hosts and key are only set for lines that are a host's key, rather
+	// than e.g. a comment or a CA
+	hosts []string
+	key   gossh.PublicKey
+}
+
+// knownHostsAddresses are the addresses a host is recorded under, as
+// knownhosts.WriteKnownHost records them.
+func knownHostsAddresses(hostname string, remote net.Addr) []string {
+	addresses := []string{hostname}
+
+	if remote != nil {
+		normalized := knownhosts.Normalize(remote.String())
+		if normalized != "[0.0.0.0]:0" && normalized != knownhosts.Normalize(hostname) {
+			addresses = append(addresses, remote.String())
+		}
+	}
+
+	return addresses
+}
+
+func (f knownHostsFile) read() ([]knownHostsLine, error) {
+	b, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	var lines []knownHostsLine
+
+	for _, raw := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		line := knownHostsLine{raw: raw}
+
+		marker, hosts, key, _, _, err := gossh.ParseKnownHosts([]byte(raw))
+		if err == nil && marker == "" {
+			line.hosts = hosts
+			line.key = key
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+// knows reports whether key is known for the host at any of the addresses.
+func (f knownHostsFile) knows(addresses []string, key gossh.PublicKey) (bool, error) {
+	lines, err := f.read()
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range lines {
+		if matchesAny, _ := line.matches(addresses); matchesAny && keysEqual(line.key, key) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// update records keys as the host's keys. Keys that are new are added, and
+// keys that aren't in keys are removed from lines that are only for the
+// host. It returns how many keys were added and removed.
+func (f knownHostsFile) update(addresses []string, keys []gossh.PublicKey) (int, int, error) {
+	lines, err := f.read()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var kept []string
+	var known []gossh.PublicKey
+	removed := 0
+
+	for _, line := range lines {
+		matchesAny, matchesOnly := line.matches(addresses)
+
+		if matchesOnly && !containsKey(keys, line.key) {
+			removed++
+			continue
+		}
+
+		if matchesAny {
+			known = append(known, line.key)
+		}
+
+		kept = append(kept, line.raw)
+	}
+
+	added := 0
+
+	for _, key := range keys {
+		if !containsKey(known, key) {
+			kept = append(kept, knownhosts.Line(addresses, key))
+			added++
+		}
+	}
+
+	if added == 0 && removed == 0 {
+		return 0, 0, nil
+	}
+
+	// written alongside and renamed, so the file is never left half-written
+	tmp, err := os.CreateTemp(filepath.Dir(string(f)), ".known_hosts")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strings.Join(kept, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return 0, 0, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	if info, err := os.Stat(string(f)); err == nil {
+		if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), string(f)); err != nil {
+		return 0, 0, err
+	}
+
+	return added, removed, nil
+}
+
+// matches reports whether the line is a key for any of the addresses, and
+// whether every host it's for is one of them.
+func (l knownHostsLine) matches(addresses []string) (bool, bool) {
+	if l.key == nil {
+		return false, false
+	}
+
+	matchesAny, matchesAll := false, true
+
+	for _, pattern := range l.hosts {
+		matched := false
+
+		for _, address := range addresses {
+			if matchesHost(pattern, address) {
+				matched = true
+				break
+			}
+		}
+
+		matchesAny = matchesAny || matched
+		matchesAll = matchesAll && matched
+	}
+
+	return matchesAny, matchesAny && matchesAll
+}
+
+// matchesHost reports whether a known_hosts host pattern, either plain or
+// hashed, is the address. Wildcards and negations never match.
+func matchesHost(pattern, address string) bool {
+	address = knownhosts.Normalize(address)
+
+	if hashed, ok := strings.CutPrefix(pattern, "|1|"); ok {
+		salt64, hash64, ok := strings.Cut(hashed, "|")
+		if !ok {
+			return false
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(salt64)
+		if err != nil {
+			return false
+		}
+
+		hash, err := base64.StdEncoding.DecodeString(hash64)
+		if err != nil {
+			return false
+		}
+
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(address))
+
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+
+	return pattern == address
+}
+
+func containsKey(keys []gossh.PublicKey, key gossh.PublicKey) bool {
+	for _, k := range keys {
+		if keysEqual(k, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func keysEqual(a, b gossh.PublicKey) bool {
+	return a != nil && b != nil && bytes.Equal(a.Marshal(), b.Marshal())
+}
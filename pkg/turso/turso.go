@@ -1,101 +1,185 @@
+// Package turso is a client for the Turso platform API.
+//
+// See https://docs.turso.tech/api-reference for the API it covers.
 package turso
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+
+	"github.com/nixpig/syringe.sh/pkg/resilience"
+	"github.com/nixpig/syringe.sh/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/nixpig/syringe.sh/pkg/turso")
+
+const DefaultBaseURL = "https://api.turso.tech/v1"
+
 type TursoClient struct {
 	organization string
 	token        string
 	httpClient   http.Client
 	baseURL      string
+	retry        *resilience.Policy
 }
 
-type TursoError struct {
-	Error string `json:"error"`
-}
+type Option func(t *TursoClient)
 
-type TursoDatabase struct {
-	DBID     string `json:"DbId"`
-	HostName string `json:"HostName"`
-	Name     string `json:"Name"`
+// WithBaseURL sets the URL the API is served from, e.g. to use a fake server
+// in tests. An empty URL leaves the default in place.
+func WithBaseURL(baseURL string) Option {
+	return func(t *TursoClient) {
+		if baseURL != "" {
+			t.baseURL = baseURL
+		}
+	}
 }
 
-type TursoDatabaseResponse struct {
-	Database TursoDatabase `json:"database"`
+// WithRetry retries requests that fail transiently, e.g. with a 429 or 5xx
+// status, as the policy describes. Requests that aren't safe to repeat, like
+// creating a database, are only retried when they're known to have had no
+// effect: they were refused with a 429, or never reached the API.
+func WithRetry(policy resilience.Policy) Option {
+	return func(t *TursoClient) {
+		t.retry = &policy
+	}
 }
 
-type TursoDatabases struct {
-	Databases []TursoDatabase `json:"databases"`
+func New(
+	organization, apiToken string,
+	httpClient http.Client,
+	options ...Option,
+) TursoClient {
+	t := TursoClient{
+		organization: organization,
+		token:        apiToken,
+		httpClient:   httpClient,
+		baseURL:      DefaultBaseURL,
+	}
+
+	for _, option := range options {
+		option(&t)
+	}
+
+	return t
 }
 
-type TursoToken struct {
-	Jwt string `json:"jwt"`
+// Organization is the organisation the client makes requests for.
+func (t *TursoClient) Organization() string {
+	return t.organization
 }
 
-type TursoDatabaseAPI interface {
-	CreateDatabase(name, group string) (*TursoDatabaseResponse, error)
-	ListDatabases() (*[]TursoDatabase, error)
-	CreateToken(name string) (*TursoToken, error)
+func (t *TursoClient) organizationPath(elem ...string) string {
+	return "/organizations/" + url.PathEscape(t.organization) + path(elem...)
 }
 
-func New(organization, apiToken string, httpClient http.Client) TursoClient {
-	return TursoClient{
-		organization: organization,
-		token:        apiToken,
-		httpClient:   httpClient,
-		baseURL:      "https://api.turso.tech/v1",
-	}
+// do sends a request to the API and decodes the JSON response into out, if
+// it isn't nil. Responses with any status outside 2xx are returned as errors.
+// Each request is traced in one span, including any retries.
+func (t *TursoClient) do(
+	ctx context.Context,
+	method, path string,
+	body, out any,
+) error {
+	return t.request(ctx, method, path, body, out, idempotent(method))
 }
 
-func (t *TursoClient) CreateDatabase(name, group string) (*TursoDatabaseResponse, error) {
-	url := t.baseURL + "/organizations/" + t.organization + "/databases"
-	body := []byte(fmt.Sprintf(`{
-		"name": "%s",
-		"group": "%s"
-	}`, name, group))
+// doRepeatable sends a request like do, for requests that are safe to repeat
+// whatever their method.
+func (t *TursoClient) doRepeatable(
+	ctx context.Context,
+	method, path string,
+	body, out any,
+) error {
+	return t.request(ctx, method, path, body, out, true)
+}
 
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, err
+func (t *TursoClient) request(
+	ctx context.Context,
+	method, path string,
+	body, out any,
+	repeatable bool,
+) (err error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"turso "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("url.path", path),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if t.retry == nil {
+		return t.doOnce(ctx, method, path, body, out)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", t.token))
+	policy := *t.retry
 
-	res, err := t.httpClient.Do(req)
-	if err != nil {
-		return nil, err
+	if !repeatable {
+		policy.Repeatable = hadNoEffect
 	}
 
-	if res.StatusCode != 200 {
-		var apiErr TursoError
-
-		if err := json.NewDecoder(res.Body).Decode(&apiErr); err != nil {
-			return nil, err
-		}
+	return resilience.Retry(ctx, policy, func(ctx context.Context) error {
+		return t.doOnce(ctx, method, path, body, out)
+	})
+}
 
-		return nil, WrapErr(res.StatusCode, apiErr.Error)
+// idempotent reports whether making a request with the method more than once
+// has the same effect as making it once.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
 	}
+}
 
-	var createdDatabase TursoDatabaseResponse
-
-	if err := json.NewDecoder(res.Body).Decode(&createdDatabase); err != nil {
-		return nil, err
+// hadNoEffect reports whether a failed request is known not to have changed
+// anything: it was refused for being one too many, or the connection to send
+// it was never made.
+func hadNoEffect(err error) bool {
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status() == http.StatusTooManyRequests
 	}
 
-	return &createdDatabase, nil
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr) && opErr.Op == "dial"
 }
 
-func (t *TursoClient) ListDatabases() (*TursoDatabases, error) {
-	url := t.baseURL + "/organizations/" + t.organization + "/databases"
+func (t *TursoClient) doOnce(
+	ctx context.Context,
+	method, path string,
+	body, out any,
+) error {
+	var reqBody io.Reader
+
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, t.baseURL+path, reqBody)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -103,60 +187,43 @@ func (t *TursoClient) ListDatabases() (*TursoDatabases, error) {
 
 	res, err := t.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var databases TursoDatabases
+	defer res.Body.Close()
 
-	if err := json.NewDecoder(res.Body).Decode(&databases); err != nil {
-		return nil, err
-	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("http.response.status_code", res.StatusCode),
+	)
 
-	return &databases, nil
-}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var apiErr TursoError
 
-func (t *TursoClient) CreateToken(name, expiration string) (*TursoToken, error) {
-	url := t.baseURL + "/organizations/" + t.organization + "/databases/" + name + "/auth/tokens?expiration=" + expiration
+		msg := http.StatusText(res.StatusCode)
+		if err := json.NewDecoder(res.Body).Decode(&apiErr); err == nil && apiErr.Error != "" {
+			msg = apiErr.Error
+		}
 
-	req, err := http.NewRequest(http.MethodPost, url, nil)
-	if err != nil {
-		return nil, err
+		return WrapErr(res.StatusCode, msg)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", t.token))
-
-	res, err := t.httpClient.Do(req)
-	if err != nil {
-		return nil, err
+	if out == nil {
+		return nil
 	}
 
-	var token TursoToken
-
-	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
-		return nil, err
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	return &token, nil
-}
-
-type ErrConflict struct {
-	StatusCode int
-	Err        error
+	return nil
 }
 
-func (e ErrConflict) Error() string {
-	return fmt.Sprint(e.Err.Error())
-}
+func path(elem ...string) string {
+	var p string
 
-func WrapErr(statusCode int, msg string) error {
-	switch statusCode {
-	case http.StatusConflict:
-		return ErrConflict{
-			StatusCode: http.StatusConflict,
-			Err:        fmt.Errorf(msg),
-		}
+	for _, e := range elem {
+		p += "/" + url.PathEscape(e)
 	}
 
-	return nil
+	return p
 }
@@ -0,0 +1,181 @@
+package turso
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+type TursoDatabase struct {
+	DBID          string   `json:"DbId"`
+	HostName      string   `json:"Hostname"`
+	Name          string   `json:"Name"`
+	Group         string   `json:"group,omitempty"`
+	PrimaryRegion string   `json:"primaryRegion,omitempty"`
+	Regions       []string `json:"regions,omitempty"`
+	Type          string   `json:"type,omitempty"`
+	Version       string   `json:"version,omitempty"`
+	BlockReads    bool     `json:"block_reads"`
+	BlockWrites   bool     `json:"block_writes"`
+}
+
+type TursoDatabaseResponse struct {
+	Database TursoDatabase `json:"database"`
+}
+
+type TursoDatabases struct {
+	Databases []TursoDatabase `json:"databases"`
+}
+
+type TursoToken struct {
+	Jwt string `json:"jwt"`
+}
+
+type TursoUsage struct {
+	RowsRead     int64 `json:"rows_read"`
+	RowsWritten  int64 `json:"rows_written"`
+	StorageBytes int64 `json:"storage_bytes"`
+}
+
+type TursoDatabaseUsage struct {
+	UUID  string     `json:"uuid"`
+	Usage TursoUsage `json:"usage"`
+}
+
+type TursoDatabaseUsageResponse struct {
+	Database TursoDatabaseUsage `json:"database"`
+}
+
+type TursoDatabaseAPI interface {
+	CreateDatabase(ctx context.Context, name, group string) (*TursoDatabaseResponse, error)
+	RetrieveDatabase(ctx context.Context, name string) (*TursoDatabaseResponse, error)
+	ListDatabases(ctx context.Context) (*TursoDatabases, error)
+	DeleteDatabase(ctx context.Context, name string) error
+	CreateToken(ctx context.Context, name, expiration string) (*TursoToken, error)
+	InvalidateTokens(ctx context.Context, name string) error
+	DatabaseUsage(ctx context.Context, name string) (*TursoDatabaseUsageResponse, error)
+}
+
+var _ TursoDatabaseAPI = (*TursoClient)(nil)
+
+func (t *TursoClient) CreateDatabase(
+	ctx context.Context,
+	name, group string,
+) (*TursoDatabaseResponse, error) {
+	body := struct {
+		Name  string `json:"name"`
+		Group string `json:"group"`
+	}{name, group}
+
+	var createdDatabase TursoDatabaseResponse
+
+	if err := t.do(
+		ctx,
+		http.MethodPost,
+		t.organizationPath("databases"),
+		body,
+		&createdDatabase,
+	); err != nil {
+		return nil, err
+	}
+
+	return &createdDatabase, nil
+}
+
+func (t *TursoClient) RetrieveDatabase(
+	ctx context.Context,
+	name string,
+) (*TursoDatabaseResponse, error) {
+	var database TursoDatabaseResponse
+
+	if err := t.do(
+		ctx,
+		http.MethodGet,
+		t.organizationPath("databases", name),
+		nil,
+		&database,
+	); err != nil {
+		return nil, err
+	}
+
+	return &database, nil
+}
+
+func (t *TursoClient) ListDatabases(ctx context.Context) (*TursoDatabases, error) {
+	var databases TursoDatabases
+
+	if err := t.do(
+		ctx,
+		http.MethodGet,
+		t.organizationPath("databases"),
+		nil,
+		&databases,
+	); err != nil {
+		return nil, err
+	}
+
+	return &databases, nil
+}
+
+func (t *TursoClient) DeleteDatabase(ctx context.Context, name string) error {
+	return t.do(
+		ctx,
+		http.MethodDelete,
+		t.organizationPath("databases", name),
+		nil,
+		nil,
+	)
+}
+
+// CreateToken creates a token for a database. The expiration is a duration
+// such as '30s' or '2w1d', or 'never'. Creating a token more than once is
+// harmless, so it's retried like any request that's safe to repeat.
+func (t *TursoClient) CreateToken(
+	ctx context.Context,
+	name, expiration string,
+) (*TursoToken, error) {
+	var token TursoToken
+
+	if err := t.doRepeatable(
+		ctx,
+		http.MethodPost,
+		t.organizationPath("databases", name, "auth", "tokens")+"?"+url.Values{"expiration": {expiration}}.Encode(),
+		nil,
+		&token,
+	); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// InvalidateTokens invalidates every token that has been created for a
+// database.
+func (t *TursoClient) InvalidateTokens(ctx context.Context, name string) error {
+	return t.do(
+		ctx,
+		http.MethodPost,
+		t.organizationPath("databases", name, "auth", "rotate"),
+		nil,
+		nil,
+	)
+}
+
+func (t *TursoClient) DatabaseUsage(
+	ctx context.Context,
+	name string,
+) (*TursoDatabaseUsageResponse, error) {
+	var usage TursoDatabaseUsageResponse
+
+	if err := t.do(
+		ctx,
+		http.MethodGet,
+		t.organizationPath("databases", name, "usage"),
+		nil,
+		&usage,
+	); err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
@@ -0,0 +1,148 @@
+package turso
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// TursoError is the body of an error response from the API.
+type TursoError struct {
+	Error string `json:"error"`
+}
+
+// StatusError is implemented by every error returned for a response with an
+// unsuccessful status.
+type StatusError interface {
+	error
+	Status() int
+}
+
+// ErrBadRequest is returned for 400 Bad Request.
+type ErrBadRequest struct {
+	StatusCode int
+	Err        error
+}
+
+func (e ErrBadRequest) Error() string { return e.Err.Error() }
+func (e ErrBadRequest) Unwrap() error { return e.Err }
+func (e ErrBadRequest) Status() int   { return e.StatusCode }
+
+// ErrUnauthorized is returned for 401 Unauthorized, e.g. when the API token
+// is invalid.
+type ErrUnauthorized struct {
+	StatusCode int
+	Err        error
+}
+
+func (e ErrUnauthorized) Error() string { return e.Err.Error() }
+func (e ErrUnauthorized) Unwrap() error { return e.Err }
+func (e ErrUnauthorized) Status() int   { return e.StatusCode }
+
+// ErrForbidden is returned for 403 Forbidden.
+type ErrForbidden struct {
+	StatusCode int
+	Err        error
+}
+
+func (e ErrForbidden) Error() string { return e.Err.Error() }
+func (e ErrForbidden) Unwrap() error { return e.Err }
+func (e ErrForbidden) Status() int   { return e.StatusCode }
+
+// ErrNotFound is returned for 404 Not Found.
+type ErrNotFound struct {
+	StatusCode int
+	Err        error
+}
+
+func (e ErrNotFound) Error() string { return e.Err.Error() }
+func (e ErrNotFound) Unwrap() error { return e.Err }
+func (e ErrNotFound) Status() int   { return e.StatusCode }
+
+// ErrConflict is returned for 409 Conflict, e.g. when a database already
+// exists.
+type ErrConflict struct {
+	StatusCode int
+	Err        error
+}
+
+func (e ErrConflict) Error() string { return e.Err.Error() }
+func (e ErrConflict) Unwrap() error { return e.Err }
+func (e ErrConflict) Status() int   { return e.StatusCode }
+
+// ErrUnprocessableEntity is returned for 422 Unprocessable Entity.
+type ErrUnprocessableEntity struct {
+	StatusCode int
+	Err        error
+}
+
+func (e ErrUnprocessableEntity) Error() string { return e.Err.Error() }
+func (e ErrUnprocessableEntity) Unwrap() error { return e.Err }
+func (e ErrUnprocessableEntity) Status() int   { return e.StatusCode }
+
+// ErrTooManyRequests is returned for 429 Too Many Requests.
+type ErrTooManyRequests struct {
+	StatusCode int
+	Err        error
+}
+
+func (e ErrTooManyRequests) Error() string { return e.Err.Error() }
+func (e ErrTooManyRequests) Unwrap() error { return e.Err }
+func (e ErrTooManyRequests) Status() int   { return e.StatusCode }
+
+// ErrServer is returned for any 5xx status.
+type ErrServer struct {
+	StatusCode int
+	Err        error
+}
+
+func (e ErrServer) Error() string { return e.Err.Error() }
+func (e ErrServer) Unwrap() error { return e.Err }
+func (e ErrServer) Status() int   { return e.StatusCode }
+
+// ErrUnexpectedStatus is returned for any other unsuccessful status.
+type ErrUnexpectedStatus struct {
+	StatusCode int
+	Err        error
+}
+
+func (e ErrUnexpectedStatus) Error() string { return e.Err.Error() }
+func (e ErrUnexpectedStatus) Unwrap() error { return e.Err }
+func (e ErrUnexpectedStatus) Status() int   { return e.StatusCode }
+
+// WrapErr returns the error for an unsuccessful response status.
+func WrapErr(statusCode int, msg string) error {
+	err := fmt.Errorf("turso api: %s (%d)", msg, statusCode)
+
+	switch {
+	case statusCode == http.StatusBadRequest:
+		return ErrBadRequest{StatusCode: statusCode, Err: err}
+	case statusCode == http.StatusUnauthorized:
+		return ErrUnauthorized{StatusCode: statusCode, Err: err}
+	case statusCode == http.StatusForbidden:
+		return ErrForbidden{StatusCode: statusCode, Err: err}
+	case statusCode == http.StatusNotFound:
+		return ErrNotFound{StatusCode: statusCode, Err: err}
+	case statusCode == http.StatusConflict:
+		return ErrConflict{StatusCode: statusCode, Err: err}
+	case statusCode == http.StatusUnprocessableEntity:
+		return ErrUnprocessableEntity{StatusCode: statusCode, Err: err}
+	case statusCode == http.StatusTooManyRequests:
+		return ErrTooManyRequests{StatusCode: statusCode, Err: err}
+	case statusCode >= 500 && statusCode <= 599:
+		return ErrServer{StatusCode: statusCode, Err: err}
+	default:
+		return ErrUnexpectedStatus{StatusCode: statusCode, Err: err}
+	}
+}
+
+// StatusCode is the response status an error was returned for, or 0 if it
+// wasn't returned for a response.
+func StatusCode(err error) int {
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status()
+	}
+
+	return 0
+}
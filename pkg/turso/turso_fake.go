@@ -0,0 +1,577 @@
+package turso
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeAPI is an in-memory stand-in for the Turso platform API, for testing
+// code that uses the client without reaching the real API. It serves a single
+// organisation with a 'default' group, under '/v1'.
+type FakeAPI struct {
+	organization string
+	token        string
+
+	// HostName picks the hostname of a created database. It defaults to the
+	// hostname Turso would give it.
+	HostName func(name string) string
+
+	// Dir, if set, is where each database is kept as a SQLite file, named
+	// after its hostname (see FakeDatabaseURL). The file is created with the
+	// database and removed when it's deleted.
+	Dir string
+
+	mu          sync.Mutex
+	databases   map[string]TursoDatabase
+	groups      map[string]TursoGroup
+	locations   map[string]string
+	generations map[string]int
+	tokens      map[string]fakeToken
+
+	mux *http.ServeMux
+}
+
+type fakeToken struct {
+	database   string
+	group      string
+	generation int
+	expiresAt  time.Time
+}
+
+func NewFakeAPI(organization, apiToken string) *FakeAPI {
+	f := &FakeAPI{
+		organization: organization,
+		token:        apiToken,
+		HostName: func(name string) string {
+			return name + "-" + organization + ".turso.io"
+		},
+		databases: map[string]TursoDatabase{},
+		groups: map[string]TursoGroup{
+			"default": {Name: "default", UUID: newUUID(), Primary: "lhr", Locations: []string{"lhr"}},
+		},
+		locations: map[string]string{
+			"ams": "Amsterdam, Netherlands",
+			"iad": "Ashburn, Virginia (US)",
+			"lhr": "London, United Kingdom",
+			"syd": "Sydney, Australia",
+		},
+		generations: map[string]int{},
+		tokens:      map[string]fakeToken{},
+		mux:         http.NewServeMux(),
+	}
+
+	org := "/v1/organizations/{org}"
+
+	f.mux.HandleFunc("GET /v1/locations", f.listLocations)
+	f.mux.HandleFunc("GET "+org+"/usage", f.organizationUsage)
+
+	f.mux.HandleFunc("GET "+org+"/databases", f.listDatabases)
+	f.mux.HandleFunc("POST "+org+"/databases", f.createDatabase)
+	f.mux.HandleFunc("GET "+org+"/databases/{name}", f.retrieveDatabase)
+	f.mux.HandleFunc("DELETE "+org+"/databases/{name}", f.deleteDatabase)
+	f.mux.HandleFunc("GET "+org+"/databases/{name}/usage", f.databaseUsage)
+	f.mux.HandleFunc("POST "+org+"/databases/{name}/auth/tokens", f.createDatabaseToken)
+	f.mux.HandleFunc("POST "+org+"/databases/{name}/auth/rotate", f.invalidateDatabaseTokens)
+
+	f.mux.HandleFunc("GET "+org+"/groups", f.listGroups)
+	f.mux.HandleFunc("POST "+org+"/groups", f.createGroup)
+	f.mux.HandleFunc("GET "+org+"/groups/{name}", f.retrieveGroup)
+	f.mux.HandleFunc("DELETE "+org+"/groups/{name}", f.deleteGroup)
+	f.mux.HandleFunc("POST "+org+"/groups/{name}/locations/{location}", f.addLocation)
+	f.mux.HandleFunc("DELETE "+org+"/groups/{name}/locations/{location}", f.removeLocation)
+	f.mux.HandleFunc("POST "+org+"/groups/{name}/auth/tokens", f.createGroupToken)
+	f.mux.HandleFunc("POST "+org+"/groups/{name}/auth/rotate", f.invalidateGroupTokens)
+
+	return f
+}
+
+func (f *FakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/v1/organizations/"); ok {
+		if org, _, _ := strings.Cut(rest, "/"); org != f.organization {
+			writeError(w, http.StatusNotFound, "organization not found")
+			return
+		}
+	}
+
+	f.mux.ServeHTTP(w, r)
+}
+
+// ValidToken reports whether a token was created for a database, either
+// directly or through its group, and hasn't expired or been invalidated.
+func (f *FakeAPI) ValidToken(database, jwt string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token, ok := f.tokens[jwt]
+	if !ok || (!token.expiresAt.IsZero() && time.Now().After(token.expiresAt)) {
+		return false
+	}
+
+	if token.database != "" {
+		return token.database == database &&
+			token.generation == f.generations["database:"+database]
+	}
+
+	db, ok := f.databases[database]
+
+	return ok && db.Group == token.group &&
+		token.generation == f.generations["group:"+token.group]
+}
+
+// FakeServer serves a FakeAPI over HTTP.
+type FakeServer struct {
+	*httptest.Server
+	*FakeAPI
+}
+
+func NewFakeServer(organization, apiToken string) *FakeServer {
+	api := NewFakeAPI(organization, apiToken)
+
+	return &FakeServer{
+		Server:  httptest.NewServer(api),
+		FakeAPI: api,
+	}
+}
+
+// FakeDatabaseURL is the 'file:' URL of the SQLite file a FakeAPI with the
+// given Dir keeps a database in.
+func FakeDatabaseURL(dir, hostName string) string {
+	return "file:" + filepath.Join(dir, hostName+".db")
+}
+
+// BaseURL is the URL to pass to WithBaseURL.
+func (f *FakeServer) BaseURL() string {
+	return f.URL + "/v1"
+}
+
+// TursoClient is a client for the fake server's organisation.
+func (f *FakeServer) TursoClient() TursoClient {
+	return New(f.organization, f.token, *f.Server.Client(), WithBaseURL(f.BaseURL()))
+}
+
+func (f *FakeAPI) listLocations(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	writeJSON(w, TursoLocations{Locations: f.locations})
+}
+
+func (f *FakeAPI) organizationUsage(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	usage := TursoOrganizationUsage{UUID: f.organization}
+
+	for _, db := range f.databases {
+		usage.Databases = append(usage.Databases, TursoDatabaseUsage{UUID: db.DBID})
+	}
+
+	writeJSON(w, TursoOrganizationUsageResponse{Organization: usage})
+}
+
+func (f *FakeAPI) listDatabases(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	databases := TursoDatabases{Databases: []TursoDatabase{}}
+
+	for _, db := range f.databases {
+		databases.Databases = append(databases.Databases, db)
+	}
+
+	writeJSON(w, databases)
+}
+
+func (f *FakeAPI) createDatabase(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name  string `json:"name"`
+		Group string `json:"group"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if body.Group == "" {
+		body.Group = "default"
+	}
+
+	group, ok := f.groups[body.Group]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("group %s not found", body.Group))
+		return
+	}
+
+	if _, ok := f.databases[body.Name]; ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("database with name %s already exists", body.Name))
+		return
+	}
+
+	db := TursoDatabase{
+		DBID:          newUUID(),
+		HostName:      f.HostName(body.Name),
+		Name:          body.Name,
+		Group:         group.Name,
+		PrimaryRegion: group.Primary,
+		Regions:       group.Locations,
+		Type:          "logical",
+	}
+
+	if f.Dir != "" {
+		if err := os.WriteFile(f.databasePath(db), nil, 0o600); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	f.databases[db.Name] = db
+
+	writeJSON(w, TursoDatabaseResponse{Database: db})
+}
+
+func (f *FakeAPI) retrieveDatabase(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	db, ok := f.database(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, TursoDatabaseResponse{Database: db})
+}
+
+func (f *FakeAPI) deleteDatabase(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	db, ok := f.database(w, r)
+	if !ok {
+		return
+	}
+
+	if err := f.removeDatabase(db); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, map[string]string{"database": db.Name})
+}
+
+func (f *FakeAPI) databaseUsage(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	db, ok := f.database(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, TursoDatabaseUsageResponse{Database: TursoDatabaseUsage{UUID: db.DBID}})
+}
+
+func (f *FakeAPI) createDatabaseToken(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	db, ok := f.database(w, r)
+	if !ok {
+		return
+	}
+
+	f.createToken(w, r, fakeToken{
+		database:   db.Name,
+		generation: f.generations["database:"+db.Name],
+	})
+}
+
+func (f *FakeAPI) invalidateDatabaseTokens(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	db, ok := f.database(w, r)
+	if !ok {
+		return
+	}
+
+	f.generations["database:"+db.Name]++
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *FakeAPI) listGroups(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	groups := TursoGroups{Groups: []TursoGroup{}}
+
+	for _, g := range f.groups {
+		groups.Groups = append(groups.Groups, g)
+	}
+
+	writeJSON(w, groups)
+}
+
+func (f *FakeAPI) createGroup(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name     string `json:"name"`
+		Location string `json:"location"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.locations[body.Location]; !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid location %s", body.Location))
+		return
+	}
+
+	if _, ok := f.groups[body.Name]; ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("group %s already exists", body.Name))
+		return
+	}
+
+	group := TursoGroup{
+		Name:      body.Name,
+		UUID:      newUUID(),
+		Primary:   body.Location,
+		Locations: []string{body.Location},
+	}
+
+	f.groups[group.Name] = group
+
+	writeJSON(w, TursoGroupResponse{Group: group})
+}
+
+func (f *FakeAPI) retrieveGroup(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.group(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, TursoGroupResponse{Group: group})
+}
+
+func (f *FakeAPI) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.group(w, r)
+	if !ok {
+		return
+	}
+
+	// deleting a group deletes its databases
+	for _, db := range f.databases {
+		if db.Group == group.Name {
+			if err := f.removeDatabase(db); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+	}
+
+	delete(f.groups, group.Name)
+
+	writeJSON(w, TursoGroupResponse{Group: group})
+}
+
+func (f *FakeAPI) addLocation(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.group(w, r)
+	if !ok {
+		return
+	}
+
+	location := r.PathValue("location")
+	if _, ok := f.locations[location]; !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid location %s", location))
+		return
+	}
+
+	for _, l := range group.Locations {
+		if l == location {
+			writeJSON(w, TursoGroupResponse{Group: group})
+			return
+		}
+	}
+
+	group.Locations = append(group.Locations, location)
+	f.groups[group.Name] = group
+
+	writeJSON(w, TursoGroupResponse{Group: group})
+}
+
+func (f *FakeAPI) removeLocation(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.group(w, r)
+	if !ok {
+		return
+	}
+
+	location := r.PathValue("location")
+	if location == group.Primary {
+		writeError(w, http.StatusBadRequest, "cannot remove primary location")
+		return
+	}
+
+	var locations []string
+
+	for _, l := range group.Locations {
+		if l != location {
+			locations = append(locations, l)
+		}
+	}
+
+	group.Locations = locations
+	f.groups[group.Name] = group
+
+	writeJSON(w, TursoGroupResponse{Group: group})
+}
+
+func (f *FakeAPI) createGroupToken(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.group(w, r)
+	if !ok {
+		return
+	}
+
+	f.createToken(w, r, fakeToken{
+		group:      group.Name,
+		generation: f.generations["group:"+group.Name],
+	})
+}
+
+func (f *FakeAPI) invalidateGroupTokens(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	group, ok := f.group(w, r)
+	if !ok {
+		return
+	}
+
+	f.generations["group:"+group.Name]++
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *FakeAPI) database(w http.ResponseWriter, r *http.Request) (TursoDatabase, bool) {
+	name := r.PathValue("name")
+
+	db, ok := f.databases[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("database %s not found", name))
+	}
+
+	return db, ok
+}
+
+func (f *FakeAPI) removeDatabase(db TursoDatabase) error {
+	if f.Dir != "" {
+		if err := os.Remove(f.databasePath(db)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	delete(f.databases, db.Name)
+
+	return nil
+}
+
+func (f *FakeAPI) databasePath(db TursoDatabase) string {
+	return filepath.Join(f.Dir, db.HostName+".db")
+}
+
+func (f *FakeAPI) group(w http.ResponseWriter, r *http.Request) (TursoGroup, bool) {
+	name := r.PathValue("name")
+
+	group, ok := f.groups[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("group %s not found", name))
+	}
+
+	return group, ok
+}
+
+func (f *FakeAPI) createToken(w http.ResponseWriter, r *http.Request, token fakeToken) {
+	if expiration := r.URL.Query().Get("expiration"); expiration != "" && expiration != "never" {
+		d, err := time.ParseDuration(expiration)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid expiration %s", expiration))
+			return
+		}
+
+		token.expiresAt = time.Now().Add(d)
+	}
+
+	claims := map[string]any{"a": "rw", "id": newUUID()}
+	if !token.expiresAt.IsZero() {
+		claims["exp"] = token.expiresAt.Unix()
+	}
+
+	payload, _ := json.Marshal(claims)
+
+	// shaped like a JWT, but not signed
+	jwt := strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)),
+		base64.RawURLEncoding.EncodeToString(payload),
+		"",
+	}, ".")
+
+	f.tokens[jwt] = token
+
+	writeJSON(w, TursoToken{Jwt: jwt})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(TursoError{Error: msg})
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,149 @@
+package turso
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+type TursoGroup struct {
+	Name      string   `json:"name"`
+	UUID      string   `json:"uuid"`
+	Version   string   `json:"version"`
+	Primary   string   `json:"primary"`
+	Locations []string `json:"locations"`
+	Archived  bool     `json:"archived"`
+}
+
+type TursoGroupResponse struct {
+	Group TursoGroup `json:"group"`
+}
+
+type TursoGroups struct {
+	Groups []TursoGroup `json:"groups"`
+}
+
+type TursoGroupAPI interface {
+	CreateGroup(ctx context.Context, name, location string) (*TursoGroupResponse, error)
+	RetrieveGroup(ctx context.Context, name string) (*TursoGroupResponse, error)
+	ListGroups(ctx context.Context) (*TursoGroups, error)
+	DeleteGroup(ctx context.Context, name string) error
+	AddLocation(ctx context.Context, group, location string) (*TursoGroupResponse, error)
+	RemoveLocation(ctx context.Context, group, location string) (*TursoGroupResponse, error)
+	CreateGroupToken(ctx context.Context, group, expiration string) (*TursoToken, error)
+	InvalidateGroupTokens(ctx context.Context, group string) error
+}
+
+var _ TursoGroupAPI = (*TursoClient)(nil)
+
+func (t *TursoClient) CreateGroup(
+	ctx context.Context,
+	name, location string,
+) (*TursoGroupResponse, error) {
+	body := struct {
+		Name     string `json:"name"`
+		Location string `json:"location"`
+	}{name, location}
+
+	var group TursoGroupResponse
+
+	if err := t.do(ctx, http.MethodPost, t.organizationPath("groups"), body, &group); err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+func (t *TursoClient) RetrieveGroup(ctx context.Context, name string) (*TursoGroupResponse, error) {
+	var group TursoGroupResponse
+
+	if err := t.do(ctx, http.MethodGet, t.organizationPath("groups", name), nil, &group); err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+func (t *TursoClient) ListGroups(ctx context.Context) (*TursoGroups, error) {
+	var groups TursoGroups
+
+	if err := t.do(ctx, http.MethodGet, t.organizationPath("groups"), nil, &groups); err != nil {
+		return nil, err
+	}
+
+	return &groups, nil
+}
+
+func (t *TursoClient) DeleteGroup(ctx context.Context, name string) error {
+	return t.do(ctx, http.MethodDelete, t.organizationPath("groups", name), nil, nil)
+}
+
+func (t *TursoClient) AddLocation(
+	ctx context.Context,
+	group, location string,
+) (*TursoGroupResponse, error) {
+	var updated TursoGroupResponse
+
+	if err := t.do(
+		ctx,
+		http.MethodPost,
+		t.organizationPath("groups", group, "locations", location),
+		nil,
+		&updated,
+	); err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+func (t *TursoClient) RemoveLocation(
+	ctx context.Context,
+	group, location string,
+) (*TursoGroupResponse, error) {
+	var updated TursoGroupResponse
+
+	if err := t.do(
+		ctx,
+		http.MethodDelete,
+		t.organizationPath("groups", group, "locations", location),
+		nil,
+		&updated,
+	); err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// CreateGroupToken creates a token for every database in a group.
+func (t *TursoClient) CreateGroupToken(
+	ctx context.Context,
+	group, expiration string,
+) (*TursoToken, error) {
+	var token TursoToken
+
+	if err := t.do(
+		ctx,
+		http.MethodPost,
+		t.organizationPath("groups", group, "auth", "tokens")+"?"+url.Values{"expiration": {expiration}}.Encode(),
+		nil,
+		&token,
+	); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// InvalidateGroupTokens invalidates every token that has been created for
+// the databases in a group.
+func (t *TursoClient) InvalidateGroupTokens(ctx context.Context, group string) error {
+	return t.do(
+		ctx,
+		http.MethodPost,
+		t.organizationPath("groups", group, "auth", "rotate"),
+		nil,
+		nil,
+	)
+}
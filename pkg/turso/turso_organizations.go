@@ -0,0 +1,42 @@
+package turso
+
+import (
+	"context"
+	"net/http"
+)
+
+// TursoLocations maps location codes, e.g. 'lhr', to their descriptions.
+type TursoLocations struct {
+	Locations map[string]string `json:"locations"`
+}
+
+type TursoOrganizationUsage struct {
+	UUID      string               `json:"uuid"`
+	Usage     TursoUsage           `json:"usage"`
+	Databases []TursoDatabaseUsage `json:"databases"`
+}
+
+type TursoOrganizationUsageResponse struct {
+	Organization TursoOrganizationUsage `json:"organization"`
+}
+
+// ListLocations lists the locations databases can be placed in.
+func (t *TursoClient) ListLocations(ctx context.Context) (*TursoLocations, error) {
+	var locations TursoLocations
+
+	if err := t.do(ctx, http.MethodGet, "/locations", nil, &locations); err != nil {
+		return nil, err
+	}
+
+	return &locations, nil
+}
+
+func (t *TursoClient) OrganizationUsage(ctx context.Context) (*TursoOrganizationUsageResponse, error) {
+	var usage TursoOrganizationUsageResponse
+
+	if err := t.do(ctx, http.MethodGet, t.organizationPath("usage"), nil, &usage); err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
@@ -0,0 +1,400 @@
+package turso_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nixpig/syringe.sh/pkg/resilience"
+	"github.com/nixpig/syringe.sh/pkg/turso"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTurso(t *testing.T) {
+	scenarios := map[string]func(
+		t *testing.T,
+		server *turso.FakeServer,
+		client turso.TursoClient,
+	){
+		"test create and retrieve database":     testCreateAndRetrieveDatabase,
+		"test create database conflict":         testCreateDatabaseConflict,
+		"test create database in unknown group": testCreateDatabaseInUnknownGroup,
+		"test list and delete databases":        testListAndDeleteDatabases,
+		"test retrieve missing database":        testRetrieveMissingDatabase,
+		"test database tokens":                  testDatabaseTokens,
+		"test group tokens":                     testGroupTokens,
+		"test groups and locations":             testGroupsAndLocations,
+		"test usage":                            testUsage,
+		"test unauthorized":                     testUnauthorized,
+		"test unknown organization":             testUnknownOrganization,
+		"test cancelled context":                testCancelledContext,
+		"test database files":                   testDatabaseFiles,
+	}
+
+	for scenario, fn := range scenarios {
+		t.Run(scenario, func(t *testing.T) {
+			server := turso.NewFakeServer("my_cool_org", "api_token")
+			defer server.Close()
+
+			fn(t, server, server.TursoClient())
+		})
+	}
+}
+
+func testCreateAndRetrieveDatabase(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	ctx := context.Background()
+
+	created, err := client.CreateDatabase(ctx, "my_cool_db", "default")
+	require.NoError(t, err)
+	require.Equal(t, "my_cool_db", created.Database.Name)
+	require.Equal(t, "my_cool_db-my_cool_org.turso.io", created.Database.HostName)
+	require.Equal(t, "default", created.Database.Group)
+
+	retrieved, err := client.RetrieveDatabase(ctx, "my_cool_db")
+	require.NoError(t, err)
+	require.Equal(t, created.Database, retrieved.Database)
+}
+
+func testCreateDatabaseConflict(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	ctx := context.Background()
+
+	_, err := client.CreateDatabase(ctx, "my_cool_db", "default")
+	require.NoError(t, err)
+
+	_, err = client.CreateDatabase(ctx, "my_cool_db", "default")
+
+	var conflict turso.ErrConflict
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, http.StatusConflict, turso.StatusCode(err))
+	require.ErrorContains(t, err, "database with name my_cool_db already exists")
+}
+
+func testCreateDatabaseInUnknownGroup(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	_, err := client.CreateDatabase(context.Background(), "my_cool_db", "my_cool_group")
+
+	require.ErrorAs(t, err, &turso.ErrNotFound{})
+}
+
+func testListAndDeleteDatabases(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	ctx := context.Background()
+
+	_, err := client.CreateDatabase(ctx, "my_cool_db", "default")
+	require.NoError(t, err)
+
+	databases, err := client.ListDatabases(ctx)
+	require.NoError(t, err)
+	require.Len(t, databases.Databases, 1)
+
+	require.NoError(t, client.DeleteDatabase(ctx, "my_cool_db"))
+
+	databases, err = client.ListDatabases(ctx)
+	require.NoError(t, err)
+	require.Empty(t, databases.Databases)
+
+	require.ErrorAs(t, client.DeleteDatabase(ctx, "my_cool_db"), &turso.ErrNotFound{})
+}
+
+func testRetrieveMissingDatabase(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	_, err := client.RetrieveDatabase(context.Background(), "my_missing_db")
+
+	require.ErrorAs(t, err, &turso.ErrNotFound{})
+	require.Equal(t, http.StatusNotFound, turso.StatusCode(err))
+}
+
+func testDatabaseTokens(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	ctx := context.Background()
+
+	_, err := client.CreateDatabase(ctx, "my_cool_db", "default")
+	require.NoError(t, err)
+
+	token, err := client.CreateToken(ctx, "my_cool_db", "30s")
+	require.NoError(t, err)
+	require.True(t, server.ValidToken("my_cool_db", token.Jwt))
+	require.False(t, server.ValidToken("my_other_db", token.Jwt))
+
+	require.NoError(t, client.InvalidateTokens(ctx, "my_cool_db"))
+	require.False(t, server.ValidToken("my_cool_db", token.Jwt))
+
+	_, err = client.CreateToken(ctx, "my_cool_db", "not a duration")
+	require.ErrorAs(t, err, &turso.ErrBadRequest{})
+}
+
+func testGroupTokens(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	ctx := context.Background()
+
+	_, err := client.CreateDatabase(ctx, "my_cool_db", "default")
+	require.NoError(t, err)
+
+	token, err := client.CreateGroupToken(ctx, "default", "never")
+	require.NoError(t, err)
+	require.True(t, server.ValidToken("my_cool_db", token.Jwt))
+
+	require.NoError(t, client.InvalidateGroupTokens(ctx, "default"))
+	require.False(t, server.ValidToken("my_cool_db", token.Jwt))
+}
+
+func testGroupsAndLocations(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	ctx := context.Background()
+
+	locations, err := client.ListLocations(ctx)
+	require.NoError(t, err)
+	require.Contains(t, locations.Locations, "ams")
+
+	group, err := client.CreateGroup(ctx, "my_cool_group", "ams")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ams"}, group.Group.Locations)
+
+	_, err = client.CreateGroup(ctx, "my_cool_group", "ams")
+	require.ErrorAs(t, err, &turso.ErrConflict{})
+
+	group, err = client.AddLocation(ctx, "my_cool_group", "syd")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ams", "syd"}, group.Group.Locations)
+
+	group, err = client.RemoveLocation(ctx, "my_cool_group", "syd")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ams"}, group.Group.Locations)
+
+	groups, err := client.ListGroups(ctx)
+	require.NoError(t, err)
+	require.Len(t, groups.Groups, 2)
+
+	_, err = client.CreateDatabase(ctx, "my_cool_db", "my_cool_group")
+	require.NoError(t, err)
+
+	require.NoError(t, client.DeleteGroup(ctx, "my_cool_group"))
+
+	_, err = client.RetrieveGroup(ctx, "my_cool_group")
+	require.ErrorAs(t, err, &turso.ErrNotFound{})
+
+	_, err = client.RetrieveDatabase(ctx, "my_cool_db")
+	require.ErrorAs(t, err, &turso.ErrNotFound{})
+}
+
+func testUsage(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	ctx := context.Background()
+
+	created, err := client.CreateDatabase(ctx, "my_cool_db", "default")
+	require.NoError(t, err)
+
+	usage, err := client.DatabaseUsage(ctx, "my_cool_db")
+	require.NoError(t, err)
+	require.Equal(t, created.Database.DBID, usage.Database.UUID)
+
+	orgUsage, err := client.OrganizationUsage(ctx)
+	require.NoError(t, err)
+	require.Len(t, orgUsage.Organization.Databases, 1)
+}
+
+func testUnauthorized(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	client = turso.New("my_cool_org", "wrong_token", *server.Client(), turso.WithBaseURL(server.BaseURL()))
+
+	_, err := client.ListDatabases(context.Background())
+
+	require.ErrorAs(t, err, &turso.ErrUnauthorized{})
+}
+
+func testUnknownOrganization(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	client = turso.New("my_other_org", "api_token", *server.Client(), turso.WithBaseURL(server.BaseURL()))
+
+	_, err := client.ListDatabases(context.Background())
+
+	require.ErrorAs(t, err, &turso.ErrNotFound{})
+}
+
+func testCancelledContext(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.ListDatabases(ctx)
+
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func testDatabaseFiles(t *testing.T, server *turso.FakeServer, client turso.TursoClient) {
+	ctx := context.Background()
+	server.Dir = t.TempDir()
+
+	created, err := client.CreateDatabase(ctx, "my_cool_db", "default")
+	require.NoError(t, err)
+
+	url := turso.FakeDatabaseURL(server.Dir, created.Database.HostName)
+	path := strings.TrimPrefix(url, "file:")
+	require.Equal(t, filepath.Join(server.Dir, "my_cool_db-my_cool_org.turso.io.db"), path)
+	require.FileExists(t, path)
+
+	require.NoError(t, client.DeleteDatabase(ctx, "my_cool_db"))
+	require.NoFileExists(t, path)
+
+	_, err = client.CreateGroup(ctx, "my_cool_group", "ams")
+	require.NoError(t, err)
+
+	created, err = client.CreateDatabase(ctx, "my_other_db", "my_cool_group")
+	require.NoError(t, err)
+
+	path = strings.TrimPrefix(turso.FakeDatabaseURL(server.Dir, created.Database.HostName), "file:")
+	require.FileExists(t, path)
+
+	require.NoError(t, client.DeleteGroup(ctx, "my_cool_group"))
+	require.NoFileExists(t, path)
+}
+
+func TestWrapErr(t *testing.T) {
+	scenarios := map[int]any{
+		http.StatusBadRequest:          &turso.ErrBadRequest{},
+		http.StatusUnauthorized:        &turso.ErrUnauthorized{},
+		http.StatusForbidden:           &turso.ErrForbidden{},
+		http.StatusNotFound:            &turso.ErrNotFound{},
+		http.StatusConflict:            &turso.ErrConflict{},
+		http.StatusUnprocessableEntity: &turso.ErrUnprocessableEntity{},
+		http.StatusTooManyRequests:     &turso.ErrTooManyRequests{},
+		http.StatusInternalServerError: &turso.ErrServer{},
+		http.StatusBadGateway:          &turso.ErrServer{},
+		http.StatusTeapot:              &turso.ErrUnexpectedStatus{},
+	}
+
+	for statusCode, target := range scenarios {
+		t.Run(http.StatusText(statusCode), func(t *testing.T) {
+			err := turso.WrapErr(statusCode, "something went wrong")
+
+			require.ErrorAs(t, err, target)
+			require.Equal(t, statusCode, turso.StatusCode(err))
+			require.ErrorContains(t, err, "something went wrong")
+		})
+	}
+}
+
+func TestErrorWithoutBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := turso.New("my_cool_org", "api_token", *server.Client(), turso.WithBaseURL(server.URL))
+
+	_, err := client.ListDatabases(context.Background())
+
+	require.ErrorAs(t, err, &turso.ErrServer{})
+	require.ErrorContains(t, err, http.StatusText(http.StatusServiceUnavailable))
+	require.False(t, errors.Is(err, context.Canceled))
+}
+
+func TestRetry(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte(`{"databases":[]}`))
+	}))
+	defer server.Close()
+
+	client := turso.New(
+		"my_cool_org",
+		"api_token",
+		*server.Client(),
+		turso.WithBaseURL(server.URL),
+		turso.WithRetry(resilience.Policy{
+			MaxAttempts:  3,
+			InitialDelay: time.Millisecond,
+			Multiplier:   2,
+		}),
+	)
+
+	databases, err := client.ListDatabases(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, databases.Databases)
+	require.Equal(t, 3, requests)
+}
+
+func TestRetryOnlyIdempotent(t *testing.T) {
+	requests := 0
+	status := http.StatusServiceUnavailable
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	client := turso.New(
+		"my_cool_org",
+		"api_token",
+		*server.Client(),
+		turso.WithBaseURL(server.URL),
+		turso.WithRetry(resilience.Policy{
+			MaxAttempts:  3,
+			InitialDelay: time.Millisecond,
+			Multiplier:   2,
+		}),
+	)
+
+	// the database may have been created despite the error
+	_, err := client.CreateDatabase(context.Background(), "my_db", "default")
+	require.ErrorAs(t, err, &turso.ErrServer{})
+	require.Equal(t, 1, requests)
+
+	requests = 0
+
+	err = client.DeleteDatabase(context.Background(), "my_db")
+	require.ErrorAs(t, err, &turso.ErrServer{})
+	require.Equal(t, 3, requests)
+
+	requests = 0
+
+	// tokens can be created more than once
+	_, err = client.CreateToken(context.Background(), "my_db", "1h")
+	require.ErrorAs(t, err, &turso.ErrServer{})
+	require.Equal(t, 3, requests)
+
+	requests = 0
+	status = http.StatusTooManyRequests
+
+	// requests refused for being too many had no effect
+	_, err = client.CreateDatabase(context.Background(), "my_db", "default")
+	require.ErrorContains(t, err, http.StatusText(http.StatusTooManyRequests))
+	require.Equal(t, 3, requests)
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	server := turso.NewFakeServer("my_cool_org", "api_token")
+	defer server.Close()
+
+	client := server.TursoClient()
+
+	_, err := client.ListDatabases(context.Background())
+	require.NoError(t, err)
+
+	_, err = client.RetrieveDatabase(context.Background(), "my_missing_db")
+	require.ErrorAs(t, err, &turso.ErrNotFound{})
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	require.Equal(t, "turso GET", spans[0].Name())
+	require.Equal(t, trace.SpanKindClient, spans[0].SpanKind())
+	require.Contains(t, spans[0].Attributes(), attribute.String("url.path", "/organizations/my_cool_org/databases"))
+	require.Contains(t, spans[0].Attributes(), attribute.Int("http.response.status_code", http.StatusOK))
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	require.Contains(t, spans[1].Attributes(), attribute.Int("http.response.status_code", http.StatusNotFound))
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+}
@@ -1,35 +1,200 @@
 package test
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"testing"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/charmbracelet/wish"
+	"github.com/nixpig/syringe.sh/config"
+	"github.com/nixpig/syringe.sh/internal/admin"
+	"github.com/nixpig/syringe.sh/internal/auth"
+	"github.com/nixpig/syringe.sh/internal/database"
+	"github.com/nixpig/syringe.sh/internal/middleware"
+	"github.com/nixpig/syringe.sh/internal/user"
+	"github.com/nixpig/syringe.sh/pkg/mailer"
+	"github.com/nixpig/syringe.sh/pkg/resilience"
+	"github.com/nixpig/syringe.sh/pkg/turso"
+	"github.com/nixpig/syringe.sh/pkg/validation"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	gossh "golang.org/x/crypto/ssh"
 )
 
 const (
-	host = "localhost"
-	port = "23234"
+	org      = "my_cool_org"
+	apiToken = "api_token"
 )
 
 func TestEndToEnd(t *testing.T) {
-	scenarios := map[string]func(t *testing.T){
+	// libsql opens the fake's 'file:' databases with whichever sqlite driver
+	// is linked in
+	if !slices.Contains(sql.Drivers(), "sqlite") && !slices.Contains(sql.Drivers(), "sqlite3") {
+		t.Skip("no sqlite driver linked to back the fake turso databases")
+	}
+
+	scenarios := map[string]func(t *testing.T, addr string, fake *turso.FakeServer, mail *bytes.Buffer){
 		"test register with new key": testRegisterWithNewKey,
+		"test unregistered key":      testUnregisteredKey,
 	}
 
 	for scenario, fn := range scenarios {
 		t.Run(scenario, func(t *testing.T) {
-			// sshServer := server.NewServer(appService, &log)
+			dir := t.TempDir()
+
+			fake := turso.NewFakeServer(org, apiToken)
+			fake.Dir = dir
+			defer fake.Close()
+
+			retry := resilience.DefaultPolicy
+
+			tursoAPISettings := user.TursoAPISettings{
+				URL:          fake.BaseURL(),
+				Token:        apiToken,
+				Retry:        &retry,
+				Organization: org,
+				Group:        "default",
+				DatabaseURL:  database.FakeURL(dir),
+			}
+
+			appDB, err := database.Connection(context.Background(), tursoAPISettings.DatabaseURL("app"), "")
+			require.NoError(t, err)
+			defer appDB.Close()
+
+			require.NoError(t, database.MigrateAppDB(appDB))
+
+			tursoAPI := fake.TursoClient()
+
+			connections := database.NewConnectionManager(
+				&tursoAPI,
+				org,
+				database.WithURL(tursoAPISettings.DatabaseURL),
+			)
+			defer connections.Close()
 
-			// if err := sshServer.Start(
-			// 	HOST, PORT,
-			// ); err != nil {
-			// 	t.Fatal("failed to start server")
-			// }
+			var mail bytes.Buffer
 
-			// todo: how do we stop it?
+			log := zerolog.Nop()
+			validate := validation.New()
+			authService := auth.NewAuthService(auth.NewSqliteAuthStore(appDB), validate)
 
-			fn(t)
+			sshServer, err := wish.NewServer(
+				wish.WithHostKeyPath(filepath.Join(dir, "id_ed25519")),
+				wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
+					return auth.DefaultKeyPolicy.Check(key) == nil
+				}),
+				wish.WithMiddleware(
+					middleware.NewMiddlewareCommand(
+						&log,
+						appDB,
+						validate,
+						connections,
+						tursoAPISettings,
+						http.Client{},
+						mailer.NewLogMailer(&mail),
+						admin.Allowlist{},
+						config.DefaultServer().Timeouts.For,
+					),
+					middleware.NewMiddlewareAuth(&log, authService),
+					middleware.NewMiddlewareLogging(&log),
+				),
+			)
+			require.NoError(t, err)
+
+			listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+			require.NoError(t, err)
+
+			go sshServer.Serve(listener)
+			defer sshServer.Close()
+
+			fn(t, listener.Addr().String(), fake, &mail)
 		})
 	}
 }
 
-func testRegisterWithNewKey(t *testing.T) {
+func testRegisterWithNewKey(t *testing.T, addr string, fake *turso.FakeServer, mail *bytes.Buffer) {
+	client := dial(t, addr, "alice", newSigner(t))
+	defer client.Close()
+
+	_, _, err := run(t, client, "user register --email alice@example.org")
+	require.NoError(t, err)
+
+	api := fake.TursoClient()
+
+	databases, err := api.ListDatabases(context.Background())
+	require.NoError(t, err)
+	require.Len(t, databases.Databases, 1)
+	require.FileExists(t, filepath.Join(fake.Dir, databases.Databases[0].HostName+".db"))
+
+	_, _, err = run(t, client, "project add my_cool_project")
+	require.Error(t, err, "unverified users can't add projects")
+
+	code := regexp.MustCompile(`code is (\d{6})`).FindStringSubmatch(mail.String())
+	require.Len(t, code, 2)
+
+	_, _, err = run(t, client, "user verify "+code[1])
+	require.NoError(t, err)
+
+	stdout, _, err := run(t, client, "project add my_cool_project")
+	require.NoError(t, err)
+	require.Equal(t, ProjectAddedSuccessMsg("my_cool_project"), stdout)
+
+	stdout, _, err = run(t, client, "project list")
+	require.NoError(t, err)
+	require.Equal(t, "my_cool_project", stdout)
+}
+
+func testUnregisteredKey(t *testing.T, addr string, fake *turso.FakeServer, mail *bytes.Buffer) {
+	client := dial(t, addr, "bob", newSigner(t))
+	defer client.Close()
+
+	_, _, err := run(t, client, "project list")
+
+	var exitErr *gossh.ExitError
+	require.True(t, errors.As(err, &exitErr))
+}
+
+func newSigner(t *testing.T) gossh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := gossh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	return signer
+}
+
+func dial(t *testing.T, addr, username string, signer gossh.Signer) *gossh.Client {
+	client, err := gossh.Dial("tcp", addr, &gossh.ClientConfig{
+		User:            username,
+		Auth:            []gossh.AuthMethod{gossh.PublicKeys(signer)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+
+	return client
+}
+
+func run(t *testing.T, client *gossh.Client, cmd string) (string, string, error) {
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	err = session.Run(cmd)
+
+	return stdout.String(), stderr.String(), err
 }